@@ -0,0 +1,364 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/util"
+)
+
+const (
+	fileSinkTag     = "file"
+	databaseSinkTag = "database"
+
+	redactedValue = "***"
+
+	defaultBufferSize    = 1024
+	defaultFlushInterval = time.Second
+	maxBatchSize         = 100
+)
+
+var (
+	log      = l.NewLogger()
+	logger   *Logger
+	loggerMu sync.RWMutex
+)
+
+// Decision is the result of an authorization
+type Decision string
+
+const (
+	// Allow means that the request was allowed by a policy
+	Allow Decision = "allow"
+	// Deny means that the request was denied, either by a deny policy or because no policy matched
+	Deny Decision = "deny"
+)
+
+// Request describes the API request an authorization decision is made for
+type Request struct {
+	Method   string
+	Path     string
+	SourceIP string
+}
+
+// Record describes a single authorization decision
+type Record struct {
+	Timestamp  int64    `json:"timestamp"`
+	TraceID    string   `json:"trace_id"`
+	Principal  string   `json:"principal"`
+	UserID     string   `json:"user_id"`
+	Role       string   `json:"role"`
+	Roles      []string `json:"roles"`
+	TenantID   string   `json:"tenant_id"`
+	TenantName string   `json:"tenant_name"`
+	DomainID   string   `json:"domain_id"`
+	Method     string   `json:"method"`
+	SourceIP   string   `json:"source_ip"`
+	Action     string   `json:"action"`
+	Path       string   `json:"path"`
+	ResourceID string   `json:"resource_id"`
+	PolicyID   string   `json:"policy_id"`
+	Decision   Decision `json:"decision"`
+}
+
+// NewRecord returns a record of the decision made by the policy for given authorization.
+// A nil policy means that no policy matched the request.
+func NewRecord(auth schema.Authorization, action, path string, policy *schema.Policy, role *schema.Role) *Record {
+	record := &Record{
+		Timestamp:  time.Now().Unix(),
+		Principal:  auth.UserName(),
+		UserID:     auth.UserID(),
+		TenantID:   auth.TenantID(),
+		TenantName: auth.TenantName(),
+		DomainID:   auth.DomainID(),
+		Action:     action,
+		Path:       path,
+		Decision:   Deny,
+	}
+	for _, r := range auth.Roles() {
+		record.Roles = append(record.Roles, r.Name)
+	}
+	if record.Principal == "" {
+		record.Principal = record.UserID
+	}
+	if role != nil {
+		record.Role = role.Name
+	}
+	if policy != nil {
+		record.PolicyID = policy.ID
+		if !policy.IsDeny() {
+			record.Decision = Allow
+		}
+	}
+	return record
+}
+
+// Deny marks the record as denied, e.g. when the policy allowed the request,
+// but access to the resource was denied later
+func (record *Record) Deny() {
+	record.Decision = Deny
+}
+
+// WithRequest fills request details of the record
+func (record *Record) WithRequest(request *Request) *Record {
+	if request != nil {
+		record.Method = request.Method
+		record.SourceIP = request.SourceIP
+	}
+	return record
+}
+
+func (record *Record) stringFields() map[string]*string {
+	return map[string]*string{
+		"trace_id":    &record.TraceID,
+		"principal":   &record.Principal,
+		"user_id":     &record.UserID,
+		"role":        &record.Role,
+		"tenant_id":   &record.TenantID,
+		"tenant_name": &record.TenantName,
+		"domain_id":   &record.DomainID,
+		"method":      &record.Method,
+		"source_ip":   &record.SourceIP,
+		"action":      &record.Action,
+		"path":        &record.Path,
+		"resource_id": &record.ResourceID,
+		"policy_id":   &record.PolicyID,
+	}
+}
+
+func (record *Record) redact(fields []string) {
+	stringFields := record.stringFields()
+	for _, field := range fields {
+		if value, ok := stringFields[field]; ok && *value != "" {
+			*value = redactedValue
+		}
+		if field == "roles" && len(record.Roles) > 0 {
+			record.Roles = []string{redactedValue}
+		}
+	}
+}
+
+func (record *Record) toMap() map[string]interface{} {
+	data := map[string]interface{}{
+		"timestamp": record.Timestamp,
+		"roles":     strings.Join(record.Roles, ","),
+		"decision":  string(record.Decision),
+	}
+	for key, value := range record.stringFields() {
+		data[key] = *value
+	}
+	return data
+}
+
+// Sink stores audit records
+type Sink interface {
+	Write(records []*Record) error
+	Close() error
+}
+
+// Logger filters audit records and passes them asynchronously to a sink
+type Logger struct {
+	sink            Sink
+	logAllowed      bool
+	allowedSampling int
+	redact          []string
+	flushInterval   time.Duration
+	records         chan *Record
+	done            sync.WaitGroup
+	// mu keeps records from being closed while Log sends to it
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewLogger creates a logger writing to the given sink
+func NewLogger(sink Sink, logAllowed bool, allowedSampling int, redact []string, bufferSize int, flushInterval time.Duration) *Logger {
+	logger := &Logger{
+		sink:            sink,
+		logAllowed:      logAllowed,
+		allowedSampling: allowedSampling,
+		redact:          redact,
+		flushInterval:   flushInterval,
+		records:         make(chan *Record, bufferSize),
+	}
+	logger.done.Add(1)
+	go logger.run()
+	return logger
+}
+
+// Log queues the record if it should be recorded
+func (logger *Logger) Log(record *Record) {
+	if !logger.shouldLog(record) {
+		return
+	}
+	record.redact(logger.redact)
+	logger.mu.RLock()
+	defer logger.mu.RUnlock()
+	if logger.closed {
+		return
+	}
+	select {
+	case logger.records <- record:
+	default:
+		metrics.UpdateCounter(1, "audit.dropped")
+		log.Warning("Audit log buffer is full, dropping record of trace %s", record.TraceID)
+	}
+}
+
+func (logger *Logger) shouldLog(record *Record) bool {
+	if record.Decision == Deny {
+		return true
+	}
+	return logger.logAllowed && rand.Intn(100) < logger.allowedSampling
+}
+
+func (logger *Logger) run() {
+	defer logger.done.Done()
+	ticker := time.NewTicker(logger.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Record, 0, maxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := logger.sink.Write(batch); err != nil {
+			metrics.UpdateCounter(int64(len(batch)), "audit.write_failed")
+			log.Error("Failed to write %d audit records: %s", len(batch), err)
+		} else {
+			metrics.UpdateCounter(int64(len(batch)), "audit.written")
+		}
+		batch = make([]*Record, 0, maxBatchSize)
+	}
+
+	for {
+		select {
+		case record, ok := <-logger.records:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= maxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close flushes pending records and closes the sink
+func (logger *Logger) Close() error {
+	logger.mu.Lock()
+	if logger.closed {
+		logger.mu.Unlock()
+		return nil
+	}
+	logger.closed = true
+	close(logger.records)
+	logger.mu.Unlock()
+	logger.done.Wait()
+	return logger.sink.Close()
+}
+
+// SetupAudit creates the audit logger from config
+func SetupAudit(config *util.Config, dataStore db.DB) error {
+	if !config.GetBool("audit/enabled", false) {
+		return nil
+	}
+
+	sink, err := createSink(config, dataStore)
+	if err != nil {
+		return err
+	}
+
+	allowedSampling := config.GetInt("audit/allowed_sampling", 100)
+	if allowedSampling < 0 || allowedSampling > 100 {
+		return fmt.Errorf("audit/allowed_sampling should be a percentage, %d given", allowedSampling)
+	}
+
+	SetLogger(NewLogger(
+		sink,
+		config.GetBool("audit/log_allowed", false),
+		allowedSampling,
+		config.GetStringList("audit/redact", nil),
+		config.GetInt("audit/buffer_size", defaultBufferSize),
+		config.GetDuration("audit/flush_interval", defaultFlushInterval),
+	))
+	return nil
+}
+
+func createSink(config *util.Config, dataStore db.DB) (Sink, error) {
+	sinkTag := config.GetString("audit/sink", fileSinkTag)
+	switch sinkTag {
+	case fileSinkTag:
+		return NewFileSink(config.GetString("audit/file", "audit.log"))
+	case databaseSinkTag:
+		return NewDBSink(dataStore)
+	default:
+		return nil, fmt.Errorf("unsupported audit sink under audit/sink: %s, must be '%s' or '%s'",
+			sinkTag, fileSinkTag, databaseSinkTag)
+	}
+}
+
+// SetLogger sets the global audit logger, nil disables auditing
+func SetLogger(newLogger *Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = newLogger
+}
+
+func getLogger() *Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// Enabled checks if auditing is enabled
+func Enabled() bool {
+	return getLogger() != nil
+}
+
+// Log passes the record to the global audit logger
+func Log(record *Record) {
+	if current := getLogger(); current != nil {
+		current.Log(record)
+	}
+}
+
+// Stop flushes and disables the global audit logger.
+// Records logged concurrently are dropped once the logger is closed.
+func Stop() {
+	loggerMu.Lock()
+	current := logger
+	logger = nil
+	loggerMu.Unlock()
+	if current == nil {
+		return
+	}
+	if err := current.Close(); err != nil {
+		log.Error("Failed to close audit sink: %s", err)
+	}
+}
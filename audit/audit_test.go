@@ -0,0 +1,197 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/schema"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type memorySink struct {
+	records []*Record
+}
+
+func (sink *memorySink) Write(records []*Record) error {
+	sink.records = append(sink.records, records...)
+	return nil
+}
+
+func (sink *memorySink) Close() error {
+	return nil
+}
+
+var _ = Describe("Audit", func() {
+	var (
+		auth        schema.Authorization
+		allowPolicy *schema.Policy
+		denyPolicy  *schema.Policy
+	)
+
+	newPolicy := func(id, effect string) *schema.Policy {
+		policy, err := schema.NewPolicy(map[string]interface{}{
+			"action":    "*",
+			"effect":    effect,
+			"id":        id,
+			"principal": "Member",
+			"resource":  map[string]interface{}{"path": ".*"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return policy
+	}
+
+	BeforeEach(func() {
+		auth = schema.NewAuthorizationBuilder().
+			WithTenant(schema.Tenant{ID: "tenant", Name: "demo"}).
+			WithUser(schema.User{ID: "user", Name: "alice"}).
+			WithRoleIDs("Member").
+			BuildScopedToTenant()
+		allowPolicy = newPolicy("allow_member", "allow")
+		denyPolicy = newPolicy("deny_member", "deny")
+	})
+
+	Describe("Records", func() {
+		It("should record allow decision", func() {
+			record := NewRecord(auth, "read", "/v2.0/networks", allowPolicy, &schema.Role{Name: "Member"})
+			Expect(record.Decision).To(Equal(Allow))
+			Expect(record.PolicyID).To(Equal("allow_member"))
+			Expect(record.Principal).To(Equal("alice"))
+			Expect(record.UserID).To(Equal("user"))
+			Expect(record.Role).To(Equal("Member"))
+			Expect(record.TenantID).To(Equal("tenant"))
+			Expect(record.Roles).To(ConsistOf("Member"))
+		})
+
+		It("should record deny decision", func() {
+			record := NewRecord(auth, "read", "/v2.0/networks", denyPolicy, &schema.Role{Name: "Member"})
+			Expect(record.Decision).To(Equal(Deny))
+			Expect(record.PolicyID).To(Equal("deny_member"))
+		})
+
+		It("should record deny decision when no policy matched", func() {
+			record := NewRecord(auth, "read", "/v2.0/networks", nil, nil)
+			Expect(record.Decision).To(Equal(Deny))
+			Expect(record.PolicyID).To(BeEmpty())
+		})
+
+		It("should redact fields", func() {
+			record := NewRecord(auth, "read", "/v2.0/networks", nil, nil)
+			record.redact([]string{"tenant_name", "roles", "unknown"})
+			Expect(record.TenantName).To(Equal(redactedValue))
+			Expect(record.Roles).To(ConsistOf(redactedValue))
+			Expect(record.TenantID).To(Equal("tenant"))
+		})
+	})
+
+	Describe("Logger", func() {
+		var sink *memorySink
+
+		BeforeEach(func() {
+			sink = &memorySink{}
+		})
+
+		It("should log only denied requests by default", func() {
+			logger := NewLogger(sink, false, 100, nil, 10, time.Hour)
+			logger.Log(NewRecord(auth, "read", "/a", allowPolicy, nil))
+			logger.Log(NewRecord(auth, "read", "/b", nil, nil))
+			Expect(logger.Close()).To(Succeed())
+
+			Expect(sink.records).To(HaveLen(1))
+			Expect(sink.records[0].Path).To(Equal("/b"))
+		})
+
+		It("should log allowed requests according to sampling", func() {
+			logger := NewLogger(sink, true, 0, nil, 10, time.Hour)
+			logger.Log(NewRecord(auth, "read", "/a", allowPolicy, nil))
+			Expect(logger.Close()).To(Succeed())
+			Expect(sink.records).To(BeEmpty())
+
+			logger = NewLogger(sink, true, 100, nil, 10, time.Hour)
+			logger.Log(NewRecord(auth, "read", "/a", allowPolicy, nil))
+			Expect(logger.Close()).To(Succeed())
+			Expect(sink.records).To(HaveLen(1))
+		})
+
+		It("should drop records logged while closing", func() {
+			logger := NewLogger(sink, false, 100, nil, 10, time.Hour)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						logger.Log(NewRecord(auth, "read", "/a", nil, nil))
+					}
+				}()
+			}
+			Expect(logger.Close()).To(Succeed())
+			wg.Wait()
+			Expect(logger.Close()).To(Succeed())
+		})
+
+		It("should redact records", func() {
+			logger := NewLogger(sink, false, 100, []string{"path"}, 10, time.Hour)
+			logger.Log(NewRecord(auth, "read", "/a", nil, nil))
+			Expect(logger.Close()).To(Succeed())
+			Expect(sink.records[0].Path).To(Equal(redactedValue))
+		})
+	})
+
+	Describe("File sink", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "audit")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should write JSON lines", func() {
+			path := filepath.Join(dir, "audit.log")
+			sink, err := NewFileSink(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink.Write([]*Record{
+				NewRecord(auth, "read", "/a", allowPolicy, nil),
+				NewRecord(auth, "delete", "/b", denyPolicy, nil),
+			})).To(Succeed())
+			Expect(sink.Close()).To(Succeed())
+
+			file, err := os.Open(path)
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+			scanner := bufio.NewScanner(file)
+			decisions := []Decision{}
+			for scanner.Scan() {
+				record := Record{}
+				Expect(json.Unmarshal(scanner.Bytes(), &record)).To(Succeed())
+				decisions = append(decisions, record.Decision)
+			}
+			Expect(decisions).To(Equal([]Decision{Allow, Deny}))
+		})
+	})
+})
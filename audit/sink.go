@@ -0,0 +1,99 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+)
+
+const auditLogSchemaID = "audit_log"
+
+// FileSink writes audit records to a file, one JSON document per line
+type FileSink struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewFileSink opens the file for appending
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %s", path, err)
+	}
+	return &FileSink{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Write appends records to the file
+func (sink *FileSink) Write(records []*Record) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, record := range records {
+		if err := sink.encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file
+func (sink *FileSink) Close() error {
+	return sink.file.Close()
+}
+
+// DBSink writes audit records to the audit_log table
+type DBSink struct {
+	dataStore db.DB
+	schema    *schema.Schema
+}
+
+// NewDBSink creates a sink writing to the audit_log table of given database
+func NewDBSink(dataStore db.DB) (*DBSink, error) {
+	if dataStore == nil {
+		return nil, fmt.Errorf("no database available for audit log")
+	}
+	auditSchema, ok := schema.GetManager().Schema(auditLogSchemaID)
+	if !ok {
+		return nil, fmt.Errorf("Schema '%s' not found. Check if gohan.json is loaded", auditLogSchemaID)
+	}
+	return &DBSink{dataStore: dataStore, schema: auditSchema}, nil
+}
+
+// Write stores records in a single transaction
+func (sink *DBSink) Write(records []*Record) error {
+	return db.WithinTx(sink.dataStore, func(tx transaction.Transaction) error {
+		ctx := context.Background()
+		for _, record := range records {
+			if _, err := tx.Create(ctx, schema.NewResource(sink.schema, record.toMap())); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close does nothing as the database is owned by the server
+func (sink *DBSink) Close() error {
+	return nil
+}
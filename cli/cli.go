@@ -68,6 +68,10 @@ func Run(name, usage string) {
 		getGraceServerCommand(),
 		getGenerateCommand(),
 		getConverterCommand(),
+		getTenantCommand(),
+		getSyncCommand(),
+		getClusterCommand(),
+		getSecretsCommand(),
 	}
 	app.Run(os.Args)
 }
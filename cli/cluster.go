@@ -0,0 +1,97 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
+	sync_util "github.com/cloudwan/gohan/sync/util"
+	"github.com/urfave/cli"
+)
+
+func getClusterCommand() cli.Command {
+	return cli.Command{
+		Name:  "cluster",
+		Usage: "Manage leaders of the Gohan cluster",
+		Subcommands: []cli.Command{
+			getClusterLeadersCommand(),
+			getClusterResignCommand(),
+		},
+	}
+}
+
+func getClusterLeadersCommand() cli.Command {
+	return cli.Command{
+		Name:  "leaders",
+		Usage: "List leaders of cluster roles",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+		},
+		Action: func(c *cli.Context) {
+			sync := loadClusterSync(c.String("config-file"))
+			defer sync.Close()
+
+			leaders, err := election.List(context.Background(), sync)
+			if err != nil {
+				log.Fatalf("Failed to list leaders: %s", err)
+			}
+			output, _ := json.MarshalIndent(leaders, "", "\t")
+			fmt.Println(string(output))
+		},
+	}
+}
+
+func getClusterResignCommand() cli.Command {
+	return cli.Command{
+		Name:  "resign",
+		Usage: "Request the leader of a role to resign",
+		Description: "The leader steps down and doesn't campaign again for a while, " +
+			"so that another process takes over the role, e.g. before the node is drained.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+			cli.StringFlag{Name: "role", Value: "", Usage: "Role of the leader, e.g. sync_writer"},
+		},
+		Action: func(c *cli.Context) {
+			role := c.String("role")
+			if role == "" {
+				log.Fatal("Need to provide a role")
+			}
+			sync := loadClusterSync(c.String("config-file"))
+			defer sync.Close()
+
+			leader, err := election.RequestResign(context.Background(), sync, role)
+			if err != nil {
+				log.Fatalf("Failed to request resignation of %s: %s", role, err)
+			}
+			log.Info("Requested %s to resign from %s", leader.ProcessID, role)
+		},
+	}
+}
+
+func loadClusterSync(configFile string) gohan_sync.Sync {
+	sync, err := sync_util.CreateFromConfig(loadSyncConfig(configFile))
+	if err != nil {
+		log.Fatalf("Failed to create sync, err: %s", err)
+	}
+	if sync == nil {
+		log.Fatal("No sync backend configured")
+	}
+	return sync
+}
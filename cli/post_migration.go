@@ -132,7 +132,7 @@ func (e *postMigrationEventEmitter) publishPostMigrateEventWithServices(envName
 
 		pluralURL := s.GetPluralURL()
 
-		if _, ok := envManager.GetEnvironment(s.ID); !ok {
+		if !envManager.HasEnvironment(s.ID) {
 			now := time.Now()
 			left := deadline.Sub(now)
 			if now.After(deadline) {
@@ -150,14 +150,14 @@ func (e *postMigrationEventEmitter) publishPostMigrateEventWithServices(envName
 
 			log.Info("Loading environment for %s schema with URL: %s", s.ID, pluralURL)
 
-			if err := env.LoadExtensionsForPath(manager.Extensions, manager.TimeLimit, manager.TimeLimits, pluralURL); err != nil {
+			if err := env.LoadExtensionsForPath(manager.GetExtensions(), manager.TimeLimit, manager.TimeLimits, pluralURL); err != nil {
 				log.Fatal(fmt.Sprintf("[%s] %v", pluralURL, err))
 			}
 
 			envManager.RegisterEnvironment(s.ID, env)
 		}
 
-		env, _ := envManager.GetEnvironment(s.ID)
+		env, _ := envManager.AcquireEnvironment(s.ID)
 
 		eventContext := map[string]interface{}{}
 		eventContext["schema"] = s
@@ -171,6 +171,7 @@ func (e *postMigrationEventEmitter) publishPostMigrateEventWithServices(envName
 		if err := env.HandleEvent(eventPostMigration, eventContext); err != nil {
 			log.Fatalf("Failed to handle event '%s': %s", eventPostMigration, err)
 		}
+		envManager.ReleaseEnvironment(env)
 
 		if err := migration.UnmarkSchema(s.ID); err != nil {
 			log.Fatalf("Failed to remove '%s' from pending post migration schemas: %s", s.ID, err)
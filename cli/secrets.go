@@ -0,0 +1,134 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cloudwan/gohan/secret"
+	"github.com/cloudwan/gohan/util"
+	"github.com/urfave/cli"
+)
+
+func getSecretsCommand() cli.Command {
+	return cli.Command{
+		Name:  "secrets",
+		Usage: "Manage secrets of extensions",
+		Subcommands: []cli.Command{
+			getSecretsSetCommand(),
+			getSecretsGetCommand(),
+			getSecretsRotateCommand(),
+		},
+	}
+}
+
+func getSecretsSetCommand() cli.Command {
+	return cli.Command{
+		Name:      "set",
+		Usage:     "Store a secret",
+		ArgsUsage: "NAME [VALUE]",
+		Description: "Store a secret in the encrypted secrets file, the value is read from the standard input " +
+			"when it isn't given. Only extensions of paths matching --path may read the secret, " +
+			"all extensions may read a secret without --path.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+			cli.StringSliceFlag{Name: "path", Usage: "Regexp matching whole extension paths allowed to read the secret, can be repeated"},
+		},
+		Action: func(c *cli.Context) {
+			if c.NArg() < 1 || c.NArg() > 2 {
+				log.Fatal("Need to provide a secret name and optionally its value")
+			}
+			value := c.Args().Get(1)
+			if c.NArg() == 1 {
+				line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && line == "" {
+					log.Fatalf("Failed to read the secret value: %s", err)
+				}
+				value = strings.TrimRight(line, "\r\n")
+			}
+			store := loadSecretStore(c.String("config-file"))
+			if err := store.Set(c.Args().First(), value, c.StringSlice("path")); err != nil {
+				log.Fatalf("Failed to store secret: %s", err)
+			}
+		},
+	}
+}
+
+func getSecretsGetCommand() cli.Command {
+	return cli.Command{
+		Name:      "get",
+		Usage:     "Print a secret",
+		ArgsUsage: "NAME",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+		},
+		Action: func(c *cli.Context) {
+			if c.NArg() != 1 {
+				log.Fatal("Need to provide a secret name")
+			}
+			store := loadSecretStore(c.String("config-file"))
+			found, err := store.Lookup(c.Args().First())
+			if err != nil {
+				log.Fatalf("Failed to get secret: %s", err)
+			}
+			fmt.Println(found.Value)
+		},
+	}
+}
+
+func getSecretsRotateCommand() cli.Command {
+	return cli.Command{
+		Name:  "rotate",
+		Usage: "Encrypt secrets with a new key",
+		Description: "Generate a new key, encrypt the secrets file with it and remove previous keys from the key file. " +
+			"Running servers load the re-encrypted secrets file.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+		},
+		Action: func(c *cli.Context) {
+			store := loadSecretStore(c.String("config-file"))
+			if err := store.Rotate(); err != nil {
+				log.Fatalf("Failed to rotate the key: %s", err)
+			}
+		},
+	}
+}
+
+func loadSecretStore(configFile string) *secret.Store {
+	config := util.GetConfig()
+	if configFile == "" {
+		log.Fatal("Need to provide server config file")
+	}
+	if err := config.ReadConfig(configFile); err != nil {
+		log.Fatalf("Error while loading server config file: %s", err)
+	}
+	if err := os.Chdir(path.Dir(configFile)); err != nil {
+		log.Fatalf("Chdir error: %s", err)
+	}
+
+	store := secret.NewStoreFromConfig(config)
+	if store == nil {
+		log.Fatal("No secrets file configured, set secrets/file in the config")
+	}
+	if err := store.Load(); err != nil {
+		log.Fatalf("Failed to load secrets: %s", err)
+	}
+	return store
+}
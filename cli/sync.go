@@ -0,0 +1,171 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/dbutil"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/namespace"
+	sync_util "github.com/cloudwan/gohan/sync/util"
+	"github.com/cloudwan/gohan/util"
+	"github.com/urfave/cli"
+)
+
+func getSyncCommand() cli.Command {
+	return cli.Command{
+		Name:  "sync",
+		Usage: "Manage the sync (etcd) backend",
+		Subcommands: []cli.Command{
+			getSyncVerifyCommand(),
+			getSyncMovePrefixCommand(),
+		},
+	}
+}
+
+func getSyncVerifyCommand() cli.Command {
+	return cli.Command{
+		Name:  "verify",
+		Usage: "Compare syncable resources with the sync backend",
+		Description: "Report keys of resources missing or stale in the sync backend, and orphaned keys without a resource. " +
+			"With --repair, only the differences are written: events are re-emitted for missing and stale resources " +
+			"and orphaned keys are deleted. Exits with status 1 when drift was found and not repaired.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+			cli.BoolFlag{Name: "repair", Usage: "Repair the differences"},
+		},
+		Action: func(c *cli.Context) {
+			dbConn, sync := loadSyncEnvironment(c.String("config-file"))
+			ctx := context.Background()
+
+			report, err := server.NewSyncVerifier(dbConn, sync).Verify(ctx, c.Bool("repair"))
+			if report != nil {
+				output, _ := json.MarshalIndent(report, "", "\t")
+				fmt.Println(string(output))
+			}
+			if err != nil {
+				log.Fatalf("Sync verify failed: %s", err)
+			}
+			if !report.HasDrift() {
+				return
+			}
+			if !report.Repair {
+				os.Exit(1)
+			}
+			if _, err := server.NewSyncWriter(sync, dbConn).Sync(ctx); err != nil {
+				log.Fatalf("Error when syncing events: %s", err)
+			}
+		},
+	}
+}
+
+func getSyncMovePrefixCommand() cli.Command {
+	return cli.Command{
+		Name:  "move-prefix",
+		Usage: "Move keys of Gohan to another cluster prefix",
+		Description: "Move keys used by Gohan from one cluster prefix (sync_prefix) to another. " +
+			"By default keys under Gohan paths, paths of syncable schemas and watched keys are moved, " +
+			"keys of extensions outside of these paths have to be added with --path. " +
+			"Locks and process keys are not moved. All Gohan processes must be stopped.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+			cli.StringFlag{Name: "from", Value: "", Usage: "Current cluster prefix, empty for keys without a prefix"},
+			cli.StringFlag{Name: "to", Value: "", Usage: "New cluster prefix, defaults to sync_prefix of the config"},
+			cli.StringSliceFlag{Name: "path", Usage: "Additional path to move, can be repeated"},
+			cli.BoolFlag{Name: "dry-run", Usage: "Only print keys which would be moved"},
+		},
+		Action: func(c *cli.Context) {
+			config := loadSyncConfig(c.String("config-file"))
+			raw, err := sync_util.CreateBackendFromConfig(config)
+			if err != nil {
+				log.Fatalf("Failed to create sync, err: %s", err)
+			}
+			if raw == nil {
+				log.Fatal("No sync backend configured")
+			}
+			defer raw.Close()
+
+			to := c.String("to")
+			if !c.IsSet("to") {
+				to = config.GetString("sync_prefix", "")
+			}
+			paths, leased := server.SyncPaths()
+			moved, err := namespace.Move(context.Background(), raw, namespace.MoveOptions{
+				From:    c.String("from"),
+				To:      to,
+				Paths:   append(paths, c.StringSlice("path")...),
+				Exclude: append(leased, syncMigrationsPath),
+				DryRun:  c.Bool("dry-run"),
+			})
+			for _, key := range moved {
+				fmt.Println(key)
+			}
+			if err != nil {
+				log.Fatalf("Failed to move keys: %s", err)
+			}
+			log.Info("Moved %d keys from %q to %q", len(moved), namespace.NormalizePrefix(c.String("from")), namespace.NormalizePrefix(to))
+		},
+	}
+}
+
+func loadSyncEnvironment(configFile string) (db.DB, gohan_sync.Sync) {
+	config := loadSyncConfig(configFile)
+
+	dbConn, err := dbutil.CreateFromConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to create db conn, err: %s", err)
+	}
+
+	sync, err := sync_util.CreateFromConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to create sync, err: %s", err)
+	}
+	if sync == nil {
+		log.Fatal("No sync backend configured")
+	}
+	return dbConn, sync
+}
+
+func loadSyncConfig(configFile string) *util.Config {
+	config := util.GetConfig()
+	if configFile == "" {
+		log.Fatal("Need to provide server config file")
+	}
+	if err := config.ReadConfig(configFile); err != nil {
+		log.Fatalf("Error while loading server config file: %s", err)
+	}
+	if err := os.Chdir(path.Dir(configFile)); err != nil {
+		log.Fatalf("Chdir error: %s", err)
+	}
+
+	schemaFiles := config.GetStringList("schemas", nil)
+	if schemaFiles == nil {
+		log.Fatal("No schema specified in configuration")
+	}
+	log.Info("Loading schemas %s", schemaFiles)
+	if err := schema.GetManager().LoadSchemasFromFiles(schemaFiles...); err != nil {
+		log.Fatalf("Error when loading schemas: %s", err)
+	}
+	return config
+}
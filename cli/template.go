@@ -291,6 +291,12 @@ func doTemplate(c *cli.Context) {
 		util.ExitFatal(err)
 		return
 	}
+	roleHierarchy, err := schema.NewRoleHierarchyFromConfig(config)
+	if err != nil {
+		util.ExitFatal(err)
+		return
+	}
+	manager.SetRoleHierarchy(roleHierarchy)
 	templateCode, err := util.GetContent(template)
 	if err != nil {
 		util.ExitFatal(err)
@@ -624,7 +630,7 @@ func isMatchingPolicy(action schema.Action, policy *schema.Policy) bool {
 }
 
 func policyMatchesPrincipal(policy *schema.Policy, principal string) bool {
-	return policy.Principal == principal
+	return schema.GetManager().RoleHierarchy().Implies(principal, policy.Principal)
 }
 
 func policyMatchesScopes(policy *schema.Policy, scopes []schema.Scope) bool {
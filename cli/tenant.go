@@ -0,0 +1,66 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudwan/gohan/server"
+	"github.com/urfave/cli"
+)
+
+func getTenantCommand() cli.Command {
+	return cli.Command{
+		Name:  "tenant",
+		Usage: "Manage resources of tenants",
+		Subcommands: []cli.Command{
+			getTenantPurgeCommand(),
+		},
+	}
+}
+
+func getTenantPurgeCommand() cli.Command {
+	return cli.Command{
+		Name:      "purge",
+		Usage:     "Delete all resources of a tenant",
+		ArgsUsage: "<tenant_id>",
+		Description: "Delete all resources of a tenant in reverse schema dependency order, running delete extensions. " +
+			"An interrupted purge is resumed from the last checkpoint when run again.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: defaultConfigFile, Usage: "Server config File"},
+			cli.BoolFlag{Name: "dry-run", Usage: "Only list resources which would be deleted"},
+		},
+		Action: func(c *cli.Context) {
+			if c.NArg() != 1 {
+				log.Fatal("Tenant ID is required")
+			}
+			srv, err := server.NewServer(c.String("config-file"))
+			if err != nil {
+				log.Fatal(err)
+			}
+			report, err := server.NewTenantPurger(srv).Purge(context.Background(), c.Args().First(), c.Bool("dry-run"))
+			if report != nil {
+				output, _ := json.MarshalIndent(report, "", "\t")
+				fmt.Println(string(output))
+			}
+			if err != nil {
+				log.Fatalf("Tenant purge failed: %s", err)
+			}
+		},
+	}
+}
@@ -101,7 +101,7 @@ func (client *keystoneV3Client) VerifyToken(token string) (schema.Authorization,
 		return nil, fmt.Errorf("Error during verifying token: %s", tokenResult.Err.Error())
 	}
 	_, err := tokenResult.ExtractToken()
-	_, ok := tokenResult.Body.(map[string]interface{})
+	body, ok := tokenResult.Body.(map[string]interface{})
 	// tricky gophercloud behavior.
 	// If system token doesn't need reauth, err is set when token is invalid
 	// If system token needed reauth and user token is invalid, err wont be propagated neither by return nor Err field,
@@ -122,6 +122,8 @@ func (client *keystoneV3Client) VerifyToken(token string) (schema.Authorization,
 	for _, r := range roles {
 		roleIDs = append(roleIDs, r.Name)
 	}
+	authMethods := extractAuthMethods(body)
+	user := extractUser(body)
 
 	// Get project/tenant
 	project, err := tokenResult.ExtractProject()
@@ -141,7 +143,9 @@ func (client *keystoneV3Client) VerifyToken(token string) (schema.Authorization,
 		builder := schema.NewAuthorizationBuilder().
 			WithTenant(tenant).
 			WithDomain(domain).
-			WithRoleIDs(roleIDs...)
+			WithUser(user).
+			WithRoleIDs(roleIDs...).
+			WithAuthMethods(authMethods...)
 
 		if isTokenScopedToAdminProject(&tokenResult) {
 			if err := client.validateRolesForAdmin(roleIDs); err != nil {
@@ -167,7 +171,9 @@ func (client *keystoneV3Client) VerifyToken(token string) (schema.Authorization,
 		}
 		auth := schema.NewAuthorizationBuilder().
 			WithDomain(domain).
+			WithUser(user).
 			WithRoleIDs(roleIDs...).
+			WithAuthMethods(authMethods...).
 			BuildScopedToDomain()
 		return auth, nil
 	}
@@ -224,6 +230,27 @@ func extractDomain(result *v3tokens.GetResult) (*v3tokens.Domain, error) {
 	return s.Domain, err
 }
 
+// extractAuthMethods returns methods used to obtain the token, e.g. password or totp
+func extractAuthMethods(body map[string]interface{}) []string {
+	token, _ := body["token"].(map[string]interface{})
+	rawMethods, _ := token["methods"].([]interface{})
+	methods := []string{}
+	for _, rawMethod := range rawMethods {
+		if method, ok := rawMethod.(string); ok {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+func extractUser(body map[string]interface{}) schema.User {
+	token, _ := body["token"].(map[string]interface{})
+	rawUser, _ := token["user"].(map[string]interface{})
+	id, _ := rawUser["id"].(string)
+	name, _ := rawUser["name"].(string)
+	return schema.User{ID: id, Name: name}
+}
+
 func isTokenScopedToAdminProject(result *v3tokens.GetResult) bool {
 	var s struct {
 		IsAdminProject bool `json:"is_admin_project"`
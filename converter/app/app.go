@@ -132,10 +132,22 @@ func Run(params ConverterParams) error {
 		return err
 	}
 
-	return writeResult(
+	if err = writeResult(
 		[]string{util.Const(generated.Names)},
 		params.SchemasPackage,
 		params.Output,
 		"names.go",
+	); err != nil {
+		return err
+	}
+
+	if len(generated.Columns) == 0 {
+		return nil
+	}
+	return writeResult(
+		append([]string{`import "github.com/cloudwan/gohan/extension/goext/filter"` + "\n"}, generated.Columns...),
+		params.SchemasPackage,
+		params.Output,
+		"columns.go",
 	)
 }
@@ -443,3 +443,17 @@ func (object *Object) GenerateSchemaName(goextPackage, typename string) string {
 		object.Name(),
 	)
 }
+
+// GenerateColumns creates a variable holding columns of an object used in filters
+func (object *Object) GenerateColumns(filterPackage string) string {
+	properties := object.properties.ToArray()
+	code := "var " + util.ToGoName(object.Name(), "") + "Columns = struct {\n"
+	for _, property := range properties {
+		code += fmt.Sprintf("\t%s %s.Column\n", property.(*Property).goName(), filterPackage)
+	}
+	code += "}{\n"
+	for _, property := range properties {
+		code += fmt.Sprintf("\t%s: \"%s\",\n", property.(*Property).goName(), property.(*Property).Name())
+	}
+	return code + "}\n"
+}
@@ -1278,6 +1278,25 @@ var _ = Describe("object tests", func() {
 		})
 	})
 
+	Describe("generate columns tests", func() {
+		It("Should generate columns", func() {
+			propertiesSet := set.New()
+			propertiesSet.Insert(CreateProperty("id"))
+			propertiesSet.Insert(CreateProperty("network_id"))
+			object := &Object{objectType: "snake_name", properties: propertiesSet}
+			actual := object.GenerateColumns("filter")
+			expected := `var SnakeNameColumns = struct {
+	ID filter.Column
+	NetworkID filter.Column
+}{
+	ID: "id",
+	NetworkID: "network_id",
+}
+`
+			Expect(actual).To(Equal(expected))
+		})
+	})
+
 	Describe("generate schema name tests", func() {
 		It("Should generate schema name", func() {
 			object := &Object{objectType: "snake_name"}
@@ -78,6 +78,7 @@ func Convert(
 		result.Names = append(result.Names, object.GenerateSchemaName(packageName, "SchemaID"))
 
 		if !object.Empty() {
+			result.Columns = append(result.Columns, object.GenerateColumns("filter"))
 			for _, raw := range boolean {
 				for _, lock := range boolean {
 					for _, filter := range boolean {
@@ -24,5 +24,6 @@ type Generated struct {
 	Implementations,
 	RawCrud,
 	Crud,
-	Names []string
+	Names,
+	Columns []string
 }
@@ -31,6 +31,11 @@ func NewSearchField(value string) Search {
 	return Search{Value: searchValue.String()}
 }
 
+// NewPrefixField creates a search matching strings starting with value
+func NewPrefixField(value string) Search {
+	return Search{Value: escapeSpecialChars(value) + "%"}
+}
+
 func escapeSpecialChars(value string) string{
 	value = strings.Replace(value, "\\", "\\\\", -1)
 	value = strings.Replace(value, "_", "\\_", -1)
@@ -1,7 +1,9 @@
 package sql
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/cloudwan/gohan/db/search"
@@ -12,6 +14,8 @@ const (
 	OrCondition   = "__or__"
 	AndCondition  = "__and__"
 	BoolCondition = "__bool__"
+	// OutOfSyncCondition matches resources of state versioned schemas whose state_version differs from config_version
+	OutOfSyncCondition = "__out_of_sync__"
 )
 
 type queryBuilder interface {
@@ -65,6 +69,9 @@ func addFilterToQuery(s *schema.Schema, q queryBuilder, filter map[string]interf
 			}
 			q.Where(andFilter)
 			continue
+		} else if key == OutOfSyncCondition {
+			q.Where(outOfSyncCondition(s, value, join))
+			continue
 		} else if b, ok := filter[BoolCondition]; ok {
 			if b.(bool) {
 				q.Where("(1=1)")
@@ -74,19 +81,11 @@ func addFilterToQuery(s *schema.Schema, q queryBuilder, filter map[string]interf
 			continue
 		}
 
-		property, err := s.GetPropertyByID(key)
-
+		column, property, err := filterColumn(s, key, join)
 		if err != nil {
 			return err
 		}
 
-		var column string
-		if join {
-			column = makeColumn(s.GetDbTableName(), *property)
-		} else {
-			column = quote(key)
-		}
-
 		substr, ok := value.(search.Search)
 		if ok {
 			q.Where(Like{column: substr.Value})
@@ -118,8 +117,97 @@ func addAndToQuery(s *schema.Schema, q queryBuilder, filter interface{}, join bo
 	return addToFilter(s, q, filter, join, sq.And{})
 }
 
+// filterList returns filters of a conjunction or disjunction, which are lists of maps when passed from javascript
+func filterList(filter interface{}) ([]map[string]interface{}, error) {
+	switch filter := filter.(type) {
+	case []map[string]interface{}:
+		return filter, nil
+	case []interface{}:
+		filters := make([]map[string]interface{}, 0, len(filter))
+		for _, element := range filter {
+			elementMap, ok := element.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("filter has to be an object, got %T", element)
+			}
+			filters = append(filters, elementMap)
+		}
+		return filters, nil
+	default:
+		return nil, fmt.Errorf("filters have to be a list, got %T", filter)
+	}
+}
+
+// filterColumn returns the column of a filtered property, properties of related schemas are
+// preceded by their relation properties separated by dots, e.g. network.name, and require a join
+func filterColumn(s *schema.Schema, key string, join bool) (string, *schema.Property, error) {
+	path := strings.Split(key, ".")
+	tableName := s.GetDbTableName()
+	for _, relationProperty := range path[:len(path)-1] {
+		if !join {
+			return "", nil, fmt.Errorf("filtering by %s requires joining related schemas", key)
+		}
+		relation, err := relationPropertyOf(s, relationProperty)
+		if err != nil {
+			return "", nil, err
+		}
+		related, ok := schema.GetManager().Schema(relation.Relation)
+		if !ok {
+			return "", nil, fmt.Errorf("missing schema %s", relation.Relation)
+		}
+		tableName = makeAliasTableName(tableName, *relation)
+		s = related
+	}
+	property, err := s.GetPropertyByID(path[len(path)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	if join {
+		return makeColumn(tableName, *property), property, nil
+	}
+	return quote(property.ID), property, nil
+}
+
+func relationPropertyOf(s *schema.Schema, relationProperty string) (*schema.Property, error) {
+	for i := range s.Properties {
+		if s.Properties[i].RelationProperty == relationProperty {
+			return &s.Properties[i], nil
+		}
+	}
+	return nil, fmt.Errorf("relation property %s not found in schema %s", relationProperty, s.ID)
+}
+
+// filterUsesRelations checks whether a filter refers to properties of related schemas
+func filterUsesRelations(filter map[string]interface{}) bool {
+	for key, value := range filter {
+		switch key {
+		case OrCondition, AndCondition:
+			filters, err := filterList(value)
+			if err != nil {
+				continue
+			}
+			for _, nested := range filters {
+				if filterUsesRelations(nested) {
+					return true
+				}
+			}
+		case "property":
+			if property, ok := value.(string); ok && strings.Contains(property, ".") {
+				return true
+			}
+		default:
+			if strings.Contains(key, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func addToFilter(s *schema.Schema, q queryBuilder, filter interface{}, join bool, sqlizer []sq.Sqlizer) ([]sq.Sqlizer, error) {
-	filters := filter.([]map[string]interface{})
+	filters, err := filterList(filter)
+	if err != nil {
+		return nil, err
+	}
 	for _, filter := range filters {
 		if match, ok := filter[OrCondition]; ok {
 			res, err := addOrToQuery(s, q, match, join)
@@ -133,6 +221,8 @@ func addToFilter(s *schema.Schema, q queryBuilder, filter interface{}, join bool
 				return nil, err
 			}
 			sqlizer = append(sqlizer, res)
+		} else if match, ok := filter[OutOfSyncCondition]; ok {
+			sqlizer = append(sqlizer, outOfSyncCondition(s, match, join))
 		} else if b, ok := filter[BoolCondition]; ok {
 			if b.(bool) {
 				sqlizer = append(sqlizer, sq.Expr("(1=1)"))
@@ -140,19 +230,15 @@ func addToFilter(s *schema.Schema, q queryBuilder, filter interface{}, join bool
 				sqlizer = append(sqlizer, sq.Expr("(1=0)"))
 			}
 		} else {
-			key := filter["property"].(string)
-			property, err := s.GetPropertyByID(key)
+			key, ok := filter["property"].(string)
+			if !ok {
+				return nil, fmt.Errorf("filter property has to be a string, got %T", filter["property"])
+			}
+			column, property, err := filterColumn(s, key, join)
 			if err != nil {
 				return nil, err
 			}
 
-			var column string
-			if join {
-				column = makeColumn(s.GetDbTableName(), *property)
-			} else {
-				column = quote(key)
-			}
-
 			value := filter["value"]
 			substr, ok := value.(search.Search)
 			if ok {
@@ -166,10 +252,54 @@ func addToFilter(s *schema.Schema, q queryBuilder, filter interface{}, join bool
 				sqlizer = append(sqlizer, sq.Eq{column: value})
 			case "neq":
 				sqlizer = append(sqlizer, sq.NotEq{column: value})
+			case "gt":
+				sqlizer = append(sqlizer, sq.Gt{column: value})
+			case "gte":
+				sqlizer = append(sqlizer, sq.GtOrEq{column: value})
+			case "lt":
+				sqlizer = append(sqlizer, sq.Lt{column: value})
+			case "lte":
+				sqlizer = append(sqlizer, sq.LtOrEq{column: value})
+			case "like", "prefix":
+				pattern, ok := value.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s filter of %s has to be a string, got %T", filter["type"], key, value)
+				}
+				if filter["type"] == "prefix" {
+					pattern = search.NewPrefixField(pattern).Value
+				}
+				sqlizer = append(sqlizer, Like{column: pattern})
+			case "in", "not_in":
+				if !isListType(value) {
+					return nil, fmt.Errorf("%s filter of %s has to be a list, got %T", filter["type"], key, value)
+				}
+				if filter["type"] == "in" {
+					sqlizer = append(sqlizer, sq.Eq{column: value})
+				} else {
+					sqlizer = append(sqlizer, sq.NotEq{column: value})
+				}
+			case "null":
+				if isNull, _ := value.(bool); isNull {
+					sqlizer = append(sqlizer, sq.Eq{column: nil})
+				} else {
+					sqlizer = append(sqlizer, sq.NotEq{column: nil})
+				}
 			default:
-				panic("type has to be one of [eq, neq]")
+				return nil, fmt.Errorf("filter type of %s has to be one of [eq, neq, gt, gte, lt, lte, like, prefix, in, not_in, null], got %v", key, filter["type"])
 			}
 		}
 	}
 	return sqlizer, nil
 }
+
+func outOfSyncCondition(s *schema.Schema, outOfSync interface{}, join bool) sq.Sqlizer {
+	configVersion, stateVersion := quote(configVersionColumnName), quote(stateVersionColumnName)
+	if join {
+		configVersion = fmt.Sprintf("%s.%s", quote(s.GetDbTableName()), configVersion)
+		stateVersion = fmt.Sprintf("%s.%s", quote(s.GetDbTableName()), stateVersion)
+	}
+	if b, ok := outOfSync.(bool); ok && !b {
+		return sq.Expr(fmt.Sprintf("%s = %s", configVersion, stateVersion))
+	}
+	return sq.Expr(fmt.Sprintf("%s <> %s", configVersion, stateVersion))
+}
@@ -40,8 +40,7 @@ import (
 
 	// Import mysql lib
 	_ "github.com/go-sql-driver/mysql"
-	// Import go-sqlite3 lib
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
@@ -319,13 +318,36 @@ func (db *DB) updateCounter(delta int64, counter string) {
 	metrics.UpdateCounter(delta, "db.%s", counter)
 }
 
+// sqliteForeignKeysDriver is sqlite3 enabling foreign keys on every connection of the pool.
+// PRAGMA foreign_keys is a no-op within a transaction, so connections opened
+// by concurrent transactions would otherwise not check foreign keys.
+// It's registered under its own name, as a connection string can't set
+// parameters of an empty (temporary) database.
+const sqliteForeignKeysDriver = "sqlite3_foreign_keys"
+
+func init() {
+	sql.Register(sqliteForeignKeysDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec("PRAGMA foreign_keys = ON;", nil)
+			return err
+		},
+	})
+}
+
+func driverName(sqlType, conn string) string {
+	if sqlType != "sqlite3" || strings.Contains(conn, "_foreign_keys=") || strings.Contains(conn, "_fk=") {
+		return sqlType
+	}
+	return sqliteForeignKeysDriver
+}
+
 //Connect connects to the db
 func (db *DB) Connect(sqlType, conn string, maxOpenConn int) (err error) {
 	defer db.measureTime(time.Now(), "connect")
 
 	db.sqlType = sqlType
 	db.connectionString = conn
-	rawDB, err := sql.Open(db.sqlType, db.connectionString)
+	rawDB, err := sql.Open(driverName(db.sqlType, conn), db.connectionString)
 	if err != nil {
 		return err
 	}
@@ -822,7 +844,9 @@ func buildSelect(sc *selectContext) (string, []interface{}, error) {
 
 	cols := MakeColumns(sc.schema, t, sc.fields, sc.join)
 	q := sq.Select(cols...).From(quote(t))
-	q, err := AddFilterToSelectQuery(sc.schema, q, sc.filter, sc.join)
+	// related schemas are joined to filter by their properties even without details
+	join := sc.join || filterUsesRelations(sc.filter)
+	q, err := AddFilterToSelectQuery(sc.schema, q, sc.filter, join)
 	if err != nil {
 		return "", nil, err
 	}
@@ -842,7 +866,7 @@ func buildSelect(sc *selectContext) (string, []interface{}, error) {
 			q = q.Offset(sc.paginator.Offset)
 		}
 	}
-	if sc.join {
+	if join {
 		q = makeJoin(sc.schema, t, q)
 	}
 	return q.ToSql()
@@ -1016,12 +1040,20 @@ func (tx *Transaction) decode(s *schema.Schema, tableName string, skipNil, recur
 func (tx *Transaction) Count(ctx context.Context, s *schema.Schema, filter transaction.Filter) (res uint64, err error) {
 	defer tx.measureTime(time.Now(), s.ID, "count")
 
-	q := sq.Select("Count(id) as count").From(quote(s.GetDbTableName()))
+	t := s.GetDbTableName()
+	join := filterUsesRelations(filter)
+	q := sq.Select("Count(id) as count").From(quote(t))
+	if join {
+		q = sq.Select(fmt.Sprintf("Count(%s.id) as count", quote(t))).From(quote(t))
+	}
 	//Filter get already tested
-	q, err = AddFilterToSelectQuery(s, q, filter, false)
+	q, err = AddFilterToSelectQuery(s, q, filter, join)
 	if err != nil {
 		return
 	}
+	if join {
+		q = makeJoin(s, t, q)
+	}
 	sql, args, err := q.ToSql()
 	if err != nil {
 		return
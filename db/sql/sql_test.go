@@ -17,10 +17,12 @@ package sql_test
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/cloudwan/gohan/db"
@@ -31,6 +33,7 @@ import (
 	"github.com/cloudwan/gohan/db/search"
 	. "github.com/cloudwan/gohan/db/sql"
 	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/extension/goext/filter"
 	"github.com/cloudwan/gohan/schema"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -818,6 +821,111 @@ var _ = Describe("Sql", func() {
 			)
 		})
 	})
+
+	Describe("Typed filters", func() {
+		var query squirrel.SelectBuilder
+
+		BeforeEach(func() {
+			query = squirrel.Select("*").From("`tests`")
+		})
+
+		DescribeTable("should map predicates to sql",
+			func(predicate filter.FilterElem, expectedSql string, expectedParams ...interface{}) {
+				res, err := AddFilterToSelectQuery(testSchema, query, filter.And(predicate), false)
+				Expect(err).ToNot(HaveOccurred())
+				resSql, params, err := res.ToSql()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resSql).To(Equal("SELECT * FROM `tests` WHERE (" + expectedSql + ")"))
+				if len(expectedParams) == 0 {
+					Expect(params).To(BeEmpty())
+				} else {
+					Expect(params).To(Equal(expectedParams))
+				}
+			},
+			Entry("gt", filter.Gt("test_integer", 1), "`test_integer` > ?", 1),
+			Entry("gte", filter.Gte("test_integer", 1), "`test_integer` >= ?", 1),
+			Entry("lt", filter.Lt("test_integer", 1), "`test_integer` < ?", 1),
+			Entry("lte", filter.Lte("test_integer", 1), "`test_integer` <= ?", 1),
+			Entry("like", filter.Like("test_string", "o_j%"), "`test_string` LIKE ? ESCAPE '\\\\'", "o_j%"),
+			Entry("prefix", filter.Prefix("test_string", "o_j%"), "`test_string` LIKE ? ESCAPE '\\\\'", "o\\_j\\%%"),
+			Entry("in", filter.In("test_integer", 1, 2), "`test_integer` IN (?,?)", 1, 2),
+			Entry("not in", filter.NotIn("test_integer", 1, 2), "`test_integer` NOT IN (?,?)", 1, 2),
+			Entry("null", filter.IsNull("test_string"), "`test_string` IS NULL"),
+			Entry("not null", filter.IsNotNull("test_string"), "`test_string` IS NOT NULL"),
+			Entry("column", filter.Column("test_integer").Gt(1), "`test_integer` > ?", 1),
+		)
+
+		It("should reject invalid predicates", func() {
+			for _, predicate := range []filter.FilterElem{
+				filter.Predicate("test_integer", "between", 1),
+				filter.Predicate("test_integer", "in", 1),
+				filter.Predicate("test_integer", "like", 1),
+				filter.Eq("test_string.name", "value"),
+			} {
+				_, err := AddFilterToSelectQuery(testSchema, query, filter.And(predicate), false)
+				Expect(err).To(HaveOccurred(), fmt.Sprint(predicate))
+			}
+		})
+
+		It("should list resources matching predicates", func() {
+			list, total, err := tx.List(ctx, testSchema, filter.And(
+				filter.Gt("test_integer", 0),
+				filter.IsNotNull("test_string"),
+				filter.NotIn("id", "3"),
+			), nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(BeEquivalentTo(1))
+			Expect(list[0].ID()).To(Equal("2"))
+		})
+
+		It("should list resources matching predicates passed from javascript", func() {
+			list, _, err := tx.List(ctx, testSchema, map[string]interface{}{
+				"__or__": []interface{}{
+					map[string]interface{}{"property": "test_integer", "type": "lt", "value": 0},
+					map[string]interface{}{"property": "id", "type": "in", "value": []interface{}{"3"}},
+				},
+			}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			ids := []string{}
+			for _, resource := range list {
+				ids = append(ids, resource.ID())
+			}
+			Expect(ids).To(ConsistOf("1", "3"))
+		})
+
+		It("should filter by properties of related schemas", func() {
+			serverSchema, ok := schema.GetManager().Schema("server")
+			Expect(ok).To(BeTrue())
+			networkSchema, ok := schema.GetManager().Schema("network")
+			Expect(ok).To(BeTrue())
+			create := func(s *schema.Schema, properties map[string]interface{}) {
+				resource := schema.NewResource(s, properties)
+				Expect(resource.PopulateDefaults()).To(Succeed())
+				_, err := tx.Create(ctx, resource)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			create(networkSchema, map[string]interface{}{"id": "red", "name": "red network", "tenant_id": "tenant"})
+			create(networkSchema, map[string]interface{}{"id": "blue", "name": "blue network", "tenant_id": "tenant"})
+			create(serverSchema, map[string]interface{}{"id": "in_red", "network_id": "red", "tenant_id": "tenant"})
+			create(serverSchema, map[string]interface{}{"id": "in_blue", "network_id": "blue", "tenant_id": "tenant"})
+
+			networkName := filter.Column("name").Of("network")
+			list, total, err := tx.List(ctx, serverSchema, filter.And(networkName.Eq("red network")), nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(BeEquivalentTo(1))
+			Expect(list[0].ID()).To(Equal("in_red"))
+
+			paginator, err := pagination.NewPaginator(pagination.OptionLimit(1))
+			Expect(err).ToNot(HaveOccurred())
+			list, total, err = tx.List(ctx, serverSchema, map[string]interface{}{"network.name": "blue network"}, nil, paginator)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(BeEquivalentTo(1))
+			Expect(list).To(HaveLen(1))
+			Expect(list[0].ID()).To(Equal("in_blue"))
+
+			Expect(tx.DeleteFilter(ctx, serverSchema, filter.And(networkName.Eq("red network")))).ToNot(Succeed())
+		})
+	})
 })
 
 func quote(str string) string {
@@ -836,3 +944,115 @@ func readFixtures(path string, v interface{}) {
 		panic("failed parse test fixtures")
 	}
 }
+
+var _ = Describe("Sqlite connections", func() {
+	DescribeTable("should check foreign keys on every connection",
+		func(conn string) {
+			dbc, err := dbutil.ConnectDB("sqlite3", conn, 2, options.Default())
+			Expect(err).ToNot(HaveOccurred())
+			defer dbc.Close()
+			sqlConn := dbc.(*DB)
+
+			ctx := context.Background()
+			first, err := sqlConn.DB.Conn(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			defer first.Close()
+			second, err := sqlConn.DB.Conn(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			defer second.Close()
+			for _, c := range []interface {
+				QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+			}{first, second} {
+				var enabled int
+				Expect(c.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled)).To(Succeed())
+				Expect(enabled).To(Equal(1))
+			}
+
+			_, err = os.Stat("?_foreign_keys=1")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		},
+		Entry("temporary database", ""),
+		Entry("in-memory database", ":memory:"),
+	)
+
+	Context("With a database shared by the connections", func() {
+		const (
+			dbFile   = "./foreign_keys_test.db"
+			poolSize = 4
+		)
+
+		var sqlConn *DB
+
+		insertChild := func(ctx context.Context, tx *sql.Tx, id, parentID string) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO children (id, parent_id) VALUES (?, ?)", id, parentID)
+			return err
+		}
+
+		BeforeEach(func() {
+			os.Remove(dbFile)
+			dbc, err := dbutil.ConnectDB("sqlite3", dbFile+"?_busy_timeout=5000", poolSize, options.Default())
+			Expect(err).ToNot(HaveOccurred())
+			sqlConn = dbc.(*DB)
+			_, err = sqlConn.DB.Exec("CREATE TABLE parents (id TEXT PRIMARY KEY)")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = sqlConn.DB.Exec("CREATE TABLE children (id TEXT PRIMARY KEY, parent_id TEXT REFERENCES parents(id))")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = sqlConn.DB.Exec("INSERT INTO parents (id) VALUES ('parent')")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			sqlConn.Close()
+			os.Remove(dbFile)
+		})
+
+		It("should enforce foreign keys in transactions of every pooled connection", func() {
+			ctx := context.Background()
+			conns := make([]*sql.Conn, poolSize)
+			for i := range conns {
+				var err error
+				conns[i], err = sqlConn.DB.Conn(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				defer conns[i].Close()
+			}
+
+			for i, conn := range conns {
+				tx, err := conn.BeginTx(ctx, nil)
+				Expect(err).ToNot(HaveOccurred())
+				defer tx.Rollback()
+				Expect(insertChild(ctx, tx, fmt.Sprintf("orphan%d", i), "missing")).To(MatchError(ContainSubstring("FOREIGN KEY constraint failed")))
+				Expect(insertChild(ctx, tx, fmt.Sprintf("child%d", i), "parent")).To(Succeed())
+				Expect(tx.Commit()).To(Succeed())
+			}
+		})
+
+		It("should enforce foreign keys in concurrent transactions", func() {
+			ctx := context.Background()
+			var begun sync.WaitGroup
+			begun.Add(poolSize)
+			errs := make(chan error, poolSize)
+			for i := 0; i < poolSize; i++ {
+				go func(i int) {
+					defer GinkgoRecover()
+					tx, err := sqlConn.DB.BeginTx(ctx, nil)
+					begun.Done()
+					if err != nil {
+						errs <- err
+						return
+					}
+					defer tx.Rollback()
+					// keep every transaction open until all of them have begun, so each uses its own connection
+					begun.Wait()
+					errs <- insertChild(ctx, tx, fmt.Sprintf("orphan%d", i), "missing")
+				}(i)
+			}
+			for i := 0; i < poolSize; i++ {
+				Expect(<-errs).To(MatchError(ContainSubstring("FOREIGN KEY constraint failed")))
+			}
+
+			var count int
+			Expect(sqlConn.DB.QueryRow("SELECT COUNT(*) FROM children").Scan(&count)).To(Succeed())
+			Expect(count).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,143 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudwan/gohan/util"
+)
+
+const (
+	fileSinkTag = "file"
+	httpSinkTag = "http"
+	unixSinkTag = "unix"
+
+	defaultBatchSize = 100
+	defaultInterval  = time.Second
+	defaultBackoff   = 5 * time.Second
+	defaultMaxQueued = 100000
+)
+
+// Event is a committed change of a resource
+type Event struct {
+	// ID is the ID of the event in the event table, events of a sink are sent in its order
+	ID        int64                  `json:"id"`
+	Type      string                 `json:"type"`
+	SchemaID  string                 `json:"schema_id"`
+	Path      string                 `json:"path"`
+	Version   int64                  `json:"version,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+	Resource  map[string]interface{} `json:"resource"`
+}
+
+// EventSink receives resource change events. A batch which failed to be sent
+// is sent again, so a sink may receive an event more than once.
+type EventSink interface {
+	Send(ctx context.Context, events []*Event) error
+	Close() error
+}
+
+// Config of a sink listed under "event_sinks"
+type Config struct {
+	Name string
+	Type string
+	// Schemas routed to the sink, all schemas when empty
+	Schemas   map[string]bool
+	BatchSize int
+	Interval  time.Duration
+	Backoff   time.Duration
+	// MaxQueued is the number of undelivered events above which new events are dropped, 0 for no limit
+	MaxQueued int
+
+	options *util.Config
+}
+
+// Routes checks if events of the schema are sent to the sink
+func (config *Config) Routes(schemaID string) bool {
+	return len(config.Schemas) == 0 || config.Schemas[schemaID]
+}
+
+// LoadConfigs reads sinks listed under "event_sinks"
+func LoadConfigs(config *util.Config) ([]*Config, error) {
+	configs := []*Config{}
+	names := map[string]bool{}
+	for i, item := range config.GetList("event_sinks", nil) {
+		options, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("event_sinks/%d should be a map", i)
+		}
+		sinkConfig := newConfig(util.NewConfig(options))
+		if sinkConfig.Name == "" {
+			return nil, fmt.Errorf("event_sinks/%d has no name", i)
+		}
+		if names[sinkConfig.Name] {
+			return nil, fmt.Errorf("event sink %s is configured twice", sinkConfig.Name)
+		}
+		names[sinkConfig.Name] = true
+		switch sinkConfig.Type {
+		case fileSinkTag, httpSinkTag, unixSinkTag:
+		default:
+			return nil, fmt.Errorf("unsupported type of event sink %s: %s, must be '%s', '%s' or '%s'",
+				sinkConfig.Name, sinkConfig.Type, fileSinkTag, httpSinkTag, unixSinkTag)
+		}
+		configs = append(configs, sinkConfig)
+	}
+	return configs, nil
+}
+
+func newConfig(options *util.Config) *Config {
+	config := &Config{
+		Name:      options.GetString("name", ""),
+		Type:      options.GetString("type", ""),
+		Schemas:   map[string]bool{},
+		BatchSize: options.GetInt("batch_size", defaultBatchSize),
+		Interval:  options.GetDuration("interval", defaultInterval),
+		Backoff:   options.GetDuration("backoff", defaultBackoff),
+		MaxQueued: options.GetInt("max_queued", defaultMaxQueued),
+		options:   options,
+	}
+	for _, schemaID := range options.GetStringList("schemas", nil) {
+		config.Schemas[schemaID] = true
+	}
+	if config.BatchSize < 1 {
+		config.BatchSize = 1
+	}
+	return config
+}
+
+// New creates the sink of the config
+func New(config *Config) (EventSink, error) {
+	options := config.options
+	switch config.Type {
+	case fileSinkTag:
+		return NewFileSink(options.GetString("file", ""), int64(options.GetInt("max_size", defaultMaxSize)), options.GetInt("max_backups", defaultMaxBackups))
+	case httpSinkTag:
+		headers := map[string]string{}
+		if values, ok := options.GetParam("headers", nil).(map[string]interface{}); ok {
+			for key, value := range values {
+				headers[key] = fmt.Sprint(value)
+			}
+		}
+		return NewHTTPSink(options.GetString("url", ""), headers, options.GetDuration("timeout", defaultTimeout))
+	case unixSinkTag:
+		return NewUnixSink(options.GetString("socket", ""), options.GetDuration("timeout", defaultTimeout))
+	default:
+		return nil, fmt.Errorf("unsupported type of event sink %s: %s", config.Name, config.Type)
+	}
+}
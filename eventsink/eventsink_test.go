@@ -0,0 +1,185 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudwan/gohan/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Event sinks", func() {
+	var (
+		ctx    context.Context
+		dir    string
+		events []*Event
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = ioutil.TempDir("", "eventsink")
+		Expect(err).ToNot(HaveOccurred())
+		events = []*Event{
+			{ID: 1, Type: "create", SchemaID: "network", Path: "/v2.0/network/red", Resource: map[string]interface{}{"id": "red"}},
+			{ID: 2, Type: "delete", SchemaID: "network", Path: "/v2.0/network/red", Resource: map[string]interface{}{"id": "red"}},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Config", func() {
+		It("should load sinks with schema routing", func() {
+			configs, err := LoadConfigs(util.NewConfig(map[string]interface{}{
+				"event_sinks": []interface{}{
+					map[string]interface{}{"name": "lake", "type": "file", "file": "events.jsonl"},
+					map[string]interface{}{"name": "billing", "type": "http", "url": "http://billing", "schemas": []interface{}{"network"}},
+				},
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configs).To(HaveLen(2))
+			Expect(configs[0].Routes("subnet")).To(BeTrue())
+			Expect(configs[1].Routes("network")).To(BeTrue())
+			Expect(configs[1].Routes("subnet")).To(BeFalse())
+			Expect(configs[1].BatchSize).To(Equal(defaultBatchSize))
+		})
+
+		It("should reject invalid sinks", func() {
+			for _, sinks := range [][]interface{}{
+				{map[string]interface{}{"type": "file"}},
+				{map[string]interface{}{"name": "lake", "type": "kafka"}},
+				{map[string]interface{}{"name": "lake", "type": "file"}, map[string]interface{}{"name": "lake", "type": "http"}},
+			} {
+				_, err := LoadConfigs(util.NewConfig(map[string]interface{}{"event_sinks": sinks}))
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Describe("File sink", func() {
+		readLines := func(path string) []string {
+			file, err := os.Open(path)
+			Expect(err).ToNot(HaveOccurred())
+			defer file.Close()
+			lines := []string{}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			return lines
+		}
+
+		It("should append events as JSON lines", func() {
+			path := filepath.Join(dir, "events.jsonl")
+			sink, err := NewFileSink(path, 0, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sink.Send(ctx, events)).To(Succeed())
+			Expect(sink.Close()).To(Succeed())
+
+			lines := readLines(path)
+			Expect(lines).To(HaveLen(2))
+			var event Event
+			Expect(json.Unmarshal([]byte(lines[1]), &event)).To(Succeed())
+			Expect(event.ID).To(BeEquivalentTo(2))
+			Expect(event.Type).To(Equal("delete"))
+		})
+
+		It("should rotate files", func() {
+			path := filepath.Join(dir, "events.jsonl")
+			sink, err := NewFileSink(path, 10, 2)
+			Expect(err).ToNot(HaveOccurred())
+			defer sink.Close()
+			for i := 0; i < 4; i++ {
+				Expect(sink.Send(ctx, events[:1])).To(Succeed())
+			}
+
+			Expect(readLines(path)).To(HaveLen(1))
+			Expect(readLines(path + ".1")).To(HaveLen(1))
+			Expect(readLines(path + ".2")).To(HaveLen(1))
+			_, err = os.Stat(path + ".3")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("HTTP sink", func() {
+		It("should post batches", func() {
+			var received map[string][]*Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+				Expect(json.NewDecoder(r.Body).Decode(&received)).To(Succeed())
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer server.Close()
+
+			sink, err := NewHTTPSink(server.URL, map[string]string{"Authorization": "Bearer token"}, defaultTimeout)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sink.Send(ctx, events)).To(Succeed())
+			Expect(received["events"]).To(HaveLen(2))
+		})
+
+		It("should fail on error responses", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			sink, err := NewHTTPSink(server.URL, nil, defaultTimeout)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sink.Send(ctx, events)).ToNot(Succeed())
+		})
+	})
+
+	Describe("Unix socket sink", func() {
+		It("should write JSON lines and reconnect", func() {
+			socket := filepath.Join(dir, "events.sock")
+			sink, err := NewUnixSink(socket, defaultTimeout)
+			Expect(err).ToNot(HaveOccurred())
+			defer sink.Close()
+			Expect(sink.Send(ctx, events)).ToNot(Succeed())
+
+			listener, err := net.Listen("unix", socket)
+			Expect(err).ToNot(HaveOccurred())
+			defer listener.Close()
+			lines := make(chan string, 2)
+			go func() {
+				defer GinkgoRecover()
+				conn, err := listener.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+
+			Expect(sink.Send(ctx, events)).To(Succeed())
+			Eventually(lines).Should(Receive(ContainSubstring(`"type":"create"`)))
+			Eventually(lines).Should(Receive(ContainSubstring(`"type":"delete"`)))
+		})
+	})
+})
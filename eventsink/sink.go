@@ -0,0 +1,232 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxSize    = 100 * 1024 * 1024
+	defaultMaxBackups = 5
+	defaultTimeout    = 5 * time.Second
+)
+
+func encodeLines(events []*Event) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// FileSink appends events to a file, one JSON document per line.
+// The file is rotated when it would exceed maxSize bytes, keeping maxBackups
+// rotated files named <file>.1 (the newest) to <file>.<maxBackups>.
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink opens the file for appending
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no file given for the event sink")
+	}
+	sink := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *FileSink) open() error {
+	file, err := os.OpenFile(sink.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open event sink file %s: %s", sink.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sink.file, sink.size = file, info.Size()
+	return nil
+}
+
+func (sink *FileSink) rotate() error {
+	if err := sink.file.Close(); err != nil {
+		return err
+	}
+	if sink.maxBackups > 0 {
+		for i := sink.maxBackups - 1; i >= 1; i-- {
+			backup := fmt.Sprintf("%s.%d", sink.path, i)
+			if _, err := os.Stat(backup); err == nil {
+				if err := os.Rename(backup, fmt.Sprintf("%s.%d", sink.path, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(sink.path, sink.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(sink.path); err != nil {
+		return err
+	}
+	return sink.open()
+}
+
+// Send appends events to the file
+func (sink *FileSink) Send(ctx context.Context, events []*Event) error {
+	data, err := encodeLines(events)
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.maxSize > 0 && sink.size > 0 && sink.size+int64(len(data)) > sink.maxSize {
+		if err := sink.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate event sink file %s: %s", sink.path, err)
+		}
+	}
+	written, err := sink.file.Write(data)
+	sink.size += int64(written)
+	return err
+}
+
+// Close closes the file
+func (sink *FileSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.file.Close()
+}
+
+// HTTPSink posts batches of events to a URL as {"events": [...]}
+type HTTPSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewHTTPSink creates a sink posting to the URL
+func NewHTTPSink(url string, headers map[string]string, timeout time.Duration) (*HTTPSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("no url given for the event sink")
+	}
+	return &HTTPSink{url: url, headers: headers, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Send posts events, any status other than 2xx is an error
+func (sink *HTTPSink) Send(ctx context.Context, events []*Event) error {
+	data, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, sink.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range sink.headers {
+		request.Header.Set(key, value)
+	}
+	response, err := sink.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("event sink %s responded with %d: %s", sink.url, response.StatusCode, body)
+	}
+	return nil
+}
+
+// Close does nothing
+func (sink *HTTPSink) Close() error {
+	return nil
+}
+
+// UnixSink writes events to a Unix socket, one JSON document per line.
+// The socket is connected on the first send and again after a failure.
+type UnixSink struct {
+	mu      sync.Mutex
+	socket  string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+// NewUnixSink creates a sink writing to the socket
+func NewUnixSink(socket string, timeout time.Duration) (*UnixSink, error) {
+	if socket == "" {
+		return nil, fmt.Errorf("no socket given for the event sink")
+	}
+	return &UnixSink{socket: socket, timeout: timeout}, nil
+}
+
+// Send writes events to the socket
+func (sink *UnixSink) Send(ctx context.Context, events []*Event) error {
+	data, err := encodeLines(events)
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		dialer := net.Dialer{Timeout: sink.timeout}
+		conn, err := dialer.DialContext(ctx, "unix", sink.socket)
+		if err != nil {
+			return err
+		}
+		sink.conn = conn
+	}
+	sink.conn.SetWriteDeadline(time.Now().Add(sink.timeout))
+	if _, err := sink.conn.Write(data); err != nil {
+		sink.conn.Close()
+		sink.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection
+func (sink *UnixSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		return nil
+	}
+	err := sink.conn.Close()
+	sink.conn = nil
+	return err
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build wasip1
+// +build wasip1
+
+package main
+
+import (
+	"github.com/cloudwan/gohan/extension/wasm/guest"
+)
+
+// Handlers are registered by init functions, main isn't called
+func init() {
+	// Validate and modify the created resource
+	guest.RegisterEventHandler("pre_create", func(env *guest.Environment, context guest.Context) error {
+		resource, _ := context["resource"].(map[string]interface{})
+		if resource["name"] == "" {
+			return &guest.Error{Code: 400, Message: "name of the todo can't be empty"}
+		}
+		if resource["description"] == "" {
+			resource["description"] = "created by a wasm extension"
+		}
+		env.Logger().Infof("Creating todo %v", resource["name"])
+		return nil
+	})
+
+	// Use the transaction of the request
+	guest.RegisterEventHandler("post_create_in_transaction", func(env *guest.Environment, context guest.Context) error {
+		todos, err := context.Transaction().List("todo", map[string]interface{}{}, nil)
+		if err != nil {
+			return err
+		}
+		env.Logger().Infof("Found %d todos of tenant %s", len(todos), env.Auth().GetTenantName(context))
+		return nil
+	})
+}
+
+func main() {}
@@ -17,6 +17,7 @@ package extension
 
 import (
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
@@ -36,10 +37,24 @@ type Environment interface {
 //Manager takes care of mapping schemas to Environments.
 //This is a singleton class.
 type Manager struct {
-	environments map[string]Environment
+	environments map[string]*registration
+	clones       map[Environment]*registration
 	mu           sync.RWMutex
 }
 
+//registration is an environment registered for a schema with clones of it
+//which aren't released yet
+type registration struct {
+	env    Environment
+	clones sync.WaitGroup
+}
+
+//stopper is an environment which has to be stopped when it's no longer used,
+//e.g. to release resources held by go extensions
+type stopper interface {
+	Stop()
+}
+
 //RegisterEnvironment registers a new environment for the given schema ID
 func (manager *Manager) RegisterEnvironment(schemaID string, env Environment) error {
 	manager.mu.Lock()
@@ -48,10 +63,34 @@ func (manager *Manager) RegisterEnvironment(schemaID string, env Environment) er
 	if _, ok := manager.environments[schemaID]; ok {
 		return fmt.Errorf("Environment already registered for schema '%s'", schemaID)
 	}
-	manager.environments[schemaID] = env
+	manager.environments[schemaID] = &registration{env: env}
 	return nil
 }
 
+//ReplaceEnvironment replaces an environment registered for the given schema ID.
+//The previous environment is stopped once it's drained, i.e. all clones of it acquired by AcquireEnvironment
+//are released by ReleaseEnvironment, then the returned channel is closed.
+func (manager *Manager) ReplaceEnvironment(schemaID string, env Environment) (<-chan struct{}, error) {
+	manager.mu.Lock()
+	previous, ok := manager.environments[schemaID]
+	if !ok {
+		manager.mu.Unlock()
+		return nil, fmt.Errorf("No environment registered for this schema")
+	}
+	manager.environments[schemaID] = &registration{env: env}
+	manager.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		previous.clones.Wait()
+		if stoppable, ok := previous.env.(stopper); ok {
+			stoppable.Stop()
+		}
+		close(drained)
+	}()
+	return drained, nil
+}
+
 //UnRegisterEnvironment removes an environment registered for the given schema ID
 func (manager *Manager) UnRegisterEnvironment(schemaID string) error {
 	manager.mu.Lock()
@@ -64,22 +103,78 @@ func (manager *Manager) UnRegisterEnvironment(schemaID string) error {
 	return nil
 }
 
-//GetEnvironment returns the environment registered for the given schema ID
+//HasEnvironment returns whether an environment is registered for the given schema ID
+func (manager *Manager) HasEnvironment(schemaID string) bool {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	_, ok := manager.environments[schemaID]
+	return ok
+}
+
+//GetEnvironment returns a clone of the environment registered for the given schema ID.
+//The clone isn't tracked, so the environment may be stopped when it's replaced while the clone
+//is still used; use AcquireEnvironment to keep it until the clone is released
 func (manager *Manager) GetEnvironment(schemaID string) (env Environment, ok bool) {
 	manager.mu.RLock()
 	defer manager.mu.RUnlock()
 
-	env, ok = manager.environments[schemaID]
+	registered, ok := manager.environments[schemaID]
+	if !ok {
+		return nil, false
+	}
+	return registered.env.Clone(), true
+}
+
+//AcquireEnvironment returns a clone of the environment registered for the given schema ID.
+//The clone has to be released by ReleaseEnvironment when handling events by it is finished,
+//the environment is stopped after it's replaced only once all acquired clones are released
+func (manager *Manager) AcquireEnvironment(schemaID string) (env Environment, ok bool) {
+	manager.mu.RLock()
+	registered, ok := manager.environments[schemaID]
+	if ok {
+		registered.clones.Add(1)
+	}
+	manager.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	env = registered.env.Clone()
+	if env == nil || !reflect.TypeOf(env).Comparable() {
+		registered.clones.Done()
+		return env, true
+	}
+	manager.mu.Lock()
+	manager.clones[env] = registered
+	manager.mu.Unlock()
+	return env, true
+}
+
+//ReleaseEnvironment releases a clone returned by AcquireEnvironment, it's a no-op for other environments
+func (manager *Manager) ReleaseEnvironment(env Environment) {
+	if env == nil || !reflect.TypeOf(env).Comparable() {
+		return
+	}
+	manager.mu.Lock()
+	registered, ok := manager.clones[env]
+	delete(manager.clones, env)
+	manager.mu.Unlock()
 	if ok {
-		env = env.Clone()
+		registered.clones.Done()
 	}
-	return
 }
 
 // HandleEventInAllEnvironments handles the event in all registered environments
 func (manager *Manager) HandleEventInAllEnvironments(context map[string]interface{}, event string, schemaID string) error {
-	for name := range manager.environments {
-		err := HandleEvent(context, manager.environments[name], event, schemaID)
+	manager.mu.RLock()
+	envs := make([]Environment, 0, len(manager.environments))
+	for _, registered := range manager.environments {
+		envs = append(envs, registered.env)
+	}
+	manager.mu.RUnlock()
+
+	for _, env := range envs {
+		err := HandleEvent(context, env, event, schemaID)
 		if err != nil {
 			return err
 		}
@@ -91,7 +186,8 @@ func (manager *Manager) HandleEventInAllEnvironments(context map[string]interfac
 func GetManager() *Manager {
 	return singleton.Get("extension/manager", func() interface{} {
 		return &Manager{
-			environments: map[string]Environment{},
+			environments: map[string]*registration{},
+			clones:       map[Environment]*registration{},
 		}
 	}).(*Manager)
 }
@@ -80,6 +80,49 @@ var _ = Describe("Environment manager", func() {
 		})
 	})
 
+	Describe("Replacing an environment", func() {
+		Context("When it is registered", func() {
+			It("Should stop the previous one once its clones are released", func() {
+				stoppable := &stoppableEnvironment{Environment: env1, stopped: make(chan struct{})}
+				Expect(manager.RegisterEnvironment(schemaID2, stoppable)).To(Succeed())
+				cloned, ok := manager.AcquireEnvironment(schemaID2)
+				Expect(ok).To(BeTrue())
+
+				drained, err := manager.ReplaceEnvironment(schemaID2, env2)
+				Expect(err).ToNot(HaveOccurred())
+				Consistently(drained).ShouldNot(BeClosed())
+				Expect(stoppable.stopped).ToNot(BeClosed())
+
+				manager.ReleaseEnvironment(cloned)
+				Eventually(drained).Should(BeClosed())
+				Expect(stoppable.stopped).To(BeClosed())
+
+				env, ok := manager.GetEnvironment(schemaID2)
+				Expect(ok).To(BeTrue())
+				Expect(env).NotTo(BeNil())
+			})
+
+			It("Should not wait for clones which aren't acquired", func() {
+				stoppable := &stoppableEnvironment{Environment: env1, stopped: make(chan struct{})}
+				Expect(manager.RegisterEnvironment(schemaID2, stoppable)).To(Succeed())
+				_, ok := manager.GetEnvironment(schemaID2)
+				Expect(ok).To(BeTrue())
+
+				drained, err := manager.ReplaceEnvironment(schemaID2, env2)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(drained).Should(BeClosed())
+				Expect(stoppable.stopped).To(BeClosed())
+			})
+		})
+
+		Context("When it isn't registered", func() {
+			It("Should return an error", func() {
+				_, err := manager.ReplaceEnvironment(schemaID2, env2)
+				Expect(err).To(MatchError("No environment registered for this schema"))
+			})
+		})
+	})
+
 	Describe("Unregistering an environment", func() {
 		Context("When it is registered", func() {
 			It("Should unregister it", func() {
@@ -128,3 +171,12 @@ var _ = Describe("Environment manager", func() {
 		})
 	})
 })
+
+type stoppableEnvironment struct {
+	extension.Environment
+	stopped chan struct{}
+}
+
+func (env *stoppableEnvironment) Stop() {
+	close(env.stopped)
+}
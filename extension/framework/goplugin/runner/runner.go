@@ -213,7 +213,7 @@ func (testRunner *TestRunner) runSingle(t ginkgo.GinkgoTestingT, reporter *Repor
 	}
 
 	// load extensions
-	if err := env.LoadExtensionsForPath(manager.Extensions, manager.TimeLimit, manager.TimeLimits, ""); err != nil {
+	if err := env.LoadExtensionsForPath(manager.GetExtensions(), manager.TimeLimit, manager.TimeLimits, ""); err != nil {
 		return fmt.Errorf("failed to load schemas extensions: %s", err)
 	}
 
@@ -450,5 +450,5 @@ func (env *Environment) loadExtensions() error {
 	}
 	pathString, _ := pathValue.ToString()
 
-	return env.LoadExtensionsForPath(manager.Extensions, manager.TimeLimit, manager.TimeLimits, pathString)
+	return env.LoadExtensionsForPath(manager.GetExtensions(), manager.TimeLimit, manager.TimeLimits, pathString)
 }
@@ -38,6 +38,10 @@ type IEnvironment interface {
 	Auth() IAuth
 	// Util returns an implementation of IIUtil interface
 	Util() IUtil
+	// Jobs returns an implementation of IJobs interface
+	Jobs() IJobs
+	// Secrets returns an implementation of ISecrets interface
+	Secrets() ISecrets
 
 	// state
 
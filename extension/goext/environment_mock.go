@@ -20,7 +20,7 @@ import "github.com/golang/mock/gomock"
 // MockModules indicates modules which should be mocked.
 // By default none of the modules are mocked so that MockIEnvironment behaves exactly the same as IEnvironment.
 type MockModules struct {
-	Core, Logger, Schemas, Sync, Database, Http, Auth, Util, Config, DefaultDatabase bool
+	Core, Logger, Schemas, Sync, Database, Http, Auth, Util, Config, Jobs, Secrets, DefaultDatabase bool
 }
 
 // MockIEnvironment is the only scope of Gohan available for a go unit tests extensions;
@@ -38,4 +38,6 @@ type MockIEnvironment interface {
 	MockAuth() *MockIAuth
 	MockConfig() *MockIConfig
 	MockUtil() *MockIUtil
+	MockJobs() *MockIJobs
+	MockSecrets() *MockISecrets
 }
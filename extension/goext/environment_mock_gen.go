@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/cloudwan/gohan/extension/goext (interfaces: ICore,ILogger,ISchemas,ISync,IDatabase,ITransaction,IHTTP,IAuth,IConfig,IUtil,ISchema)
+// Source: github.com/cloudwan/gohan/extension/goext (interfaces: ICore,ILogger,ISchemas,ISync,IDatabase,ITransaction,IHTTP,IAuth,IConfig,IUtil,IJobs,ISecrets,ISchema)
 
 // Package goext is a generated GoMock package.
 package goext
@@ -371,6 +371,21 @@ func (m *MockISync) EXPECT() *MockISyncMockRecorder {
 	return m.recorder
 }
 
+// CompareAndSwap mocks base method
+func (m *MockISync) CompareAndSwap(arg0 context.Context, arg1, arg2, arg3 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompareAndSwap", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompareAndSwap indicates an expected call of CompareAndSwap
+func (mr *MockISyncMockRecorder) CompareAndSwap(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSwap", reflect.TypeOf((*MockISync)(nil).CompareAndSwap), arg0, arg1, arg2, arg3)
+}
+
 // Delete mocks base method
 func (m *MockISync) Delete(arg0 context.Context, arg1 string, arg2 bool) error {
 	m.ctrl.T.Helper()
@@ -400,6 +415,35 @@ func (mr *MockISyncMockRecorder) Fetch(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fetch", reflect.TypeOf((*MockISync)(nil).Fetch), arg0, arg1)
 }
 
+// Lock mocks base method
+func (m *MockISync) Lock(arg0 context.Context, arg1 string, arg2 bool) (chan struct{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Lock", arg0, arg1, arg2)
+	ret0, _ := ret[0].(chan struct{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lock indicates an expected call of Lock
+func (mr *MockISyncMockRecorder) Lock(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lock", reflect.TypeOf((*MockISync)(nil).Lock), arg0, arg1, arg2)
+}
+
+// Unlock mocks base method
+func (m *MockISync) Unlock(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Unlock", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Unlock indicates an expected call of Unlock
+func (mr *MockISyncMockRecorder) Unlock(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unlock", reflect.TypeOf((*MockISync)(nil).Unlock), arg0, arg1)
+}
+
 // Update mocks base method
 func (m *MockISync) Update(arg0 context.Context, arg1, arg2 string) error {
 	m.ctrl.T.Helper()
@@ -1137,6 +1181,82 @@ func (mr *MockIUtilMockRecorder) ResourceToMap(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResourceToMap", reflect.TypeOf((*MockIUtil)(nil).ResourceToMap), arg0)
 }
 
+// MockIJobs is a mock of IJobs interface
+type MockIJobs struct {
+	ctrl     *gomock.Controller
+	recorder *MockIJobsMockRecorder
+}
+
+// MockIJobsMockRecorder is the mock recorder for MockIJobs
+type MockIJobsMockRecorder struct {
+	mock *MockIJobs
+}
+
+// NewMockIJobs creates a new mock instance
+func NewMockIJobs(ctrl *gomock.Controller) *MockIJobs {
+	mock := &MockIJobs{ctrl: ctrl}
+	mock.recorder = &MockIJobsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockIJobs) EXPECT() *MockIJobsMockRecorder {
+	return m.recorder
+}
+
+// Enqueue mocks base method
+func (m *MockIJobs) Enqueue(arg0 string, arg1 map[string]interface{}, arg2 JobOptions, arg3 Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enqueue", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Enqueue indicates an expected call of Enqueue
+func (mr *MockIJobsMockRecorder) Enqueue(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*MockIJobs)(nil).Enqueue), arg0, arg1, arg2, arg3)
+}
+
+// MockISecrets is a mock of ISecrets interface
+type MockISecrets struct {
+	ctrl     *gomock.Controller
+	recorder *MockISecretsMockRecorder
+}
+
+// MockISecretsMockRecorder is the mock recorder for MockISecrets
+type MockISecretsMockRecorder struct {
+	mock *MockISecrets
+}
+
+// NewMockISecrets creates a new mock instance
+func NewMockISecrets(ctrl *gomock.Controller) *MockISecrets {
+	mock := &MockISecrets{ctrl: ctrl}
+	mock.recorder = &MockISecretsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockISecrets) EXPECT() *MockISecretsMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method
+func (m *MockISecrets) Get(arg0 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get
+func (mr *MockISecretsMockRecorder) Get(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockISecrets)(nil).Get), arg0)
+}
+
 // MockISchema is a mock of ISchema interface
 type MockISchema struct {
 	ctrl     *gomock.Controller
@@ -0,0 +1,87 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+// Column is a property of a schema used in filters,
+// the converter generates columns of each schema, e.g. schemas.NetworkColumns.Name
+type Column string
+
+// Of refers to the column of a schema related through relationProperty,
+// e.g. schemas.NetworkColumns.Name.Of("network") matches names of networks of subnets,
+// filtering by related schemas joins their tables
+func (column Column) Of(relationProperty string) Column {
+	return Column(relationProperty + "." + string(column))
+}
+
+// Eq matches resources whose column equals value
+func (column Column) Eq(value interface{}) FilterElem {
+	return Eq(string(column), value)
+}
+
+// Neq matches resources whose column doesn't equal value
+func (column Column) Neq(value interface{}) FilterElem {
+	return Neq(string(column), value)
+}
+
+// Gt matches resources whose column is greater than value
+func (column Column) Gt(value interface{}) FilterElem {
+	return Gt(string(column), value)
+}
+
+// Gte matches resources whose column is greater than or equal to value
+func (column Column) Gte(value interface{}) FilterElem {
+	return Gte(string(column), value)
+}
+
+// Lt matches resources whose column is less than value
+func (column Column) Lt(value interface{}) FilterElem {
+	return Lt(string(column), value)
+}
+
+// Lte matches resources whose column is less than or equal to value
+func (column Column) Lte(value interface{}) FilterElem {
+	return Lte(string(column), value)
+}
+
+// Like matches resources whose column matches an SQL LIKE pattern
+func (column Column) Like(pattern string) FilterElem {
+	return Like(string(column), pattern)
+}
+
+// Prefix matches resources whose column starts with prefix
+func (column Column) Prefix(prefix string) FilterElem {
+	return Prefix(string(column), prefix)
+}
+
+// In matches resources whose column equals one of values
+func (column Column) In(values ...interface{}) FilterElem {
+	return In(string(column), values...)
+}
+
+// NotIn matches resources whose column equals none of values
+func (column Column) NotIn(values ...interface{}) FilterElem {
+	return NotIn(string(column), values...)
+}
+
+// IsNull matches resources whose column is null
+func (column Column) IsNull() FilterElem {
+	return IsNull(string(column))
+}
+
+// IsNotNull matches resources whose column is not null
+func (column Column) IsNotNull() FilterElem {
+	return IsNotNull(string(column))
+}
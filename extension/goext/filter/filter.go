@@ -18,6 +18,57 @@ func Neq(property string, value interface{}) FilterElem {
 	return Predicate(property, "neq", value)
 }
 
+// Gt matches resources whose property is greater than value
+func Gt(property string, value interface{}) FilterElem {
+	return Predicate(property, "gt", value)
+}
+
+// Gte matches resources whose property is greater than or equal to value
+func Gte(property string, value interface{}) FilterElem {
+	return Predicate(property, "gte", value)
+}
+
+// Lt matches resources whose property is less than value
+func Lt(property string, value interface{}) FilterElem {
+	return Predicate(property, "lt", value)
+}
+
+// Lte matches resources whose property is less than or equal to value
+func Lte(property string, value interface{}) FilterElem {
+	return Predicate(property, "lte", value)
+}
+
+// Like matches resources whose property matches an SQL LIKE pattern,
+// where % and _ are wildcards which can be escaped with a backslash
+func Like(property, pattern string) FilterElem {
+	return Predicate(property, "like", pattern)
+}
+
+// Prefix matches resources whose property starts with prefix
+func Prefix(property, prefix string) FilterElem {
+	return Predicate(property, "prefix", prefix)
+}
+
+// In matches resources whose property equals one of values
+func In(property string, values ...interface{}) FilterElem {
+	return Predicate(property, "in", values)
+}
+
+// NotIn matches resources whose property equals none of values
+func NotIn(property string, values ...interface{}) FilterElem {
+	return Predicate(property, "not_in", values)
+}
+
+// IsNull matches resources whose property is null
+func IsNull(property string) FilterElem {
+	return Predicate(property, "null", true)
+}
+
+// IsNotNull matches resources whose property is not null
+func IsNotNull(property string) FilterElem {
+	return Predicate(property, "null", false)
+}
+
 func And(filters ...FilterElem) FilterElem {
 	return FilterElem{
 		"__and__": filters,
@@ -30,6 +81,14 @@ func Or(filters ...FilterElem) FilterElem {
 	}
 }
 
+// OutOfSync matches resources of state versioned schemas whose state_version differs
+// from config_version, or equals it when outOfSync is false
+func OutOfSync(outOfSync bool) FilterElem {
+	return FilterElem{
+		"__out_of_sync__": outOfSync,
+	}
+}
+
 func True() FilterElem {
 	return FilterElem{
 		"__bool__": true,
@@ -15,7 +15,10 @@
 
 package goext
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Header represents HTTP header
 type Header map[string][]string
@@ -35,3 +38,27 @@ type IHTTP interface {
 	// RequestRaw performs raw http request
 	RequestRaw(ctx context.Context, method, rawURL string, headers map[string]string, rawData string) (*Response, error)
 }
+
+// HTTPOptions override the policy configured for the destination of a request,
+// zero values keep the policy
+type HTTPOptions struct {
+	// Retries of an idempotent request, negative disables retries
+	Retries int
+	// Backoff before the first retry, doubled with every next one
+	Backoff time.Duration
+	// Timeout of a single attempt
+	Timeout time.Duration
+}
+
+type httpOptionsKey struct{}
+
+// WithHTTPOptions returns a context making requests of IHTTP with options
+func WithHTTPOptions(ctx context.Context, options HTTPOptions) context.Context {
+	return context.WithValue(ctx, httpOptionsKey{}, options)
+}
+
+// HTTPOptionsFromContext returns options set by WithHTTPOptions
+func HTTPOptionsFromContext(ctx context.Context) (HTTPOptions, bool) {
+	options, ok := ctx.Value(httpOptionsKey{}).(HTTPOptions)
+	return options, ok
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goext
+
+import "time"
+
+// JobOptions are options of an enqueued job
+type JobOptions struct {
+	// Delay after which the job is due
+	Delay time.Duration
+	// MaxAttempts after which the job fails, 5 when 0
+	MaxAttempts int
+	// UniqueKey prevents queueing a job while a pending or running job has the same key
+	UniqueKey string
+}
+
+// IJobs is an interface to the background job queue in Gohan
+type IJobs interface {
+	// Enqueue stores a job in the transaction of the context, or in a new transaction
+	// when there is none, and returns the ID of the job. The job is run after the transaction
+	// is committed, by extensions of path job://<jobType> handling the "job" event.
+	// The ID of the queued job is returned when a job with the same unique key is queued.
+	Enqueue(jobType string, payload map[string]interface{}, options JobOptions, context Context) (int64, error)
+}
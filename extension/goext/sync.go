@@ -46,6 +46,15 @@ type ISync interface {
 	Watch(ctx context.Context, path string, timeout time.Duration, revision int64) ([]*Event, error)
 	// Update updates a path with given json
 	Update(ctx context.Context, path string, json string) error
+	// CompareAndSwap updates a path with given json only if its current value is expected,
+	// and reports whether the path was updated
+	CompareAndSwap(ctx context.Context, path string, json string, expected string) (bool, error)
+	// Lock locks a path; when block is false, an error is returned if the path is already locked.
+	// The lock is kept alive until ctx is done, the returned channel is closed when the lock is lost.
+	// Locks not unlocked are released when the event handler returns
+	Lock(ctx context.Context, path string, block bool) (chan struct{}, error)
+	// Unlock unlocks a path
+	Unlock(ctx context.Context, path string) error
 }
 
 type ErrCompacted struct {
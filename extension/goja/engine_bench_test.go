@@ -0,0 +1,106 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/goja"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+)
+
+const cpuHeavyCode = `
+	function fib(n) {
+		return n < 2 ? n : fib(n - 1) + fib(n - 2);
+	}
+	gohan_register_handler("test_event", function(context) {
+		var items = [];
+		for (var i = 0; i < 1000; i++) {
+			items.push({id: "item" + i, value: i * 2});
+		}
+		context.sum = _.reduce(items, function(sum, item) { return sum + item.value; }, 0);
+		context.fib = fib(20);
+	});`
+
+func newOttoEnvironment() extension.Environment {
+	return otto.NewEnvironment("bench", nil, &middleware.FakeIdentity{}, nil)
+}
+
+func newGojaEnvironment() extension.Environment {
+	return goja.NewEnvironment("bench", nil, &middleware.FakeIdentity{}, nil)
+}
+
+func load(b *testing.B, newEnvironment func() extension.Environment) extension.Environment {
+	log.SetUpBasicLogging(ioutil.Discard, log.CliFormat)
+
+	ext, err := schema.NewExtension(map[string]interface{}{
+		"id":   "cpu_heavy",
+		"code": cpuHeavyCode,
+		"path": ".*",
+	})
+	if err != nil {
+		b.Fatal("creating extension failed", err)
+	}
+	env := newEnvironment()
+	if err := env.LoadExtensionsForPath([]*schema.Extension{ext}, time.Minute, nil, "bench"); err != nil {
+		b.Fatal("loading failed", err)
+	}
+
+	defer b.ResetTimer()
+	return env
+}
+
+func benchmarkHandleEvent(b *testing.B, newEnvironment func() extension.Environment) {
+	env := load(b, newEnvironment)
+
+	for n := 0; n < b.N; n++ {
+		eventContext := map[string]interface{}{"context": context.Background()}
+		if err := env.HandleEvent("test_event", eventContext); err != nil {
+			b.Fatal("handling event failed", err)
+		}
+	}
+}
+
+func benchmarkClone(b *testing.B, newEnvironment func() extension.Environment) {
+	env := load(b, newEnvironment)
+
+	for n := 0; n < b.N; n++ {
+		env.Clone()
+	}
+}
+
+func BenchmarkHandleEvent_Otto(b *testing.B) {
+	benchmarkHandleEvent(b, newOttoEnvironment)
+}
+
+func BenchmarkHandleEvent_Goja(b *testing.B) {
+	benchmarkHandleEvent(b, newGojaEnvironment)
+}
+
+func BenchmarkClone_Otto(b *testing.B) {
+	benchmarkClone(b, newOttoEnvironment)
+}
+
+func BenchmarkClone_Goja(b *testing.B) {
+	benchmarkClone(b, newGojaEnvironment)
+}
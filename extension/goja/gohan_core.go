@@ -0,0 +1,232 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"fmt"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/robertkrimen/otto/underscore"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/otto"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/schema"
+)
+
+var log = l.NewLogger()
+
+var (
+	builtinPrograms   = map[string]*goja.Program{}
+	builtinProgramsMu gosync.Mutex
+)
+
+// loadBuiltin runs built-in code, which is compiled once and shared by all VMs
+func (env *Environment) loadBuiltin(source, code string) error {
+	builtinProgramsMu.Lock()
+	program, ok := builtinPrograms[source]
+	if !ok {
+		var err error
+		program, err = goja.Compile(source, code, false)
+		if err != nil {
+			builtinProgramsMu.Unlock()
+			return err
+		}
+		builtinPrograms[source] = program
+	}
+	builtinProgramsMu.Unlock()
+	return env.run(program)
+}
+
+func init() {
+	gohanInit := func(env *Environment) {
+		vm := env.VM
+		builtins := map[string]interface{}{
+			"require": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "require", 1)
+				moduleName, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				value, err := env.require(moduleName, "")
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return value
+			},
+			"gohan_schemas": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_schemas", 0)
+				manager := schema.GetManager()
+				response := []interface{}{}
+				for _, schema := range manager.OrderedSchemas() {
+					response = append(response, schema)
+				}
+				return vm.ToValue(response)
+			},
+			"gohan_schema_url": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_schema_url", 1)
+				schemaID, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				schema, err := getSchema(schemaID)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(schema.URL)
+			},
+			"gohan_policies": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_policies", 0)
+				manager := schema.GetManager()
+				response := []interface{}{}
+				for _, policy := range manager.Policies() {
+					response = append(response, policy.RawData)
+				}
+				return vm.ToValue(response)
+			},
+			"gohan_trigger_event": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_trigger_event", 2)
+
+				event, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				context, err := GetMap(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				schemaID := ""
+
+				if s, ok := context["schema"]; ok {
+					schemaID = s.(*schema.Schema).ID
+				} else {
+					log.Panic("gohan_trigger_event: schema not found")
+				}
+
+				envManager := extension.GetManager()
+				envManager.HandleEventInAllEnvironments(context, event, schemaID)
+
+				return goja.Null()
+			},
+			"gohan_profile_start": func(call goja.FunctionCall) goja.Value {
+				if !extension.ProfilingEnabled() {
+					return vm.ToValue(0)
+				}
+				return vm.ToValue(float64(time.Since(profileEpoch)))
+			},
+			"gohan_profile_end": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_profile_end", 6)
+				if !extension.ProfilingEnabled() {
+					return goja.Null()
+				}
+				schemaID, traceID := "", ""
+				if context, ok := call.Argument(5).(*goja.Object); ok {
+					schemaID = profiledString(context, "schema_id")
+					traceID = profiledString(context, "trace_id")
+				}
+				extension.ProfileHandler(extension.Handler{
+					Environment: "goja",
+					Schema:      schemaID,
+					Event:       call.Argument(1).String(),
+					Name:        fmt.Sprintf("%s#%d", call.Argument(2).String(), call.Argument(3).ToInteger()),
+				}, profileEpoch.Add(time.Duration(call.Argument(0).ToFloat())), traceID, call.Argument(4).ToBoolean())
+				return goja.Null()
+			},
+			"gohan_trace_start": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_trace_start", 4)
+				context, _ := call.Argument(3).Export().(map[string]interface{})
+				trace := extension.ContextTrace(context)
+				if trace == nil {
+					return goja.Null()
+				}
+				return vm.ToValue(trace.Begin(extension.Handler{
+					Environment: "goja",
+					Schema:      extension.ContextSchemaID(context),
+					Event:       call.Argument(0).String(),
+					Name:        fmt.Sprintf("%s#%d", call.Argument(1).String(), call.Argument(2).ToInteger()),
+				}, context))
+			},
+			"gohan_trace_end": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_trace_end", 3)
+				span, ok := call.Argument(0).Export().(*extension.Span)
+				if !ok {
+					return goja.Null()
+				}
+				var err error
+				if caught := call.Argument(1); !goja.IsNull(caught) && !goja.IsUndefined(caught) {
+					err = fmt.Errorf("%s", caught.String())
+				}
+				context, _ := call.Argument(2).Export().(map[string]interface{})
+				span.End(context, err)
+				return goja.Null()
+			},
+			"console": newConsole(vm, env.Name),
+		}
+
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+
+		if err := env.loadBuiltin("<Underscore>", underscore.Source()); err != nil {
+			log.Fatal(err)
+		}
+		if err := env.loadBuiltin("<Gohan built-in exceptions>", otto.BuiltinExceptionsCode); err != nil {
+			log.Fatal(err)
+		}
+		if err := env.loadBuiltin("<Gohan built-ins>", otto.BuiltinHandlersCode); err != nil {
+			log.Fatal(err)
+		}
+	}
+	RegisterInit(gohanInit)
+}
+
+// profileEpoch is the base of times passed to javascript by gohan_profile_start
+var profileEpoch = time.Now()
+
+func profiledString(object *goja.Object, key string) string {
+	value := object.Get(key)
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return ""
+	}
+	return value.String()
+}
+
+// newConsole creates the console object, which otto provides natively
+func newConsole(vm *goja.Runtime, name string) *goja.Object {
+	logger := l.NewLogger(l.ModuleName("gohan.extension." + name + ".console"))
+	write := func(logAction func(format string, args ...interface{})) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			items := make([]string, 0, len(call.Arguments))
+			for _, argument := range call.Arguments {
+				items = append(items, fmt.Sprint(argument))
+			}
+			logAction("%s", strings.Join(items, " "))
+			return goja.Undefined()
+		}
+	}
+	console := vm.NewObject()
+	console.Set("log", write(logger.Info))
+	console.Set("info", write(logger.Info))
+	console.Set("debug", write(logger.Debug))
+	console.Set("warn", write(logger.Warning))
+	console.Set("error", write(logger.Error))
+	return console
+}
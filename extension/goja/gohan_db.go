@@ -0,0 +1,366 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"context"
+
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/schema"
+)
+
+func init() {
+	gohanDBInit := func(env *Environment) {
+		vm := env.VM
+		builtins := map[string]interface{}{
+			"gohan_db_transaction": func(call goja.FunctionCall) goja.Value {
+				maxArgs := 1
+				if len(call.Arguments) > maxArgs {
+					ThrowException(vm,
+						"Expected no more than %d arguments in %s call, %d arguments given",
+						maxArgs, "gohan_db_transaction", len(call.Arguments))
+				}
+
+				opts := []transaction.Option{}
+				if len(call.Arguments) > 0 {
+					strIsolationLevel, err := GetString(call.Argument(0))
+					if err != nil {
+						ThrowException(vm, err.Error())
+					}
+					opts = append(opts, transaction.IsolationLevel(transaction.Type(strIsolationLevel)))
+				}
+
+				tx, err := env.DataStore.BeginTx(opts...)
+				if err != nil {
+					ThrowException(vm, "failed to start a transaction: %s", err.Error())
+				}
+				env.addCloser(tx)
+				return vm.ToValue(tx)
+			},
+			"gohan_db_list": func(call goja.FunctionCall) goja.Value {
+				schema, filter, pg, tx, needCommit, err := listArgumentsHelper(vm, &call, env)
+				if err != nil {
+					ThrowException(vm, "Error during listArgumentsHelper: %s", err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+
+				resources, _, err := tx.List(context.Background(), schema, filter, nil, pg)
+				if err != nil {
+					ThrowException(vm, "Error during gohan_db_list: %s", err.Error())
+				}
+				return vm.ToValue(parseListResults(resources))
+			},
+			"gohan_db_lock_list": func(call goja.FunctionCall) goja.Value {
+				schema, filter, pg, tx, needCommit, lockPolicy, err := lockListArgumentsHelper(vm, &call, env)
+				if err != nil {
+					ThrowException(vm, "Error during lockListArgumentsHelper: %s", err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+
+				resources, _, err := tx.LockList(context.Background(), schema, filter, nil, pg, lockPolicy)
+				if err != nil {
+					ThrowException(vm, "Error during gohan_db_lock_list: %s", err.Error())
+				}
+				return vm.ToValue(parseListResults(resources))
+			},
+			"gohan_db_fetch": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_fetch", 4)
+				tx, needCommit, err := env.GetOrCreateTransaction(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+				schemaID, ID, tenantID := stringArguments(vm, &call, 1, 3)
+
+				resp, err := otto.GohanDbFetch(tx, schemaID, ID, tenantID)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(resp.Data())
+			},
+			"gohan_db_lock_fetch": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_lock_fetch", 5)
+				tx, needCommit, err := env.GetOrCreateTransaction(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+				schemaID, ID, tenantID := stringArguments(vm, &call, 1, 3)
+				rawLockPolicy, err := GetInt64(call.Argument(4))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				resp, err := otto.GohanDbLockFetch(tx, schemaID, ID, tenantID, schema.LockPolicy(rawLockPolicy))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(resp.Data())
+			},
+			"gohan_db_state_fetch": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_state_fetch", 4)
+				tx, needCommit, err := env.GetOrCreateTransaction(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+				schemaID, ID, tenantID := stringArguments(vm, &call, 1, 3)
+
+				data, err := otto.GohanDbStateFetch(tx, schemaID, ID, tenantID)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(data)
+			},
+			"gohan_db_create": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_create", 3)
+				tx, needCommit, schemaID, dataMap := resourceArguments(vm, &call, env)
+				if needCommit {
+					defer tx.Close()
+				}
+
+				resource, err := otto.GohanDbCreate(tx, needCommit, schemaID, dataMap)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(resource.Data())
+			},
+			"gohan_db_update": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_update", 3)
+				tx, needCommit, schemaID, dataMap := resourceArguments(vm, &call, env)
+				if needCommit {
+					defer tx.Close()
+				}
+
+				resource, err := otto.GohanDbUpdate(tx, needCommit, schemaID, dataMap)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(resource.Data())
+			},
+			"gohan_db_state_update": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_state_update", 3)
+				tx, needCommit, schemaID, dataMap := resourceArguments(vm, &call, env)
+				if needCommit {
+					defer tx.Close()
+				}
+
+				resource, err := otto.GohanDbStateUpdate(tx, needCommit, schemaID, dataMap)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(resource.Data())
+			},
+			"gohan_db_delete": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_delete", 3)
+				tx, needCommit, err := env.GetOrCreateTransaction(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+				schemaID, ID, _ := stringArguments(vm, &call, 1, 2)
+
+				if err := otto.GohanDbDelete(tx, needCommit, schemaID, ID); err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return goja.Null()
+			},
+			"gohan_db_query": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_query", 4)
+				tx, needCommit, err := env.GetOrCreateTransaction(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+				schemaID, sqlString, _ := stringArguments(vm, &call, 1, 2)
+				arguments, err := GetList(call.Argument(3))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				resp, err := otto.GohanDbQuery(tx, needCommit, schemaID, sqlString, arguments)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(resp)
+			},
+			"gohan_db_sql_make_columns": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_db_sql_make_columns", 1)
+				schemaID, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				results, err := otto.GohanDbMakeColumns(schemaID)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(results)
+			},
+		}
+
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanDBInit)
+}
+
+// stringArguments reads count string arguments starting at first, throwing if any isn't a string
+func stringArguments(vm *goja.Runtime, call *goja.FunctionCall, first, count int) (string, string, string) {
+	values := make([]string, 3)
+	for i := 0; i < count; i++ {
+		value, err := GetString(call.Argument(first + i))
+		if err != nil {
+			ThrowException(vm, err.Error())
+		}
+		values[i] = value
+	}
+	return values[0], values[1], values[2]
+}
+
+// resourceArguments reads (transaction, schema ID, resource) arguments
+func resourceArguments(vm *goja.Runtime, call *goja.FunctionCall, env *Environment) (transaction.Transaction, bool, string, map[string]interface{}) {
+	tx, needCommit, err := env.GetOrCreateTransaction(call.Argument(0))
+	if err != nil {
+		ThrowException(vm, err.Error())
+	}
+	schemaID, err := GetString(call.Argument(1))
+	if err != nil {
+		closeIf(needCommit, tx)
+		ThrowException(vm, err.Error())
+	}
+	dataMap, err := GetMap(call.Argument(2))
+	if err != nil {
+		closeIf(needCommit, tx)
+		ThrowException(vm, err.Error())
+	}
+	return tx, needCommit, schemaID, dataMap
+}
+
+func closeIf(needClose bool, tx transaction.Transaction) {
+	if needClose {
+		tx.Close()
+	}
+}
+
+func lockListArgumentsHelper(vm *goja.Runtime, call *goja.FunctionCall, env *Environment) (sh *schema.Schema, filter map[string]interface{}, pg *pagination.Paginator, tx transaction.Transaction, needCommit bool, lockPolicy schema.LockPolicy, err error) {
+	sh, filter, pg, tx, needCommit, err = listArgumentsHelper(vm, call, env)
+
+	if len(call.Arguments) < 7 {
+		lockPolicy = schema.SkipRelatedResources
+	} else {
+		var rawLockPolicy int64
+		rawLockPolicy, err = GetInt64(call.Argument(6))
+		if err != nil {
+			return
+		}
+		lockPolicy = schema.LockPolicy(rawLockPolicy)
+	}
+	return
+}
+
+func listArgumentsHelper(vm *goja.Runtime, call *goja.FunctionCall, env *Environment) (
+	schema *schema.Schema,
+	filter map[string]interface{},
+	pg *pagination.Paginator,
+	tx transaction.Transaction,
+	needCommit bool,
+	err error) {
+
+	opts := []pagination.OptionPaginator{}
+
+	if len(call.Arguments) < 3 {
+		ThrowException(vm, "Error: not enough arguments for gohan_db_list")
+	}
+
+	schemaID, err := GetString(call.Argument(1))
+	if err != nil {
+		return
+	}
+
+	schema, err = getSchema(schemaID)
+	if err != nil {
+		return
+	}
+
+	filter, err = GetMap(call.Argument(2))
+	if err != nil {
+		return
+	}
+
+	if len(call.Arguments) > 3 {
+		var orderKey string
+		orderKey, err = GetString(call.Argument(3))
+		if err != nil {
+			return
+		}
+		opts = append(opts, pagination.OptionKey(schema, orderKey))
+	}
+
+	if len(call.Arguments) > 4 {
+		var rawLimit int64
+		rawLimit, err = GetInt64(call.Argument(4))
+		if err != nil {
+			return
+		}
+		opts = append(opts, pagination.OptionLimit(uint64(rawLimit)))
+	}
+
+	if len(call.Arguments) > 5 {
+		var rawOffset int64
+		rawOffset, err = GetInt64(call.Argument(5))
+		if err != nil {
+			return
+		}
+		opts = append(opts, pagination.OptionOffset(uint64(rawOffset)))
+	}
+
+	opts = append(opts, pagination.OptionOrder(pagination.ASC)) // To match previous implementation based on mySql default
+	pg, err = pagination.NewPaginator(opts...)
+	if err != nil {
+		return
+	}
+
+	tx, needCommit, err = env.GetOrCreateTransaction(call.Argument(0))
+	return
+}
+
+func parseListResults(resources []*schema.Resource) []map[string]interface{} {
+	resp := make([]map[string]interface{}, len(resources))
+	for i, resource := range resources {
+		resp[i] = resource.Data()
+	}
+	return resp
+}
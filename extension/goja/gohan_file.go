@@ -0,0 +1,96 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/dop251/goja"
+)
+
+func init() {
+	gohanFileInit := func(env *Environment) {
+		vm := env.VM
+		builtins := map[string]interface{}{
+			"gohan_file_list": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_file_list", 1)
+				dirName := call.Argument(0).String()
+
+				cmdOut, err := exec.Command("ls", dirName).Output()
+				if err != nil {
+					ThrowException(vm, "Error in listing files: %v", err)
+				}
+				return vm.ToValue(string(cmdOut))
+			},
+			"gohan_file_dir": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_file_dir", 1)
+				fileName := call.Argument(0).String()
+				stat, err := os.Stat(fileName)
+				if err != nil {
+					ThrowException(vm, "%v", err)
+				}
+				return vm.ToValue(stat.IsDir())
+			},
+			"gohan_file_read": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_file_read", 1)
+				fileName := call.Argument(0).String()
+				bytes, err := ioutil.ReadFile(fileName)
+				if err != nil {
+					ThrowException(vm, "%v", err)
+				}
+				return vm.ToValue(string(bytes))
+			},
+			"gohan_file_read_cd": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_file_read_cd", 1)
+				fileName := call.Argument(0).String()
+
+				if !filepath.IsAbs(fileName) {
+					fileName = filepath.Join(filepath.Dir(callerSource(vm)), fileName)
+				}
+
+				bytes, err := ioutil.ReadFile(fileName)
+				if err != nil {
+					ThrowException(vm, fmt.Sprintf("%v", err))
+				}
+				return vm.ToValue(string(bytes))
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanFileInit)
+}
+
+// callerSource returns the source name of the innermost JavaScript caller
+func callerSource(vm *goja.Runtime) string {
+	for _, frame := range vm.CaptureCallStack(0, nil) {
+		source := frame.SrcName()
+		if source == "<native>" {
+			continue
+		}
+		if sourceURL, err := url.Parse(source); err == nil && sourceURL.Scheme == "file" {
+			return sourceURL.Host + sourceURL.Path
+		}
+		return source
+	}
+	return ""
+}
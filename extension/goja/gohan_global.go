@@ -0,0 +1,64 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/extension/otto"
+)
+
+func init() {
+	gohanGlobalInit := func(env *Environment) {
+		vm := env.VM
+
+		builtins := map[string]interface{}{
+			"gohan_global": gohanGlobalBuiltin(vm, "gohan_global", env.GohanGlobal),
+			// shared with otto environments of the process
+			"gohan_process_global": gohanGlobalBuiltin(vm, "gohan_process_global", otto.GohanProcessGlobal),
+			"gohan_load_hook": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_load_hook", 1)
+				funcName, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				env.loadHooks = append(env.loadHooks, funcName)
+				return vm.ToValue(true)
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanGlobalInit)
+}
+
+func gohanGlobalBuiltin(vm *goja.Runtime, builtinName string, gohanGlobal func(name string) map[string]interface{}) func(call goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		VerifyCallArguments(vm, &call, builtinName, 1)
+
+		name, err := GetString(call.Argument(0))
+		if err != nil {
+			log.Error("%s failed with error: %s", builtinName, err.Error())
+		}
+		return vm.ToValue(gohanGlobal(name))
+	}
+}
+
+//GohanGlobal returns a value shared by clones of the environment
+func (env *Environment) GohanGlobal(name string) map[string]interface{} {
+	return env.globalStore.Get(name)
+}
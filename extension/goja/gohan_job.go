@@ -0,0 +1,57 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/dop251/goja"
+)
+
+func init() {
+	gohanJobInit := func(env *Environment) {
+		vm := env.VM
+
+		builtins := map[string]interface{}{
+			"gohan_job_enqueue": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) != 3 && len(call.Arguments) != 4 {
+					ThrowException(vm, "Expected 3 or 4 arguments in gohan_job_enqueue call, %d arguments given",
+						len(call.Arguments))
+				}
+				tx, needCommit, jobType, payload := resourceArguments(vm, &call, env)
+				if needCommit {
+					defer tx.Close()
+				}
+				options := map[string]interface{}{}
+				if len(call.Arguments) == 4 {
+					var err error
+					if options, err = GetMap(call.Argument(3)); err != nil {
+						ThrowException(vm, err.Error())
+					}
+				}
+
+				id, err := otto.GohanJobEnqueue(tx, needCommit, jobType, payload, options)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				return vm.ToValue(id)
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanJobInit)
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/extension/otto"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/secret"
+)
+
+func init() {
+	gohanLoggingInit := func(env *Environment) {
+		vm := env.VM
+
+		builtins := map[string]interface{}{
+			"gohan_log_impl": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_log_impl", 4)
+
+				module, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, "Log module: %v", err)
+				}
+				logger := l.NewLogger(l.ModuleName(module))
+
+				intLevel, err := GetInt64(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, "Log level: %v", err)
+				}
+				level := l.Level(intLevel)
+
+				caller, err := GetString(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, "Caller: %v", err)
+				}
+
+				message, err := GetString(call.Argument(3))
+				if err != nil {
+					ThrowException(vm, "Message: %v", err)
+				}
+				message = secret.Redact(message)
+
+				// if caller is non-empty, add extra information about the calling handler
+				if caller != "" {
+					logGeneral(logger, level, "[%s] %s", caller, message)
+				} else {
+					logGeneral(logger, level, "%s", message)
+				}
+				return goja.Undefined()
+			},
+			"LOG_LEVEL": map[string]interface{}{
+				"CRITICAL": int(l.CRITICAL),
+				"ERROR":    int(l.ERROR),
+				"WARNING":  int(l.WARNING),
+				"NOTICE":   int(l.NOTICE),
+				"INFO":     int(l.INFO),
+				"DEBUG":    int(l.DEBUG),
+			},
+			"LOG_MODULE": "gohan.extension." + env.Name,
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+
+		if err := env.loadBuiltin("<Gohan logging built-ins>", otto.BuiltinLoggingCode); err != nil {
+			log.Fatal(err)
+		}
+	}
+	RegisterInit(gohanLoggingInit)
+}
+
+func logGeneral(logger l.Logger, level l.Level, format string, args ...interface{}) {
+	switch level {
+	case l.CRITICAL:
+		logger.Critical(format, args...)
+	case l.ERROR:
+		logger.Error(format, args...)
+	case l.WARNING:
+		logger.Warning(format, args...)
+	case l.NOTICE:
+		logger.Notice(format, args...)
+	case l.INFO:
+		logger.Info(format, args...)
+	default:
+		logger.Debug(format, args...)
+	}
+}
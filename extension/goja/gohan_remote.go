@@ -0,0 +1,183 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"bytes"
+
+	"github.com/Juniper/go-netconf/netconf"
+	"github.com/dop251/goja"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/cloudwan/gohan/util"
+)
+
+//SetUp sets up vm to with environment
+func init() {
+	gohanRemoteInit := func(env *Environment) {
+		vm := env.VM
+
+		builtins := map[string]interface{}{
+			"gohan_netconf_open": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) != 2 {
+					panic("Wrong number of arguments in gohan_netconf_open call.")
+				}
+				rawHost := call.Argument(0).Export()
+				host, ok := rawHost.(string)
+				if !ok {
+					return goja.Null()
+				}
+				rawUserName := call.Argument(1).Export()
+				userName, ok := rawUserName.(string)
+				if !ok {
+					return goja.Null()
+				}
+				config := util.GetConfig()
+				publicKeyFile := config.GetString("ssh/key_file", "")
+				if publicKeyFile == "" {
+					return goja.Null()
+				}
+				sshConfig := &ssh.ClientConfig{
+					User: userName,
+					Auth: []ssh.AuthMethod{
+						util.PublicKeyFile(publicKeyFile),
+					},
+				}
+				s, err := netconf.DialSSH(host, sshConfig)
+
+				if err != nil {
+					ThrowException(vm, "Error during gohan_netconf_open: %s", err.Error())
+				}
+				return vm.ToValue(s)
+			},
+			"gohan_netconf_close": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) != 1 {
+					panic("Wrong number of arguments in gohan_netconf_close call.")
+				}
+				rawSession := call.Argument(0).Export()
+				s, ok := rawSession.(*netconf.Session)
+				if !ok {
+					ThrowException(vm, "Error during gohan_netconf_close")
+				}
+				s.Close()
+				return goja.Null()
+			},
+			"gohan_netconf_exec": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) != 2 {
+					panic("Wrong number of arguments in gohan_netconf_exec call.")
+				}
+				rawSession := call.Argument(0).Export()
+				s, ok := rawSession.(*netconf.Session)
+				if !ok {
+					return goja.Null()
+				}
+				rawCommand := call.Argument(1).Export()
+				command, ok := rawCommand.(string)
+				if !ok {
+					return goja.Null()
+				}
+				reply, err := s.Exec(netconf.RawMethod(command))
+				resp := map[string]interface{}{}
+				if err != nil {
+					resp["status"] = "error"
+					resp["output"] = err.Error()
+				} else {
+					resp["status"] = "success"
+					resp["output"] = reply
+				}
+				return vm.ToValue(resp)
+			},
+			"gohan_ssh_open": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) != 2 {
+					panic("Wrong number of arguments in gohan_ssh_open call.")
+				}
+				rawHost := call.Argument(0).Export()
+				host, ok := rawHost.(string)
+				if !ok {
+					return goja.Null()
+				}
+				rawUserName := call.Argument(1).Export()
+				userName, ok := rawUserName.(string)
+				if !ok {
+					return goja.Null()
+				}
+				config := util.GetConfig()
+				publicKeyFile := config.GetString("ssh/key_file", "")
+				if publicKeyFile == "" {
+					return goja.Null()
+				}
+				sshConfig := &ssh.ClientConfig{
+					User: userName,
+					Auth: []ssh.AuthMethod{
+						util.PublicKeyFile(publicKeyFile),
+					},
+				}
+				conn, err := ssh.Dial("tcp", host, sshConfig)
+				if err != nil {
+					ThrowException(vm, "Error during gohan_ssh_open %s", err)
+				}
+				session, err := conn.NewSession()
+				if err != nil {
+					ThrowException(vm, "Error during gohan_ssh_open %s", err)
+				}
+				return vm.ToValue(session)
+			},
+			"gohan_ssh_close": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) != 1 {
+					panic("Wrong number of arguments in gohan_ssh_close call.")
+				}
+				rawSession := call.Argument(0).Export()
+				s, ok := rawSession.(*ssh.Session)
+				if !ok {
+					ThrowException(vm, "Error during gohan_ssh_close")
+				}
+				s.Close()
+				return goja.Null()
+			},
+			"gohan_ssh_exec": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) != 2 {
+					panic("Wrong number of arguments in gohan_ssh_exec call.")
+				}
+				rawSession := call.Argument(0).Export()
+				s, ok := rawSession.(*ssh.Session)
+				if !ok {
+					return goja.Null()
+				}
+				rawCommand := call.Argument(1).Export()
+				command, ok := rawCommand.(string)
+				if !ok {
+					return goja.Null()
+				}
+				var stdoutBuf bytes.Buffer
+				s.Stdout = &stdoutBuf
+				err := s.Run(command)
+				resp := map[string]interface{}{}
+				if err != nil {
+					resp["status"] = "error"
+					resp["output"] = err.Error()
+				} else {
+					resp["status"] = "success"
+					resp["output"] = stdoutBuf.String()
+				}
+				return vm.ToValue(resp)
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanRemoteInit)
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/server/resources"
+)
+
+func handleChainError(vm *goja.Runtime, err error) {
+	switch err := err.(type) {
+	default:
+		ThrowException(vm, err.Error())
+	case resources.ResourceError:
+		Throw(vm, "ResourceException", err.Message, int64(err.Problem))
+	case extension.Error:
+		Throw(vm, "ExtensionException", err.Error(), err.ExceptionInfo)
+	}
+}
+
+func init() {
+	gohanChainingInit := func(env *Environment) {
+		vm := env.VM
+		// reads the context and schema ID arguments of all gohan_model builtins
+		contextArguments := func(call *goja.FunctionCall) (map[string]interface{}, string) {
+			context, err := GetMap(call.Argument(0))
+			if err != nil {
+				ThrowException(vm, err.Error())
+			}
+			schemaID, err := GetString(call.Argument(1))
+			if err != nil {
+				ThrowException(vm, err.Error())
+			}
+			return context, schemaID
+		}
+		// tenant and domain IDs are optional
+		optionalStringList := func(value goja.Value) []string {
+			list, err := GetStringList(value)
+			if err != nil {
+				return nil
+			}
+			return list
+		}
+		builtins := map[string]interface{}{
+			"gohan_model_list": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_model_list", 3)
+				context, schemaID := contextArguments(&call)
+				filterMap, err := GetMap(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				resources, err := otto.GohanModelList(context, schemaID, filterMap)
+				if err != nil {
+					handleChainError(vm, err)
+				}
+				return vm.ToValue(resources)
+			},
+			"gohan_model_fetch": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_model_fetch", 4)
+				context, schemaID := contextArguments(&call)
+				resourceID, err := GetString(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				resource, err := otto.GohanModelFetch(context, schemaID, resourceID,
+					optionalStringList(call.Argument(3)), optionalStringList(call.Argument(4)))
+				if err != nil {
+					handleChainError(vm, err)
+				}
+				return vm.ToValue(resource)
+			},
+			"gohan_model_create": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_model_create", 3)
+				context, schemaID := contextArguments(&call)
+				dataMap, err := GetMap(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				resource, err := otto.GohanModelCreate(context, schemaID, dataMap)
+				if err != nil {
+					handleChainError(vm, err)
+				}
+				return vm.ToValue(resource)
+			},
+			"gohan_model_update": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_model_update", 5)
+				context, schemaID := contextArguments(&call)
+				resourceID, err := GetString(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				dataMap, err := GetMap(call.Argument(3))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				resource, err := otto.GohanModelUpdate(context, schemaID, resourceID, dataMap,
+					optionalStringList(call.Argument(4)), optionalStringList(call.Argument(5)))
+				if err != nil {
+					handleChainError(vm, err)
+				}
+				return vm.ToValue(resource)
+			},
+			"gohan_model_delete": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_model_delete", 3)
+				context, schemaID := contextArguments(&call)
+				resourceID, err := GetString(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				if err := otto.GohanModelDelete(context, schemaID, resourceID); err != nil {
+					handleChainError(vm, err)
+				}
+				return goja.Undefined()
+			},
+		}
+
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanChainingInit)
+}
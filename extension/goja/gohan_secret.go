@@ -0,0 +1,48 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/secret"
+)
+
+func init() {
+	gohanSecretInit := func(env *Environment) {
+		vm := env.VM
+
+		builtins := map[string]interface{}{
+			"gohan_secret_get": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_secret_get", 1)
+
+				name, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, "Invalid type of first argument: expected a string")
+				}
+				value, err := secret.Get(name, env.path)
+				if err != nil {
+					ThrowException(vm, "Failed to get secret %s: %s", name, err)
+				}
+				return vm.ToValue(value)
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanSecretInit)
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"context"
+
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/extension/otto"
+)
+
+func init() {
+	gohanSyncInit := func(env *Environment) {
+		vm := env.VM
+		env.syncLocks = otto.NewSyncLocks(env.Sync)
+
+		builtins := map[string]interface{}{
+			"gohan_sync_update": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_sync_update", 2)
+
+				path, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, "Invalid type of first argument: expected a string")
+				}
+				value, err := GetString(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, "Invalid type of second argument: expected a string")
+				}
+
+				errCh := make(chan error, 1)
+				go func() {
+					errCh <- env.Sync.Update(context.Background(), path, value)
+				}()
+
+				select {
+				case <-env.Interrupted():
+					log.Debug("Received goja interrupt in gohan_sync_update")
+				case err = <-errCh:
+					if err != nil {
+						ThrowException(vm, "Failed to update sync: %s", err)
+					}
+				}
+				return goja.Null()
+			},
+			"gohan_sync_lock": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_sync_lock", 2)
+
+				path, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, "Invalid type of first argument: expected a string")
+				}
+				block, err := GetBool(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, "Invalid type of second argument: expected a boolean")
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go func() {
+					select {
+					case <-env.Interrupted():
+						log.Debug("Received goja interrupt in gohan_sync_lock")
+						cancel()
+					case <-ctx.Done():
+					}
+				}()
+
+				if err = env.syncLocks.Lock(ctx, path, block); err != nil {
+					if !block {
+						log.Debug("Failed to lock %s: %s", path, err)
+						return vm.ToValue(false)
+					}
+					ThrowException(vm, "Failed to lock sync: %s", err)
+				}
+				return vm.ToValue(true)
+			},
+			"gohan_sync_unlock": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_sync_unlock", 1)
+
+				path, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, "Invalid type of first argument: expected a string")
+				}
+				if err = env.syncLocks.Unlock(context.Background(), path); err != nil {
+					ThrowException(vm, "Failed to unlock sync: %s", err)
+				}
+				return goja.Null()
+			},
+			"gohan_sync_compare_and_swap": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_sync_compare_and_swap", 3)
+
+				path, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, "Invalid type of first argument: expected a string")
+				}
+				value, err := GetString(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, "Invalid type of second argument: expected a string")
+				}
+				expected, err := GetString(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, "Invalid type of third argument: expected a string")
+				}
+
+				swapped, err := env.Sync.CompareAndSwap(context.Background(), path, value, env.Sync.ByValue(expected))
+				if err != nil {
+					ThrowException(vm, "Failed to compare and swap sync: %s", err)
+				}
+				return vm.ToValue(swapped)
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanSyncInit)
+}
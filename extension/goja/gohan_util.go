@@ -0,0 +1,334 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/twinj/uuid"
+
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/httpclient"
+	"github.com/cloudwan/gohan/util"
+)
+
+const (
+	unknownSchemaErrorMesssageFormat = "Unknown schema '%s'"
+	defaultHTTPRequestTimeout        = 3000
+)
+
+// rawHTTPResult is the response of a request made by gohan_raw_http
+type rawHTTPResult struct {
+	resp *http.Response
+	err  error
+}
+
+func init() {
+	gohanUtilInit := func(env *Environment) {
+		vm := env.VM
+		builtins := map[string]interface{}{
+			"gohan_http": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) == 4 {
+					call.Arguments = append(call.Arguments, vm.ToValue(false))
+				}
+				if len(call.Arguments) == 5 {
+					call.Arguments = append(call.Arguments, vm.ToValue(defaultHTTPRequestTimeout))
+				}
+				if len(call.Arguments) == 6 {
+					call.Arguments = append(call.Arguments, goja.Null())
+				}
+				VerifyCallArguments(vm, &call, "gohan_http", 7)
+				method, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				url, err := GetString(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				rawHeaders, err := GetMap(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				// A string or a map[string]interface{}
+				data := export(call.Argument(3))
+				opaque, err := GetBool(call.Argument(4))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				timeout, err := GetInt64(call.Argument(5))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				options, err := getHTTPOptions(call.Argument(6))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				log.Debug("gohan_http  [%s] %s %s %s %s", method, rawHeaders, url, opaque, timeout)
+
+				ctx, cancel := httpclient.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond, env.deadline)
+				defer cancel()
+
+				var (
+					code    int
+					headers http.Header
+					body    string
+				)
+
+				done := make(chan struct{})
+				go func() {
+					code, headers, body, err = otto.GohanHTTP(ctx, method, url, rawHeaders, data, opaque, options)
+					close(done)
+				}()
+
+				select {
+				case <-env.Interrupted():
+					log.Debug("Received goja interrupt in gohan_http")
+					cancel()
+					return goja.Undefined()
+				case <-done:
+				}
+
+				log.Debug("response code %d", code)
+				resp := map[string]interface{}{}
+				if err != nil {
+					resp["status"] = "err"
+					resp["error"] = err.Error()
+				} else {
+					resp["status"] = "success"
+					resp["status_code"] = fmt.Sprint(code)
+					resp["body"] = body
+					resp["headers"] = headers
+				}
+				return vm.ToValue(resp)
+			},
+			"gohan_raw_http": func(call goja.FunctionCall) goja.Value {
+				if len(call.Arguments) == 4 {
+					call.Arguments = append(call.Arguments, goja.Null())
+				}
+				VerifyCallArguments(vm, &call, "gohan_raw_http", 5)
+				method, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				url, err := GetString(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				rawHeaders, err := GetMap(call.Argument(2))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				rawData, err := GetString(call.Argument(3))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				options, err := getHTTPOptions(call.Argument(4))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				ctx, cancel := httpclient.WithTimeout(context.Background(), 0, env.deadline)
+				defer cancel()
+
+				// prepare request
+				req, err := http.NewRequest(method, url, strings.NewReader(rawData))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				req = req.WithContext(ctx)
+
+				// set headers
+				for header, rawValue := range rawHeaders {
+					value, ok := rawValue.(string)
+					if !ok {
+						ThrowException(vm, "Header '%s' value must be a string type", header)
+					}
+					req.Header.Set(header, value)
+				}
+
+				// run query, the response is handed over only while the call waits for it
+				results := make(chan rawHTTPResult)
+				interrupted := make(chan struct{})
+				go func() {
+					resp, err := httpclient.Do(req, http.DefaultTransport.RoundTrip, options)
+					select {
+					case results <- rawHTTPResult{resp: resp, err: err}:
+					case <-interrupted:
+						// the body has to be closed to release the connection and the slot of the host
+						if err == nil {
+							resp.Body.Close()
+						}
+					}
+				}()
+
+				var response rawHTTPResult
+				select {
+				case <-env.Interrupted():
+					log.Debug("Received goja interrupt in gohan_raw_http")
+					cancel()
+					close(interrupted)
+					return goja.Undefined()
+				case response = <-results:
+				}
+
+				resp, err := response.resp, response.err
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				defer resp.Body.Close()
+
+				// process resp
+				result := map[string]interface{}{}
+				result["status"] = resp.Status
+				result["status_code"] = resp.StatusCode
+				result["headers"] = resp.Header
+
+				body, err := ioutil.ReadAll(resp.Body)
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				result["body"] = string(body)
+
+				return vm.ToValue(result)
+			},
+			"gohan_uuid": func(call goja.FunctionCall) goja.Value {
+				return vm.ToValue(uuid.NewV4().String())
+			},
+			"gohan_sleep": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_sleep", 1)
+				milliseconds, _ := GetInt64(call.Argument(0))
+				sleep := time.Duration(milliseconds) * time.Millisecond
+				log.Debug("Sleep %s", sleep)
+				timer := time.NewTimer(sleep)
+				defer timer.Stop()
+				select {
+				case <-env.Interrupted():
+					log.Debug("Received goja interrupt in gohan_sleep")
+				case <-timer.C:
+				}
+				return goja.Null()
+			},
+			"gohan_template": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_template", 2)
+				templateString, err := GetString(call.Argument(0))
+				if err != nil {
+					return call.Argument(0)
+				}
+				data := export(call.Argument(1))
+				t := template.Must(template.New("tmpl").Parse(templateString))
+				b := bytes.NewBuffer(make([]byte, 0, 100))
+				t.Execute(b, data)
+				return vm.ToValue(b.String())
+			},
+			"gohan_exec": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_exec", 2)
+				command, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				stringArgs, err := GetStringList(call.Argument(1))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+
+				cmd := exec.Command(command, stringArgs...)
+				var stdout bytes.Buffer
+				cmd.Stdout = &stdout
+				cmd.Stderr = &stdout
+
+				done := make(chan struct{})
+				go func() {
+					if err = cmd.Run(); err != nil {
+						log.Debug("Run %s %s error: %s", command, stringArgs, err)
+					}
+					close(done)
+				}()
+
+				select {
+				case <-env.Interrupted():
+					log.Debug("Received goja interrupt in gohan_exec")
+					if cmd.Process != nil {
+						if err := cmd.Process.Kill(); err != nil {
+							log.Debug("Kill %s %s failed: %s", command, stringArgs, err)
+						}
+					}
+					return goja.Undefined()
+				case <-done:
+				}
+
+				resp := map[string]string{}
+				if err != nil {
+					resp["status"] = "error"
+					resp["output"] = err.Error()
+				} else {
+					resp["status"] = "success"
+					resp["output"] = stdout.String()
+				}
+				return vm.ToValue(resp)
+			},
+			"gohan_config": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_config", 2)
+				configKey, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowException(vm, err.Error())
+				}
+				defaultValue := export(call.Argument(1))
+				config := util.GetConfig()
+				return vm.ToValue(config.GetParam(configKey, defaultValue))
+			},
+			"gohan_get_env": func(call goja.FunctionCall) goja.Value {
+				VerifyCallArguments(vm, &call, "gohan_get_env", 2)
+				key, err := GetString(call.Argument(0))
+				ThrowWithMessageIfHappened(vm, err, "Expected one string argument")
+				defaultValue, err := GetString(call.Argument(1))
+				ThrowWithMessageIfHappened(vm, err, "Expected default value")
+				result := os.Getenv(key)
+				if result == "" {
+					result = defaultValue
+				}
+				return vm.ToValue(result)
+			},
+		}
+
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanUtilInit)
+}
+
+// getHTTPOptions reads optional options of HTTP builtins
+func getHTTPOptions(value goja.Value) (httpclient.Options, error) {
+	if goja.IsUndefined(value) || goja.IsNull(value) {
+		return httpclient.Options{}, nil
+	}
+	options, err := GetMap(value)
+	if err != nil {
+		return httpclient.Options{}, err
+	}
+	return httpclient.OptionsFromMap(options)
+}
@@ -0,0 +1,508 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	ext "github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/sync"
+)
+
+var inits = []func(env *Environment){}
+
+//Environment is an ES2020 javascript based environment for gohan extension.
+//It runs extensions of the "javascript" code type, same as the otto environment.
+type Environment struct {
+	Name        string
+	VM          *goja.Runtime
+	DataStore   db.DB
+	timeLimit   time.Duration
+	timeLimits  []*schema.EventTimeLimit
+	Identity    middleware.IdentityService
+	Sync        sync.Sync
+	globalStore *otto.GlobalStore
+	loadHooks   []string
+	// path extensions are loaded for, secrets are allowed by it
+	path string
+	// programs loaded to the VM, replayed in clones
+	programs []*goja.Program
+	// objects registered in the VM by Go code, set again in clones
+	objects map[string]interface{}
+	modules map[string]goja.Value
+	closers []io.Closer
+	// locks taken by extensions, released when the handled event returns
+	syncLocks *otto.SyncLocks
+	// interrupted is closed when the handled event is interrupted
+	interrupted chan struct{}
+	// deadline is the time the handled event times out at
+	deadline time.Time
+}
+
+//NewEnvironment create new gohan extension environment based on context
+func NewEnvironment(name string, dataStore db.DB, identity middleware.IdentityService, sync sync.Sync) *Environment {
+	env := &Environment{
+		Name:        name,
+		VM:          goja.New(),
+		DataStore:   dataStore,
+		Identity:    identity,
+		Sync:        sync,
+		globalStore: otto.NewGlobalStore(),
+		loadHooks:   make([]string, 0),
+		objects:     map[string]interface{}{},
+		modules:     map[string]goja.Value{},
+		interrupted: make(chan struct{}),
+	}
+	env.SetUp()
+	return env
+}
+
+//SetUp initialize environment
+func (env *Environment) SetUp() {
+	for _, init := range inits {
+		init(env)
+	}
+}
+
+//RegisterInit registers init code
+func RegisterInit(init func(env *Environment)) {
+	inits = append(inits, init)
+}
+
+//Load loads script for environment
+func (env *Environment) Load(source, code string) error {
+	vm := env.VM
+
+	for _, hook := range env.loadHooks {
+		hookFunction, ok := goja.AssertFunction(vm.Get(hook))
+		if !ok {
+			return fmt.Errorf("load hook %s is not a function", hook)
+		}
+		rv, err := hookFunction(goja.Undefined(), vm.ToValue(code), vm.ToValue(source))
+		if err != nil {
+			return err
+		}
+		transformedCode, err := GetString(rv)
+		if err != nil {
+			return err
+		}
+		code = transformedCode
+	}
+
+	program, err := goja.Compile(source, code, false)
+	if err != nil {
+		// parser errors already start with the source name and position, same as in otto
+		if syntaxError, ok := err.(*goja.CompilerSyntaxError); ok && syntaxError.File == nil {
+			return errors.New(syntaxError.Message)
+		}
+		return err
+	}
+	return env.run(program)
+}
+
+func (env *Environment) run(program *goja.Program) error {
+	if _, err := env.VM.RunProgram(program); err != nil {
+		return err
+	}
+	env.programs = append(env.programs, program)
+	return nil
+}
+
+// setHandlerSource names handlers registered by the next loaded extension in profiles,
+// it's run as a program to be repeated in clones
+func (env *Environment) setHandlerSource(id, url string) error {
+	source := url
+	if source == "" {
+		source = id
+	}
+	quoted, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	program, err := goja.Compile("", "gohan_handler_source = "+string(quoted)+";", false)
+	if err != nil {
+		return err
+	}
+	return env.run(program)
+}
+
+//RegisterObject register new object for VM
+func (env *Environment) RegisterObject(objectID string, object interface{}) {
+	env.objects[objectID] = object
+	env.VM.Set(objectID, object)
+}
+
+//LoadExtensionsForPath loads extensions for specific path
+func (env *Environment) LoadExtensionsForPath(extensions []*schema.Extension, timeLimit time.Duration, timeLimits []*schema.PathEventTimeLimit, path string) error {
+	env.path = path
+	for _, extension := range extensions {
+		if extension.Match(path) {
+			code := extension.Code
+			if extension.CodeType != "javascript" {
+				continue
+			}
+			url := strings.TrimPrefix(extension.URL, "file://")
+			if err := env.setHandlerSource(extension.ID, url); err != nil {
+				return err
+			}
+			err := env.Load(url, code)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// setup time limits for matching extensions
+	env.timeLimit = timeLimit
+	for _, timeLimit := range timeLimits {
+		if timeLimit.Match(path) {
+			env.timeLimits = append(env.timeLimits, schema.NewEventTimeLimit(timeLimit.EventRegex, timeLimit.TimeDuration))
+		}
+	}
+	return nil
+}
+
+//HandleEvent handles event
+func (env *Environment) HandleEvent(event string, context map[string]interface{}) (err error) {
+	vm := env.VM
+	var closeNotify <-chan bool
+	if httpResponse, ok := context["http_response"]; ok {
+		if closeNotifier, ok := httpResponse.(http.CloseNotifier); ok {
+			closeNotify = closeNotifier.CloseNotify()
+		}
+	}
+	context["event_type"] = event
+	var timeout = fmt.Errorf("exceed timeout for extension execution for event: %s", event)
+	var disconnected = fmt.Errorf("client disconnected for event: %s", event)
+
+	// take time limit from first passing regex or default
+	selectedTimeLimit := env.timeLimit
+	for _, timeLimit := range env.timeLimits {
+		if timeLimit.Match(event) {
+			selectedTimeLimit = timeLimit.TimeDuration
+			break
+		}
+	}
+	timer := time.NewTimer(selectedTimeLimit)
+	defer timer.Stop()
+	previousDeadline := env.deadline
+	env.deadline = time.Now().Add(selectedTimeLimit)
+	defer func() { env.deadline = previousDeadline }()
+	interrupted := make(chan struct{})
+	env.interrupted = interrupted
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-closeNotify:
+			metrics.UpdateCounter(1, "req.peer_disconnect")
+			vm.Interrupt(disconnected)
+			close(interrupted)
+		case <-timer.C:
+			vm.Interrupt(timeout)
+			close(interrupted)
+		case <-done:
+			// extension executed successfully
+		}
+	}()
+
+	defer func() {
+		// cleanup Closers
+		log.Debug("Closers for vm %p: %v", vm, env.closers)
+		for _, closer := range env.closers {
+			if closer != nil {
+				if err := closer.Close(); err != nil {
+					log.Error("Error when closing object %T %p : %s", closer, closer, err)
+				}
+			}
+		}
+		env.closers = nil
+		env.syncLocks.Release()
+	}()
+
+	handleEvent, ok := goja.AssertFunction(vm.Get("gohan_handle_event"))
+	if !ok {
+		return fmt.Errorf("%s: gohan_handle_event is not defined", event)
+	}
+	_, err = handleEvent(goja.Undefined(), vm.ToValue(event), vm.ToValue(context))
+
+	close(done)
+	<-watcherDone
+	// the VM might have been interrupted after the handler returned
+	vm.ClearInterrupt()
+
+	switch caught := err.(type) {
+	case nil:
+		return nil
+	case *goja.InterruptedError:
+		if caughtError, ok := caught.Value().(error); ok {
+			log.Warning(caughtError.Error())
+			return caughtError
+		}
+		return fmt.Errorf("%s: %s", event, caught.String())
+	case *goja.Exception:
+		return fmt.Errorf("%s: %s", event, caught.String())
+	default:
+		return fmt.Errorf("%s: %s", event, err.Error())
+	}
+}
+
+// Interrupted returns a channel closed when the handled event is interrupted,
+// builtins blocking on Go code should return when it's closed
+func (env *Environment) Interrupted() <-chan struct{} {
+	return env.interrupted
+}
+
+func (env *Environment) addCloser(closer io.Closer) {
+	log.Debug("Registering closer %p in VM %p", closer, env.VM)
+	env.closers = append(env.closers, closer)
+}
+
+// SetEventTimeLimit overrides the default time limit for a given event for this environment
+func (env *Environment) SetEventTimeLimit(eventRegex string, timeLimit time.Duration) {
+	env.timeLimits = append(env.timeLimits, schema.NewEventTimeLimit(regexp.MustCompile(eventRegex), timeLimit))
+}
+
+//Clone makes clone of the environment. Goja VMs can't be copied,
+//so programs loaded to this environment are run again in a new VM.
+//Top-level code of the extensions runs once per clone: globals start from
+//the values left by the top-level code, like in the otto environment
+//copied right after loading, but side effects of the top-level code
+//(e.g. gohan_* calls) happen again for every clone.
+func (env *Environment) Clone() ext.Environment {
+	clone := NewEnvironment(env.Name, env.DataStore, env.Identity, env.Sync)
+	clone.timeLimit = env.timeLimit
+	clone.timeLimits = env.timeLimits
+	clone.path = env.path
+	clone.globalStore = env.globalStore
+	for _, hook := range env.loadHooks {
+		clone.loadHooks = append(clone.loadHooks, hook)
+	}
+	for objectID, object := range env.objects {
+		clone.RegisterObject(objectID, object)
+	}
+	setUpPrograms := len(clone.programs)
+	for _, program := range env.programs[setUpPrograms:] {
+		if err := clone.run(program); err != nil {
+			log.Error("Failed to load a program in a clone of %s: %s", env.Name, err)
+		}
+	}
+	return clone
+}
+
+// IsEventHandled returns whether a given event is handled by this environment
+func (env *Environment) IsEventHandled(event string, context map[string]interface{}) bool {
+	handlers := env.VM.Get("gohan_handler")
+	if handlers == nil || goja.IsUndefined(handlers) || goja.IsNull(handlers) {
+		return false
+	}
+	handler := handlers.ToObject(env.VM).Get(event)
+	return handler != nil && !goja.IsUndefined(handler)
+}
+
+//GetOrCreateTransaction gets transaction from goja value or creates new is goja value is null
+func (env *Environment) GetOrCreateTransaction(value goja.Value) (transaction.Transaction, bool, error) {
+	if !goja.IsNull(value) {
+		tx, err := GetTransaction(value)
+		return tx, false, err
+	}
+	dataStore := env.DataStore
+	tx, err := dataStore.BeginTx()
+	if err != nil {
+		return nil, false, fmt.Errorf("Error creating transaction: %v", err.Error())
+	}
+	return tx, true, nil
+}
+
+// ClearEnvironment closes sync and db in env
+func (env *Environment) ClearEnvironment() {
+	env.syncLocks.Release()
+	env.Sync.Close()
+	env.DataStore.Close()
+}
+
+//Throw throws custom JavaScript exception
+func Throw(vm *goja.Runtime, exceptionName string, arguments ...interface{}) {
+	values := make([]goja.Value, 0, len(arguments))
+	for _, argument := range arguments {
+		values = append(values, vm.ToValue(argument))
+	}
+	exception, err := vm.New(vm.Get(exceptionName), values...)
+	if err != nil {
+		panic(vm.NewGoError(err))
+	}
+	panic(exception)
+}
+
+//ThrowException throws a JavaScript exception
+func ThrowException(vm *goja.Runtime, format string, arguments ...interface{}) {
+	Throw(vm, "Error", fmt.Sprintf(format, arguments...))
+}
+
+// ThrowIfHappened throws an exception with err's message if err happened
+func ThrowIfHappened(vm *goja.Runtime, err error) {
+	ThrowWithMessageIfHappened(vm, err, "%v", err)
+}
+
+// ThrowWithMessageIfHappened throws an exception with the formatted message if err happened
+func ThrowWithMessageIfHappened(vm *goja.Runtime, err error, format string, arguments ...interface{}) {
+	if err != nil {
+		ThrowException(vm, format, arguments...)
+	}
+}
+
+//VerifyCallArguments verify number of calles
+func VerifyCallArguments(vm *goja.Runtime, call *goja.FunctionCall, functionName string, expectedArgumentsCount int) {
+	if len(call.Arguments) != expectedArgumentsCount {
+		ThrowException(vm, "Expected %d arguments in %s call, %d arguments given",
+			expectedArgumentsCount, functionName, len(call.Arguments))
+	}
+}
+
+const wrongArgumentType string = "Argument '%v' should be of type '%s'"
+
+func export(value goja.Value) interface{} {
+	if value == nil {
+		return nil
+	}
+	return value.Export()
+}
+
+//GetString gets string from goja value
+func GetString(value goja.Value) (string, error) {
+	rawString := export(value)
+	result, ok := rawString.(string)
+	if !ok {
+		return "", fmt.Errorf(wrongArgumentType, rawString, "string")
+	}
+	return result, nil
+}
+
+//GetMap gets map[string]interface{} from goja value
+func GetMap(value goja.Value) (map[string]interface{}, error) {
+	rawMap := export(value)
+	result, ok := rawMap.(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}, fmt.Errorf(wrongArgumentType, rawMap, "Object")
+	}
+	return result, nil
+}
+
+//GetTransaction gets Transaction from goja value
+func GetTransaction(value goja.Value) (transaction.Transaction, error) {
+	rawTransaction := export(value)
+	result, ok := rawTransaction.(transaction.Transaction)
+	if !ok {
+		return nil, fmt.Errorf(wrongArgumentType, rawTransaction, "Transaction")
+	}
+	return result, nil
+}
+
+//GetAuthorization gets Authorization from goja value
+func GetAuthorization(value goja.Value) (schema.Authorization, error) {
+	rawAuthorization := export(value)
+	result, ok := rawAuthorization.(schema.Authorization)
+	if !ok {
+		return nil, fmt.Errorf(wrongArgumentType, rawAuthorization, "Authorization")
+	}
+	return result, nil
+}
+
+//GetBool gets bool from goja value
+func GetBool(value goja.Value) (bool, error) {
+	rawBool := export(value)
+	result, ok := rawBool.(bool)
+	if !ok {
+		return false, fmt.Errorf(wrongArgumentType, rawBool, "bool")
+	}
+	return result, nil
+}
+
+//GetList gets []interface{} from goja value
+func GetList(value goja.Value) ([]interface{}, error) {
+	rawSlice := export(value)
+	result := make([]interface{}, 0)
+	if rawSlice == nil {
+		return result, nil
+	}
+	typeOfSlice := reflect.TypeOf(rawSlice)
+	if typeOfSlice.Kind() != reflect.Array && typeOfSlice.Kind() != reflect.Slice {
+		return result, fmt.Errorf(wrongArgumentType, value, "array")
+	}
+	list := reflect.ValueOf(rawSlice)
+	for i := 0; i < list.Len(); i++ {
+		result = append(result, list.Index(i).Interface())
+	}
+	return result, nil
+}
+
+//GetStringList gets []string from goja value
+func GetStringList(value goja.Value) ([]string, error) {
+	rawData := export(value)
+	switch rawData := rawData.(type) {
+	case []string:
+		return rawData, nil
+	case []interface{}:
+		result := make([]string, 0, len(rawData))
+		for _, item := range rawData {
+			itemString, ok := item.(string)
+			if !ok {
+				return make([]string, 0), fmt.Errorf(wrongArgumentType, rawData, "array of strings")
+			}
+			result = append(result, itemString)
+		}
+		return result, nil
+	}
+	return make([]string, 0), fmt.Errorf(wrongArgumentType, rawData, "array of strings")
+}
+
+//GetInt64 gets int64 from goja value
+func GetInt64(value goja.Value) (int64, error) {
+	switch number := export(value).(type) {
+	case int64:
+		return number, nil
+	case float64:
+		return int64(number), nil
+	case int:
+		return int64(number), nil
+	}
+	return 0, fmt.Errorf(wrongArgumentType, value, "int64")
+}
+
+func getSchema(schemaID string) (*schema.Schema, error) {
+	manager := schema.GetManager()
+	schema, ok := manager.Schema(schemaID)
+	if !ok {
+		return nil, fmt.Errorf(unknownSchemaErrorMesssageFormat, schemaID)
+	}
+	return schema, nil
+}
@@ -0,0 +1,330 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goja
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dop251/goja"
+
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/util"
+)
+
+// require loads a module, which is a built-in node module replacement,
+// a CommonJS module from the npm path or a module registered by Go extensions.
+// Relative module names are resolved against dir.
+func (env *Environment) require(moduleName, dir string) (goja.Value, error) {
+	if module, ok := env.modules[moduleName]; ok {
+		return module, nil
+	}
+	if builtin, ok := builtinModules[moduleName]; ok {
+		module := builtin(env)
+		env.modules[moduleName] = module
+		return module, nil
+	}
+
+	fileName, err := findFileModule(moduleName, dir)
+	if err != nil {
+		log.Debug("Loading module %s from npm failed: %s, trying to load from Go extensions", moduleName, err)
+		rawModule, err := otto.RequireModule(moduleName)
+		if err != nil {
+			return nil, err
+		}
+		return env.VM.ToValue(rawModule), nil
+	}
+	if module, ok := env.modules[fileName]; ok {
+		return module, nil
+	}
+	return env.loadFileModule(fileName)
+}
+
+// findFileModule resolves a module name to the entry point of the module
+func findFileModule(moduleName, dir string) (string, error) {
+	var base string
+	if strings.HasPrefix(moduleName, "./") || strings.HasPrefix(moduleName, "../") || filepath.IsAbs(moduleName) {
+		if dir == "" {
+			dir = "."
+		}
+		base = moduleName
+		if !filepath.IsAbs(moduleName) {
+			base = filepath.Join(dir, moduleName)
+		}
+	} else {
+		npmPath := util.GetConfig().GetString("extension/npm_path", ".")
+		base = filepath.Join(npmPath, "node_modules", moduleName)
+		if main := packageMain(base); main != "" {
+			base = filepath.Join(base, main)
+		}
+	}
+
+	for _, candidate := range []string{base, base + ".js", base + ".json", filepath.Join(base, "index.js")} {
+		if candidateFile, err := os.Stat(candidate); err == nil && !candidateFile.IsDir() {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("Cannot find module %s", moduleName)
+}
+
+// packageMain returns the main entry of package.json in the module directory
+func packageMain(dir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return ""
+	}
+	var pkg struct {
+		Main string `json:"main"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return ""
+	}
+	return pkg.Main
+}
+
+// loadFileModule runs a CommonJS module and returns its exports
+func (env *Environment) loadFileModule(fileName string) (goja.Value, error) {
+	vm := env.VM
+	source, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(fileName) == ".json" {
+		var data interface{}
+		if err := json.Unmarshal(source, &data); err != nil {
+			return nil, err
+		}
+		module := vm.ToValue(data)
+		env.modules[fileName] = module
+		return module, nil
+	}
+
+	wrapped := "(function(exports, require, module, __filename, __dirname) {" + string(source) + "\n})"
+	program, err := goja.Compile(fileName, wrapped, false)
+	if err != nil {
+		return nil, err
+	}
+	wrapper, err := vm.RunProgram(program)
+	if err != nil {
+		return nil, err
+	}
+	moduleFunction, ok := goja.AssertFunction(wrapper)
+	if !ok {
+		return nil, fmt.Errorf("Failed to load module %s", fileName)
+	}
+
+	dir := filepath.Dir(fileName)
+	module := vm.NewObject()
+	exports := vm.NewObject()
+	module.Set("exports", exports)
+	// cache exports before running the module to support cyclic dependencies
+	env.modules[fileName] = exports
+	requireFromModule := func(call goja.FunctionCall) goja.Value {
+		VerifyCallArguments(vm, &call, "require", 1)
+		moduleName, err := GetString(call.Argument(0))
+		if err != nil {
+			ThrowException(vm, err.Error())
+		}
+		value, err := env.require(moduleName, dir)
+		if err != nil {
+			ThrowException(vm, err.Error())
+		}
+		return value
+	}
+	if _, err := moduleFunction(exports, exports, vm.ToValue(requireFromModule), module, vm.ToValue(fileName), vm.ToValue(dir)); err != nil {
+		delete(env.modules, fileName)
+		return nil, err
+	}
+
+	result := module.Get("exports")
+	env.modules[fileName] = result
+	return result, nil
+}
+
+var builtinModules = map[string]func(env *Environment) goja.Value{
+	"fs":     fsModule,
+	"vm":     vmModule,
+	"crypto": cryptoModule,
+	"os":     emptyModule,
+	"assert": emptyModule,
+	"glob":   emptyModule,
+}
+
+func emptyModule(env *Environment) goja.Value {
+	return env.VM.NewObject()
+}
+
+func fsModule(env *Environment) goja.Value {
+	vm := env.VM
+	fs := vm.NewObject()
+
+	fs.Set("readFileSync", func(call goja.FunctionCall) goja.Value {
+		filename, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Filename: %v", err)
+
+		bytes, err := ioutil.ReadFile(filename)
+		ThrowWithMessageIfHappened(vm, err,
+			"Failed to read file '%s': %v", filename, err)
+
+		// Note: same as in otto, a string is returned instead of a Buffer
+		return vm.ToValue(string(bytes))
+	})
+
+	fs.Set("existsSync", func(call goja.FunctionCall) goja.Value {
+		filename, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Filename: %v", err)
+
+		_, err = os.Stat(filename)
+		return vm.ToValue(err == nil)
+	})
+
+	fs.Set("writeFileSync", func(call goja.FunctionCall) goja.Value {
+		filename, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Filename: %v", err)
+		data, err := GetString(call.Argument(1))
+		ThrowWithMessageIfHappened(vm, err,
+			"File data: %v", err)
+
+		err = ioutil.WriteFile(filename, []byte(data), 0666)
+		ThrowWithMessageIfHappened(vm, err,
+			"Failed to write file '%s': %v", filename, err)
+		return goja.Undefined()
+	})
+
+	fs.Set("readdirSync", func(call goja.FunctionCall) goja.Value {
+		dirname, err := GetString(call.Argument(0))
+		ThrowIfHappened(vm, err)
+
+		files, err := ioutil.ReadDir(dirname)
+		ThrowIfHappened(vm, err)
+
+		result := []interface{}{}
+		for _, file := range files {
+			result = append(result, file.Name())
+		}
+		return vm.ToValue(result)
+	})
+
+	fs.Set("mkdirSync", func(call goja.FunctionCall) goja.Value {
+		dirname, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Directory name: %v", err)
+		mode := int64(0777)
+		if len(call.Arguments) > 1 {
+			mode, err = GetInt64(call.Argument(1))
+			ThrowWithMessageIfHappened(vm, err,
+				"Permission mode: %v", err)
+		}
+
+		err = os.MkdirAll(dirname, os.FileMode(int32(mode)))
+		ThrowWithMessageIfHappened(vm, err,
+			"Failed to create directory '%s': %v", dirname, err)
+		return goja.Undefined()
+	})
+
+	return fs
+}
+
+func vmModule(env *Environment) goja.Value {
+	vm := env.VM
+	mod := vm.NewObject()
+
+	mod.Set("createScript", func(call goja.FunctionCall) goja.Value {
+		return goja.Undefined()
+	})
+
+	mod.Set("runInThisContext", func(call goja.FunctionCall) goja.Value {
+		VerifyCallArguments(vm, &call, "runInThisContext", 2)
+		source, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Source code: %v", err)
+		filename, err := GetString(call.Argument(1))
+		ThrowWithMessageIfHappened(vm, err,
+			"Filename: %v", err)
+
+		program, err := goja.Compile(filename, source, false)
+		ThrowWithMessageIfHappened(vm, err,
+			"Failed to compile %s err: %v", filename, err)
+		result, err := vm.RunProgram(program)
+		ThrowIfHappened(vm, err)
+		return result
+	})
+
+	return mod
+}
+
+func cryptoModule(env *Environment) goja.Value {
+	vm := env.VM
+	mod := vm.NewObject()
+
+	mod.Set("createHash", func(call goja.FunctionCall) goja.Value {
+		VerifyCallArguments(vm, &call, "createHash", 1)
+		_, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Algorithm: %v", err)
+
+		return wrapHash(vm, md5.New())
+	})
+
+	return mod
+}
+
+func wrapHash(vm *goja.Runtime, h hash.Hash) goja.Value {
+	wrapped := vm.NewObject()
+
+	wrapped.Set("update", func(call goja.FunctionCall) goja.Value {
+		data, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Data: %v", err)
+
+		_, err = io.WriteString(h, data)
+		ThrowWithMessageIfHappened(vm, err,
+			"Failed to update hash with data: %v", err)
+		return wrapped
+	})
+
+	wrapped.Set("digest", func(call goja.FunctionCall) goja.Value {
+		encoding, err := GetString(call.Argument(0))
+		ThrowWithMessageIfHappened(vm, err,
+			"Encoding: %v", err)
+
+		sum := h.Sum(nil)
+
+		var result string
+		switch encoding {
+		case "base64":
+			result = base64.StdEncoding.EncodeToString(sum)
+		case "hex":
+			result = fmt.Sprintf("%x", sum)
+		default:
+			ThrowException(vm, "Unsupported hash encoding: '%s'", encoding)
+		}
+		return vm.ToValue(result)
+	})
+
+	return wrapped
+}
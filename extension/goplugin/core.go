@@ -56,7 +56,8 @@ func (core *Core) TriggerEvent(event string, context goext.Context) error {
 	ensureRawTxInContext(context)
 
 	envManager := extension.GetManager()
-	if env, found := envManager.GetEnvironment(string(schemaID)); found {
+	if env, found := envManager.AcquireEnvironment(string(schemaID)); found {
+		defer envManager.ReleaseEnvironment(env)
 		err := env.HandleEvent(event, context)
 		return parseHandleEventResult(err, context)
 	}
@@ -20,8 +20,8 @@ import (
 	"fmt"
 	"math/rand"
 	"path/filepath"
-	"plugin"
 	"reflect"
+	"runtime"
 	"sort"
 	"strings"
 	"time"
@@ -72,6 +72,7 @@ type Environment struct {
 	databaseImpl *Database
 
 	name       string
+	path       string
 	traceID    string
 	timeLimit  time.Duration
 	timeLimits []*schema.EventTimeLimit
@@ -181,6 +182,16 @@ func (env *Environment) Util() goext.IUtil {
 	return &Util{}
 }
 
+// Jobs returns an implementation of IJobs interface
+func (env *Environment) Jobs() goext.IJobs {
+	return NewJobs(env)
+}
+
+// Secrets returns an implementation of ISecrets interface
+func (env *Environment) Secrets() goext.ISecrets {
+	return NewSecrets(env.path)
+}
+
 // SetDatabase sets and binds database implementation
 func (env *Environment) SetDatabase(db gohan_db.DB) {
 	env.bindDatabase(db)
@@ -273,7 +284,7 @@ func (env *Environment) Load(fileName string) error {
 		return errors.Errorf("go extension must be a *.so file, file: %s", fileName)
 	}
 
-	pl, err := plugin.Open(fileName)
+	pl, err := openPlugin(fileName)
 
 	if err != nil {
 		return errors.Errorf("failed to load go extension: %s", err)
@@ -299,6 +310,7 @@ func (env *Environment) Load(fileName string) error {
 
 //LoadExtensionsForPath for returns extensions for specific path
 func (env *Environment) LoadExtensionsForPath(extensions []*schema.Extension, timeLimit time.Duration, timeLimits []*schema.PathEventTimeLimit, path string) error {
+	env.path = path
 	for _, extension := range extensions {
 		if extension.Match(path) {
 			if extension.CodeType != "goext" {
@@ -347,18 +359,67 @@ func dispatchSchemaEventForEnv(env IEnvironment, prioritizedSchemaHandlers Prior
 	for _, priority := range sortSchemaHandlers(prioritizedSchemaHandlers) {
 		for index, schemaEventHandler := range prioritizedSchemaHandlers[priority] {
 			context["go_validation"] = true
-			if err := schemaEventHandler(context, resource, env); err != nil {
+			call := beginHandlerCall(env, string(sch.ID()), event, schemaEventHandler, priority, context)
+			err := schemaEventHandler(context, resource, env)
+			if err != nil {
+				call.end(context, err)
 				env.Logger().Warningf("failed to handle schema '%s' event '%s' at priority '%d' with index '%d': %s", sch.ID(), event, priority, index, err)
 				return err
 			}
 			if resource != nil {
 				context["resource"] = env.Util().ResourceToMap(resource)
 			}
+			call.end(context, nil)
 		}
 	}
 	return nil
 }
 
+// handlerCall is a call of a handler being profiled or traced
+type handlerCall struct {
+	env     IEnvironment
+	handler extension.Handler
+	started time.Time
+	span    *extension.Span
+}
+
+// beginHandlerCall starts profiling and tracing a call of a handler, named by its function and priority,
+// it returns nil when neither profiling nor tracing of the request is enabled
+func beginHandlerCall(env IEnvironment, schemaID, event string, handler interface{}, priority int, context map[string]interface{}) *handlerCall {
+	trace := extension.ContextTrace(context)
+	if !extension.ProfilingEnabled() && trace == nil {
+		return nil
+	}
+	name := "unknown"
+	if fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); fn != nil {
+		name = fn.Name()
+	}
+	call := &handlerCall{
+		env: env,
+		handler: extension.Handler{
+			Environment: "goplugin",
+			Schema:      schemaID,
+			Event:       event,
+			Name:        fmt.Sprintf("%s@%d", name, priority),
+		},
+	}
+	call.span = trace.Begin(call.handler, context)
+	call.started = time.Now()
+	return call
+}
+
+func (call *handlerCall) end(context map[string]interface{}, err *goext.Error) {
+	if call == nil {
+		return
+	}
+	extension.ProfileHandler(call.handler, call.started, call.env.getTraceID(), err != nil)
+	if err != nil {
+		call.span.End(context, err)
+	} else {
+		call.span.End(context, nil)
+	}
+}
+
 func sortSchemaHandlers(schemaHandlers PrioritizedSchemaHandlers) (priorities []int) {
 	priorities = []int{}
 	for priority := range schemaHandlers {
@@ -425,8 +486,11 @@ func (env *Environment) HandleEvent(event string, context map[string]interface{}
 	}
 
 	err := handleEventForEnv(env, event, context)
-	if err != nil && !hasParent {
-		dumpStackTrace(env.Logger(), err)
+	if !hasParent {
+		env.syncImpl.ReleaseLocks()
+		if err != nil {
+			dumpStackTrace(env.Logger(), err)
+		}
 	}
 
 	return err
@@ -475,7 +539,10 @@ func handleEventForEnv(env IEnvironment, event string, requestContext map[string
 	if prioritizedEventHandlers, ok := env.getHandlers(event); ok {
 		for _, priority := range sortHandlers(prioritizedEventHandlers) {
 			for index, eventHandler := range prioritizedEventHandlers[priority] {
-				if err := eventHandler(requestContext, env); err != nil {
+				call := beginHandlerCall(env, extension.ContextSchemaID(requestContext), event, eventHandler, priority, requestContext)
+				err := eventHandler(requestContext, env)
+				call.end(requestContext, err)
+				if err != nil {
 					env.Logger().Warningf("failed to handle event '%s' at priority '%d' with index '%d': %s", event, priority, index, err)
 					return err
 				}
@@ -575,6 +642,8 @@ func (env *Environment) RegisterType(name goext.SchemaID, typeValue interface{})
 func (env *Environment) Stop() {
 	log.Info("Stop environment")
 
+	env.syncImpl.ReleaseLocks()
+
 	// reset locals
 	env.coreImpl = nil
 	env.loggerImpl = nil
@@ -620,6 +689,7 @@ func (env *Environment) Clone() extension.Environment {
 		databaseImpl: env.databaseImpl.Clone(),
 
 		name:    env.name,
+		path:    env.path,
 		traceID: fmt.Sprintf("%s-clone-%03d", env.traceID, rand.Int31n(maxEnvId)),
 
 		handlers:       deepcopy.Copy(env.handlers).(EventPrioritizedHandlers),
@@ -41,6 +41,8 @@ type MockIEnvironment struct {
 	auth     goext.IAuth
 	config   goext.IConfig
 	util     goext.IUtil
+	jobs     goext.IJobs
+	secrets  goext.ISecrets
 }
 
 func (mockEnv *MockIEnvironment) setModules() {
@@ -54,6 +56,8 @@ func (mockEnv *MockIEnvironment) setModules() {
 	mockEnv.auth = mockEnv.env.Auth()
 	mockEnv.config = mockEnv.env.Config()
 	mockEnv.util = mockEnv.env.Util()
+	mockEnv.jobs = NewJobs(mockEnv)
+	mockEnv.secrets = mockEnv.env.Secrets()
 }
 
 func (mockEnv *MockIEnvironment) GetController() *gomock.Controller {
@@ -101,6 +105,14 @@ func (mockEnv *MockIEnvironment) SetMockModules(modules goext.MockModules) {
 	if mockEnv.mockModules.Util {
 		mockEnv.util = goext.NewMockIUtil(mockEnv.ctrl)
 	}
+
+	if mockEnv.mockModules.Jobs {
+		mockEnv.jobs = goext.NewMockIJobs(mockEnv.ctrl)
+	}
+
+	if mockEnv.mockModules.Secrets {
+		mockEnv.secrets = goext.NewMockISecrets(mockEnv.ctrl)
+	}
 }
 
 func (mockEnv *MockIEnvironment) Core() goext.ICore {
@@ -139,6 +151,14 @@ func (mockEnv *MockIEnvironment) Util() goext.IUtil {
 	return mockEnv.util
 }
 
+func (mockEnv *MockIEnvironment) Jobs() goext.IJobs {
+	return mockEnv.jobs
+}
+
+func (mockEnv *MockIEnvironment) Secrets() goext.ISecrets {
+	return mockEnv.secrets
+}
+
 func (mockEnv *MockIEnvironment) MockCore() *goext.MockICore {
 	return mockEnv.core.(*goext.MockICore)
 }
@@ -175,6 +195,14 @@ func (mockEnv *MockIEnvironment) MockUtil() *goext.MockIUtil {
 	return mockEnv.util.(*goext.MockIUtil)
 }
 
+func (mockEnv *MockIEnvironment) MockJobs() *goext.MockIJobs {
+	return mockEnv.jobs.(*goext.MockIJobs)
+}
+
+func (mockEnv *MockIEnvironment) MockSecrets() *goext.MockISecrets {
+	return mockEnv.secrets.(*goext.MockISecrets)
+}
+
 func (mockEnv *MockIEnvironment) Reset() {
 	mockEnv.setModules()
 	mockEnv.env.Reset()
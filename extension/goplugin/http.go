@@ -23,6 +23,7 @@ import (
 
 	"github.com/cloudwan/gohan/extension/goext"
 	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/httpclient"
 )
 
 // HTTP is an implementation of IHTTP
@@ -31,7 +32,7 @@ type HTTP struct{}
 // Request performs http request
 func (http *HTTP) Request(ctx context.Context, method, rawURL string, headers map[string]interface{}, postData interface{}, opaque bool) (*goext.Response, error) {
 	log.Debug("gohan_http  [%s] %s %s %t", method, headers, rawURL, opaque)
-	code, header, body, error := otto.GohanHTTP(ctx, method, rawURL, headers, postData, opaque)
+	code, header, body, error := otto.GohanHTTP(ctx, method, rawURL, headers, postData, opaque, httpOptions(ctx))
 	return &goext.Response{Code: code, Header: convertHeader(header), Body: body}, error
 }
 
@@ -49,10 +50,11 @@ func (http *HTTP) RequestRaw(ctx context.Context, method, rawURL string, headers
 		req.Header.Set(header, value)
 	}
 
-	resp, err := net_http.DefaultTransport.RoundTrip(req)
+	resp, err := httpclient.Do(req, net_http.DefaultTransport.RoundTrip, httpOptions(ctx))
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -60,6 +62,11 @@ func (http *HTTP) RequestRaw(ctx context.Context, method, rawURL string, headers
 	return &goext.Response{Code: resp.StatusCode, Status: resp.Status, Header: convertHeader(resp.Header), Body: string(body)}, nil
 }
 
+func httpOptions(ctx context.Context) httpclient.Options {
+	options, _ := goext.HTTPOptionsFromContext(ctx)
+	return httpclient.Options{Retries: options.Retries, Backoff: options.Backoff, Timeout: options.Timeout}
+}
+
 func convertHeader(header net_http.Header) goext.Header {
 	ret := make(map[string][]string, len(header))
 	for k, v := range header {
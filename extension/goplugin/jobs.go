@@ -0,0 +1,56 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goplugin
+
+import (
+	"fmt"
+
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/extension/goext"
+	"github.com/cloudwan/gohan/job"
+)
+
+// Jobs is an implementation of IJobs
+type Jobs struct {
+	env goext.IEnvironment
+}
+
+// NewJobs allocates Jobs
+func NewJobs(env goext.IEnvironment) *Jobs {
+	return &Jobs{env: env}
+}
+
+// Enqueue stores a job in the transaction of the context, or in a new transaction when there is none
+func (jobs *Jobs) Enqueue(jobType string, payload map[string]interface{}, options goext.JobOptions, context goext.Context) (id int64, err error) {
+	enqueue := func(tx goext.ITransaction) error {
+		rawTx, ok := tx.RawTransaction().(transaction.Transaction)
+		if !ok {
+			return fmt.Errorf("unsupported transaction type %T", tx.RawTransaction())
+		}
+		id, err = job.Enqueue(goext.GetContext(context), rawTx, jobType, payload, job.Options{
+			Delay:       options.Delay,
+			MaxAttempts: options.MaxAttempts,
+			UniqueKey:   options.UniqueKey,
+		})
+		return err
+	}
+	if tx, ok := contextGetTransaction(context); ok {
+		err = enqueue(tx)
+		return
+	}
+	err = jobs.env.Database().Within(context, enqueue)
+	return
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goplugin_test
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/dbutil"
+	"github.com/cloudwan/gohan/db/options"
+	"github.com/cloudwan/gohan/extension/goext"
+	"github.com/cloudwan/gohan/extension/goplugin"
+	"github.com/cloudwan/gohan/job"
+	"github.com/cloudwan/gohan/schema"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Jobs", func() {
+	const (
+		dbFile = "test.db"
+		dbType = "sqlite3"
+	)
+
+	var (
+		env   *goplugin.Environment
+		rawDB db.DB
+		queue *job.Queue
+	)
+
+	BeforeEach(func() {
+		Expect(schema.GetManager().LoadSchemaFromFile("../../etc/schema/gohan.json")).To(Succeed())
+		Expect(dbutil.InitDBWithSchemas(dbType, dbFile, db.DefaultTestInitDBParams())).To(Succeed())
+		var err error
+		rawDB, err = dbutil.ConnectDB(dbType, dbFile, db.DefaultMaxOpenConn, options.Default())
+		Expect(err).ToNot(HaveOccurred())
+		env = goplugin.NewEnvironment("test", nil, nil)
+		env.SetDatabase(rawDB)
+		queue = job.NewQueue(rawDB)
+	})
+
+	AfterEach(func() {
+		rawDB.Close()
+		os.Remove(dbFile)
+		schema.ClearManager()
+	})
+
+	It("should enqueue jobs in the transaction of the context", func() {
+		tx, err := env.Database().Begin()
+		Expect(err).ToNot(HaveOccurred())
+		requestContext := goext.MakeContext().WithTransaction(tx)
+		id, err := env.Jobs().Enqueue("cleanup", map[string]interface{}{"network": "red"}, goext.JobOptions{
+			Delay:       time.Minute,
+			MaxAttempts: 3,
+		}, requestContext)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tx.Commit()).To(Succeed())
+		Expect(tx.Close()).To(Succeed())
+
+		enqueued, err := queue.Get(context.Background(), id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(enqueued.Type).To(Equal("cleanup"))
+		Expect(enqueued.Payload).To(HaveKeyWithValue("network", "red"))
+		Expect(enqueued.MaxAttempts).To(Equal(3))
+		Expect(enqueued.RunAt).To(BeNumerically(">", time.Now().Unix()+30))
+	})
+
+	It("should enqueue jobs in a new transaction without one in the context", func() {
+		options := goext.JobOptions{UniqueKey: "network/red"}
+		id, err := env.Jobs().Enqueue("provision", nil, options, goext.MakeContext())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.Jobs().Enqueue("provision", nil, options, goext.MakeContext())).To(Equal(id))
+
+		jobs, err := queue.List(context.Background(), job.StatusPending, "provision")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(jobs).To(HaveLen(1))
+	})
+})
@@ -16,8 +16,11 @@
 package goplugin
 
 import (
+	"fmt"
+
 	"github.com/cloudwan/gohan/extension/goext"
 	gohan_log "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/secret"
 )
 
 const logModule = "[GOEXT]"
@@ -29,6 +32,7 @@ type Logger struct {
 
 func (logger *Logger) dispatchLog(module string, level goext.Level, format string) {
 	log := gohan_log.NewLogger(gohan_log.ModuleName(module), gohan_log.TraceId(logger.env.getTraceID()))
+	format = secret.Redact(format)
 
 	switch level {
 	case goext.LevelCritical:
@@ -50,20 +54,21 @@ func (logger *Logger) dispatchLog(module string, level goext.Level, format strin
 
 func (logger *Logger) dispatchLogf(module string, level goext.Level, format string, args ...interface{}) {
 	log := gohan_log.NewLogger(gohan_log.ModuleName(module), gohan_log.TraceId(logger.env.getTraceID()))
+	message := secret.Redact(fmt.Sprintf(format, args...))
 
 	switch level {
 	case goext.LevelCritical:
-		log.Critical(format, args...)
+		log.Critical("%s", message)
 	case goext.LevelError:
-		log.Error(format, args...)
+		log.Error("%s", message)
 	case goext.LevelWarning:
-		log.Warning(format, args...)
+		log.Warning("%s", message)
 	case goext.LevelNotice:
-		log.Notice(format, args...)
+		log.Notice("%s", message)
 	case goext.LevelInfo:
-		log.Info(format, args...)
+		log.Info("%s", message)
 	case goext.LevelDebug:
-		log.Debug(format, args...)
+		log.Debug("%s", message)
 	default:
 		panic("Invalid error level")
 	}
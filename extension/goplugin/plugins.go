@@ -0,0 +1,95 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goplugin
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	plugins     = map[string]*openedPlugin{}
+	pluginsLock sync.Mutex
+)
+
+type openedPlugin struct {
+	plugin  *plugin.Plugin
+	modTime time.Time
+	size    int64
+}
+
+// openPlugin opens a plugin file. Plugins are cached by path and can't be unloaded,
+// so a file changed since it was opened is opened from a copy to load the new version.
+// Loading fails if the new version has the same plugin path as the previous one,
+// i.e. plugins have to be built from files, not from packages.
+func openPlugin(fileName string) (*plugin.Plugin, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		// let plugin.Open report files which can't be opened
+		return plugin.Open(fileName)
+	}
+
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+
+	opened, ok := plugins[fileName]
+	if ok && opened.modTime.Equal(info.ModTime()) && opened.size == info.Size() {
+		return opened.plugin, nil
+	}
+
+	path := fileName
+	if ok {
+		if path, err = copyPlugin(fileName); err != nil {
+			return nil, err
+		}
+		defer os.Remove(path)
+		log.Info("Loading changed go extension %s from %s", fileName, path)
+	}
+
+	pl, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	plugins[fileName] = &openedPlugin{plugin: pl, modTime: info.ModTime(), size: info.Size()}
+	return pl, nil
+}
+
+func copyPlugin(fileName string) (string, error) {
+	source, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	base := strings.TrimSuffix(filepath.Base(fileName), ".so")
+	copied, err := ioutil.TempFile("", "gohan-"+base+"-*.so")
+	if err != nil {
+		return "", err
+	}
+	defer copied.Close()
+
+	if _, err := io.Copy(copied, source); err != nil {
+		os.Remove(copied.Name())
+		return "", err
+	}
+	return copied.Name(), nil
+}
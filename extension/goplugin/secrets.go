@@ -0,0 +1,33 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goplugin
+
+import "github.com/cloudwan/gohan/secret"
+
+// Secrets is an implementation of ISecrets
+type Secrets struct {
+	path string
+}
+
+// NewSecrets allocates Secrets for extensions of path
+func NewSecrets(path string) *Secrets {
+	return &Secrets{path: path}
+}
+
+// Get returns the value of a secret
+func (secrets *Secrets) Get(name string) (string, error) {
+	return secret.Get(name, secrets.path)
+}
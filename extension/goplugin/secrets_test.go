@@ -0,0 +1,65 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goplugin_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goplugin"
+	"github.com/cloudwan/gohan/secret"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Secrets", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "secrets_test")
+		Expect(err).ToNot(HaveOccurred())
+		store := secret.NewStore(filepath.Join(dir, "secrets.json"), filepath.Join(dir, "secrets.key"))
+		Expect(store.Set("device_password", "s3cr3t", []string{"^/v2.0/devices"})).To(Succeed())
+		secret.SetStore(store)
+	})
+
+	AfterEach(func() {
+		secret.SetStore(nil)
+		os.RemoveAll(dir)
+	})
+
+	newEnvironment := func(path string) *goplugin.Environment {
+		env := goplugin.NewEnvironment("test", nil, nil)
+		Expect(env.LoadExtensionsForPath(nil, time.Second, nil, path)).To(Succeed())
+		return env
+	}
+
+	It("should get secrets allowed for the path of the environment", func() {
+		env := newEnvironment("/v2.0/devices")
+		Expect(env.Secrets().Get("device_password")).To(Equal("s3cr3t"))
+		Expect(env.Clone().(*goplugin.Environment).Secrets().Get("device_password")).To(Equal("s3cr3t"))
+		_, err := env.Secrets().Get("unknown")
+		Expect(err).To(Equal(secret.ErrNotFound))
+	})
+
+	It("should not get secrets of other paths", func() {
+		_, err := newEnvironment("/v2.0/networks").Secrets().Get("device_password")
+		Expect(err).To(Equal(secret.ErrForbidden))
+	})
+})
@@ -17,6 +17,8 @@ package goplugin
 
 import (
 	"context"
+	"fmt"
+	sync_lib "sync"
 	"time"
 
 	"github.com/cloudwan/gohan/extension/goext"
@@ -62,6 +64,9 @@ func convertNodes(nodes []*gohan_sync.Node) []*goext.Node {
 // Sync is an implementation of ISync
 type Sync struct {
 	raw gohan_sync.Sync
+
+	mu    sync_lib.Mutex
+	locks map[string]struct{}
 }
 
 // Fetch fetches a path from sync
@@ -98,6 +103,59 @@ func (sync *Sync) Update(ctx context.Context, path string, json string) error {
 	return sync.raw.Update(ctx, path, json)
 }
 
+// CompareAndSwap updates a path with given json only if its current value is expected
+func (sync *Sync) CompareAndSwap(ctx context.Context, path string, json string, expected string) (bool, error) {
+	return sync.raw.CompareAndSwap(ctx, path, json, sync.raw.ByValue(expected))
+}
+
+// Lock locks a path and remembers it to be released by ReleaseLocks
+func (sync *Sync) Lock(ctx context.Context, path string, block bool) (chan struct{}, error) {
+	lost, err := sync.raw.Lock(ctx, path, block)
+	if err != nil {
+		return nil, err
+	}
+
+	sync.mu.Lock()
+	defer sync.mu.Unlock()
+	if sync.locks == nil {
+		sync.locks = map[string]struct{}{}
+	}
+	sync.locks[path] = struct{}{}
+	return lost, nil
+}
+
+// Unlock unlocks a path locked by Lock
+func (sync *Sync) Unlock(ctx context.Context, path string) error {
+	sync.mu.Lock()
+	if _, ok := sync.locks[path]; !ok {
+		sync.mu.Unlock()
+		return fmt.Errorf("path %s is not locked by this extension", path)
+	}
+	delete(sync.locks, path)
+	sync.mu.Unlock()
+
+	return sync.raw.Unlock(ctx, path)
+}
+
+// ReleaseLocks unlocks paths locked and not unlocked yet; object may be nil
+func (sync *Sync) ReleaseLocks() {
+	if sync == nil {
+		return
+	}
+
+	sync.mu.Lock()
+	locks := sync.locks
+	sync.locks = nil
+	sync.mu.Unlock()
+
+	for path := range locks {
+		log.Warning("Releasing lock %s not unlocked by extension", path)
+		if err := sync.raw.Unlock(context.Background(), path); err != nil {
+			log.Warning("Failed to release lock %s: %s", path, err)
+		}
+	}
+}
+
 // NewSync allocates Sync
 func NewSync(sync gohan_sync.Sync) *Sync {
 	return &Sync{raw: sync}
@@ -63,6 +63,47 @@ var _ = Describe("Sync", func() {
 		Eventually(doneCh).Should(Receive())
 	})
 
+	It("releases locks not unlocked when the handler returns", func() {
+		mockSync := mock_sync.NewMockSync(mockCtrl)
+		mockSync.EXPECT().Lock(gomock.Any(), "unlocked", false).Return(make(chan struct{}), nil)
+		mockSync.EXPECT().Unlock(gomock.Any(), "unlocked").Return(nil)
+		mockSync.EXPECT().Lock(gomock.Any(), "left", true).Return(make(chan struct{}), nil)
+		mockSync.EXPECT().Unlock(gomock.Any(), "left").Return(nil)
+
+		env := goplugin.Environment{}
+		env.SetSync(mockSync)
+		ctx := context.Background()
+
+		_, err := env.Sync().Lock(ctx, "unlocked", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.Sync().Unlock(ctx, "unlocked")).To(Succeed())
+		_, err = env.Sync().Lock(ctx, "left", true)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(env.HandleEvent("test_event", map[string]interface{}{})).To(Succeed())
+		Expect(env.HandleEvent("test_event", map[string]interface{}{})).To(Succeed())
+	})
+
+	It("refuses to unlock paths not locked by the handler", func() {
+		mockSync := mock_sync.NewMockSync(mockCtrl)
+
+		env := goplugin.Environment{}
+		env.SetSync(mockSync)
+
+		Expect(env.Sync().Unlock(context.Background(), "foreign")).To(MatchError(ContainSubstring("is not locked by this extension")))
+	})
+
+	It("compares and swaps by value", func() {
+		mockSync := mock_sync.NewMockSync(mockCtrl)
+		mockSync.EXPECT().ByValue("old").Return("value is old")
+		mockSync.EXPECT().CompareAndSwap(gomock.Any(), "key", "new", "value is old").Return(true, nil)
+
+		env := goplugin.Environment{}
+		env.SetSync(mockSync)
+
+		Expect(env.Sync().CompareAndSwap(context.Background(), "key", "new", "old")).To(BeTrue())
+	})
+
 	It("returns nil data on delete", func() {
 		rawSync, err := etcdv3.NewSync([]string{"localhost:2379"}, time.Second)
 		Expect(err).NotTo(HaveOccurred())
@@ -71,7 +71,17 @@ func (env *MultiEnvironment) handleEventInChild(childIdx int, event string, cont
 		return nil
 	}
 	env.cloneChildIfNeeded(childIdx)
-	return env.childEnv[childIdx].HandleEvent(event, context)
+	trace := ContextTrace(context)
+	if !ProfilingEnabled() && trace == nil {
+		return env.childEnv[childIdx].HandleEvent(event, context)
+	}
+	environment, schemaID := EnvironmentType(env.baseEnvs[childIdx]), ContextSchemaID(context)
+	span := trace.Begin(Handler{Environment: environment, Schema: schemaID, Event: event}, context)
+	started := time.Now()
+	err := env.childEnv[childIdx].HandleEvent(event, context)
+	ProfileEnvironment(environment, schemaID, event, started, err != nil)
+	span.End(context, err)
+	return err
 }
 
 func (env *MultiEnvironment) cloneChildIfNeeded(childIdx int) {
@@ -85,6 +95,15 @@ func (env *MultiEnvironment) Clone() Environment {
 	return NewEnvironment(env.baseEnvs)
 }
 
+//Stop stops environments which have to be stopped
+func (env *MultiEnvironment) Stop() {
+	for _, base := range env.baseEnvs {
+		if stoppable, ok := base.(stopper); ok {
+			stoppable.Stop()
+		}
+	}
+}
+
 // IsEventHandled returns whether a given event is handled by this environment
 func (env *MultiEnvironment) IsEventHandled(event string, context map[string]interface{}) bool {
 	for _, env := range env.baseEnvs {
@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ddliu/motto"
 	"github.com/robertkrimen/otto"
@@ -33,94 +34,8 @@ import (
 
 var log = l.NewLogger()
 
-func init() {
-	gohanInit := func(env *Environment) {
-		vm := env.VM
-		builtins := map[string]interface{}{
-			"require": func(call otto.FunctionCall) otto.Value {
-				VerifyCallArguments(&call, "require", 1)
-				moduleName, err := GetString(call.Argument(0))
-				if err != nil {
-					ThrowOttoException(&call, err.Error())
-				}
-				value, err := require(moduleName, vm)
-				if err != nil {
-					ThrowOttoException(&call, err.Error())
-				}
-				return value
-			},
-			"gohan_schemas": func(call otto.FunctionCall) otto.Value {
-				VerifyCallArguments(&call, "gohan_schemas", 0)
-				manager := schema.GetManager()
-				response := []interface{}{}
-				for _, schema := range manager.OrderedSchemas() {
-					response = append(response, schema)
-				}
-				value, _ := vm.ToValue(response)
-				return value
-			},
-			"gohan_schema_url": func(call otto.FunctionCall) otto.Value {
-				VerifyCallArguments(&call, "gohan_schema_url", 1)
-				schemaID, err := GetString(call.Argument(0))
-				if err != nil {
-					ThrowOttoException(&call, err.Error())
-				}
-				manager := schema.GetManager()
-				schema, ok := manager.Schema(schemaID)
-				if !ok {
-					ThrowOttoException(&call, unknownSchemaErrorMesssageFormat, schemaID)
-				}
-				value, _ := vm.ToValue(schema.URL)
-				return value
-			},
-			"gohan_policies": func(call otto.FunctionCall) otto.Value {
-				VerifyCallArguments(&call, "gohan_policies", 0)
-				manager := schema.GetManager()
-				response := []interface{}{}
-				for _, policy := range manager.Policies() {
-					response = append(response, policy.RawData)
-				}
-				value, _ := vm.ToValue(response)
-				return value
-			},
-			"gohan_trigger_event": func(call otto.FunctionCall) otto.Value {
-				VerifyCallArguments(&call, "gohan_trigger_event", 2)
-
-				event, err := GetString(call.Argument(0))
-				if err != nil {
-					ThrowOttoException(&call, err.Error())
-				}
-
-				context, err := GetMap(call.Argument(1))
-				if err != nil {
-					ThrowOttoException(&call, err.Error())
-				}
-
-				schemaID := ""
-
-				if s, ok := context["schema"]; ok {
-					schemaID = s.(*schema.Schema).ID
-				} else {
-					log.Panic("gohan_trigger_event: schema not found")
-				}
-
-				envManager := extension.GetManager()
-				envManager.HandleEventInAllEnvironments(context, event, schemaID)
-
-				value, _ := vm.ToValue(nil)
-
-				return value
-			},
-			"gohan_closers": []io.Closer{},
-		}
-
-		for name, object := range builtins {
-			vm.Set(name, object)
-		}
-		loadNPMModules()
-		registerBuiltinModules(vm)
-
-		err := env.Load("<Gohan built-in exceptions>", `
+//BuiltinExceptionsCode defines exceptions thrown by extensions, shared by JavaScript environments
+const BuiltinExceptionsCode = `
 		function BaseException() {
 		  this.fields = ["name", "message"]
 		  this.message = "";
@@ -181,13 +96,12 @@ func init() {
 		  this.fields.push("inner_exception");
 		}
 		ExtensionException.prototype = Object.create(BaseException.prototype);
-		`)
-		if err != nil {
-			log.Fatal(err)
-		}
+		`
 
-		err = env.Load("<Gohan built-ins>", `
+//BuiltinHandlersCode registers and dispatches event handlers, shared by JavaScript environments
+const BuiltinHandlersCode = `
 		var gohan_handler = {};
+		var gohan_handler_source = "";
 		var gohan_caller = "";
 		function gohan_add_dots(str, lim){
   		  if(str.length > lim){
@@ -200,7 +114,7 @@ func init() {
 		    gohan_handler[event_type] = [];
 		  }
 		  var handlerUUID = gohan_uuid();
-		  gohan_handler[event_type].push({fn:func,uuid:handlerUUID})
+		  gohan_handler[event_type].push({fn:func,uuid:handlerUUID,source:gohan_handler_source})
 		  gohan_log_debug("REG: id=" + handlerUUID + ", type=" + event_type.toString() +
 				", index=" + (gohan_handler[event_type].length - 1).toString())
 		}
@@ -210,6 +124,10 @@ func init() {
 		  }
 		  gohan_caller = gohan_uuid();
 		  for (var i = 0; i < gohan_handler[event_type].length; ++i) {
+		    var profile = gohan_profile_start();
+		    var trace = gohan_trace_start(event_type, gohan_handler[event_type][i].source, i, context);
+		    var failed = true;
+		    var caught = null;
 		    try {
 		      var old_module = gohan_log_module_push(event_type);
 		      var handlerUUID = gohan_handler[event_type][i].uuid;
@@ -222,7 +140,9 @@ func init() {
 		      if (!_.isUndefined(context.response_code)) {
 		        throw new CustomException(context.response, context.response_code);
 		      }
+		      failed = false;
 		    } catch(e) {
+		      caught = e;
 		      if (e instanceof BaseException) {
 		        context.exception = e.toDict();
 		        context.exception_message = event_type.concat(": ").concat(e.toString());
@@ -230,11 +150,171 @@ func init() {
 		        throw e;
 		      }
 		    } finally {
+		      gohan_profile_end(profile, event_type, gohan_handler[event_type][i].source, i, failed, context);
+		      gohan_trace_end(trace, caught, context);
 		      gohan_log_module_restore(old_module);
 		    }
 		  }
 		}
-		`)
+		`
+
+func init() {
+	gohanInit := func(env *Environment) {
+		vm := env.VM
+		builtins := map[string]interface{}{
+			"require": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "require", 1)
+				moduleName, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+				value, err := require(moduleName, vm)
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+				return value
+			},
+			"gohan_schemas": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_schemas", 0)
+				manager := schema.GetManager()
+				response := []interface{}{}
+				for _, schema := range manager.OrderedSchemas() {
+					response = append(response, schema)
+				}
+				value, _ := vm.ToValue(response)
+				return value
+			},
+			"gohan_schema_url": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_schema_url", 1)
+				schemaID, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+				manager := schema.GetManager()
+				schema, ok := manager.Schema(schemaID)
+				if !ok {
+					ThrowOttoException(&call, unknownSchemaErrorMesssageFormat, schemaID)
+				}
+				value, _ := vm.ToValue(schema.URL)
+				return value
+			},
+			"gohan_policies": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_policies", 0)
+				manager := schema.GetManager()
+				response := []interface{}{}
+				for _, policy := range manager.Policies() {
+					response = append(response, policy.RawData)
+				}
+				value, _ := vm.ToValue(response)
+				return value
+			},
+			"gohan_trigger_event": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_trigger_event", 2)
+
+				event, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+
+				context, err := GetMap(call.Argument(1))
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+
+				schemaID := ""
+
+				if s, ok := context["schema"]; ok {
+					schemaID = s.(*schema.Schema).ID
+				} else {
+					log.Panic("gohan_trigger_event: schema not found")
+				}
+
+				envManager := extension.GetManager()
+				envManager.HandleEventInAllEnvironments(context, event, schemaID)
+
+				value, _ := vm.ToValue(nil)
+
+				return value
+			},
+			"gohan_profile_start": func(call otto.FunctionCall) otto.Value {
+				value, _ := vm.ToValue(0)
+				if extension.ProfilingEnabled() {
+					value, _ = vm.ToValue(float64(time.Since(profileEpoch)))
+				}
+				return value
+			},
+			"gohan_profile_end": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_profile_end", 6)
+				if !extension.ProfilingEnabled() {
+					return otto.NullValue()
+				}
+				started, _ := call.Argument(0).ToFloat()
+				event, _ := call.Argument(1).ToString()
+				source, _ := call.Argument(2).ToString()
+				index, _ := call.Argument(3).ToInteger()
+				failed, _ := call.Argument(4).ToBoolean()
+				schemaID, traceID := "", ""
+				if context := call.Argument(5).Object(); context != nil {
+					schemaID = profiledString(context, "schema_id")
+					traceID = profiledString(context, "trace_id")
+				}
+				extension.ProfileHandler(extension.Handler{
+					Environment: "otto",
+					Schema:      schemaID,
+					Event:       event,
+					Name:        fmt.Sprintf("%s#%d", source, index),
+				}, profileEpoch.Add(time.Duration(started)), traceID, failed)
+				return otto.NullValue()
+			},
+			"gohan_trace_start": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_trace_start", 4)
+				context := exportedContext(call.Argument(3))
+				trace := extension.ContextTrace(context)
+				if trace == nil {
+					return otto.NullValue()
+				}
+				event, _ := call.Argument(0).ToString()
+				source, _ := call.Argument(1).ToString()
+				index, _ := call.Argument(2).ToInteger()
+				context = convertedContext(context)
+				span := trace.Begin(extension.Handler{
+					Environment: "otto",
+					Schema:      extension.ContextSchemaID(context),
+					Event:       event,
+					Name:        fmt.Sprintf("%s#%d", source, index),
+				}, context)
+				value, _ := call.Otto.ToValue(span)
+				return value
+			},
+			"gohan_trace_end": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_trace_end", 3)
+				exported, _ := call.Argument(0).Export()
+				span, ok := exported.(*extension.Span)
+				if !ok {
+					return otto.NullValue()
+				}
+				var err error
+				if caught := call.Argument(1); !caught.IsNull() && !caught.IsUndefined() {
+					err = fmt.Errorf("%s", caught.String())
+				}
+				span.End(convertedContext(exportedContext(call.Argument(2))), err)
+				return otto.NullValue()
+			},
+			"gohan_closers": []io.Closer{},
+		}
+
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+		loadNPMModules()
+		registerBuiltinModules(vm)
+
+		err := env.Load("<Gohan built-in exceptions>", BuiltinExceptionsCode)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = env.Load("<Gohan built-ins>", BuiltinHandlersCode)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -242,6 +322,32 @@ func init() {
 	RegisterInit(gohanInit)
 }
 
+// profileEpoch is the base of times passed to javascript by gohan_profile_start
+var profileEpoch = time.Now()
+
+func profiledString(object *otto.Object, key string) string {
+	value, err := object.Get(key)
+	if err != nil || !value.IsString() {
+		return ""
+	}
+	return value.String()
+}
+
+func exportedContext(value otto.Value) map[string]interface{} {
+	exported, _ := value.Export()
+	context, _ := exported.(map[string]interface{})
+	return context
+}
+
+// convertedContext copies a context converting values set by javascript, so traces compare Go values
+func convertedContext(context map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(context))
+	for key, value := range context {
+		result[key] = ConvertOttoToGo(value)
+	}
+	return result
+}
+
 func requireFromOtto(moduleName string, vm *otto.Otto) (otto.Value, error) {
 	log.Debug(fmt.Sprintf("Loading module %s from otto", moduleName))
 	rawModule, errRequire := RequireModule(moduleName)
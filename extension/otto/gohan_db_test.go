@@ -28,25 +28,23 @@ import (
 	"github.com/cloudwan/gohan/db/transaction"
 	tr_mocks "github.com/cloudwan/gohan/db/transaction/mocks"
 	"github.com/cloudwan/gohan/extension"
-	"github.com/cloudwan/gohan/extension/otto"
 	"github.com/cloudwan/gohan/schema"
-	"github.com/cloudwan/gohan/server/middleware"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
-func newEnvironmentWithExtension(extension *schema.Extension, db db.DB) (env extension.Environment) {
+func newEnvironmentWithExtension(newEnvironment func(dataStore db.DB) testEnvironment, extension *schema.Extension, db db.DB) (env extension.Environment) {
 	timeLimit := time.Duration(1) * time.Second
 	timeLimits := []*schema.PathEventTimeLimit{}
 	extensions := []*schema.Extension{extension}
-	env = otto.NewEnvironment("db_test", db, &middleware.FakeIdentity{}, testSync)
+	env = newEnvironment(db)
 	Expect(env.LoadExtensionsForPath(extensions, timeLimit, timeLimits, "test_path")).To(Succeed())
 	return
 }
 
-var _ = Describe("GohanDb", func() {
+var _ = describeEnginesWithDB("GohanDb", func(engine string, newEnvironment func(dataStore db.DB) testEnvironment) {
 	var (
 		manager       *schema.Manager
 		s             *schema.Schema
@@ -102,7 +100,7 @@ var _ = Describe("GohanDb", func() {
 
 				mockDB := db_mocks.NewMockDB(mockCtrl)
 				mockDB.EXPECT().BeginTx(gomock.Any()).Return(mockTx, nil)
-				env := newEnvironmentWithExtension(ext, mockDB)
+				env := newEnvironmentWithExtension(newEnvironment, ext, mockDB)
 
 				context := map[string]interface{}{}
 
@@ -138,7 +136,7 @@ var _ = Describe("GohanDb", func() {
 					Expect(params.IsolationLevel).To(Equal(transaction.Serializable))
 					return mockTx, nil
 				})
-				env := newEnvironmentWithExtension(ext, mockDB)
+				env := newEnvironmentWithExtension(newEnvironment, ext, mockDB)
 
 				context := map[string]interface{}{
 					"transaction": mockTx,
@@ -175,7 +173,7 @@ var _ = Describe("GohanDb", func() {
 						"path": ".*",
 					})
 					Expect(err).ToNot(HaveOccurred())
-					env := newEnvironmentWithExtension(extension, testDB)
+					env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 					mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 					pg, err := pagination.NewPaginator(pagination.OptionOrder(pagination.ASC))
@@ -219,7 +217,7 @@ var _ = Describe("GohanDb", func() {
 						"path": ".*",
 					})
 					Expect(err).ToNot(HaveOccurred())
-					env := newEnvironmentWithExtension(extension, testDB)
+					env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 					mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 					pg, err := pagination.NewPaginator(pagination.OptionOrder(pagination.ASC))
@@ -267,7 +265,7 @@ var _ = Describe("GohanDb", func() {
 						"path": ".*",
 					})
 					Expect(err).ToNot(HaveOccurred())
-					env := newEnvironmentWithExtension(extension, testDB)
+					env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 					mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 					pg, _ := pagination.NewPaginator(pagination.OptionOrder(pagination.ASC), pagination.OptionLimit(1))
@@ -311,7 +309,7 @@ var _ = Describe("GohanDb", func() {
 						"path": ".*",
 					})
 					Expect(err).ToNot(HaveOccurred())
-					env := newEnvironmentWithExtension(extension, testDB)
+					env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 					mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 					pg, _ := pagination.NewPaginator(
@@ -359,7 +357,7 @@ var _ = Describe("GohanDb", func() {
 						"path": ".*",
 					})
 					Expect(err).ToNot(HaveOccurred())
-					env := newEnvironmentWithExtension(extension, testDB)
+					env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 					mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 					pg, _ := pagination.NewPaginator(
@@ -409,7 +407,7 @@ var _ = Describe("GohanDb", func() {
 						"path": ".*",
 					})
 					Expect(err).ToNot(HaveOccurred())
-					env := newEnvironmentWithExtension(extension, testDB)
+					env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 					mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 					pg, _ := pagination.NewPaginator(
@@ -460,7 +458,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 				mockTx.EXPECT().StateFetch(ctx, s, transaction.Filter{"id": "resource_id", "tenant_id": "tenant0"}).Return(
@@ -506,7 +504,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				context := map[string]interface{}{}
 				Expect(env.HandleEvent("test_event", context)).To(Succeed())
@@ -527,7 +525,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				context := map[string]interface{}{}
 				err = env.HandleEvent("test_event", context)
@@ -556,7 +554,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 				mockTx.EXPECT().Query(ctx, s, "SELECT DUMMY", []interface{}{"tenant0", "obj1"}).Return(
@@ -588,7 +586,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				context := map[string]interface{}{
 					"transaction": "not_a_transaction",
@@ -617,7 +615,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 
@@ -647,7 +645,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 
@@ -677,7 +675,7 @@ var _ = Describe("GohanDb", func() {
 					"path": ".*",
 				})
 				Expect(err).ToNot(HaveOccurred())
-				env := newEnvironmentWithExtension(extension, testDB)
+				env := newEnvironmentWithExtension(newEnvironment, extension, testDB)
 
 				mockTx := tr_mocks.NewMockTransaction(mockCtrl)
 				mockTx.EXPECT().Query(ctx, s, "SELECT DUMMY", []interface{}{}).Return(
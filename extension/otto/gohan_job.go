@@ -0,0 +1,92 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otto
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/job"
+	"github.com/robertkrimen/otto"
+)
+
+//GohanJobEnqueue stores a job within a transaction, optionally committing it
+func GohanJobEnqueue(tx transaction.Transaction, needCommit bool, jobType string,
+	payload, options map[string]interface{}) (int64, error) {
+
+	jobOptions, err := job.OptionsFromMap(options)
+	if err != nil {
+		return 0, fmt.Errorf("Error during gohan_job_enqueue: %s", err)
+	}
+	id, err := job.Enqueue(context.Background(), tx, jobType, payload, jobOptions)
+	if err != nil {
+		return 0, fmt.Errorf("Error during gohan_job_enqueue: %s", err)
+	}
+	if needCommit {
+		if err = tx.Commit(); err != nil {
+			return 0, fmt.Errorf("Error during gohan_job_enqueue: %s", err)
+		}
+	}
+	return id, nil
+}
+
+func init() {
+	gohanJobInit := func(env *Environment) {
+		vm := env.VM
+
+		builtins := map[string]interface{}{
+			"gohan_job_enqueue": func(call otto.FunctionCall) otto.Value {
+				if len(call.ArgumentList) != 3 && len(call.ArgumentList) != 4 {
+					ThrowOttoException(&call, "Expected 3 or 4 arguments in gohan_job_enqueue call, %d arguments given",
+						len(call.ArgumentList))
+				}
+				tx, needCommit, err := env.GetOrCreateTransaction(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+				if needCommit {
+					defer tx.Close()
+				}
+				jobType, err := GetString(call.Argument(1))
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+				payload, err := GetMap(call.Argument(2))
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+				options := map[string]interface{}{}
+				if len(call.ArgumentList) == 4 {
+					if options, err = GetMap(call.Argument(3)); err != nil {
+						ThrowOttoException(&call, err.Error())
+					}
+				}
+
+				id, err := GohanJobEnqueue(tx, needCommit, jobType, payload, options)
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
+				value, _ := vm.ToValue(id)
+				return value
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanJobInit)
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/robertkrimen/otto"
 
 	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/secret"
 	//Import otto underscore lib
 	_ "github.com/robertkrimen/otto/underscore"
 )
@@ -28,6 +29,47 @@ const (
 	wrongTypeErrorMessageFormat = "%s must be %s: %v"
 )
 
+//BuiltinLoggingCode defines logging functions on top of gohan_log_impl, shared by JavaScript environments
+const BuiltinLoggingCode = `
+		function gohan_log_module_push(new_module){
+		    var old_module = LOG_MODULE;
+		    LOG_MODULE += "." + new_module;
+		    return old_module;
+		}
+
+		function gohan_log_module_restore(old_module){
+		    LOG_MODULE = old_module;
+		}
+
+		function gohan_log(module, level, msg) {
+		    gohan_log_impl(module, level, gohan_caller, msg);
+		}
+
+		function gohan_log_critical(msg) {
+		    gohan_log(LOG_MODULE, LOG_LEVEL.CRITICAL, msg);
+		}
+
+		function gohan_log_error(msg) {
+		    gohan_log(LOG_MODULE, LOG_LEVEL.ERROR, msg);
+		}
+
+		function gohan_log_warning(msg) {
+		    gohan_log(LOG_MODULE, LOG_LEVEL.WARNING, msg);
+		}
+
+		function gohan_log_notice(msg) {
+		    gohan_log(LOG_MODULE, LOG_LEVEL.NOTICE, msg);
+		}
+
+		function gohan_log_info(msg) {
+		    gohan_log(LOG_MODULE, LOG_LEVEL.INFO, msg);
+		}
+
+		function gohan_log_debug(msg) {
+		    gohan_log(LOG_MODULE, LOG_LEVEL.DEBUG, msg);
+		}
+		`
+
 func init() {
 	gohanLoggingInit := func(env *Environment) {
 		vm := env.VM
@@ -63,6 +105,7 @@ func init() {
 				if err != nil {
 					ThrowOttoException(&call, "Message: %v", err)
 				}
+				message = secret.Redact(message)
 
 				// if caller is non-empty, add extra information about the calling handler
 				if caller != "" {
@@ -92,45 +135,7 @@ func init() {
 
 		vm.Set("LOG_MODULE", "gohan.extension."+env.Name)
 
-		err := env.Load("<Gohan logging built-ins>", `
-		function gohan_log_module_push(new_module){
-		    var old_module = LOG_MODULE;
-		    LOG_MODULE += "." + new_module;
-		    return old_module;
-		}
-
-		function gohan_log_module_restore(old_module){
-		    LOG_MODULE = old_module;
-		}
-
-		function gohan_log(module, level, msg) {
-		    gohan_log_impl(module, level, gohan_caller, msg);
-		}
-
-		function gohan_log_critical(msg) {
-		    gohan_log(LOG_MODULE, LOG_LEVEL.CRITICAL, msg);
-		}
-
-		function gohan_log_error(msg) {
-		    gohan_log(LOG_MODULE, LOG_LEVEL.ERROR, msg);
-		}
-
-		function gohan_log_warning(msg) {
-		    gohan_log(LOG_MODULE, LOG_LEVEL.WARNING, msg);
-		}
-
-		function gohan_log_notice(msg) {
-		    gohan_log(LOG_MODULE, LOG_LEVEL.NOTICE, msg);
-		}
-
-		function gohan_log_info(msg) {
-		    gohan_log(LOG_MODULE, LOG_LEVEL.INFO, msg);
-		}
-
-		function gohan_log_debug(msg) {
-		    gohan_log(LOG_MODULE, LOG_LEVEL.DEBUG, msg);
-		}
-		`)
+		err := env.Load("<Gohan logging built-ins>", BuiltinLoggingCode)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,49 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otto
+
+import (
+	"github.com/robertkrimen/otto"
+
+	"github.com/cloudwan/gohan/secret"
+)
+
+func init() {
+	gohanSecretInit := func(env *Environment) {
+		vm := env.VM
+
+		builtins := map[string]interface{}{
+			"gohan_secret_get": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_secret_get", 1)
+
+				name, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+				}
+				value, err := secret.Get(name, env.path)
+				if err != nil {
+					ThrowOttoException(&call, "Failed to get secret %s: %s", name, err)
+				}
+				result, _ := otto.ToValue(value)
+				return result
+			},
+		}
+		for name, object := range builtins {
+			vm.Set(name, object)
+		}
+	}
+	RegisterInit(gohanSecretInit)
+}
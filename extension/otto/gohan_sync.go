@@ -17,12 +17,112 @@ package otto
 
 import (
 	"context"
+	"fmt"
+	gosync "sync"
 
 	"github.com/robertkrimen/otto"
 
 	"github.com/cloudwan/gohan/sync"
 )
 
+const syncLocksName = "gohan_sync_locks"
+
+// SyncLocks tracks paths locked by an extension, to release them when the handled event returns
+type SyncLocks struct {
+	sync  sync.Sync
+	mu    gosync.Mutex
+	paths map[string]struct{}
+}
+
+// NewSyncLocks allocates SyncLocks
+func NewSyncLocks(sync sync.Sync) *SyncLocks {
+	return &SyncLocks{sync: sync, paths: map[string]struct{}{}}
+}
+
+// Lock locks a path, locking is abandoned when ctx is canceled.
+// The lock itself outlives ctx, as sync keeps locks alive with the context they're taken with
+func (locks *SyncLocks) Lock(ctx context.Context, path string, block bool) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := locks.sync.Lock(context.Background(), path, block)
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+		locks.mu.Lock()
+		defer locks.mu.Unlock()
+		locks.paths[path] = struct{}{}
+		return nil
+	case <-ctx.Done():
+		go func() {
+			if err := <-errCh; err == nil {
+				locks.unlock(path)
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// Unlock unlocks a path locked by Lock
+func (locks *SyncLocks) Unlock(ctx context.Context, path string) error {
+	locks.mu.Lock()
+	if _, ok := locks.paths[path]; !ok {
+		locks.mu.Unlock()
+		return fmt.Errorf("path %s is not locked by this extension", path)
+	}
+	delete(locks.paths, path)
+	locks.mu.Unlock()
+	return locks.sync.Unlock(ctx, path)
+}
+
+// Release unlocks paths locked and not unlocked yet
+func (locks *SyncLocks) Release() {
+	locks.mu.Lock()
+	paths := locks.paths
+	locks.paths = map[string]struct{}{}
+	locks.mu.Unlock()
+
+	for path := range paths {
+		log.Warning("Releasing lock %s not unlocked by extension", path)
+		locks.unlock(path)
+	}
+}
+
+func (locks *SyncLocks) unlock(path string) {
+	if err := locks.sync.Unlock(context.Background(), path); err != nil {
+		log.Warning("Failed to release lock %s: %s", path, err)
+	}
+}
+
+func getSyncLocks(vm *otto.Otto) (*SyncLocks, error) {
+	value, err := vm.Get(syncLocksName)
+	if err != nil {
+		return nil, err
+	}
+	exported, err := value.Export()
+	if err != nil {
+		return nil, err
+	}
+	locks, ok := exported.(*SyncLocks)
+	if !ok {
+		return nil, fmt.Errorf("Object %#v is not sync locks", exported)
+	}
+	return locks, nil
+}
+
+func releaseSyncLocks(vm *otto.Otto) {
+	locks, err := getSyncLocks(vm)
+	if err != nil {
+		log.Warning(err.Error())
+		return
+	}
+	locks.Release()
+}
+
 func convertSyncNode(node *sync.Node) map[string]interface{} {
 	jsNode := map[string]interface{}{}
 
@@ -84,10 +184,91 @@ func init() {
 				}
 				return otto.NullValue()
 			},
+			"gohan_sync_lock": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_sync_lock", 2)
+
+				path, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+				}
+				block, err := GetBool(call.Argument(1))
+				if err != nil {
+					ThrowOttoException(&call, "Invalid type of second argument: expected a boolean")
+				}
+				locks, err := getSyncLocks(call.Otto)
+				if err != nil {
+					ThrowOttoException(&call, "Failed to lock sync: "+err.Error())
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				errCh := make(chan error, 1)
+				go func() {
+					errCh <- locks.Lock(ctx, path, block)
+				}()
+
+				select {
+				case interrupt := <-call.Otto.Interrupt:
+					log.Debug("Received otto interrupt in gohan_sync_lock")
+					cancel()
+					interrupt()
+				case err = <-errCh:
+				}
+				if err != nil {
+					if !block {
+						log.Debug("Failed to lock %s: %s", path, err)
+						value, _ := otto.ToValue(false)
+						return value
+					}
+					ThrowOttoException(&call, "Failed to lock sync: "+err.Error())
+				}
+				value, _ := otto.ToValue(true)
+				return value
+			},
+			"gohan_sync_unlock": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_sync_unlock", 1)
+
+				path, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+				}
+				locks, err := getSyncLocks(call.Otto)
+				if err == nil {
+					err = locks.Unlock(context.Background(), path)
+				}
+				if err != nil {
+					ThrowOttoException(&call, "Failed to unlock sync: "+err.Error())
+				}
+				return otto.NullValue()
+			},
+			"gohan_sync_compare_and_swap": func(call otto.FunctionCall) otto.Value {
+				VerifyCallArguments(&call, "gohan_sync_compare_and_swap", 3)
+
+				path, err := GetString(call.Argument(0))
+				if err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+				}
+				value, err := GetString(call.Argument(1))
+				if err != nil {
+					ThrowOttoException(&call, "Invalid type of second argument: expected a string")
+				}
+				expected, err := GetString(call.Argument(2))
+				if err != nil {
+					ThrowOttoException(&call, "Invalid type of third argument: expected a string")
+				}
+
+				swapped, err := env.Sync.CompareAndSwap(context.Background(), path, value, env.Sync.ByValue(expected))
+				if err != nil {
+					ThrowOttoException(&call, "Failed to compare and swap sync: "+err.Error())
+				}
+				result, _ := otto.ToValue(swapped)
+				return result
+			},
 		}
 		for name, object := range builtins {
 			vm.Set(name, object)
 		}
+		vm.Set(syncLocksName, NewSyncLocks(env.Sync))
 	}
 	RegisterInit(gohanSyncInit)
 }
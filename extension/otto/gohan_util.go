@@ -33,6 +33,7 @@ import (
 	"github.com/robertkrimen/otto"
 	"github.com/twinj/uuid"
 
+	"github.com/cloudwan/gohan/httpclient"
 	"github.com/cloudwan/gohan/schema"
 	"github.com/cloudwan/gohan/util"
 )
@@ -43,6 +44,12 @@ const (
 	defaultHTTPRequestTimeout        = 3000
 )
 
+// rawHTTPResult is the response of a request made by gohan_raw_http
+type rawHTTPResult struct {
+	resp *http.Response
+	err  error
+}
+
 func init() {
 	gohanUtilInit := func(env *Environment) {
 		vm := env.VM
@@ -56,7 +63,10 @@ func init() {
 					defaultTimeout, _ := otto.ToValue(defaultHTTPRequestTimeout)
 					call.ArgumentList = append(call.ArgumentList, defaultTimeout)
 				}
-				VerifyCallArguments(&call, "gohan_http", 6)
+				if len(call.ArgumentList) == 6 {
+					call.ArgumentList = append(call.ArgumentList, otto.NullValue())
+				}
+				VerifyCallArguments(&call, "gohan_http", 7)
 				method, err := GetString(call.Argument(0))
 				if err != nil {
 					ThrowOttoException(&call, err.Error())
@@ -79,9 +89,13 @@ func init() {
 				if err != nil {
 					ThrowOttoException(&call, err.Error())
 				}
+				options, err := getHTTPOptions(&call, 6)
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
 				log.Debug("gohan_http  [%s] %s %s %s %s", method, rawHeaders, url, opaque, timeout)
 
-				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+				ctx, cancel := httpclient.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond, getDeadline(call.Otto))
 				defer cancel()
 
 				var (
@@ -92,7 +106,7 @@ func init() {
 
 				done := make(chan struct{})
 				go func() {
-					code, headers, body, err = GohanHTTP(ctx, method, url, rawHeaders, data, opaque)
+					code, headers, body, err = GohanHTTP(ctx, method, url, rawHeaders, data, opaque, options)
 					close(done)
 				}()
 
@@ -120,7 +134,10 @@ func init() {
 				return value
 			},
 			"gohan_raw_http": func(call otto.FunctionCall) otto.Value {
-				VerifyCallArguments(&call, "gohan_raw_http", 4)
+				if len(call.ArgumentList) == 4 {
+					call.ArgumentList = append(call.ArgumentList, otto.NullValue())
+				}
+				VerifyCallArguments(&call, "gohan_raw_http", 5)
 				method, err := GetString(call.Argument(0))
 				if err != nil {
 					ThrowOttoException(&call, err.Error())
@@ -137,9 +154,12 @@ func init() {
 				if err != nil {
 					ThrowOttoException(&call, err.Error())
 				}
-				//TODO: pass Transport options like timeouts
+				options, err := getHTTPOptions(&call, 4)
+				if err != nil {
+					ThrowOttoException(&call, err.Error())
+				}
 
-				ctx, cancel := context.WithCancel(context.Background())
+				ctx, cancel := httpclient.WithTimeout(context.Background(), 0, getDeadline(call.Otto))
 				defer cancel()
 
 				// prepare request
@@ -159,27 +179,38 @@ func init() {
 					req.Header.Set(header, value)
 				}
 
-				var resp *http.Response
-
-				// run query
-				done := make(chan struct{})
+				// run query, the response is handed over only while the call waits for it
+				results := make(chan rawHTTPResult)
+				interrupted := make(chan struct{})
 				go func() {
-					resp, err = http.DefaultTransport.RoundTrip(req)
-					close(done)
+					resp, err := httpclient.Do(req, http.DefaultTransport.RoundTrip, options)
+					select {
+					case results <- rawHTTPResult{resp: resp, err: err}:
+					case <-interrupted:
+						// the body has to be closed to release the connection and the slot of the host
+						if err == nil {
+							resp.Body.Close()
+						}
+					}
 				}()
 
+				var response rawHTTPResult
 				select {
 				case interrupt := <-call.Otto.Interrupt:
 					log.Debug("Received otto interrupt in gohan_raw_http")
 					cancel()
+					close(interrupted)
 					interrupt()
-				case <-done:
+				case response = <-results:
 				}
 
+				resp, err := response.resp, response.err
 				if err != nil {
 					ThrowOttoException(&call, err.Error())
 				}
 
+				defer resp.Body.Close()
+
 				// process resp
 				result := map[string]interface{}{}
 				result["status"] = resp.Status
@@ -351,9 +382,9 @@ func init() {
 	RegisterInit(gohanUtilInit)
 }
 
-// GohanHTTP performs a HTTP request
+// GohanHTTP performs a HTTP request with the policy of its destination, overridden by options
 func GohanHTTP(ctx context.Context, method, rawURL string, headers map[string]interface{},
-	postData interface{}, opaque bool) (int, http.Header, string, error) {
+	postData interface{}, opaque bool, options httpclient.Options) (int, http.Header, string, error) {
 
 	var reader io.Reader
 
@@ -404,7 +435,7 @@ func GohanHTTP(ctx context.Context, method, rawURL string, headers map[string]in
 			Opaque: rawURL,
 		}
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.Do(req, http.DefaultClient.Do, options)
 	if err != nil {
 		return 0, http.Header{}, "", err
 	}
@@ -416,3 +447,16 @@ func GohanHTTP(ctx context.Context, method, rawURL string, headers map[string]in
 	}
 	return resp.StatusCode, resp.Header, string(body), nil
 }
+
+// getHTTPOptions reads optional options of HTTP builtins, given as the argument of index
+func getHTTPOptions(call *otto.FunctionCall, index int) (httpclient.Options, error) {
+	argument := call.Argument(index)
+	if argument.IsUndefined() || argument.IsNull() {
+		return httpclient.Options{}, nil
+	}
+	options, err := GetMap(argument)
+	if err != nil {
+		return httpclient.Options{}, err
+	}
+	return httpclient.OptionsFromMap(options)
+}
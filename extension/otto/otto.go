@@ -67,6 +67,8 @@ type Environment struct {
 	Sync        sync.Sync
 	globalStore *GlobalStore
 	loadHooks   []string
+	// path extensions are loaded for, secrets are allowed by it
+	path string
 }
 
 //NewEnvironment create new gohan extension environment based on context
@@ -133,6 +135,7 @@ func (env *Environment) RegisterObject(objectID string, object interface{}) {
 
 //LoadExtensionsForPath loads extensions for specific path
 func (env *Environment) LoadExtensionsForPath(extensions []*schema.Extension, timeLimit time.Duration, timeLimits []*schema.PathEventTimeLimit, path string) error {
+	env.path = path
 	for _, extension := range extensions {
 		if extension.Match(path) {
 			code := extension.Code
@@ -140,6 +143,7 @@ func (env *Environment) LoadExtensionsForPath(extensions []*schema.Extension, ti
 				continue
 			}
 			url := strings.TrimPrefix(extension.URL, "file://")
+			env.VM.Set("gohan_handler_source", handlerSource(extension.ID, url))
 			err := env.Load(url, code)
 			if err != nil {
 				return err
@@ -156,6 +160,14 @@ func (env *Environment) LoadExtensionsForPath(extensions []*schema.Extension, ti
 	return nil
 }
 
+//handlerSource names handlers registered by an extension in profiles, by its file if it has one
+func handlerSource(id, url string) string {
+	if url != "" {
+		return url
+	}
+	return id
+}
+
 func convertNilsToNulls(object interface{}) {
 	switch object := object.(type) {
 	case map[string]interface{}:
@@ -219,6 +231,9 @@ func (env *Environment) HandleEvent(event string, context map[string]interface{}
 		}
 	}
 	timer := time.NewTimer(selectedTimeLimit)
+	previousDeadline := getDeadline(vm.Otto)
+	setDeadline(vm.Otto, time.Now().Add(selectedTimeLimit))
+	defer setDeadline(vm.Otto, previousDeadline)
 	successCh := make(chan bool)
 	go func() {
 		for {
@@ -261,6 +276,7 @@ func (env *Environment) HandleEvent(event string, context map[string]interface{}
 		} else {
 			log.Warning(err.Error())
 		}
+		releaseSyncLocks(vm.Otto)
 	}()
 	_, err = vm.Call("gohan_handle_event", nil, event, contextInVM)
 	for key, value := range context {
@@ -314,6 +330,23 @@ func addCloser(vm *otto.Otto, closer io.Closer) error {
 	return nil
 }
 
+const deadlineName = "gohan_deadline"
+
+// getDeadline returns the time the handled event times out at, which is zero between events
+func getDeadline(vm *otto.Otto) time.Time {
+	value, err := vm.Get(deadlineName)
+	if err != nil {
+		return time.Time{}
+	}
+	exported, _ := value.Export()
+	deadline, _ := exported.(time.Time)
+	return deadline
+}
+
+func setDeadline(vm *otto.Otto, deadline time.Time) {
+	vm.Set(deadlineName, deadline)
+}
+
 // SetEventTimeLimit overrides the default time limit for a given event for this environment
 func (env *Environment) SetEventTimeLimit(eventRegex string, timeLimit time.Duration) {
 	env.timeLimits = append(env.timeLimits, schema.NewEventTimeLimit(regexp.MustCompile(eventRegex), timeLimit))
@@ -326,9 +359,11 @@ func (env *Environment) Clone() ext.Environment {
 	clone.VM.Otto.Interrupt = make(chan func(), 1)
 	clone.timeLimit = env.timeLimit
 	clone.timeLimits = env.timeLimits
+	clone.path = env.path
 	// workaround for original env being shared in builtin closures
 	// need another fix for this race'y and unsafe behavior
 	clone.VM.Otto.Set("gohan_closers", []io.Closer{})
+	clone.VM.Otto.Set(syncLocksName, NewSyncLocks(env.Sync))
 	clone.globalStore = env.globalStore
 	for _, hook := range env.loadHooks {
 		clone.loadHooks = append(clone.loadHooks, hook)
@@ -368,6 +403,7 @@ func (env *Environment) GetOrCreateTransaction(value otto.Value) (transaction.Tr
 
 // ClearEnvironment closes sync and db in env
 func (env *Environment) ClearEnvironment() {
+	releaseSyncLocks(env.VM.Otto)
 	env.Sync.Close()
 	env.DataStore.Close()
 }
@@ -25,26 +25,99 @@ import (
 	"strings"
 	"time"
 
+	gojapkg "github.com/dop251/goja"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
 	ottopkg "github.com/robertkrimen/otto"
 
+	"github.com/cloudwan/gohan/db"
 	"github.com/cloudwan/gohan/db/dbutil"
 	"github.com/cloudwan/gohan/db/transaction"
 	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/goja"
 	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/job"
 	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/secret"
 	"github.com/cloudwan/gohan/server/middleware"
 	"github.com/cloudwan/gohan/server/resources"
 	"github.com/cloudwan/gohan/util"
 )
 
-func newEnvironment() *otto.Environment {
-	return otto.NewEnvironment("otto_test", testDB, &middleware.FakeIdentity{}, testSync)
+//testEnvironment is implemented by environments of all JavaScript engines
+type testEnvironment interface {
+	extension.Environment
+	SetUp()
+	Load(source, code string) error
+	SetEventTimeLimit(eventRegex string, timeLimit time.Duration)
 }
 
-var _ = Describe("Otto extension manager", func() {
+//engines lists JavaScript engines running the javascript extensions
+var engines = []struct {
+	name           string
+	newEnvironment func(dataStore db.DB) testEnvironment
+}{
+	{"otto", func(dataStore db.DB) testEnvironment {
+		return otto.NewEnvironment("otto_test", dataStore, &middleware.FakeIdentity{}, testSync)
+	}},
+	{"goja", func(dataStore db.DB) testEnvironment {
+		return goja.NewEnvironment("otto_test", dataStore, &middleware.FakeIdentity{}, testSync)
+	}},
+}
+
+//describeEngines runs the same specs for each JavaScript engine
+func describeEngines(text string, body func(engine string, newEnvironment func() testEnvironment)) bool {
+	return describeEnginesWithDB(text, func(engine string, newEnvironment func(dataStore db.DB) testEnvironment) {
+		body(engine, func() testEnvironment {
+			return newEnvironment(testDB)
+		})
+	})
+}
+
+//describeEnginesWithDB runs the same specs for each JavaScript engine using the given data store
+func describeEnginesWithDB(text string, body func(engine string, newEnvironment func(dataStore db.DB) testEnvironment)) bool {
+	for _, engine := range engines {
+		engine := engine
+		Describe(fmt.Sprintf("%s (%s)", text, engine.name), func() {
+			body(engine.name, engine.newEnvironment)
+		})
+	}
+	return true
+}
+
+//getGlobal exports a global variable of the environment's VM
+func getGlobal(env extension.Environment, name string) interface{} {
+	switch env := env.(type) {
+	case *otto.Environment:
+		value, err := env.VM.Get(name)
+		Expect(err).ToNot(HaveOccurred())
+		exported, err := value.Export()
+		Expect(err).ToNot(HaveOccurred())
+		return exported
+	case *goja.Environment:
+		return env.VM.Get(name).Export()
+	}
+	Fail(fmt.Sprintf("unknown environment %T", env))
+	return nil
+}
+
+//callGlobal calls a global function of the environment's VM
+func callGlobal(env extension.Environment, name string) error {
+	switch env := env.(type) {
+	case *otto.Environment:
+		_, err := env.VM.Call(name, nil)
+		return err
+	case *goja.Environment:
+		function, ok := gojapkg.AssertFunction(env.VM.Get(name))
+		Expect(ok).To(BeTrue())
+		_, err := function(gojapkg.Undefined())
+		return err
+	}
+	return fmt.Errorf("unknown environment %T", env)
+}
+
+var _ = describeEngines("Otto extension manager", func(engine string, newEnvironment func() testEnvironment) {
 	var (
 		manager            *schema.Manager
 		environmentManager *extension.Manager
@@ -214,7 +287,11 @@ var _ = Describe("Otto extension manager", func() {
 				err = env.HandleEvent("test_event", context)
 				Expect(err).To(HaveOccurred())
 
-				Expect(regexp.MatchString(`ReferenceError:\s'b'`, err.Error())).To(BeTrue())
+				referenceError := map[string]string{
+					"otto": `ReferenceError:\s'b'`,
+					"goja": `ReferenceError:\sb is not defined`,
+				}[engine]
+				Expect(regexp.MatchString(referenceError, err.Error())).To(BeTrue())
 
 				pattern := regexp.MustCompile(`at\s(?P<function>\w+)\s\((?P<file>.*?):(?P<line>\d+).*?\)`)
 				match := pattern.FindStringSubmatch(err.Error())
@@ -273,7 +350,7 @@ var _ = Describe("Otto extension manager", func() {
 				extensions := []*schema.Extension{timeoutExtension}
 				env := newEnvironment()
 				env.LoadExtensionsForPath(extensions, timeLimit, timeLimits, "test_path")
-				cloned := env.Clone().(*otto.Environment)
+				cloned := env.Clone()
 
 				context := map[string]interface{}{
 					"id": "test",
@@ -281,18 +358,14 @@ var _ = Describe("Otto extension manager", func() {
 
 				err = env.HandleEvent("test_event", context)
 				Expect(err).To(MatchError(ContainSubstring("exceed timeout for extension execution")))
-				txValue1, err := env.VM.Get("unclosedTx")
-				Expect(err).ToNot(HaveOccurred())
-				tx1, err := otto.GetTransaction(txValue1)
-				Expect(err).ToNot(HaveOccurred())
+				tx1, ok := getGlobal(env, "unclosedTx").(transaction.Transaction)
+				Expect(ok).To(BeTrue())
 				Expect(tx1.Closed()).To(BeTrue())
 
 				err = cloned.HandleEvent("test_event", context)
 				Expect(err).To(MatchError(ContainSubstring("exceed timeout for extension execution")))
-				txValue2, err := cloned.VM.Get("unclosedTx")
-				Expect(err).ToNot(HaveOccurred())
-				tx2, err := otto.GetTransaction(txValue2)
-				Expect(err).ToNot(HaveOccurred())
+				tx2, ok := getGlobal(cloned, "unclosedTx").(transaction.Transaction)
+				Expect(ok).To(BeTrue())
 				Expect(tx1.Closed()).To(BeTrue())
 
 				Expect(tx1).NotTo(BeIdenticalTo(tx2))
@@ -430,6 +503,44 @@ var _ = Describe("Otto extension manager", func() {
 			})
 		})
 
+		Context("When retries are given", func() {
+			It("Should retry failed requests", func() {
+				server := ghttp.NewServer()
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/contents"),
+						ghttp.RespondWith(503, "UNAVAILABLE"),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/contents"),
+						ghttp.RespondWith(200, "HELLO"),
+					),
+				)
+
+				extension, err := schema.NewExtension(map[string]interface{}{
+					"id": "test_extension",
+					"code": `
+						gohan_register_handler("test_event", function(context){
+							context.resp = gohan_http('GET', '` + server.URL() + `/contents', {}, null, false, 3000, {retries: 1, backoff: 10});
+						});`,
+					"path": ".*",
+				})
+				Expect(err).ToNot(HaveOccurred())
+				extensions := []*schema.Extension{extension}
+				env := newEnvironment()
+				Expect(env.LoadExtensionsForPath(extensions, timeLimit, timeLimits, "test_path")).To(Succeed())
+
+				context := map[string]interface{}{
+					"id": "test",
+				}
+				Expect(env.HandleEvent("test_event", context)).To(Succeed())
+				Expect(context).To(HaveKeyWithValue("resp", HaveKeyWithValue("status_code", "200")))
+				Expect(context).To(HaveKeyWithValue("resp", HaveKeyWithValue("body", "HELLO")))
+				Expect(server.ReceivedRequests()).To(HaveLen(2))
+				server.Close()
+			})
+		})
+
 		Context("When the content type is not specified", func() {
 			It("Should post the data as a JSON document", func() {
 				server := ghttp.NewServer()
@@ -784,6 +895,50 @@ var _ = Describe("Otto extension manager", func() {
 				})
 			})
 
+			Context("When given typed predicates", func() {
+				It("Lists matching resources", func() {
+					tx, err := testDB.BeginTx()
+					Expect(err).ToNot(HaveOccurred(), "Failed to create transaction.")
+					defer tx.Commit()
+
+					extension, err := schema.NewExtension(map[string]interface{}{
+						"id": "test_extension",
+						"code": `
+						gohan_register_handler("test_event", function(context){
+						  _.each(['red', 'blue'], function(id) {
+						    gohan_db_create(context.transaction,
+						      'network', {
+								'id': id,
+								'name': id,
+								'description': 'description',
+								'providor_networks': {},
+								'route_targets': [],
+								'shared': false,
+								'tenant_id': 'admin'});
+						  });
+						  context.networks = gohan_db_list(context.transaction, 'network', {
+						    '__and__': [
+						      {'property': 'id', 'type': 'in', 'value': ['red', 'green']},
+						      {'property': 'description', 'type': 'null', 'value': false}
+						    ]
+						  });
+						});`,
+						"path": ".*",
+					})
+					Expect(err).ToNot(HaveOccurred())
+					env := newEnvironment()
+					env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, "test_path")
+
+					context := map[string]interface{}{
+						"id":          "test",
+						"transaction": tx,
+					}
+					Expect(env.HandleEvent("test_event", context)).To(Succeed())
+					Expect(context["networks"]).To(HaveLen(1))
+					Expect(context["networks"].([]map[string]interface{})[0]).To(HaveKeyWithValue("id", "red"))
+				})
+			})
+
 			Context("When given no transaction", func() {
 				It("Correctly handles CRUD operations", func() {
 					tx, err := testDB.BeginTx()
@@ -1091,7 +1246,7 @@ var _ = Describe("Otto extension manager", func() {
 							defer tx.Close()
 							context["transaction"] = tx
 							Expect(env.HandleEvent("test", context)).To(Succeed())
-							Expect(context["exception"]).To(HaveKeyWithValue("problem", int(resources.NotFound)))
+							Expect(context["exception"]).To(HaveKeyWithValue("problem", BeNumerically("==", resources.NotFound)))
 							Expect(context["exception_message"]).To(ContainSubstring("ResourceException"))
 						})
 					})
@@ -1659,6 +1814,190 @@ var _ = Describe("Otto extension manager", func() {
 		})
 	})
 
+	Describe("Using gohan_job_enqueue builtin", func() {
+		It("Enqueues jobs within the transaction", func() {
+			tx, err := testDB.BeginTx()
+			Expect(err).ToNot(HaveOccurred(), "Failed to create transaction.")
+			defer tx.Close()
+
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+				gohan_register_handler("test_event", function(context){
+				  context.first = gohan_job_enqueue(context.transaction, 'cleanup', {'network': 'red'});
+				  context.second = gohan_job_enqueue(context.transaction, 'provision', {}, {'delay': 30, 'max_attempts': 2, 'unique_key': 'red'});
+				  context.third = gohan_job_enqueue(context.transaction, 'provision', {}, {'unique_key': 'red'});
+				});`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			env := newEnvironment()
+			env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, "test_path")
+
+			context := map[string]interface{}{
+				"transaction": tx,
+			}
+			Expect(env.HandleEvent("test_event", context)).To(Succeed())
+			Expect(tx.Commit()).To(Succeed())
+			Expect(context["third"]).To(Equal(context["second"]))
+
+			queue := job.NewQueue(testDB)
+			jobs, err := queue.List(ctx, job.StatusPending, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(jobs).To(HaveLen(2))
+			Expect(jobs[0].Type).To(Equal("cleanup"))
+			Expect(jobs[0].Payload).To(HaveKeyWithValue("network", "red"))
+			Expect(jobs[1].MaxAttempts).To(Equal(2))
+			Expect(jobs[1].RunAt).To(BeNumerically(">", time.Now().Unix()+20))
+		})
+
+		It("Rejects invalid options", func() {
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+				gohan_register_handler("test_event", function(context){
+				  gohan_job_enqueue(null, 'cleanup', {}, {'delay': 'soon'});
+				});`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			env := newEnvironment()
+			env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, "test_path")
+			Expect(env.HandleEvent("test_event", map[string]interface{}{})).To(MatchError(ContainSubstring("delay should be")))
+		})
+	})
+
+	Describe("Using gohan_sync_lock builtin", func() {
+		const (
+			lockPath = "/otto_test/lock"
+			casPath  = "/otto_test/cas"
+		)
+
+		AfterEach(func() {
+			Expect(testSync.Delete(ctx, casPath, false)).To(Succeed())
+		})
+
+		It("Locks, swaps and releases locks when the handler returns", func() {
+			Expect(testSync.Update(ctx, casPath, `{"state":"a"}`)).To(Succeed())
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+				gohan_register_handler("test_event", function(context){
+				  context.locked = gohan_sync_lock('` + lockPath + `', false);
+				  context.relocked = gohan_sync_lock('` + lockPath + `', false);
+				  gohan_sync_unlock('` + lockPath + `');
+				  context.locked_again = gohan_sync_lock('` + lockPath + `', true);
+				  context.swapped = gohan_sync_compare_and_swap('` + casPath + `', '{"state":"b"}', '{"state":"a"}');
+				  context.not_swapped = gohan_sync_compare_and_swap('` + casPath + `', '{"state":"c"}', '{"state":"a"}');
+				});`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			env := newEnvironment()
+			env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, "test_path")
+
+			context := map[string]interface{}{}
+			Expect(env.HandleEvent("test_event", context)).To(Succeed())
+			Expect(context).To(HaveKeyWithValue("locked", true))
+			Expect(context).To(HaveKeyWithValue("relocked", false))
+			Expect(context).To(HaveKeyWithValue("locked_again", true))
+			Expect(context).To(HaveKeyWithValue("swapped", true))
+			Expect(context).To(HaveKeyWithValue("not_swapped", false))
+
+			Expect(testSync.HasLock(lockPath)).To(BeFalse())
+			node, err := testSync.Fetch(ctx, casPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.Value).To(Equal(`{"state":"b"}`))
+		})
+
+		It("Releases locks of a failed handler", func() {
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+				gohan_register_handler("test_event", function(context){
+				  gohan_sync_lock('` + lockPath + `', true);
+				  throw new Error("failed holding the lock");
+				});`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			env := newEnvironment()
+			env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, "test_path")
+
+			Expect(env.HandleEvent("test_event", map[string]interface{}{})).To(MatchError(ContainSubstring("failed holding the lock")))
+			Expect(testSync.HasLock(lockPath)).To(BeFalse())
+			Expect(env.Clone().HandleEvent("test_event", map[string]interface{}{})).To(MatchError(ContainSubstring("failed holding the lock")))
+			Expect(testSync.HasLock(lockPath)).To(BeFalse())
+		})
+
+		It("Refuses to unlock paths not locked by the handler", func() {
+			_, err := testSync.Lock(ctx, lockPath, false)
+			Expect(err).ToNot(HaveOccurred())
+			defer testSync.Unlock(ctx, lockPath)
+
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+				gohan_register_handler("test_event", function(context){
+				  gohan_sync_unlock('` + lockPath + `');
+				});`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			env := newEnvironment()
+			env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, "test_path")
+
+			Expect(env.HandleEvent("test_event", map[string]interface{}{})).To(MatchError(ContainSubstring("is not locked by this extension")))
+			Expect(testSync.HasLock(lockPath)).To(BeTrue())
+		})
+	})
+
+	Describe("Using gohan_secret_get builtin", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "otto_secrets")
+			Expect(err).ToNot(HaveOccurred())
+			store := secret.NewStore(dir+"/secrets.json", dir+"/secrets.key")
+			Expect(store.Set("device_password", "s3cr3t", []string{"^/v2.0/devices"})).To(Succeed())
+			secret.SetStore(store)
+		})
+
+		AfterEach(func() {
+			secret.SetStore(nil)
+			os.RemoveAll(dir)
+		})
+
+		loadEnvironment := func(path string) testEnvironment {
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+				gohan_register_handler("test_event", function(context){
+				  context.password = gohan_secret_get('device_password');
+				  gohan_log_info("logging " + context.password);
+				});`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			env := newEnvironment()
+			Expect(env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, path)).To(Succeed())
+			return env
+		}
+
+		It("Gets secrets allowed for the extension path", func() {
+			context := map[string]interface{}{}
+			Expect(loadEnvironment("/v2.0/devices").Clone().HandleEvent("test_event", context)).To(Succeed())
+			Expect(context).To(HaveKeyWithValue("password", "s3cr3t"))
+			Expect(secret.Redact("logging s3cr3t")).To(Equal("logging " + secret.Redacted))
+		})
+
+		It("Fails for secrets of other paths", func() {
+			Expect(loadEnvironment("/v2.0/networks").HandleEvent("test_event", map[string]interface{}{})).To(
+				MatchError(ContainSubstring(secret.ErrForbidden.Error())))
+		})
+	})
+
 	Describe("Using gohan_global", func() {
 		var (
 			loadingExtension       *schema.Extension
@@ -1791,6 +2130,39 @@ var _ = Describe("Otto extension manager", func() {
 		})
 	})
 
+	Describe("Cloning an environment", func() {
+		It("Should start each clone from the state left by the top-level code", func() {
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+					var loaded = (typeof loaded === "undefined" ? 0 : loaded) + 1;
+					var calls = 0;
+					gohan_register_handler("test_event", function(context) {
+						calls += 1;
+						context.loaded = loaded;
+						context.calls = calls;
+					});
+					`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			env := newEnvironment()
+			Expect(env.LoadExtensionsForPath([]*schema.Extension{extension}, timeLimit, timeLimits, "test_path")).To(Succeed())
+
+			for i := 0; i < 2; i++ {
+				cloned := env.Clone()
+				context := makeContext()
+				Expect(cloned.HandleEvent("test_event", context)).To(Succeed())
+				Expect(context["loaded"]).To(BeNumerically("==", 1))
+				Expect(context["calls"]).To(BeNumerically("==", 1))
+
+				context = makeContext()
+				Expect(cloned.HandleEvent("test_event", context)).To(Succeed())
+				Expect(context["calls"]).To(BeNumerically("==", 2))
+			}
+		})
+	})
+
 	Describe("Using gohan_get_env builtin", func() {
 
 		AfterEach(func() {
@@ -1890,29 +2262,39 @@ var _ = Describe("Otto extension manager", func() {
 
 	var _ = Describe("Concurrency race", func() {
 		var (
-			env *otto.Environment
+			env testEnvironment
 		)
 		channel := make(chan string)
 		Context("Given environment", func() {
 			BeforeEach(func() {
 				env = newEnvironment()
 				env.SetUp()
-				vm := env.VM
-				builtins := map[string]interface{}{
-					"test_consume": func(call ottopkg.FunctionCall) ottopkg.Value {
-						result := <-channel
-						ottoResult, _ := vm.ToValue(result)
-						return ottoResult
-					},
-					"test_produce": func(call ottopkg.FunctionCall) ottopkg.Value {
-						ottoProduct := otto.ConvertOttoToGo(call.Argument(0))
-						product := otto.ConvertOttoToGo(ottoProduct).(string)
+				switch env := env.(type) {
+				case *otto.Environment:
+					vm := env.VM
+					builtins := map[string]interface{}{
+						"test_consume": func(call ottopkg.FunctionCall) ottopkg.Value {
+							result := <-channel
+							ottoResult, _ := vm.ToValue(result)
+							return ottoResult
+						},
+						"test_produce": func(call ottopkg.FunctionCall) ottopkg.Value {
+							ottoProduct := otto.ConvertOttoToGo(call.Argument(0))
+							product := otto.ConvertOttoToGo(ottoProduct).(string)
+							channel <- product
+							return ottopkg.NullValue()
+						},
+					}
+					for name, object := range builtins {
+						vm.Set(name, object)
+					}
+				case *goja.Environment:
+					env.RegisterObject("test_consume", func() string {
+						return <-channel
+					})
+					env.RegisterObject("test_produce", func(product string) {
 						channel <- product
-						return ottopkg.NullValue()
-					},
-				}
-				for name, object := range builtins {
-					vm.Set(name, object)
+					})
 				}
 
 				Expect(env.Load("<race_test>", `
@@ -1938,16 +2320,12 @@ var _ = Describe("Otto extension manager", func() {
 
 				go func() {
 					testEnv, _ := environmentManager.GetEnvironment("test_race")
-					ottoEnv := testEnv.(*otto.Environment)
-					_, err := ottoEnv.VM.Call("consume", nil)
-					consumerError <- err
+					consumerError <- callGlobal(testEnv, "consume")
 				}()
 
 				go func() {
 					testEnv, _ := environmentManager.GetEnvironment("test_race")
-					ottoEnv := testEnv.(*otto.Environment)
-					_, err := ottoEnv.VM.Call("produce", nil)
-					producerError <- err
+					producerError <- callGlobal(testEnv, "produce")
 				}()
 
 				select {
@@ -1978,7 +2356,7 @@ var _ = Describe("Otto extension manager", func() {
 				})
 				Expect(err).ToNot(HaveOccurred())
 				extensions := []*schema.Extension{extension}
-				env := otto.NewEnvironment("otto_test", testDB, &middleware.FakeIdentity{}, testSync)
+				env := newEnvironment()
 				Expect(env.LoadExtensionsForPath(extensions, time.Duration(100), timeLimits, "test_path")).To(Succeed())
 
 				context := map[string]interface{}{
@@ -2008,7 +2386,7 @@ var _ = Describe("Otto extension manager", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 			extensions := []*schema.Extension{extension}
-			env = otto.NewEnvironment("otto_test", testDB, &middleware.FakeIdentity{}, testSync)
+			env = newEnvironment()
 			Expect(env.LoadExtensionsForPath(extensions, time.Duration(100), timeLimits, "test_path")).To(Succeed())
 		}
 
@@ -2027,11 +2405,11 @@ var _ = Describe("Otto extension manager", func() {
 	})
 })
 
-var _ = Describe("Using gohan_file builtin", func() {
+var _ = describeEngines("Using gohan_file builtin", func(engine string, newEnvironment func() testEnvironment) {
 	var (
 		ctx     = context.Background()
 		context map[string]interface{}
-		env     *otto.Environment
+		env     testEnvironment
 
 		timeLimit  time.Duration
 		timeLimits []*schema.PathEventTimeLimit
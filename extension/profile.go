@@ -0,0 +1,206 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extension
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/util"
+)
+
+const defaultSlowHandlerThreshold = time.Second
+
+var (
+	log = l.NewLogger()
+
+	profilingEnabled int32
+	slowThreshold    = defaultSlowHandlerThreshold
+
+	profilesLock sync.Mutex
+	profiles     = map[Handler]*HandlerProfile{}
+
+	invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+)
+
+//Handler identifies an extension handler
+type Handler struct {
+	//Environment is the type of the environment, e.g. otto or goplugin
+	Environment string `json:"environment"`
+	Schema      string `json:"schema"`
+	Event       string `json:"event"`
+	//Name is the file of javascript handlers and the function name with priority of go handlers
+	Name string `json:"name"`
+}
+
+//HandlerProfile is timing of a handler
+type HandlerProfile struct {
+	Handler
+	Calls  int64
+	Errors int64
+	Total  time.Duration
+	Max    time.Duration
+}
+
+//Average returns average duration of a call
+func (profile *HandlerProfile) Average() time.Duration {
+	if profile.Calls == 0 {
+		return 0
+	}
+	return profile.Total / time.Duration(profile.Calls)
+}
+
+//SetupProfiling configures profiling of handlers from config
+func SetupProfiling(config *util.Config) {
+	SetProfiling(config.GetBool("extension/profiling/enabled", false),
+		config.GetDuration("extension/profiling/slow_threshold", defaultSlowHandlerThreshold))
+}
+
+//SetProfiling enables profiling of handlers, handlers running at least
+//threshold are logged as slow unless it's 0
+func SetProfiling(enabled bool, threshold time.Duration) {
+	profilesLock.Lock()
+	defer profilesLock.Unlock()
+	slowThreshold = threshold
+	if enabled {
+		atomic.StoreInt32(&profilingEnabled, 1)
+	} else {
+		atomic.StoreInt32(&profilingEnabled, 0)
+	}
+}
+
+//ProfilingEnabled reports whether handlers are profiled
+func ProfilingEnabled() bool {
+	return atomic.LoadInt32(&profilingEnabled) == 1
+}
+
+//ProfileHandler records a call of a handler started at the given time
+func ProfileHandler(handler Handler, started time.Time, traceID string, failed bool) {
+	if !ProfilingEnabled() {
+		return
+	}
+	duration := time.Since(started)
+	name := invalidMetricChars.ReplaceAllString(handler.Name, "_")
+	metrics.UpdateTimer(started, "ext.handler.%s.%s.%s.%s", handler.Environment, handler.Schema, handler.Event, name)
+	if failed {
+		metrics.UpdateCounter(1, "ext.handler_errors.%s.%s.%s.%s", handler.Environment, handler.Schema, handler.Event, name)
+	}
+
+	profilesLock.Lock()
+	profile, ok := profiles[handler]
+	if !ok {
+		profile = &HandlerProfile{Handler: handler}
+		profiles[handler] = profile
+	}
+	profile.Calls++
+	if failed {
+		profile.Errors++
+	}
+	profile.Total += duration
+	if duration > profile.Max {
+		profile.Max = duration
+	}
+	threshold := slowThreshold
+	profilesLock.Unlock()
+
+	if threshold > 0 && duration >= threshold {
+		log.Warning("Slow extension handler %s of %s event of %s schema in %s environment took %s, trace id: %s",
+			handler.Name, handler.Event, handler.Schema, handler.Environment, duration, traceID)
+	}
+}
+
+//ProfileEnvironment records handling of an event by an environment started at the given time
+func ProfileEnvironment(environment, schemaID, event string, started time.Time, failed bool) {
+	if !ProfilingEnabled() {
+		return
+	}
+	metrics.UpdateTimer(started, "ext.env.%s.%s.%s", environment, schemaID, event)
+	if failed {
+		metrics.UpdateCounter(1, "ext.env_errors.%s.%s.%s", environment, schemaID, event)
+	}
+}
+
+//HandlerProfiles returns limit profiles with the highest average, max or total duration
+func HandlerProfiles(limit int, sortKey string) ([]HandlerProfile, error) {
+	var key func(profile *HandlerProfile) time.Duration
+	switch sortKey {
+	case "", "average":
+		key = (*HandlerProfile).Average
+	case "max":
+		key = func(profile *HandlerProfile) time.Duration { return profile.Max }
+	case "total":
+		key = func(profile *HandlerProfile) time.Duration { return profile.Total }
+	default:
+		return nil, fmt.Errorf("unknown sort key: %s", sortKey)
+	}
+
+	profilesLock.Lock()
+	result := make([]HandlerProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		result = append(result, *profile)
+	}
+	profilesLock.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return key(&result[i]) > key(&result[j])
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+//ClearHandlerProfiles removes recorded profiles
+func ClearHandlerProfiles() {
+	profilesLock.Lock()
+	profiles = map[Handler]*HandlerProfile{}
+	profilesLock.Unlock()
+}
+
+//EnvironmentType returns type of the environment, i.e. name of its package
+func EnvironmentType(env Environment) string {
+	t := reflect.TypeOf(env)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return path.Base(t.PkgPath())
+}
+
+//ContextSchemaID returns ID of the schema an event context belongs to
+func ContextSchemaID(context map[string]interface{}) string {
+	if schemaID, ok := context["schema_id"]; ok {
+		return fmt.Sprint(schemaID)
+	}
+	if s, ok := context["schema"].(*schema.Schema); ok {
+		return s.ID
+	}
+	return ""
+}
+
+//ContextTraceID returns trace ID of an event context
+func ContextTraceID(context map[string]interface{}) string {
+	traceID, _ := context["trace_id"].(string)
+	return traceID
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extension_test
+
+import (
+	"time"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiling", func() {
+	profilesByName := func() map[string]extension.HandlerProfile {
+		profiles, err := extension.HandlerProfiles(0, "")
+		Expect(err).ToNot(HaveOccurred())
+		byName := map[string]extension.HandlerProfile{}
+		for _, profile := range profiles {
+			byName[profile.Name] = profile
+		}
+		return byName
+	}
+
+	BeforeEach(func() {
+		extension.ClearHandlerProfiles()
+		extension.SetProfiling(true, 0)
+	})
+
+	AfterEach(func() {
+		extension.SetProfiling(false, 0)
+		extension.ClearHandlerProfiles()
+	})
+
+	It("should profile handlers of javascript extensions", func() {
+		env := otto.NewEnvironment("profile_test", testDB1, &middleware.FakeIdentity{}, testSync)
+		ext, err := schema.NewExtension(map[string]interface{}{
+			"id":   "profile_test",
+			"path": ".*",
+			"code": `
+				gohan_register_handler("test_event", function(context) {});
+				gohan_register_handler("test_event", function(context) {
+					throw new CustomException("failed", 400);
+				});`,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.LoadExtensionsForPath([]*schema.Extension{ext}, time.Second, nil, "/profile")).To(Succeed())
+		Expect(extension.EnvironmentType(env)).To(Equal("otto"))
+
+		context := map[string]interface{}{"schema_id": "network", "trace_id": "trace"}
+		Expect(extension.NewEnvironment([]extension.Environment{env}).HandleEvent("test_event", context)).To(Succeed())
+
+		profiles := profilesByName()
+		Expect(profiles).To(HaveLen(2))
+		Expect(profiles).To(HaveKey("profile_test#0"))
+		Expect(profiles["profile_test#0"].Handler).To(Equal(extension.Handler{
+			Environment: "otto",
+			Schema:      "network",
+			Event:       "test_event",
+			Name:        "profile_test#0",
+		}))
+		Expect(profiles["profile_test#0"].Calls).To(BeEquivalentTo(1))
+		Expect(profiles["profile_test#0"].Errors).To(BeZero())
+		Expect(profiles).To(HaveKey("profile_test#1"))
+		Expect(profiles["profile_test#1"].Errors).To(BeEquivalentTo(1))
+	})
+
+	It("should not profile when disabled", func() {
+		extension.SetProfiling(false, 0)
+		extension.ProfileHandler(extension.Handler{Name: "handler"}, time.Now(), "", false)
+		Expect(profilesByName()).To(BeEmpty())
+	})
+
+	It("should return the slowest handlers", func() {
+		now := time.Now()
+		extension.ProfileHandler(extension.Handler{Name: "often"}, now.Add(-300*time.Millisecond), "", false)
+		extension.ProfileHandler(extension.Handler{Name: "often"}, now.Add(-300*time.Millisecond), "", false)
+		extension.ProfileHandler(extension.Handler{Name: "once"}, now.Add(-500*time.Millisecond), "", false)
+		extension.ProfileHandler(extension.Handler{Name: "fast"}, now, "", false)
+
+		names := func(limit int, sortKey string) []string {
+			profiles, err := extension.HandlerProfiles(limit, sortKey)
+			Expect(err).ToNot(HaveOccurred())
+			result := []string{}
+			for _, profile := range profiles {
+				result = append(result, profile.Name)
+			}
+			return result
+		}
+		Expect(names(0, "average")).To(Equal([]string{"once", "often", "fast"}))
+		Expect(names(2, "total")).To(Equal([]string{"often", "once"}))
+		Expect(names(1, "max")).To(Equal([]string{"once"}))
+
+		_, err := extension.HandlerProfiles(1, "unknown")
+		Expect(err).To(HaveOccurred())
+	})
+})
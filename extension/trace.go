@@ -0,0 +1,244 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extension
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/util"
+)
+
+//TraceContextKey is the key of the trace of a request in event contexts
+const TraceContextKey = "extension_trace"
+
+const defaultTraceCapacity = 100
+
+var (
+	tracesLock    sync.Mutex
+	traces        = map[string]*Trace{}
+	tracesOrder   []string
+	traceCapacity = defaultTraceCapacity
+)
+
+//Changes lists top level keys of a map changed by a handler
+type Changes struct {
+	Added    []string `json:"added,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+}
+
+//Empty reports whether nothing has changed
+func (changes Changes) Empty() bool {
+	return len(changes.Added) == 0 && len(changes.Modified) == 0 && len(changes.Removed) == 0
+}
+
+//TracedHandler is a call of a handler recorded in a trace,
+//calls of whole environments have an empty name
+type TracedHandler struct {
+	Handler
+	//Depth is the number of calls the handler was called in
+	Depth    int
+	Started  time.Time
+	Duration time.Duration
+	Error    string
+	Context  Changes
+	Resource Changes
+	Response Changes
+}
+
+//Trace records handlers called for a request
+type Trace struct {
+	ID      string
+	Created time.Time
+
+	mu       sync.Mutex
+	handlers []TracedHandler
+	depth    int
+}
+
+//Span is a call of a handler being recorded
+type Span struct {
+	trace    *Trace
+	index    int
+	context  map[string]interface{}
+	resource map[string]interface{}
+	response map[string]interface{}
+}
+
+//SetupTracing configures how many traces are kept from config
+func SetupTracing(config *util.Config) {
+	SetTraceCapacity(config.GetInt("extension/tracing/capacity", defaultTraceCapacity))
+}
+
+//SetTraceCapacity sets how many latest traces are kept, 0 disables tracing
+func SetTraceCapacity(capacity int) {
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	traceCapacity = capacity
+	evictTraces()
+}
+
+//TracingEnabled reports whether requests can be traced
+func TracingEnabled() bool {
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	return traceCapacity > 0
+}
+
+//NewTrace creates a trace and keeps it until capacity newer traces are created
+func NewTrace(id string) *Trace {
+	trace := &Trace{ID: id, Created: time.Now()}
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	if _, ok := traces[id]; !ok {
+		tracesOrder = append(tracesOrder, id)
+	}
+	traces[id] = trace
+	evictTraces()
+	return trace
+}
+
+func evictTraces() {
+	for len(tracesOrder) > 0 && len(tracesOrder) > traceCapacity {
+		delete(traces, tracesOrder[0])
+		tracesOrder = tracesOrder[1:]
+	}
+}
+
+//GetTrace returns a kept trace
+func GetTrace(id string) (*Trace, bool) {
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	trace, ok := traces[id]
+	return trace, ok
+}
+
+//ContextTrace returns the trace of an event context, nil if the request isn't traced
+func ContextTrace(context map[string]interface{}) *Trace {
+	trace, _ := context[TraceContextKey].(*Trace)
+	return trace
+}
+
+//Handlers returns handlers recorded so far in order of their calls
+func (trace *Trace) Handlers() []TracedHandler {
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	return append([]TracedHandler(nil), trace.handlers...)
+}
+
+//Begin starts recording a call of a handler with the context it's called with,
+//it does nothing on a nil trace
+func (trace *Trace) Begin(handler Handler, context map[string]interface{}) *Span {
+	if trace == nil {
+		return nil
+	}
+	span := &Span{
+		trace:    trace,
+		context:  copyMap(context),
+		resource: copyMap(context["resource"]),
+		response: copyMap(context["response"]),
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	span.index = len(trace.handlers)
+	trace.handlers = append(trace.handlers, TracedHandler{
+		Handler: handler,
+		Depth:   trace.depth,
+		Started: time.Now(),
+	})
+	trace.depth++
+	return span
+}
+
+//End finishes recording a call of a handler, it does nothing on a nil span
+func (span *Span) End(context map[string]interface{}, err error) {
+	if span == nil {
+		return
+	}
+	trace := span.trace
+	contextChanges := diffMaps(span.context, context)
+	resourceChanges := diffMaps(span.resource, copyMap(context["resource"]))
+	responseChanges := diffMaps(span.response, copyMap(context["response"]))
+
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	handler := &trace.handlers[span.index]
+	handler.Duration = time.Since(handler.Started)
+	if err != nil {
+		handler.Error = err.Error()
+	}
+	handler.Context = contextChanges
+	handler.Resource = resourceChanges
+	handler.Response = responseChanges
+	trace.depth--
+}
+
+func copyMap(value interface{}) map[string]interface{} {
+	original, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	result := make(map[string]interface{}, len(original))
+	for key, value := range original {
+		result[key] = value
+	}
+	return result
+}
+
+func diffMaps(before, after map[string]interface{}) Changes {
+	changes := Changes{}
+	for key, value := range after {
+		if key == TraceContextKey {
+			continue
+		}
+		old, ok := before[key]
+		if !ok {
+			changes.Added = append(changes.Added, key)
+		} else if !equalValues(old, value) {
+			changes.Modified = append(changes.Modified, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Modified)
+	sort.Strings(changes.Removed)
+	return changes
+}
+
+// equalValues compares values by their JSON representation when they differ only in types,
+// as environments convert numbers and maps passing them to extensions
+func equalValues(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJSON, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(aJSON) == string(bJSON)
+}
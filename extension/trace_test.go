@@ -0,0 +1,106 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extension_test
+
+import (
+	"time"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracing", func() {
+	AfterEach(func() {
+		extension.SetTraceCapacity(100)
+	})
+
+	It("should record handlers and their changes", func() {
+		env := otto.NewEnvironment("trace_test", testDB1, &middleware.FakeIdentity{}, testSync)
+		ext, err := schema.NewExtension(map[string]interface{}{
+			"id":   "trace_test",
+			"path": ".*",
+			"code": `
+				gohan_register_handler("test_event", function(context) {
+					context.resource.name = "changed";
+					context.resource.description = "added";
+					context.response = {"ok": true};
+				});
+				gohan_register_handler("test_event", function(context) {
+					throw new CustomException("failed", 400);
+				});`,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.LoadExtensionsForPath([]*schema.Extension{ext}, time.Second, nil, "/trace")).To(Succeed())
+
+		trace := extension.NewTrace("trace_test")
+		context := map[string]interface{}{
+			"schema_id":               "network",
+			"resource":                map[string]interface{}{"id": "1", "name": "original"},
+			extension.TraceContextKey: trace,
+		}
+		Expect(extension.NewEnvironment([]extension.Environment{env}).HandleEvent("test_event", context)).To(Succeed())
+
+		stored, ok := extension.GetTrace("trace_test")
+		Expect(ok).To(BeTrue())
+		Expect(stored).To(Equal(trace))
+
+		handlers := trace.Handlers()
+		Expect(handlers).To(HaveLen(3))
+		Expect(handlers[0].Handler).To(Equal(extension.Handler{Environment: "otto", Schema: "network", Event: "test_event"}))
+		Expect(handlers[0].Depth).To(Equal(0))
+		Expect(handlers[0].Context.Added).To(ConsistOf("event_type", "response", "exception", "exception_message"))
+
+		Expect(handlers[1].Name).To(Equal("trace_test#0"))
+		Expect(handlers[1].Depth).To(Equal(1))
+		Expect(handlers[1].Error).To(BeEmpty())
+		Expect(handlers[1].Context.Added).To(ConsistOf("response"))
+		Expect(handlers[1].Resource).To(Equal(extension.Changes{Added: []string{"description"}, Modified: []string{"name"}}))
+		Expect(handlers[1].Response.Added).To(ConsistOf("ok"))
+
+		Expect(handlers[2].Name).To(Equal("trace_test#1"))
+		Expect(handlers[2].Error).To(ContainSubstring("failed"))
+		Expect(handlers[2].Context.Added).To(ConsistOf("exception", "exception_message"))
+		Expect(handlers[2].Resource.Empty()).To(BeTrue())
+	})
+
+	It("should not record requests which aren't traced", func() {
+		var trace *extension.Trace
+		span := trace.Begin(extension.Handler{Name: "handler"}, map[string]interface{}{})
+		Expect(span).To(BeNil())
+		span.End(map[string]interface{}{}, nil)
+	})
+
+	It("should keep only the latest traces", func() {
+		extension.SetTraceCapacity(2)
+		extension.NewTrace("first")
+		extension.NewTrace("second")
+		extension.NewTrace("third")
+		_, ok := extension.GetTrace("first")
+		Expect(ok).To(BeFalse())
+		_, ok = extension.GetTrace("third")
+		Expect(ok).To(BeTrue())
+		Expect(extension.TracingEnabled()).To(BeTrue())
+
+		extension.SetTraceCapacity(0)
+		Expect(extension.TracingEnabled()).To(BeFalse())
+		_, ok = extension.GetTrace("third")
+		Expect(ok).To(BeFalse())
+	})
+})
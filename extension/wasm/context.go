@@ -0,0 +1,132 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasm
+
+import (
+	"bytes"
+	"encoding/json"
+
+	ext "github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/schema"
+)
+
+// goContextKeys hold Go objects which stay in the context,
+// the transaction and auth are passed to extensions as a handle and a summary
+var goContextKeys = map[string]bool{
+	"transaction":       true,
+	"auth":              true,
+	"context":           true,
+	"http_request":      true,
+	"http_response":     true,
+	"sync":              true,
+	"db":                true,
+	"identity_service":  true,
+	"schema":            true,
+	"policy":            true,
+	"role":              true,
+	"catalog":           true,
+	ext.TraceContextKey: true,
+}
+
+// marshalContext serializes the context passed to extensions, values which aren't serializable are skipped
+func (extension *extension) marshalContext(context map[string]interface{}) map[string]json.RawMessage {
+	result := map[string]json.RawMessage{}
+	for key, value := range context {
+		if goContextKeys[key] {
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			log.Debug("Skipping context key %s of type %T: %s", key, value, err)
+			continue
+		}
+		result[key] = data
+	}
+	for handle, tx := range extension.transactions {
+		if tx == context["transaction"] {
+			result["transaction"], _ = json.Marshal(handle)
+		}
+	}
+	if auth, ok := context["auth"].(schema.Authorization); ok {
+		result["auth"], _ = json.Marshal(authInfo(auth))
+	}
+	return result
+}
+
+func authInfo(auth schema.Authorization) map[string]interface{} {
+	roles := []string{}
+	for _, role := range auth.Roles() {
+		roles = append(roles, role.Name)
+	}
+	return map[string]interface{}{
+		"tenant_id":   auth.TenantID(),
+		"tenant_name": auth.TenantName(),
+		"domain_id":   auth.DomainID(),
+		"domain_name": auth.DomainName(),
+		"roles":       roles,
+		"is_admin":    auth.IsAdmin(),
+	}
+}
+
+// mergeContext applies changes made by an extension to the context,
+// keys which were passed to the extension and are missing in its response are removed.
+// Values which weren't changed are kept as they are, with their Go types.
+func mergeContext(context map[string]interface{}, passed, changed map[string]json.RawMessage) error {
+	for key := range passed {
+		if _, ok := changed[key]; !ok && !goContextKeys[key] {
+			delete(context, key)
+		}
+	}
+	for key, data := range changed {
+		if goContextKeys[key] || bytes.Equal(data, passed[key]) {
+			continue
+		}
+		var value interface{}
+		if err := decodeJSON(data, &value); err != nil {
+			return err
+		}
+		context[key] = convertNumbers(value)
+	}
+	return nil
+}
+
+// decodeJSON decodes data keeping numbers as json.Number, values have to be converted by convertNumbers
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// convertNumbers converts integer numbers to int64 and others to float64
+func convertNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = convertNumbers(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,24 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//Package guest is the SDK of WebAssembly extensions run by the wasm environment.
+//
+//Extensions are main packages built as WASI reactors:
+//
+//    GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o extension.wasm .
+//
+//Handlers are registered by init functions of the extension with RegisterEventHandler,
+//the main function isn't called. The package is available only for the wasip1 target.
+package guest
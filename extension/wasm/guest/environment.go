@@ -0,0 +1,359 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build wasip1
+// +build wasip1
+
+package guest
+
+import (
+	"fmt"
+)
+
+//Environment gives access to modules of the server, same as goext.IEnvironment
+type Environment struct {
+	event   string
+	context Context
+}
+
+//Event returns the handled event
+func (env *Environment) Event() string {
+	return env.event
+}
+
+//Logger returns the logger of the extension
+func (env *Environment) Logger() *Logger {
+	return &Logger{}
+}
+
+//Database returns the database module
+func (env *Environment) Database() *Database {
+	return &Database{}
+}
+
+//Schemas returns the schemas module
+func (env *Environment) Schemas() *Schemas {
+	return &Schemas{}
+}
+
+//Sync returns the sync module
+func (env *Environment) Sync() *Sync {
+	return &Sync{}
+}
+
+//HTTP returns the HTTP module
+func (env *Environment) HTTP() *HTTP {
+	return &HTTP{}
+}
+
+//Auth returns the auth module
+func (env *Environment) Auth() *Auth {
+	return &Auth{}
+}
+
+//Logger writes to the log of the server
+type Logger struct {
+	//Module is the name of the log module, the name of the extension is used if it's empty
+	Module string
+}
+
+func (logger *Logger) log(level, message string) {
+	call("logger.log", map[string]interface{}{"module": logger.Module, "level": level, "message": message})
+}
+
+//Critical logs a message with critical level
+func (logger *Logger) Critical(message string) { logger.log("CRITICAL", message) }
+
+//Criticalf logs a formatted message with critical level
+func (logger *Logger) Criticalf(format string, args ...interface{}) {
+	logger.log("CRITICAL", fmt.Sprintf(format, args...))
+}
+
+//Error logs a message with error level
+func (logger *Logger) Error(message string) { logger.log("ERROR", message) }
+
+//Errorf logs a formatted message with error level
+func (logger *Logger) Errorf(format string, args ...interface{}) {
+	logger.log("ERROR", fmt.Sprintf(format, args...))
+}
+
+//Warning logs a message with warning level
+func (logger *Logger) Warning(message string) { logger.log("WARNING", message) }
+
+//Warningf logs a formatted message with warning level
+func (logger *Logger) Warningf(format string, args ...interface{}) {
+	logger.log("WARNING", fmt.Sprintf(format, args...))
+}
+
+//Notice logs a message with notice level
+func (logger *Logger) Notice(message string) { logger.log("NOTICE", message) }
+
+//Noticef logs a formatted message with notice level
+func (logger *Logger) Noticef(format string, args ...interface{}) {
+	logger.log("NOTICE", fmt.Sprintf(format, args...))
+}
+
+//Info logs a message with info level
+func (logger *Logger) Info(message string) { logger.log("INFO", message) }
+
+//Infof logs a formatted message with info level
+func (logger *Logger) Infof(format string, args ...interface{}) {
+	logger.log("INFO", fmt.Sprintf(format, args...))
+}
+
+//Debug logs a message with debug level
+func (logger *Logger) Debug(message string) { logger.log("DEBUG", message) }
+
+//Debugf logs a formatted message with debug level
+func (logger *Logger) Debugf(format string, args ...interface{}) {
+	logger.log("DEBUG", fmt.Sprintf(format, args...))
+}
+
+//Database begins transactions
+type Database struct{}
+
+//Begin begins a transaction, it's closed after the event if it isn't closed by the extension
+func (db *Database) Begin() (*Transaction, error) {
+	return db.BeginWithIsolationLevel("")
+}
+
+//BeginWithIsolationLevel begins a transaction with the isolation level
+func (db *Database) BeginWithIsolationLevel(level string) (*Transaction, error) {
+	var handle int64
+	if _, err := call("database.begin", map[string]interface{}{"isolation_level": level}, &handle); err != nil {
+		return nil, err
+	}
+	return &Transaction{handle: handle}, nil
+}
+
+//Transaction is a database transaction
+type Transaction struct {
+	handle int64
+}
+
+//Transaction returns the transaction of the context, nil if there is none
+func (context Context) Transaction() *Transaction {
+	handle, ok := context["transaction"].(float64)
+	if !ok {
+		return nil
+	}
+	return &Transaction{handle: int64(handle)}
+}
+
+//ListOptions are optional parameters of List
+type ListOptions struct {
+	OrderKey string
+	Limit    uint64
+	Offset   uint64
+}
+
+//Commit commits the transaction
+func (tx *Transaction) Commit() error {
+	_, err := call("tx.commit", map[string]interface{}{"transaction": tx.handle})
+	return err
+}
+
+//Close closes the transaction
+func (tx *Transaction) Close() error {
+	_, err := call("tx.close", map[string]interface{}{"transaction": tx.handle})
+	return err
+}
+
+//List lists resources matching the filter
+func (tx *Transaction) List(schemaID string, filter map[string]interface{}, options *ListOptions) ([]map[string]interface{}, error) {
+	params := map[string]interface{}{"transaction": tx.handle, "schema_id": schemaID, "filter": filter}
+	if options != nil {
+		params["order_key"] = options.OrderKey
+		params["limit"] = options.Limit
+		params["offset"] = options.Offset
+	}
+	result := []map[string]interface{}{}
+	_, err := call("tx.list", params, &result)
+	return result, err
+}
+
+//Fetch fetches a resource by ID, tenantID is optional
+func (tx *Transaction) Fetch(schemaID, id, tenantID string) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	_, err := call("tx.fetch", map[string]interface{}{
+		"transaction": tx.handle, "schema_id": schemaID, "id": id, "tenant_id": tenantID,
+	}, &result)
+	return result, err
+}
+
+//Create creates a resource, default values are set by the server
+func (tx *Transaction) Create(schemaID string, resource map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	_, err := call("tx.create", map[string]interface{}{
+		"transaction": tx.handle, "schema_id": schemaID, "resource": resource,
+	}, &result)
+	return result, err
+}
+
+//Update updates a resource
+func (tx *Transaction) Update(schemaID string, resource map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	_, err := call("tx.update", map[string]interface{}{
+		"transaction": tx.handle, "schema_id": schemaID, "resource": resource,
+	}, &result)
+	return result, err
+}
+
+//Delete deletes a resource by ID
+func (tx *Transaction) Delete(schemaID, id string) error {
+	_, err := call("tx.delete", map[string]interface{}{"transaction": tx.handle, "schema_id": schemaID, "id": id})
+	return err
+}
+
+//Query runs an SQL query returning resources of the schema
+func (tx *Transaction) Query(schemaID, query string, arguments ...interface{}) ([]map[string]interface{}, error) {
+	if arguments == nil {
+		arguments = []interface{}{}
+	}
+	result := []map[string]interface{}{}
+	_, err := call("tx.query", map[string]interface{}{
+		"transaction": tx.handle, "schema_id": schemaID, "query": query, "arguments": arguments,
+	}, &result)
+	return result, err
+}
+
+//Schema describes a schema loaded by the server
+type Schema struct {
+	ID          string                 `json:"id"`
+	Singular    string                 `json:"singular"`
+	Plural      string                 `json:"plural"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Parent      string                 `json:"parent"`
+	Prefix      string                 `json:"prefix"`
+	URL         string                 `json:"url"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+//Schemas gives access to schemas
+type Schemas struct{}
+
+//List returns all schemas
+func (schemas *Schemas) List() ([]*Schema, error) {
+	result := []*Schema{}
+	_, err := call("schemas.list", map[string]interface{}{}, &result)
+	return result, err
+}
+
+//Find returns a schema by ID
+func (schemas *Schemas) Find(id string) (*Schema, error) {
+	result := &Schema{}
+	if _, err := call("schemas.get", map[string]interface{}{"id": id}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//Node is a value stored in sync
+type Node struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Revision int64  `json:"revision"`
+}
+
+//Sync gives access to the sync backend
+type Sync struct{}
+
+//Fetch fetches a key
+func (sync *Sync) Fetch(key string) (*Node, error) {
+	result := &Node{}
+	if _, err := call("sync.fetch", map[string]interface{}{"key": key}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//Update sets a value of the key
+func (sync *Sync) Update(key, value string) error {
+	_, err := call("sync.update", map[string]interface{}{"key": key, "value": value})
+	return err
+}
+
+//Delete deletes a key, with prefix all keys under the key are deleted
+func (sync *Sync) Delete(key string, prefix bool) error {
+	_, err := call("sync.delete", map[string]interface{}{"key": key, "prefix": prefix})
+	return err
+}
+
+//Response is a response of an HTTP request
+type Response struct {
+	StatusCode int                 `json:"status_code"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+}
+
+//HTTP makes HTTP requests from the server
+type HTTP struct{}
+
+//Request performs an HTTP request, body is encoded as JSON unless content-type is text/plain.
+//With opaque the URL isn't escaped.
+func (http *HTTP) Request(method, url string, headers map[string]interface{}, body interface{}, opaque bool) (*Response, error) {
+	result := &Response{}
+	if _, err := call("http.request", map[string]interface{}{
+		"method": method, "url": url, "headers": headers, "body": body, "opaque": opaque,
+	}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//Auth reads authorization of the request from the context
+type Auth struct{}
+
+func authInfo(context Context) map[string]interface{} {
+	auth, _ := context["auth"].(map[string]interface{})
+	return auth
+}
+
+//HasRole reports whether context has given role
+func (auth *Auth) HasRole(context Context, role string) bool {
+	roles, _ := authInfo(context)["roles"].([]interface{})
+	for _, name := range roles {
+		if name == role {
+			return true
+		}
+	}
+	return false
+}
+
+//GetTenantName returns name of the tenant from the given context
+func (auth *Auth) GetTenantName(context Context) string {
+	name, _ := authInfo(context)["tenant_name"].(string)
+	return name
+}
+
+//GetTenantID returns id of the tenant from the given context
+func (auth *Auth) GetTenantID(context Context) string {
+	id, _ := authInfo(context)["tenant_id"].(string)
+	return id
+}
+
+//GetDomainID returns id of the domain from the given context
+func (auth *Auth) GetDomainID(context Context) string {
+	id, _ := authInfo(context)["domain_id"].(string)
+	return id
+}
+
+//IsAdmin reports whether context belongs to admin
+func (auth *Auth) IsAdmin(context Context) bool {
+	isAdmin, _ := authInfo(context)["is_admin"].(bool)
+	return isAdmin
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build wasip1
+// +build wasip1
+
+package guest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+//go:wasmimport gohan call
+func hostCall(method unsafe.Pointer, methodLen uint32, params unsafe.Pointer, paramsLen uint32) uint32
+
+//go:wasmimport gohan result
+func hostResult(result unsafe.Pointer)
+
+var (
+	// input is the buffer for the handled event, allocated by the host with gohan_alloc
+	input []byte
+	// output is kept until the host reads it
+	output []byte
+
+	handlers = map[string][]Handler{}
+)
+
+//Context is a context of the handled event.
+//Values are decoded from JSON, numbers are float64.
+type Context map[string]interface{}
+
+//Handler handles an event
+type Handler func(env *Environment, context Context) error
+
+//Error makes the server respond with the code and message, same as CustomException in javascript
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+//RegisterEventHandler registers a handler of the event, "*" handles all events.
+//Handlers are called in order of registration. It must be called from init functions.
+func RegisterEventHandler(event string, handler Handler) {
+	if _, err := call("handlers.register", map[string]interface{}{"events": []string{event}}); err != nil {
+		panic(err)
+	}
+	handlers[event] = append(handlers[event], handler)
+}
+
+//go:wasmexport gohan_alloc
+func gohanAlloc(size uint32) unsafe.Pointer {
+	input = make([]byte, size)
+	if size == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&input[0])
+}
+
+type eventInput struct {
+	Event   string  `json:"event"`
+	Context Context `json:"context"`
+}
+
+type eventOutput struct {
+	Context Context `json:"context"`
+	Error   string  `json:"error,omitempty"`
+}
+
+//go:wasmexport gohan_handle_event
+func gohanHandleEvent(size uint32) uint64 {
+	result := &eventOutput{}
+	event := &eventInput{}
+	if err := json.Unmarshal(input[:size], event); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Context = event.Context
+		if err := handleEvent(event.Event, event.Context); err != nil {
+			result.Error = err.Error()
+		}
+	}
+	input = nil
+
+	var err error
+	output, err = json.Marshal(result)
+	if err != nil {
+		output, _ = json.Marshal(&eventOutput{Context: event.Context, Error: err.Error()})
+	}
+	return uint64(uintptr(unsafe.Pointer(&output[0])))<<32 | uint64(len(output))
+}
+
+func handleEvent(event string, context Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	env := &Environment{event: event, context: context}
+	for _, key := range []string{event, "*"} {
+		for _, handler := range handlers[key] {
+			if err := handler(env, context); err != nil {
+				if customError, ok := err.(*Error); ok {
+					context["exception"] = map[string]interface{}{
+						"name":    "CustomException",
+						"message": customError.Message,
+						"code":    customError.Code,
+					}
+					context["exception_message"] = event + ": " + customError.Message
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type hostResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// call calls the host method, the result is decoded to result if it's given
+func call(method string, params interface{}, result ...interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	name := []byte(method)
+	size := hostCall(unsafe.Pointer(&name[0]), uint32(len(name)), unsafe.Pointer(&data[0]), uint32(len(data)))
+	buffer := make([]byte, size)
+	hostResult(unsafe.Pointer(&buffer[0]))
+
+	response := &hostResponse{}
+	if err := json.Unmarshal(buffer, response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	if len(result) > 0 && len(response.Result) > 0 && !bytes.Equal(response.Result, []byte("null")) {
+		if err := json.Unmarshal(response.Result, result[0]); err != nil {
+			return nil, err
+		}
+	}
+	return response.Result, nil
+}
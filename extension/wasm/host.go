@@ -0,0 +1,460 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/httpclient"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/secret"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// The host ABI of the "gohan" module mirrors modules of goext.IEnvironment.
+// Extensions call host methods by name with JSON encoded parameters:
+//
+//   call(method_ptr, method_len, params_ptr, params_len i32) i32
+//
+// returns the length of a JSON encoded response {"result": ..., "error": "..."}
+// which is copied to the memory of the extension by:
+//
+//   result(ptr i32)
+const gohanModule = "gohan"
+
+type hostMethod func(extension *extension, params *hostParams) (interface{}, error)
+
+// hostParams are parameters of all host methods, each method uses a part of them
+type hostParams struct {
+	Events         []string               `json:"events"`
+	Module         string                 `json:"module"`
+	Level          string                 `json:"level"`
+	Message        string                 `json:"message"`
+	IsolationLevel string                 `json:"isolation_level"`
+	Transaction    int64                  `json:"transaction"`
+	SchemaID       string                 `json:"schema_id"`
+	ID             string                 `json:"id"`
+	TenantID       string                 `json:"tenant_id"`
+	Filter         map[string]interface{} `json:"filter"`
+	OrderKey       string                 `json:"order_key"`
+	Limit          uint64                 `json:"limit"`
+	Offset         uint64                 `json:"offset"`
+	Resource       map[string]interface{} `json:"resource"`
+	Query          string                 `json:"query"`
+	Arguments      []interface{}          `json:"arguments"`
+	Key            string                 `json:"key"`
+	Value          string                 `json:"value"`
+	Prefix         bool                   `json:"prefix"`
+	Method         string                 `json:"method"`
+	URL            string                 `json:"url"`
+	Headers        map[string]interface{} `json:"headers"`
+	Body           interface{}            `json:"body"`
+	Opaque         bool                   `json:"opaque"`
+}
+
+type hostResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+var hostMethods = map[string]hostMethod{
+	"handlers.register": registerHandlers,
+	"logger.log":        logMessage,
+	"database.begin":    beginTransaction,
+	"tx.commit":         commitTransaction,
+	"tx.close":          closeTransaction,
+	"tx.list":           listResources,
+	"tx.fetch":          fetchResource,
+	"tx.create":         createResource,
+	"tx.update":         updateResource,
+	"tx.delete":         deleteResource,
+	"tx.query":          queryResources,
+	"schemas.list":      listSchemas,
+	"schemas.get":       getSchema,
+	"sync.fetch":        syncFetch,
+	"sync.update":       syncUpdate,
+	"sync.delete":       syncDelete,
+	"http.request":      httpRequest,
+}
+
+var gohanFunctions = map[string]*hostFunction{
+	"call":   function(types(i32, i32, i32, i32), types(i32), hostCall),
+	"result": function(types(i32), nil, hostResult),
+}
+
+// extensionKey is the key of the extension in the context of calls to its module
+type extensionKey struct{}
+
+// callContext returns the context of calls to the module of the extension,
+// canceling it interrupts the module
+func (extension *extension) callContext() (context.Context, context.CancelFunc) {
+	return context.WithCancel(context.WithValue(context.Background(), extensionKey{}, extension))
+}
+
+func extensionFrom(ctx context.Context) *extension {
+	return ctx.Value(extensionKey{}).(*extension)
+}
+
+// instantiateHostModules instantiates modules imported by the module in the runtime,
+// WASI functions not needed by the Go runtime fail with ENOSYS
+func instantiateHostModules(ctx context.Context, runtime wazero.Runtime, module wazero.CompiledModule) error {
+	wasi := runtime.NewHostModuleBuilder(wasiModule)
+	for name, function := range wasiFunctions {
+		function.export(wasi, name)
+	}
+	for _, definition := range module.ImportedFunctions() {
+		moduleName, name, _ := definition.Import()
+		if moduleName != wasiModule {
+			continue
+		}
+		if _, ok := wasiFunctions[name]; !ok {
+			wasiUnsupported(definition).export(wasi, name)
+		}
+	}
+	if _, err := wasi.Instantiate(ctx); err != nil {
+		return err
+	}
+	gohan := runtime.NewHostModuleBuilder(gohanModule)
+	for name, function := range gohanFunctions {
+		function.export(gohan, name)
+	}
+	_, err := gohan.Instantiate(ctx)
+	return err
+}
+
+func hostCall(_ context.Context, extension *extension, memory api.Memory, args []uint64) []uint64 {
+	response := &hostResponse{}
+	result, err := extension.callHost(memory, args)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Result = result
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		data, _ = json.Marshal(&hostResponse{Error: err.Error()})
+	}
+	extension.result = data
+	return []uint64{uint64(len(data))}
+}
+
+func readMemory(memory api.Memory, offset, size uint32) ([]byte, error) {
+	data, ok := memory.Read(offset, size)
+	if !ok {
+		return nil, fmt.Errorf("memory range %d+%d out of bounds", offset, size)
+	}
+	return data, nil
+}
+
+func (extension *extension) callHost(memory api.Memory, args []uint64) (interface{}, error) {
+	name, err := readMemory(memory, uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return nil, err
+	}
+	method, ok := hostMethods[string(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown host method %s", name)
+	}
+	data, err := readMemory(memory, uint32(args[2]), uint32(args[3]))
+	if err != nil {
+		return nil, err
+	}
+	params := &hostParams{}
+	if err := decodeJSON(data, params); err != nil {
+		return nil, fmt.Errorf("invalid parameters of %s: %s", name, err)
+	}
+	convertNumbers(params.Filter)
+	convertNumbers(params.Resource)
+	convertNumbers(params.Arguments)
+	convertNumbers(params.Headers)
+	params.Body = convertNumbers(params.Body)
+	// handlers are registered by init functions, other methods need the handled event
+	initializing := extension.initializing
+	if initializing != (string(name) == "handlers.register") && string(name) != "logger.log" {
+		if initializing {
+			return nil, fmt.Errorf("%s can't be called during initialization", name)
+		}
+		return nil, fmt.Errorf("%s can be called only during initialization", name)
+	}
+	return method(extension, params)
+}
+
+func hostResult(_ context.Context, extension *extension, memory api.Memory, args []uint64) []uint64 {
+	if !memory.Write(uint32(args[0]), extension.result) {
+		// traps the module, it's instantiated again for the next event
+		panic(fmt.Errorf("memory range %d+%d out of bounds", uint32(args[0]), len(extension.result)))
+	}
+	extension.result = nil
+	return nil
+}
+
+func (extension *extension) ctx() context.Context {
+	if ctx, ok := extension.context["context"].(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+func (extension *extension) transaction(handle int64) (transaction.Transaction, error) {
+	tx, ok := extension.transactions[handle]
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction %d", handle)
+	}
+	return tx, nil
+}
+
+func registerHandlers(extension *extension, params *hostParams) (interface{}, error) {
+	// handlers are known after the program is initialized, instances of extensions register them again
+	if extension.env != nil {
+		return nil, nil
+	}
+	for _, event := range params.Events {
+		extension.program.handlers[event] = true
+	}
+	return nil, nil
+}
+
+func logMessage(extension *extension, params *hostParams) (interface{}, error) {
+	logger := extension.logger
+	if params.Module != "" {
+		logger = l.NewLogger(l.ModuleName(params.Module))
+	}
+	message := secret.Redact(params.Message)
+	switch strings.ToUpper(params.Level) {
+	case "CRITICAL":
+		logger.Critical("%s", message)
+	case "ERROR":
+		logger.Error("%s", message)
+	case "WARNING":
+		logger.Warning("%s", message)
+	case "NOTICE":
+		logger.Notice("%s", message)
+	case "INFO":
+		logger.Info("%s", message)
+	case "DEBUG":
+		logger.Debug("%s", message)
+	default:
+		return nil, fmt.Errorf("unknown log level %s", params.Level)
+	}
+	return nil, nil
+}
+
+func beginTransaction(extension *extension, params *hostParams) (interface{}, error) {
+	opts := []transaction.Option{}
+	if params.IsolationLevel != "" {
+		opts = append(opts, transaction.IsolationLevel(transaction.Type(params.IsolationLevel)))
+	}
+	tx, err := extension.env.DataStore.BeginTx(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start a transaction: %s", err)
+	}
+	extension.opened = append(extension.opened, tx)
+	return extension.addTransaction(tx), nil
+}
+
+func commitTransaction(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+func closeTransaction(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	return nil, tx.Close()
+}
+
+func listResources(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := findSchema(params.SchemaID)
+	if err != nil {
+		return nil, err
+	}
+	opts := []pagination.OptionPaginator{}
+	if params.OrderKey != "" {
+		opts = append(opts, pagination.OptionKey(schema, params.OrderKey))
+	}
+	if params.Limit > 0 {
+		opts = append(opts, pagination.OptionLimit(params.Limit))
+	}
+	if params.Offset > 0 {
+		opts = append(opts, pagination.OptionOffset(params.Offset))
+	}
+	opts = append(opts, pagination.OptionOrder(pagination.ASC))
+	pg, err := pagination.NewPaginator(opts...)
+	if err != nil {
+		return nil, err
+	}
+	resources, _, err := tx.List(extension.ctx(), schema, params.Filter, nil, pg)
+	if err != nil {
+		return nil, fmt.Errorf("Error during list: %s", err)
+	}
+	result := make([]map[string]interface{}, len(resources))
+	for i, resource := range resources {
+		result[i] = resource.Data()
+	}
+	return result, nil
+}
+
+func fetchResource(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	resource, err := otto.GohanDbFetch(tx, params.SchemaID, params.ID, params.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Data(), nil
+}
+
+func createResource(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	resource, err := otto.GohanDbCreate(tx, false, params.SchemaID, params.Resource)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Data(), nil
+}
+
+func updateResource(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	resource, err := otto.GohanDbUpdate(tx, false, params.SchemaID, params.Resource)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Data(), nil
+}
+
+func deleteResource(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	return nil, otto.GohanDbDelete(tx, false, params.SchemaID, params.ID)
+}
+
+func queryResources(extension *extension, params *hostParams) (interface{}, error) {
+	tx, err := extension.transaction(params.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	return otto.GohanDbQuery(tx, false, params.SchemaID, params.Query, params.Arguments)
+}
+
+func findSchema(schemaID string) (*schema.Schema, error) {
+	schema, ok := schema.GetManager().Schema(schemaID)
+	if !ok {
+		return nil, fmt.Errorf("Unknown schema '%s'", schemaID)
+	}
+	return schema, nil
+}
+
+func schemaInfo(schema *schema.Schema) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          schema.ID,
+		"singular":    schema.Singular,
+		"plural":      schema.Plural,
+		"title":       schema.Title,
+		"description": schema.Description,
+		"parent":      schema.Parent,
+		"prefix":      schema.Prefix,
+		"url":         schema.URL,
+		"metadata":    schema.Metadata,
+		"schema":      schema.JSONSchema,
+	}
+}
+
+func listSchemas(extension *extension, params *hostParams) (interface{}, error) {
+	result := []map[string]interface{}{}
+	for _, schema := range schema.GetManager().OrderedSchemas() {
+		result = append(result, schemaInfo(schema))
+	}
+	return result, nil
+}
+
+func getSchema(extension *extension, params *hostParams) (interface{}, error) {
+	schema, err := findSchema(params.ID)
+	if err != nil {
+		return nil, err
+	}
+	return schemaInfo(schema), nil
+}
+
+var errSyncUnavailable = errors.New("sync is not available")
+
+func syncFetch(extension *extension, params *hostParams) (interface{}, error) {
+	if extension.env.Sync == nil {
+		return nil, errSyncUnavailable
+	}
+	node, err := extension.env.Sync.Fetch(extension.ctx(), params.Key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"key":      node.Key,
+		"value":    node.Value,
+		"revision": node.Revision,
+	}, nil
+}
+
+func syncUpdate(extension *extension, params *hostParams) (interface{}, error) {
+	if extension.env.Sync == nil {
+		return nil, errSyncUnavailable
+	}
+	return nil, extension.env.Sync.Update(extension.ctx(), params.Key, params.Value)
+}
+
+func syncDelete(extension *extension, params *hostParams) (interface{}, error) {
+	if extension.env.Sync == nil {
+		return nil, errSyncUnavailable
+	}
+	return nil, extension.env.Sync.Delete(extension.ctx(), params.Key, params.Prefix)
+}
+
+func httpRequest(extension *extension, params *hostParams) (interface{}, error) {
+	code, headers, body, err := otto.GohanHTTP(extension.ctx(), params.Method, params.URL, params.Headers, params.Body, params.Opaque, httpclient.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"status_code": code,
+		"headers":     headers,
+		"body":        body,
+	}, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasm
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tetratelabs/wazero"
+)
+
+var _ = Describe("Program cache", func() {
+	const source = "program_test.wasm"
+
+	newProgram := func() *program {
+		return &program{source: source, runtime: wazero.NewRuntime(context.Background())}
+	}
+
+	cache := func(program *program) {
+		programsLock.Lock()
+		defer programsLock.Unlock()
+		program.refs++
+		cacheProgram(program)
+	}
+
+	AfterEach(func() {
+		programsLock.Lock()
+		defer programsLock.Unlock()
+		if cached, ok := programs[source]; ok {
+			cached.close()
+			delete(programs, source)
+		}
+	})
+
+	It("should close a replaced program without extensions", func() {
+		replaced := newProgram()
+		cache(replaced)
+		replaced.release()
+		Expect(replaced.runtime).NotTo(BeNil())
+
+		cache(newProgram())
+		Expect(replaced.runtime).To(BeNil())
+	})
+
+	It("should close a replaced program once its extensions are released", func() {
+		replaced := newProgram()
+		cache(replaced)
+		env := &Environment{Name: "program_test"}
+		env.extensions = append(env.extensions, env.newExtension(replaced))
+		clone := env.Clone().(*Environment)
+
+		cache(newProgram())
+		env.Stop()
+		Expect(replaced.runtime).NotTo(BeNil())
+		clone.Stop()
+		Expect(replaced.runtime).To(BeNil())
+	})
+
+	It("should keep the cached program when its extensions are released", func() {
+		cached := newProgram()
+		cache(cached)
+		env := &Environment{Name: "program_test"}
+		env.extensions = append(env.extensions, env.newExtension(cached))
+		env.Clone().(*Environment).Stop()
+		env.Stop()
+		Expect(cached.runtime).NotTo(BeNil())
+		Expect(cached.refs).To(BeZero())
+	})
+})
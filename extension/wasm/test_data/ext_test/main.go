@@ -0,0 +1,160 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build wasip1
+// +build wasip1
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/wasm/guest"
+)
+
+var calls int
+
+func init() {
+	guest.RegisterEventHandler("echo", func(env *guest.Environment, context guest.Context) error {
+		calls++
+		context["echo"] = context["input"]
+		context["calls"] = calls
+		context["event"] = env.Event()
+		delete(context, "removed")
+		return nil
+	})
+	guest.RegisterEventHandler("fail", func(env *guest.Environment, context guest.Context) error {
+		return errors.New("failed on purpose")
+	})
+	guest.RegisterEventHandler("exception", func(env *guest.Environment, context guest.Context) error {
+		return &guest.Error{Code: 409, Message: "conflict"}
+	})
+	guest.RegisterEventHandler("panic", func(env *guest.Environment, context guest.Context) error {
+		panic("panicked on purpose")
+	})
+	guest.RegisterEventHandler("loop", func(env *guest.Environment, context guest.Context) error {
+		for {
+			calls++
+		}
+	})
+	guest.RegisterEventHandler("sleep", func(env *guest.Environment, context guest.Context) error {
+		time.Sleep(time.Hour)
+		return nil
+	})
+	guest.RegisterEventHandler("log", func(env *guest.Environment, context guest.Context) error {
+		env.Logger().Infof("logged from %s", env.Event())
+		if message, ok := context["message"].(string); ok {
+			env.Logger().Info(message)
+		}
+		fmt.Println("printed from", env.Event())
+		return nil
+	})
+	guest.RegisterEventHandler("auth", func(env *guest.Environment, context guest.Context) error {
+		auth := env.Auth()
+		context["tenant_id"] = auth.GetTenantID(context)
+		context["tenant_name"] = auth.GetTenantName(context)
+		context["is_admin"] = auth.IsAdmin(context)
+		context["has_role"] = auth.HasRole(context, "admin")
+		return nil
+	})
+	guest.RegisterEventHandler("schemas", func(env *guest.Environment, context guest.Context) error {
+		schema, err := env.Schemas().Find("network")
+		if err != nil {
+			return err
+		}
+		context["plural"] = schema.Plural
+		schemas, err := env.Schemas().List()
+		if err != nil {
+			return err
+		}
+		context["schemas"] = len(schemas)
+		_, err = env.Schemas().Find("unknown")
+		context["unknown_error"] = err.Error()
+		return nil
+	})
+	guest.RegisterEventHandler("sync", func(env *guest.Environment, context guest.Context) error {
+		sync := env.Sync()
+		key := context["key"].(string)
+		if err := sync.Update(key, `{"value": 1}`); err != nil {
+			return err
+		}
+		node, err := sync.Fetch(key)
+		if err != nil {
+			return err
+		}
+		context["value"] = node.Value
+		return sync.Delete(key, false)
+	})
+	guest.RegisterEventHandler("db", func(env *guest.Environment, context guest.Context) error {
+		tx := context.Transaction()
+		if tx == nil {
+			return errors.New("no transaction in context")
+		}
+		return useDatabase(tx, context)
+	})
+	guest.RegisterEventHandler("db_begin", func(env *guest.Environment, context guest.Context) error {
+		tx, err := env.Database().Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Close()
+		if err := useDatabase(tx, context); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	guest.RegisterEventHandler("http", func(env *guest.Environment, context guest.Context) error {
+		response, err := env.HTTP().Request("GET", context["url"].(string), nil, nil, false)
+		if err != nil {
+			return err
+		}
+		context["status_code"] = response.StatusCode
+		context["body"] = response.Body
+		return nil
+	})
+}
+
+func useDatabase(tx *guest.Transaction, context guest.Context) error {
+	id := context["id"].(string)
+	if _, err := tx.Create("network", map[string]interface{}{
+		"id":                id,
+		"name":              "name",
+		"description":       "description",
+		"providor_networks": map[string]interface{}{},
+		"route_targets":     []interface{}{},
+		"shared":            false,
+		"tenant_id":         "admin",
+	}); err != nil {
+		return err
+	}
+	if _, err := tx.Update("network", map[string]interface{}{"id": id, "name": "name_updated", "tenant_id": "admin"}); err != nil {
+		return err
+	}
+	network, err := tx.Fetch("network", id, "admin")
+	if err != nil {
+		return err
+	}
+	context["network"] = network
+	networks, err := tx.List("network", map[string]interface{}{"id": id}, nil)
+	if err != nil {
+		return err
+	}
+	context["networks"] = networks
+	return nil
+}
+
+func main() {}
@@ -0,0 +1,289 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// A minimal WASI preview 1 implementation needed by the Go runtime.
+// Extensions have no access to files, arguments and environment variables,
+// standard output and error are written to the log.
+
+const wasiModule = "wasi_snapshot_preview1"
+
+// WASI errno values
+const (
+	errnoSuccess = 0
+	errnoBadf    = 8
+	errnoFault   = 21
+	errnoInval   = 28
+	errnoNosys   = 52
+	errnoNotsup  = 58
+)
+
+const (
+	clockRealtime  = 0
+	clockMonotonic = 1
+
+	eventTypeClock           = 0
+	subscriptionSize         = 48
+	eventSize                = 32
+	subscriptionClockAbstime = 1
+)
+
+var processStart = time.Now()
+
+var (
+	i32 = api.ValueTypeI32
+	i64 = api.ValueTypeI64
+)
+
+// hostFunction is a function of a host module, extensions calling it are taken from the context of the call
+type hostFunction struct {
+	params, results []api.ValueType
+	call            func(ctx context.Context, extension *extension, memory api.Memory, args []uint64) []uint64
+}
+
+func function(params []api.ValueType, results []api.ValueType,
+	call func(ctx context.Context, extension *extension, memory api.Memory, args []uint64) []uint64) *hostFunction {
+	return &hostFunction{params: params, results: results, call: call}
+}
+
+func (function *hostFunction) export(builder wazero.HostModuleBuilder, name string) {
+	builder.NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, module api.Module, stack []uint64) {
+			copy(stack, function.call(ctx, extensionFrom(ctx), module.Memory(), stack))
+		}), function.params, function.results).
+		Export(name)
+}
+
+func errno(value uint64) []uint64 {
+	return []uint64{value}
+}
+
+func types(values ...api.ValueType) []api.ValueType {
+	return values
+}
+
+var wasiFunctions = map[string]*hostFunction{
+	"args_sizes_get":    function(types(i32, i32), types(i32), emptySizes),
+	"args_get":          function(types(i32, i32), types(i32), empty),
+	"environ_sizes_get": function(types(i32, i32), types(i32), emptySizes),
+	"environ_get":       function(types(i32, i32), types(i32), empty),
+	"clock_time_get":    function(types(i32, i64, i32), types(i32), clockTimeGet),
+	"random_get":        function(types(i32, i32), types(i32), randomGet),
+	"fd_write":          function(types(i32, i32, i32, i32), types(i32), fdWrite),
+	"poll_oneoff":       function(types(i32, i32, i32, i32), types(i32), pollOneoff),
+	// there are no preopened directories, the Go runtime lists them until EBADF
+	"fd_prestat_get": function(types(i32, i32), types(i32), func(context.Context, *extension, api.Memory, []uint64) []uint64 {
+		return errno(errnoBadf)
+	}),
+	"sched_yield": function(nil, types(i32), func(context.Context, *extension, api.Memory, []uint64) []uint64 {
+		return errno(errnoSuccess)
+	}),
+	"proc_exit": function(types(i32), nil, func(_ context.Context, extension *extension, _ api.Memory, args []uint64) []uint64 {
+		extension.exitErr = fmt.Errorf("extension exited with code %d", uint32(args[0]))
+		// the module is closed by the runtime, it's instantiated again for the next event
+		panic(sys.NewExitError(uint32(args[0])))
+	}),
+}
+
+// wasiUnsupported returns a function for an unsupported WASI import which fails with ENOSYS
+func wasiUnsupported(definition api.FunctionDefinition) *hostFunction {
+	results := definition.ResultTypes()
+	return function(definition.ParamTypes(), results, func(context.Context, *extension, api.Memory, []uint64) []uint64 {
+		if len(results) == 1 {
+			return errno(errnoNosys)
+		}
+		return make([]uint64, len(results))
+	})
+}
+
+func emptySizes(_ context.Context, _ *extension, memory api.Memory, args []uint64) []uint64 {
+	if !memory.WriteUint32Le(uint32(args[0]), 0) || !memory.WriteUint32Le(uint32(args[1]), 0) {
+		return errno(errnoFault)
+	}
+	return errno(errnoSuccess)
+}
+
+func empty(context.Context, *extension, api.Memory, []uint64) []uint64 {
+	return errno(errnoSuccess)
+}
+
+func now(clock uint32) (uint64, bool) {
+	switch clock {
+	case clockRealtime:
+		return uint64(time.Now().UnixNano()), true
+	case clockMonotonic:
+		return uint64(time.Since(processStart)), true
+	}
+	return 0, false
+}
+
+func clockTimeGet(_ context.Context, _ *extension, memory api.Memory, args []uint64) []uint64 {
+	value, ok := now(uint32(args[0]))
+	if !ok {
+		return errno(errnoInval)
+	}
+	if !memory.WriteUint64Le(uint32(args[2]), value) {
+		return errno(errnoFault)
+	}
+	return errno(errnoSuccess)
+}
+
+func randomGet(_ context.Context, _ *extension, memory api.Memory, args []uint64) []uint64 {
+	data := make([]byte, uint32(args[1]))
+	if _, err := rand.Read(data); err != nil {
+		return errno(errnoFault)
+	}
+	if !memory.Write(uint32(args[0]), data) {
+		return errno(errnoFault)
+	}
+	return errno(errnoSuccess)
+}
+
+func fdWrite(_ context.Context, extension *extension, memory api.Memory, args []uint64) []uint64 {
+	fd := uint32(args[0])
+	if fd != 1 && fd != 2 {
+		return errno(errnoBadf)
+	}
+	iovs, ok := memory.Read(uint32(args[1]), uint32(args[2])*8)
+	if !ok {
+		return errno(errnoFault)
+	}
+	written := uint32(0)
+	for i := 0; i < len(iovs); i += 8 {
+		data, ok := memory.Read(binary.LittleEndian.Uint32(iovs[i:]), binary.LittleEndian.Uint32(iovs[i+4:]))
+		if !ok {
+			return errno(errnoFault)
+		}
+		extension.output(fd, data)
+		written += uint32(len(data))
+	}
+	if !memory.WriteUint32Le(uint32(args[3]), written) {
+		return errno(errnoFault)
+	}
+	return errno(errnoSuccess)
+}
+
+// output logs complete lines written to the standard output or error
+func (extension *extension) output(fd uint32, data []byte) {
+	buffer := &extension.stdout
+	if fd == 2 {
+		buffer = &extension.stderr
+	}
+	buffer.Write(data)
+	for {
+		line, err := buffer.ReadBytes('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			rest := append([]byte(nil), line...)
+			buffer.Reset()
+			buffer.Write(rest)
+			return
+		}
+		line = bytes.TrimRight(line, "\n")
+		if fd == 2 {
+			extension.logger.Error("%s", line)
+		} else {
+			extension.logger.Info("%s", line)
+		}
+	}
+}
+
+// pollOneoff supports clock subscriptions used by the Go runtime to sleep
+func pollOneoff(ctx context.Context, _ *extension, memory api.Memory, args []uint64) []uint64 {
+	count := uint32(args[2])
+	if count == 0 {
+		return errno(errnoInval)
+	}
+	subscriptions, ok := memory.Read(uint32(args[0]), count*subscriptionSize)
+	if !ok {
+		return errno(errnoFault)
+	}
+
+	timeouts := make([]time.Duration, count)
+	var wait time.Duration = -1
+	for i := range timeouts {
+		subscription := subscriptions[i*subscriptionSize:]
+		timeouts[i] = -1
+		if subscription[8] != eventTypeClock {
+			continue
+		}
+		clock := binary.LittleEndian.Uint32(subscription[16:])
+		timeout := binary.LittleEndian.Uint64(subscription[24:])
+		if binary.LittleEndian.Uint16(subscription[40:])&subscriptionClockAbstime != 0 {
+			current, ok := now(clock)
+			if !ok {
+				continue
+			}
+			if timeout > current {
+				timeout -= current
+			} else {
+				timeout = 0
+			}
+		}
+		timeouts[i] = time.Duration(timeout)
+		if wait < 0 || timeouts[i] < wait {
+			wait = timeouts[i]
+		}
+	}
+
+	// other subscriptions are reported as unsupported without waiting
+	hasOther := false
+	for _, timeout := range timeouts {
+		if timeout < 0 {
+			hasOther = true
+		}
+	}
+	if !hasOther && wait > 0 {
+		// interrupted sleeps return early, the runtime stops the module after the call
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	events := []byte{}
+	for i, timeout := range timeouts {
+		if timeout >= 0 && (hasOther || timeout > wait) {
+			continue
+		}
+		event := make([]byte, eventSize)
+		copy(event, subscriptions[i*subscriptionSize:i*subscriptionSize+8])
+		if timeout < 0 {
+			binary.LittleEndian.PutUint16(event[8:], errnoNotsup)
+		}
+		event[10] = subscriptions[i*subscriptionSize+8]
+		events = append(events, event...)
+	}
+	if !memory.Write(uint32(args[1]), events) || !memory.WriteUint32Le(uint32(args[3]), uint32(len(events)/eventSize)) {
+		return errno(errnoFault)
+	}
+	return errno(errnoSuccess)
+}
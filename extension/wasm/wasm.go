@@ -0,0 +1,549 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	ext "github.com/cloudwan/gohan/extension"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/sync"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+const (
+	//CodeType is the code type of WebAssembly extensions
+	CodeType = "wasm"
+
+	allocFunction       = "gohan_alloc"
+	handleEventFunction = "gohan_handle_event"
+	initializeFunction  = "_initialize"
+	memoryExport        = "memory"
+)
+
+var log = l.NewLogger()
+
+var (
+	// programsLock guards the cache and references of programs
+	programsLock gosync.Mutex
+	// programs are cached by the path of the module
+	programs = map[string]*program{}
+)
+
+// moduleConfig instantiates anonymous modules, so a program has many instances,
+// which are initialized explicitly as reactors
+var moduleConfig = wazero.NewModuleConfig().WithName("").WithStartFunctions()
+
+// program is a compiled module with its runtime, instances of extensions are created from it
+type program struct {
+	source  string
+	modTime time.Time
+	size    int64
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	// handlers are events registered by the module during initialization
+	handlers map[string]bool
+	// refs counts extensions created from the program
+	refs int
+}
+
+//Environment is a WebAssembly based environment for gohan extensions.
+//It runs extensions of the "wasm" code type, modules built for the wasip1 target
+//with the extension/wasm/guest package.
+type Environment struct {
+	Name       string
+	DataStore  db.DB
+	Identity   middleware.IdentityService
+	Sync       sync.Sync
+	timeLimit  time.Duration
+	timeLimits []*schema.EventTimeLimit
+	extensions []*extension
+}
+
+// extension is an instance of a program with the state of the handled event
+type extension struct {
+	env     *Environment
+	program *program
+	// instance is created by the first handled event
+	instance api.Module
+	logger   l.Logger
+	// initializing is set while init functions of the module run
+	initializing bool
+
+	context      map[string]interface{}
+	transactions map[int64]transaction.Transaction
+	// opened are transactions begun by the extension, closed after the event
+	opened     []transaction.Transaction
+	nextHandle int64
+	// result is the response of the last host call waiting to be copied to the guest
+	result []byte
+	// exitErr is set when the module exits, the instance can't be used anymore
+	exitErr        error
+	stdout, stderr bytes.Buffer
+}
+
+//NewEnvironment create new gohan extension environment based on context
+func NewEnvironment(name string, dataStore db.DB, identity middleware.IdentityService, sync sync.Sync) *Environment {
+	return &Environment{
+		Name:      name,
+		DataStore: dataStore,
+		Identity:  identity,
+		Sync:      sync,
+	}
+}
+
+//Load loads a WebAssembly module for environment
+func (env *Environment) Load(source string) error {
+	program, err := acquireProgram(source)
+	if err != nil {
+		return err
+	}
+	env.extensions = append(env.extensions, env.newExtension(program))
+	return nil
+}
+
+// newExtension creates an extension of a program acquired for it
+func (env *Environment) newExtension(program *program) *extension {
+	extension := &extension{
+		env:     env,
+		program: program,
+		logger:  l.NewLogger(l.ModuleName("gohan.extension." + env.Name)),
+	}
+	// clones of environments are dropped without closing them, instances are released with them
+	runtime.SetFinalizer(extension, releaseExtension)
+	return extension
+}
+
+// acquireProgram loads a program from the cache, or compiles it when the module has changed,
+// and references it for a new extension
+func acquireProgram(source string) (*program, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	programsLock.Lock()
+	defer programsLock.Unlock()
+	if cached, ok := programs[source]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		cached.refs++
+		return cached, nil
+	}
+
+	binary, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	program, err := compileProgram(binary)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+	program.source = source
+	program.modTime = info.ModTime()
+	program.size = info.Size()
+	program.refs++
+	cacheProgram(program)
+	return program, nil
+}
+
+// cacheProgram replaces the cached program of the module, the runtime of the replaced one
+// is closed right away if no extension references it or else by the last released extension.
+// programsLock must be held.
+func cacheProgram(program *program) {
+	if replaced, ok := programs[program.source]; ok && replaced.refs == 0 {
+		replaced.close()
+	}
+	programs[program.source] = program
+}
+
+// acquire references the program for a new extension
+func (program *program) acquire() {
+	programsLock.Lock()
+	defer programsLock.Unlock()
+	program.refs++
+}
+
+// release drops the reference of a released extension
+func (program *program) release() {
+	programsLock.Lock()
+	defer programsLock.Unlock()
+	program.refs--
+	if program.refs == 0 && programs[program.source] != program {
+		program.close()
+	}
+}
+
+// close closes the runtime with the compiled module and all its instances
+func (program *program) close() {
+	if program.runtime != nil {
+		program.runtime.Close(context.Background())
+		program.runtime = nil
+	}
+}
+
+// compileProgram compiles the module in its own runtime and initializes it to find registered handlers
+func compileProgram(binary []byte) (result *program, err error) {
+	ctx := context.Background()
+	wasmRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	defer func() {
+		if err != nil {
+			wasmRuntime.Close(ctx)
+		}
+	}()
+	module, err := wasmRuntime.CompileModule(ctx, binary)
+	if err != nil {
+		return nil, err
+	}
+	exports := module.ExportedFunctions()
+	for _, function := range []string{allocFunction, handleEventFunction, initializeFunction} {
+		if _, ok := exports[function]; !ok {
+			return nil, fmt.Errorf("module doesn't export %s", function)
+		}
+	}
+	if _, ok := module.ExportedMemories()[memoryExport]; !ok {
+		return nil, fmt.Errorf("module doesn't export %s", memoryExport)
+	}
+	if err := instantiateHostModules(ctx, wasmRuntime, module); err != nil {
+		return nil, err
+	}
+
+	program := &program{
+		runtime:  wasmRuntime,
+		module:   module,
+		handlers: map[string]bool{},
+	}
+	// initialization runs init functions of the module which register handlers
+	initialized := &extension{
+		program: program,
+		logger:  l.NewLogger(l.ModuleName("gohan.extension.wasm")),
+	}
+	ctx, cancel := initialized.callContext()
+	defer cancel()
+	if err := initialized.instantiate(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize: %s", err)
+	}
+	initialized.close()
+	return program, nil
+}
+
+//LoadExtensionsForPath loads extensions for specific path
+func (env *Environment) LoadExtensionsForPath(extensions []*schema.Extension, timeLimit time.Duration, timeLimits []*schema.PathEventTimeLimit, path string) error {
+	for _, extension := range extensions {
+		if extension.Match(path) {
+			if extension.CodeType != CodeType {
+				continue
+			}
+			source := strings.TrimPrefix(extension.URL, "file://")
+			if err := env.Load(source); err != nil {
+				return err
+			}
+		}
+	}
+	// setup time limits for matching extensions
+	env.timeLimit = timeLimit
+	for _, timeLimit := range timeLimits {
+		if timeLimit.Match(path) {
+			env.timeLimits = append(env.timeLimits, schema.NewEventTimeLimit(timeLimit.EventRegex, timeLimit.TimeDuration))
+		}
+	}
+	return nil
+}
+
+//HandleEvent handles event
+func (env *Environment) HandleEvent(event string, context map[string]interface{}) error {
+	context["event_type"] = event
+	for _, extension := range env.extensions {
+		if !extension.handles(event) {
+			continue
+		}
+		if err := env.profileEvent(extension, event, context); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileEvent handles the event, profiling and tracing the module as a handler
+func (env *Environment) profileEvent(extension *extension, event string, context map[string]interface{}) error {
+	trace := ext.ContextTrace(context)
+	if !ext.ProfilingEnabled() && trace == nil {
+		return env.handleEvent(extension, event, context)
+	}
+	handler := ext.Handler{
+		Environment: "wasm",
+		Schema:      ext.ContextSchemaID(context),
+		Event:       event,
+		Name:        extension.program.source,
+	}
+	span := trace.Begin(handler, context)
+	_, hadException := context["exception"]
+	started := time.Now()
+	err := env.handleEvent(extension, event, context)
+	_, hasException := context["exception"]
+	ext.ProfileHandler(handler, started, ext.ContextTraceID(context), err != nil || (hasException && !hadException))
+	span.End(context, err)
+	return err
+}
+
+func (env *Environment) handleEvent(extension *extension, event string, context map[string]interface{}) (err error) {
+	var closeNotify <-chan bool
+	if httpResponse, ok := context["http_response"]; ok {
+		if closeNotifier, ok := httpResponse.(http.CloseNotifier); ok {
+			closeNotify = closeNotifier.CloseNotify()
+		}
+	}
+	var timeout = fmt.Errorf("exceed timeout for extension execution for event: %s", event)
+	var disconnected = fmt.Errorf("client disconnected for event: %s", event)
+
+	// take time limit from first passing regex or default
+	selectedTimeLimit := env.timeLimit
+	for _, timeLimit := range env.timeLimits {
+		if timeLimit.Match(event) {
+			selectedTimeLimit = timeLimit.TimeDuration
+			break
+		}
+	}
+	ctx, cancel := extension.callContext()
+	defer cancel()
+	// interrupted is set before the module is stopped by canceling the context
+	var interrupted error
+	timer := time.NewTimer(selectedTimeLimit)
+	defer timer.Stop()
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-closeNotify:
+			metrics.UpdateCounter(1, "req.peer_disconnect")
+			interrupted = disconnected
+			cancel()
+		case <-timer.C:
+			interrupted = timeout
+			cancel()
+		case <-done:
+			// extension executed successfully
+		}
+	}()
+
+	extension.begin(context)
+	defer extension.end()
+
+	passed := extension.marshalContext(context)
+	output, err := extension.call(ctx, event, passed)
+
+	close(done)
+	<-watcherDone
+	if err != nil {
+		if interrupted != nil {
+			err = interrupted
+		} else if extension.exitErr != nil {
+			err = extension.exitErr
+		}
+		// the state of the module is unknown after a trap, start again from the initialized one
+		extension.reset()
+		if err == timeout || err == disconnected {
+			log.Warning(err.Error())
+			return err
+		}
+		return fmt.Errorf("%s: %s", event, err)
+	}
+
+	if err := mergeContext(context, passed, output.Context); err != nil {
+		return fmt.Errorf("%s: invalid context returned by the extension: %s", event, err)
+	}
+	if output.Error != "" {
+		return fmt.Errorf("%s: %s", event, output.Error)
+	}
+	return nil
+}
+
+type eventInput struct {
+	Event   string                     `json:"event"`
+	Context map[string]json.RawMessage `json:"context"`
+}
+
+type eventOutput struct {
+	Context map[string]json.RawMessage `json:"context"`
+	Error   string                     `json:"error"`
+}
+
+// call passes the event to the module and returns its response
+func (extension *extension) call(ctx context.Context, event string, context map[string]json.RawMessage) (*eventOutput, error) {
+	input, err := json.Marshal(&eventInput{
+		Event:   event,
+		Context: context,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the module is closed by the runtime when it's interrupted or exits
+	if extension.instance == nil || extension.instance.IsClosed() {
+		if err := extension.instantiate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	instance := extension.instance
+	results, err := instance.ExportedFunction(allocFunction).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	if !instance.Memory().Write(uint32(results[0]), input) {
+		return nil, fmt.Errorf("memory range %d+%d out of bounds", uint32(results[0]), len(input))
+	}
+	results, err = instance.ExportedFunction(handleEventFunction).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	data, err := readMemory(instance.Memory(), uint32(results[0]>>32), uint32(results[0]))
+	if err != nil {
+		return nil, err
+	}
+	output := &eventOutput{}
+	if err := json.Unmarshal(data, output); err != nil {
+		return nil, fmt.Errorf("invalid response of the extension: %s", err)
+	}
+	return output, nil
+}
+
+func (extension *extension) handles(event string) bool {
+	return extension.program.handlers[event] || extension.program.handlers["*"]
+}
+
+// begin sets up the state of the handled event
+func (extension *extension) begin(context map[string]interface{}) {
+	extension.context = context
+	extension.transactions = map[int64]transaction.Transaction{}
+	extension.nextHandle = 1
+	if tx, ok := context["transaction"].(transaction.Transaction); ok {
+		extension.addTransaction(tx)
+	}
+}
+
+// end closes transactions begun by the extension
+func (extension *extension) end() {
+	for _, tx := range extension.opened {
+		if !tx.Closed() {
+			if err := tx.Close(); err != nil {
+				log.Error("Error when closing transaction %p : %s", tx, err)
+			}
+		}
+	}
+	extension.opened = nil
+	extension.transactions = nil
+	extension.context = nil
+	extension.result = nil
+}
+
+// instantiate creates a new instance of the program and runs its init functions
+func (extension *extension) instantiate(ctx context.Context) error {
+	instance, err := extension.program.runtime.InstantiateModule(ctx, extension.program.module, moduleConfig)
+	if err != nil {
+		return err
+	}
+	extension.instance = instance
+	extension.initializing = true
+	defer func() {
+		extension.initializing = false
+	}()
+	if _, err := instance.ExportedFunction(initializeFunction).Call(ctx); err != nil {
+		extension.close()
+		return err
+	}
+	return nil
+}
+
+func releaseExtension(extension *extension) {
+	extension.close()
+	extension.program.release()
+}
+
+// close releases the instance of the module
+func (extension *extension) close() {
+	if extension.instance != nil {
+		extension.instance.Close(context.Background())
+		extension.instance = nil
+	}
+}
+
+func (extension *extension) reset() {
+	extension.close()
+	extension.exitErr = nil
+	extension.stdout.Reset()
+	extension.stderr.Reset()
+}
+
+func (extension *extension) addTransaction(tx transaction.Transaction) int64 {
+	handle := extension.nextHandle
+	extension.nextHandle++
+	extension.transactions[handle] = tx
+	return handle
+}
+
+// SetEventTimeLimit overrides the default time limit for a given event for this environment
+func (env *Environment) SetEventTimeLimit(eventRegex string, timeLimit time.Duration) {
+	env.timeLimits = append(env.timeLimits, schema.NewEventTimeLimit(regexp.MustCompile(eventRegex), timeLimit))
+}
+
+//Clone makes clone of the environment sharing the compiled modules.
+//Instances of modules aren't copied, a clone creates new instances on its first events,
+//so init functions of the modules run again and clones don't share the state of modules.
+func (env *Environment) Clone() ext.Environment {
+	clone := NewEnvironment(env.Name, env.DataStore, env.Identity, env.Sync)
+	clone.timeLimit = env.timeLimit
+	clone.timeLimits = env.timeLimits
+	for _, extension := range env.extensions {
+		extension.program.acquire()
+		clone.extensions = append(clone.extensions, clone.newExtension(extension.program))
+	}
+	return clone
+}
+
+//Stop releases the extensions of the environment, e.g. when it's replaced by a reloaded one.
+//Runtimes of modules which changed are closed once clones of the environment are released too.
+func (env *Environment) Stop() {
+	for _, extension := range env.extensions {
+		runtime.SetFinalizer(extension, nil)
+		releaseExtension(extension)
+	}
+	env.extensions = nil
+}
+
+// IsEventHandled returns whether a given event is handled by this environment
+func (env *Environment) IsEventHandled(event string, context map[string]interface{}) bool {
+	for _, extension := range env.extensions {
+		if extension.handles(event) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/dbutil"
+	"github.com/cloudwan/gohan/db/options"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/sync/etcdv3"
+	"github.com/cloudwan/gohan/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	configDir        = "../../server"
+	configFile       = "./server_test_config.yaml"
+	dbType           = "sqlite3"
+	dbFile           = "./wasm_test.db"
+	testSyncEndpoint = "localhost:2379"
+)
+
+var (
+	testDB   db.DB
+	testSync *etcdv3.Sync
+
+	buildDir string
+	// testModule is the test extension built in BeforeSuite
+	testModule string
+)
+
+func TestWasmExtension(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Wasm Extension Suite")
+}
+
+// buildModule builds the extension in the directory for the wasip1 target
+func buildModule(source, output string) {
+	build := exec.Command("go", "build", "-buildmode=c-shared", "-o", output, ".")
+	build.Dir = source
+	build.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	out, err := build.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), "Failed to build %s: %s", source, out)
+}
+
+var _ = Describe("Suite set up and tear down", func() {
+	var _ = BeforeSuite(func() {
+		var err error
+		buildDir, err = ioutil.TempDir("", "gohan_wasm_test")
+		Expect(err).NotTo(HaveOccurred())
+		source, err := filepath.Abs("./test_data/ext_test")
+		Expect(err).NotTo(HaveOccurred())
+		testModule = filepath.Join(buildDir, "ext_test.wasm")
+		buildModule(source, testModule)
+
+		Expect(os.Chdir(configDir)).To(Succeed())
+		testDB, err = dbutil.ConnectDB(dbType, dbFile, db.DefaultMaxOpenConn, options.Default())
+		Expect(err).ToNot(HaveOccurred(), "Failed to connect database.")
+		testSync, err = etcdv3.NewSync([]string{testSyncEndpoint}, time.Second)
+		Expect(err).NotTo(HaveOccurred(), "Failed to connect to etcd")
+		manager := schema.GetManager()
+		config := util.GetConfig()
+		Expect(config.ReadConfig(configFile)).To(Succeed())
+		schemaFiles := config.GetStringList("schemas", nil)
+		Expect(schemaFiles).NotTo(BeNil())
+		Expect(manager.LoadSchemasFromFiles(schemaFiles...)).To(Succeed())
+		Expect(dbutil.InitDBWithSchemas(dbType, dbFile, db.DefaultTestInitDBParams())).To(Succeed())
+	}, 120)
+
+	var _ = AfterSuite(func() {
+		schema.ClearManager()
+		testSync.Close()
+		os.Remove(dbFile)
+		os.RemoveAll(buildDir)
+	})
+})
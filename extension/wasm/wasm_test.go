@@ -0,0 +1,307 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasm_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/cloudwan/gohan/db/dbutil"
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/extension/wasm"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/secret"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wasm environment", func() {
+	const timeLimit = 5 * time.Second
+
+	var (
+		env        *wasm.Environment
+		timeLimits []*schema.PathEventTimeLimit
+		ctx        map[string]interface{}
+	)
+
+	newExtension := func(url string) *schema.Extension {
+		extension, err := schema.NewExtension(map[string]interface{}{
+			"id":        "wasm_extension",
+			"code_type": "wasm",
+			"url":       url,
+			"path":      ".*",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return extension
+	}
+
+	BeforeEach(func() {
+		timeLimits = nil
+		ctx = map[string]interface{}{}
+	})
+
+	JustBeforeEach(func() {
+		env = wasm.NewEnvironment("wasm_test", testDB, nil, testSync)
+		extensions := []*schema.Extension{
+			newExtension("file://" + testModule),
+			// extensions of other environments are skipped
+			{ID: "javascript", CodeType: "javascript", Code: "syntax error", Path: regexp.MustCompile(".*")},
+		}
+		Expect(env.LoadExtensionsForPath(extensions, timeLimit, timeLimits, "/v2.0/networks")).To(Succeed())
+	})
+
+	Describe("Loading extensions", func() {
+		It("should report handled events", func() {
+			Expect(env.IsEventHandled("echo", ctx)).To(BeTrue())
+			Expect(env.IsEventHandled("pre_create", ctx)).To(BeFalse())
+		})
+
+		It("should ignore events without handlers", func() {
+			Expect(env.HandleEvent("pre_create", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("event_type", "pre_create"))
+		})
+
+		It("should fail for missing modules", func() {
+			env := wasm.NewEnvironment("wasm_test", testDB, nil, testSync)
+			err := env.LoadExtensionsForPath([]*schema.Extension{newExtension("file:///not/existing.wasm")}, timeLimit, nil, "/v2.0/networks")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail for invalid modules", func() {
+			invalid := filepath.Join(buildDir, "invalid.wasm")
+			Expect(ioutil.WriteFile(invalid, []byte("not a module"), 0644)).To(Succeed())
+			env := wasm.NewEnvironment("wasm_test", testDB, nil, testSync)
+			err := env.LoadExtensionsForPath([]*schema.Extension{newExtension("file://" + invalid)}, timeLimit, nil, "/v2.0/networks")
+			Expect(err).To(MatchError(ContainSubstring("invalid.wasm")))
+		})
+	})
+
+	Describe("Passing context", func() {
+		It("should apply changes of the extension", func() {
+			ctx["input"] = map[string]interface{}{"name": "test", "size": 3}
+			ctx["removed"] = "value"
+			ctx["kept"] = 1
+			ctx["not_serializable"] = func() {}
+			Expect(env.HandleEvent("echo", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("echo", map[string]interface{}{"name": "test", "size": int64(3)}))
+			Expect(ctx).To(HaveKeyWithValue("event", "echo"))
+			Expect(ctx).To(HaveKeyWithValue("kept", 1))
+			Expect(ctx).To(HaveKey("not_serializable"))
+			Expect(ctx).NotTo(HaveKey("removed"))
+		})
+
+		It("should keep the state of the module between events", func() {
+			Expect(env.HandleEvent("echo", ctx)).To(Succeed())
+			Expect(env.HandleEvent("echo", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("calls", int64(2)))
+		})
+
+		It("should start clones from the initialized module", func() {
+			Expect(env.HandleEvent("echo", ctx)).To(Succeed())
+			clone := env.Clone()
+			Expect(clone.HandleEvent("echo", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("calls", int64(1)))
+		})
+
+		It("should pass authorization", func() {
+			auth := schema.NewAuthorizationBuilder().
+				WithTenant(schema.Tenant{ID: "admin_tenant", Name: "admin"}).
+				WithRoleIDs("admin").
+				BuildAdmin()
+			ctx["auth"] = auth
+			Expect(env.HandleEvent("auth", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("tenant_id", "admin_tenant"))
+			Expect(ctx).To(HaveKeyWithValue("tenant_name", "admin"))
+			Expect(ctx).To(HaveKeyWithValue("is_admin", true))
+			Expect(ctx).To(HaveKeyWithValue("has_role", true))
+			Expect(ctx["auth"]).To(BeIdenticalTo(auth))
+		})
+	})
+
+	Describe("Errors", func() {
+		It("should return errors of handlers", func() {
+			Expect(env.HandleEvent("fail", ctx)).To(MatchError("fail: failed on purpose"))
+		})
+
+		It("should convert guest errors to exceptions", func() {
+			err := extension.HandleEvent(ctx, env, "exception", "network")
+			Expect(err).To(BeAssignableToTypeOf(extension.Error{}))
+			Expect(err.(extension.Error).ExceptionInfo).To(HaveKeyWithValue("code", int64(409)))
+			Expect(err).To(MatchError("exception: conflict"))
+		})
+
+		It("should recover from panics", func() {
+			Expect(env.HandleEvent("panic", ctx)).To(MatchError(ContainSubstring("panicked on purpose")))
+			Expect(env.HandleEvent("echo", ctx)).To(Succeed())
+		})
+	})
+
+	Describe("Time limits", func() {
+		BeforeEach(func() {
+			timeLimits = []*schema.PathEventTimeLimit{
+				schema.NewPathEventTimeLimit(".*", "^(loop|sleep)$", 200*time.Millisecond),
+			}
+		})
+
+		It("should interrupt running code", func() {
+			start := time.Now()
+			Expect(env.HandleEvent("loop", ctx)).To(MatchError("exceed timeout for extension execution for event: loop"))
+			Expect(time.Since(start)).To(BeNumerically("<", timeLimit))
+		})
+
+		It("should interrupt sleeping code", func() {
+			start := time.Now()
+			Expect(env.HandleEvent("sleep", ctx)).To(MatchError("exceed timeout for extension execution for event: sleep"))
+			Expect(time.Since(start)).To(BeNumerically("<", timeLimit))
+		})
+
+		It("should reset the module after interrupting it", func() {
+			Expect(env.HandleEvent("echo", ctx)).To(Succeed())
+			Expect(env.HandleEvent("loop", ctx)).NotTo(Succeed())
+			Expect(env.HandleEvent("echo", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("calls", int64(1)))
+		})
+	})
+
+	Describe("Host modules", func() {
+		It("should log", func() {
+			Expect(env.HandleEvent("log", ctx)).To(Succeed())
+		})
+
+		It("should redact secrets in logs", func() {
+			dir, err := ioutil.TempDir("", "wasm_secrets")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			store := secret.NewStore(dir+"/secrets.json", dir+"/secrets.key")
+			Expect(store.Set("device_password", "s3cr3t", []string{"^/v2.0/devices"})).To(Succeed())
+			secret.SetStore(store)
+			defer secret.SetStore(nil)
+
+			var output bytes.Buffer
+			l.SetUpBasicLogging(&output, l.CliFormat)
+			defer l.SetUpBasicLogging(os.Stderr, l.DefaultFormat)
+
+			ctx["message"] = "logging s3cr3t"
+			Expect(env.HandleEvent("log", ctx)).To(Succeed())
+			Expect(output.String()).To(ContainSubstring("logging " + secret.Redacted))
+			Expect(output.String()).NotTo(ContainSubstring("s3cr3t"))
+		})
+
+		It("should read schemas", func() {
+			Expect(env.HandleEvent("schemas", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("plural", "networks"))
+			Expect(ctx).To(HaveKeyWithValue("schemas", int64(len(schema.GetManager().OrderedSchemas()))))
+			Expect(ctx).To(HaveKeyWithValue("unknown_error", "Unknown schema 'unknown'"))
+		})
+
+		It("should use sync", func() {
+			ctx["key"] = "/wasm_test/key"
+			Expect(env.HandleEvent("sync", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("value", `{"value": 1}`))
+			_, err := testSync.Fetch(context.Background(), "/wasm_test/key")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should make HTTP requests", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			}))
+			defer server.Close()
+			ctx["url"] = server.URL
+			Expect(env.HandleEvent("http", ctx)).To(Succeed())
+			Expect(ctx).To(HaveKeyWithValue("status_code", int64(200)))
+			Expect(ctx).To(HaveKeyWithValue("body", "hello"))
+		})
+
+		Describe("Database", func() {
+			AfterEach(func() {
+				tx, err := testDB.BeginTx()
+				Expect(err).ToNot(HaveOccurred())
+				defer tx.Close()
+				networkSchema, _ := schema.GetManager().Schema("network")
+				Expect(dbutil.ClearTable(context.Background(), tx, networkSchema)).To(Succeed())
+				Expect(tx.Commit()).To(Succeed())
+			})
+
+			expectNetwork := func() {
+				Expect(ctx["network"]).To(HaveKeyWithValue("name", "name_updated"))
+				Expect(ctx["networks"]).To(HaveLen(1))
+				tx, err := testDB.BeginTx()
+				Expect(err).ToNot(HaveOccurred())
+				defer tx.Close()
+				network, err := otto.GohanDbFetch(tx, "network", "test1", "admin")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(network.Get("name")).To(Equal("name_updated"))
+			}
+
+			It("should use the transaction of the context", func() {
+				tx, err := testDB.BeginTx()
+				Expect(err).ToNot(HaveOccurred())
+				defer tx.Close()
+				ctx["transaction"] = tx
+				ctx["id"] = "test1"
+				Expect(env.HandleEvent("db", ctx)).To(Succeed())
+				Expect(ctx["transaction"]).To(BeIdenticalTo(tx))
+				Expect(tx.Commit()).To(Succeed())
+				expectNetwork()
+			})
+
+			It("should begin transactions", func() {
+				ctx["id"] = "test1"
+				Expect(env.HandleEvent("db_begin", ctx)).To(Succeed())
+				expectNetwork()
+			})
+
+			It("should fail without a transaction", func() {
+				ctx["id"] = "test1"
+				Expect(env.HandleEvent("db", ctx)).To(MatchError("db: no transaction in context"))
+			})
+		})
+	})
+
+	Describe("Sample extension", func() {
+		var sample *wasm.Environment
+
+		BeforeEach(func() {
+			source, err := filepath.Abs("../examples/wasm_example")
+			Expect(err).NotTo(HaveOccurred())
+			module := filepath.Join(buildDir, "wasm_example.wasm")
+			buildModule(source, module)
+			sample = wasm.NewEnvironment("wasm_example", testDB, nil, testSync)
+			Expect(sample.LoadExtensionsForPath([]*schema.Extension{newExtension("file://" + module)}, timeLimit, nil, "/v0.1/todos")).To(Succeed())
+		}, 120)
+
+		It("should validate created todos", func() {
+			ctx["resource"] = map[string]interface{}{"name": "", "description": ""}
+			err := extension.HandleEvent(ctx, sample, "pre_create", "todo")
+			Expect(err).To(BeAssignableToTypeOf(extension.Error{}))
+			Expect(err.(extension.Error).ExceptionInfo).To(HaveKeyWithValue("code", int64(400)))
+
+			ctx = map[string]interface{}{"resource": map[string]interface{}{"name": "todo", "description": ""}}
+			Expect(extension.HandleEvent(ctx, sample, "pre_create", "todo")).To(Succeed())
+			Expect(ctx["resource"]).To(HaveKeyWithValue("description", "created by a wasm extension"))
+		})
+	})
+})
@@ -0,0 +1,449 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpclient sends HTTP requests of extensions with the policy of their destination:
+// retries with backoff of idempotent requests, a circuit breaker and a concurrency cap per host.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/util"
+)
+
+var (
+	// ErrCircuitOpen is returned for requests to a host whose circuit breaker is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	log = l.NewLogger()
+
+	invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+
+	// statuses of responses worth retrying, other responses are returned as they are
+	retryableStatuses = map[int]bool{
+		http.StatusTooManyRequests:    true,
+		http.StatusBadGateway:         true,
+		http.StatusServiceUnavailable: true,
+		http.StatusGatewayTimeout:     true,
+	}
+
+	idempotentMethods = map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodOptions: true,
+		http.MethodTrace:   true,
+		http.MethodPut:     true,
+		http.MethodDelete:  true,
+	}
+
+	clientLock    sync.RWMutex
+	defaultClient = NewClient(Policy{}, nil)
+)
+
+// Policy of requests to a destination
+type Policy struct {
+	// Retries is the number of times a failed idempotent request is sent again
+	Retries int
+	// Backoff is the delay before the first retry, doubled with every next one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout of a single attempt, attempts are limited only by the request context when it's 0
+	Timeout time.Duration
+	// MaxConcurrent limits requests in progress to a host, 0 means no limit
+	MaxConcurrent int
+	// BreakerThreshold consecutive failures open the circuit breaker of a host for BreakerCooldown,
+	// after which a single request probes whether the host recovered. 0 disables the breaker
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// Options override the policy of a destination for a request, zero values keep the policy
+type Options struct {
+	// Retries of an idempotent request, negative disables retries
+	Retries int
+	Backoff time.Duration
+	Timeout time.Duration
+}
+
+// OptionsFromMap reads options given by javascript extensions:
+// retries, backoff and attempt_timeout in milliseconds
+func OptionsFromMap(options map[string]interface{}) (Options, error) {
+	result := Options{}
+	if value, ok := options["retries"]; ok {
+		retries, ok := toInt64(value)
+		if !ok || retries < 0 {
+			return result, fmt.Errorf("retries should be a non negative integer, got %v", value)
+		}
+		result.Retries = int(retries)
+		if retries == 0 {
+			result.Retries = -1
+		}
+	}
+	if value, ok := options["backoff"]; ok {
+		backoff, ok := toInt64(value)
+		if !ok || backoff < 0 {
+			return result, fmt.Errorf("backoff should be a non negative number of milliseconds, got %v", value)
+		}
+		result.Backoff = time.Duration(backoff) * time.Millisecond
+	}
+	if value, ok := options["attempt_timeout"]; ok {
+		timeout, ok := toInt64(value)
+		if !ok || timeout < 0 {
+			return result, fmt.Errorf("attempt_timeout should be a non negative number of milliseconds, got %v", value)
+		}
+		result.Timeout = time.Duration(timeout) * time.Millisecond
+	}
+	return result, nil
+}
+
+func (policy Policy) override(options Options) Policy {
+	if options.Retries < 0 {
+		policy.Retries = 0
+	} else if options.Retries > 0 {
+		policy.Retries = options.Retries
+	}
+	if options.Backoff > 0 {
+		policy.Backoff = options.Backoff
+	}
+	if options.Timeout > 0 {
+		policy.Timeout = options.Timeout
+	}
+	return policy
+}
+
+// Client sends requests with policies of their destinations. Hosts without a policy
+// get the default one
+type Client struct {
+	policy   Policy
+	policies map[string]Policy
+
+	mu           sync.Mutex
+	destinations map[string]*destination
+}
+
+// NewClient creates a client with the default policy and policies of hosts,
+// given as "host" or "host:port"
+func NewClient(policy Policy, policies map[string]Policy) *Client {
+	if policies == nil {
+		policies = map[string]Policy{}
+	}
+	return &Client{
+		policy:       policy,
+		policies:     policies,
+		destinations: map[string]*destination{},
+	}
+}
+
+// NewClientFromConfig creates a client from "http_client" config
+func NewClientFromConfig(config *util.Config) (*Client, error) {
+	defaults, _ := config.GetParam("http_client/default", nil).(map[string]interface{})
+	policy := policyFromConfig(util.NewConfig(defaults), Policy{})
+	policies := map[string]Policy{}
+	for i, item := range config.GetList("http_client/hosts", nil) {
+		options, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("http_client/hosts/%d should be a map", i)
+		}
+		hostConfig := util.NewConfig(options)
+		host := hostConfig.GetString("host", "")
+		if host == "" {
+			return nil, fmt.Errorf("http_client/hosts/%d has no host", i)
+		}
+		policies[host] = policyFromConfig(hostConfig, policy)
+	}
+	return NewClient(policy, policies), nil
+}
+
+func policyFromConfig(config *util.Config, defaults Policy) Policy {
+	return Policy{
+		Retries:          config.GetInt("retries", defaults.Retries),
+		Backoff:          config.GetDuration("backoff", defaults.Backoff),
+		MaxBackoff:       config.GetDuration("max_backoff", defaults.MaxBackoff),
+		Timeout:          config.GetDuration("timeout", defaults.Timeout),
+		MaxConcurrent:    config.GetInt("max_concurrent", defaults.MaxConcurrent),
+		BreakerThreshold: config.GetInt("breaker_threshold", defaults.BreakerThreshold),
+		BreakerCooldown:  config.GetDuration("breaker_cooldown", defaults.BreakerCooldown),
+	}
+}
+
+// SetupHTTPClient sets up the client used by extensions from "http_client" config
+func SetupHTTPClient(config *util.Config) error {
+	client, err := NewClientFromConfig(config)
+	if err != nil {
+		return err
+	}
+	SetClient(client)
+	return nil
+}
+
+// SetClient sets the client used by extensions
+func SetClient(client *Client) {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	defaultClient = client
+}
+
+// GetClient returns the client used by extensions
+func GetClient() *Client {
+	clientLock.RLock()
+	defer clientLock.RUnlock()
+	return defaultClient
+}
+
+// Do sends a request by the client used by extensions
+func Do(req *http.Request, send func(*http.Request) (*http.Response, error), options Options) (*http.Response, error) {
+	return GetClient().Do(req, send, options)
+}
+
+// WithTimeout returns a context canceled after timeout or at deadline, whichever is first.
+// Zero timeout or deadline doesn't limit the context
+func WithTimeout(ctx context.Context, timeout time.Duration, deadline time.Time) (context.Context, context.CancelFunc) {
+	if timeout > 0 && (deadline.IsZero() || time.Now().Add(timeout).Before(deadline)) {
+		return context.WithTimeout(ctx, timeout)
+	}
+	if !deadline.IsZero() {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Policy returns the policy of a host, given as "host:port" or "host"
+func (client *Client) Policy(host string) Policy {
+	if policy, ok := client.policies[host]; ok {
+		return policy
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		if policy, ok := client.policies[hostname]; ok {
+			return policy
+		}
+	}
+	return client.policy
+}
+
+// Do sends a request by send with the policy of its destination, overridden by options.
+// The request is sent again after a backoff when it's idempotent, its body can be read again
+// and the attempt failed or got a response of status 429, 502, 503 or 504, unless
+// the request context would be done before the retry.
+// The body of the returned response has to be closed, as it holds a slot of the concurrency cap
+func (client *Client) Do(req *http.Request, send func(*http.Request) (*http.Response, error), options Options) (*http.Response, error) {
+	host := req.URL.Host
+	policy := client.Policy(host).override(options)
+	dest := client.destination(host)
+	name := invalidMetricChars.ReplaceAllString(host, "_")
+	ctx := req.Context()
+
+	retries := policy.Retries
+	if !idempotentMethods[req.Method] || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		retries = 0
+	}
+	backoff := policy.Backoff
+	for attempt := 0; ; attempt++ {
+		if err := dest.acquire(ctx); err != nil {
+			metrics.UpdateCounter(1, "http_client.%s.rejected", name)
+			return nil, err
+		}
+		allowed, probe := dest.allow(policy, time.Now())
+		if !allowed {
+			dest.release()
+			metrics.UpdateCounter(1, "http_client.%s.rejected", name)
+			return nil, fmt.Errorf("request to %s failed: %s", host, ErrCircuitOpen)
+		}
+
+		metrics.UpdateCounter(1, "http_client.%s.requests", name)
+		started := time.Now()
+		resp, err := client.send(req, send, attempt, policy.Timeout, dest)
+		metrics.UpdateTimer(started, "http_client.%s.request", name)
+
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		if failed {
+			metrics.UpdateCounter(1, "http_client.%s.failures", name)
+		}
+		if dest.record(policy, failed, probe, time.Now()) {
+			metrics.UpdateCounter(1, "http_client.%s.breaker_open", name)
+			log.Warning("Circuit breaker of %s is open for %s", host, policy.BreakerCooldown)
+		}
+
+		retryable := (err != nil && ctx.Err() == nil) || (err == nil && retryableStatuses[resp.StatusCode])
+		if attempt >= retries || !retryable || !canWait(ctx, backoff) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		log.Debug("Retrying %s %s in %s after attempt %d", req.Method, req.URL, backoff, attempt+1)
+		metrics.UpdateCounter(1, "http_client.%s.retries", name)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// send makes an attempt of a request, the slot of the destination is released when it fails
+// or the body of its response is closed
+func (client *Client) send(req *http.Request, send func(*http.Request) (*http.Response, error),
+	attempt int, timeout time.Duration, dest *destination) (*http.Response, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	done := func() {
+		cancel()
+		dest.release()
+	}
+
+	attemptReq := req.WithContext(ctx)
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			done()
+			return nil, err
+		}
+		attemptReq.Body = body
+	}
+	resp, err := send(attemptReq)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &body{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+func (client *Client) destination(host string) *destination {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	dest, ok := client.destinations[host]
+	if !ok {
+		dest = &destination{}
+		if maxConcurrent := client.Policy(host).MaxConcurrent; maxConcurrent > 0 {
+			dest.slots = make(chan struct{}, maxConcurrent)
+		}
+		client.destinations[host] = dest
+	}
+	return dest
+}
+
+// destination keeps the state of a host shared by its requests
+type destination struct {
+	slots chan struct{}
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (dest *destination) acquire(ctx context.Context) error {
+	if dest.slots == nil {
+		return nil
+	}
+	select {
+	case dest.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for one of %d concurrent requests failed: %s", cap(dest.slots), ctx.Err())
+	}
+}
+
+func (dest *destination) release() {
+	if dest.slots != nil {
+		<-dest.slots
+	}
+}
+
+// allow reports whether the circuit breaker lets a request through,
+// and whether the request is the single one probing the host of a half open circuit breaker
+func (dest *destination) allow(policy Policy, now time.Time) (allowed, probe bool) {
+	if policy.BreakerThreshold <= 0 {
+		return true, false
+	}
+	dest.mu.Lock()
+	defer dest.mu.Unlock()
+	if dest.failures < policy.BreakerThreshold {
+		return true, false
+	}
+	if now.Before(dest.openUntil) || dest.probing {
+		return false, false
+	}
+	// half open, a single request probes the host
+	dest.probing = true
+	return true, true
+}
+
+// record records a result of a request, and reports whether it opened the circuit breaker.
+// Only the result of the probe ends probing, requests admitted before the circuit breaker opened
+// may complete while the probe is still running.
+func (dest *destination) record(policy Policy, failed, probe bool, now time.Time) bool {
+	dest.mu.Lock()
+	defer dest.mu.Unlock()
+	if probe {
+		dest.probing = false
+	}
+	if !failed {
+		dest.failures = 0
+		return false
+	}
+	dest.failures++
+	if policy.BreakerThreshold <= 0 || dest.failures < policy.BreakerThreshold {
+		return false
+	}
+	dest.openUntil = now.Add(policy.BreakerCooldown)
+	return true
+}
+
+type body struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *body) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+func canWait(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(delay).Before(deadline)
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
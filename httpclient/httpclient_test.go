@@ -0,0 +1,278 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwan/gohan/httpclient"
+	"github.com/cloudwan/gohan/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTP client", func() {
+	var (
+		server   *httptest.Server
+		requests int32
+		failures int32
+		release  chan struct{}
+	)
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, 0)
+		release = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			atomic.AddInt32(&requests, 1)
+			if release != nil {
+				<-release
+			}
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(body)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	do := func(client *httpclient.Client, method string, options httpclient.Options) (*http.Response, error) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader("data"))
+		Expect(err).ToNot(HaveOccurred())
+		return client.Do(req, http.DefaultClient.Do, options)
+	}
+
+	expectBody := func(resp *http.Response, err error, code int, body string) {
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(code))
+		Expect(ioutil.ReadAll(resp.Body)).To(BeEquivalentTo(body))
+	}
+
+	It("should retry idempotent requests with backoff", func() {
+		client := httpclient.NewClient(httpclient.Policy{Retries: 2, Backoff: 10 * time.Millisecond}, nil)
+
+		atomic.StoreInt32(&failures, 2)
+		started := time.Now()
+		resp, err := do(client, "PUT", httpclient.Options{})
+		expectBody(resp, err, http.StatusOK, "data")
+		Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(3))
+		Expect(time.Since(started)).To(BeNumerically(">=", 30*time.Millisecond))
+
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, 1)
+		resp, err = do(client, "POST", httpclient.Options{})
+		expectBody(resp, err, http.StatusServiceUnavailable, "")
+		Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, 1)
+		resp, err = do(client, "GET", httpclient.Options{Retries: -1})
+		expectBody(resp, err, http.StatusServiceUnavailable, "")
+		Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+	})
+
+	It("should not retry after the deadline of the request", func() {
+		client := httpclient.NewClient(httpclient.Policy{Retries: 2, Backoff: time.Second}, nil)
+		atomic.StoreInt32(&failures, 2)
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest("GET", server.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		resp, err := client.Do(req.WithContext(ctx), http.DefaultClient.Do, httpclient.Options{})
+		expectBody(resp, err, http.StatusServiceUnavailable, "")
+		Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+	})
+
+	It("should open the circuit breaker of a failing host", func() {
+		client := httpclient.NewClient(httpclient.Policy{BreakerThreshold: 2, BreakerCooldown: 100 * time.Millisecond}, nil)
+
+		atomic.StoreInt32(&failures, 3)
+		for i := 0; i < 2; i++ {
+			resp, err := do(client, "GET", httpclient.Options{})
+			expectBody(resp, err, http.StatusServiceUnavailable, "")
+		}
+		_, err := do(client, "GET", httpclient.Options{})
+		Expect(err).To(MatchError(ContainSubstring(httpclient.ErrCircuitOpen.Error())))
+		Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(2))
+
+		time.Sleep(100 * time.Millisecond)
+		resp, err := do(client, "GET", httpclient.Options{})
+		expectBody(resp, err, http.StatusServiceUnavailable, "")
+		_, err = do(client, "GET", httpclient.Options{})
+		Expect(err).To(MatchError(ContainSubstring(httpclient.ErrCircuitOpen.Error())))
+
+		time.Sleep(100 * time.Millisecond)
+		resp, err = do(client, "GET", httpclient.Options{})
+		expectBody(resp, err, http.StatusOK, "data")
+		resp, err = do(client, "GET", httpclient.Options{})
+		expectBody(resp, err, http.StatusOK, "data")
+	})
+
+	It("should probe a host by a single request while earlier requests complete", func() {
+		const cooldown = 50 * time.Millisecond
+		client := httpclient.NewClient(httpclient.Policy{BreakerThreshold: 1, BreakerCooldown: cooldown}, nil)
+		slow, probe := make(chan struct{}), make(chan struct{})
+		var probed int32
+		host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/slow":
+				<-slow
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case "/failing":
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case "/probe":
+				atomic.AddInt32(&probed, 1)
+				<-probe
+			}
+		}))
+		defer host.Close()
+		// unblock the probe when the spec fails before releasing it
+		defer func() {
+			select {
+			case <-probe:
+			default:
+				close(probe)
+			}
+		}()
+		get := func(path string) (*http.Response, error) {
+			req, err := http.NewRequest("GET", host.URL+path, nil)
+			Expect(err).ToNot(HaveOccurred())
+			return client.Do(req, http.DefaultClient.Do, httpclient.Options{})
+		}
+		getInBackground := func(path string, code int) chan struct{} {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				resp, err := get(path)
+				expectBody(resp, err, code, "")
+			}()
+			return done
+		}
+
+		slowDone := getInBackground("/slow", http.StatusServiceUnavailable)
+		resp, err := get("/failing")
+		expectBody(resp, err, http.StatusServiceUnavailable, "")
+
+		time.Sleep(cooldown)
+		probeDone := getInBackground("/probe", http.StatusOK)
+		Eventually(func() int32 { return atomic.LoadInt32(&probed) }).Should(BeEquivalentTo(1))
+
+		// the request admitted before the circuit breaker opened doesn't end probing
+		close(slow)
+		Eventually(slowDone).Should(BeClosed())
+		time.Sleep(cooldown)
+		_, err = get("/failing")
+		Expect(err).To(MatchError(ContainSubstring(httpclient.ErrCircuitOpen.Error())))
+
+		close(probe)
+		Eventually(probeDone).Should(BeClosed())
+		resp, err = get("/failing")
+		expectBody(resp, err, http.StatusServiceUnavailable, "")
+		Expect(atomic.LoadInt32(&probed)).To(BeEquivalentTo(1))
+	})
+
+	It("should cap concurrent requests to a host", func() {
+		client := httpclient.NewClient(httpclient.Policy{MaxConcurrent: 1}, nil)
+		release = make(chan struct{})
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			resp, err := do(client, "GET", httpclient.Options{})
+			expectBody(resp, err, http.StatusOK, "data")
+		}()
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }).Should(BeEquivalentTo(1))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest("GET", server.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = client.Do(req.WithContext(ctx), http.DefaultClient.Do, httpclient.Options{})
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+
+		close(release)
+		Eventually(done).Should(BeClosed())
+		resp, err := do(client, "GET", httpclient.Options{})
+		expectBody(resp, err, http.StatusOK, "data")
+	})
+
+	It("should read policies of hosts from config", func() {
+		serverURL, err := url.Parse(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		config := util.NewConfig(map[string]interface{}{
+			"http_client": map[string]interface{}{
+				"default": map[string]interface{}{"retries": 1, "backoff": "10ms"},
+				"hosts": []interface{}{
+					map[string]interface{}{"host": serverURL.Hostname(), "retries": 3, "breaker_threshold": 5},
+				},
+			},
+		})
+		client, err := httpclient.NewClientFromConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.Policy(serverURL.Host)).To(Equal(httpclient.Policy{Retries: 3, Backoff: 10 * time.Millisecond, BreakerThreshold: 5}))
+		Expect(client.Policy("example.com")).To(Equal(httpclient.Policy{Retries: 1, Backoff: 10 * time.Millisecond}))
+
+		_, err = httpclient.NewClientFromConfig(util.NewConfig(map[string]interface{}{
+			"http_client": map[string]interface{}{"hosts": []interface{}{map[string]interface{}{"retries": 1}}},
+		}))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read options of javascript extensions", func() {
+		options, err := httpclient.OptionsFromMap(map[string]interface{}{"retries": int64(0), "backoff": 250.0, "attempt_timeout": 1000})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options).To(Equal(httpclient.Options{Retries: -1, Backoff: 250 * time.Millisecond, Timeout: time.Second}))
+
+		_, err = httpclient.OptionsFromMap(map[string]interface{}{"retries": "many"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should limit contexts by the deadline of extensions", func() {
+		deadline := time.Now().Add(time.Second)
+		ctx, cancel := httpclient.WithTimeout(context.Background(), time.Hour, deadline)
+		defer cancel()
+		limit, _ := ctx.Deadline()
+		Expect(limit).To(Equal(deadline))
+
+		ctx, cancel = httpclient.WithTimeout(context.Background(), time.Millisecond, deadline)
+		defer cancel()
+		limit, _ = ctx.Deadline()
+		Expect(limit).To(BeTemporally("<", deadline))
+
+		ctx, cancel = httpclient.WithTimeout(context.Background(), 0, time.Time{})
+		defer cancel()
+		_, ok := ctx.Deadline()
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,485 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+)
+
+var log = l.NewLogger()
+
+const (
+	// SchemaID is the ID of the schema of the job table
+	SchemaID = "job"
+
+	// PathPrefix prefixes the type of a job in the path of extensions running it
+	PathPrefix = "job://"
+	// Event is the event extensions handle to run a job
+	Event = "job"
+
+	// DefaultMaxAttempts is the number of attempts of a job unless set when it's enqueued
+	DefaultMaxAttempts = 5
+)
+
+// Statuses of jobs
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrNotFound is returned for unknown jobs
+	ErrNotFound = errors.New("job not found")
+	// ErrConflict is returned when the status of a job doesn't allow an operation
+	ErrConflict = errors.New("the job is in a status which doesn't allow the operation")
+	// ErrNotClaimed is returned when the result of an attempt is stored after the job was claimed again
+	ErrNotClaimed = errors.New("the job isn't claimed by the attempt anymore")
+)
+
+// Options of an enqueued job
+type Options struct {
+	// Delay after which the job is due
+	Delay time.Duration
+	// MaxAttempts after which the job fails, DefaultMaxAttempts when 0
+	MaxAttempts int
+	// UniqueKey prevents queueing a job while a pending or running job has the same key,
+	// the key of such a job is stored in the unique active_key column
+	UniqueKey string
+}
+
+// OptionsFromMap reads options given by javascript extensions:
+// delay in seconds, max_attempts and unique_key
+func OptionsFromMap(options map[string]interface{}) (Options, error) {
+	result := Options{}
+	if value, ok := options["delay"]; ok {
+		delay, ok := toFloat64(value)
+		if !ok || delay < 0 {
+			return result, fmt.Errorf("delay should be a non negative number of seconds, got %v", value)
+		}
+		result.Delay = time.Duration(delay * float64(time.Second))
+	}
+	if value, ok := options["max_attempts"]; ok {
+		maxAttempts, ok := toInt64(value)
+		if !ok || maxAttempts < 0 {
+			return result, fmt.Errorf("max_attempts should be a non negative integer, got %v", value)
+		}
+		result.MaxAttempts = int(maxAttempts)
+	}
+	if value, ok := options["unique_key"]; ok {
+		uniqueKey, ok := value.(string)
+		if !ok {
+			return result, fmt.Errorf("unique_key should be a string, got %v", value)
+		}
+		result.UniqueKey = uniqueKey
+	}
+	return result, nil
+}
+
+// Job is a job stored in the job table
+type Job struct {
+	ID          int64                  `json:"id"`
+	Type        string                 `json:"type"`
+	Payload     map[string]interface{} `json:"payload"`
+	Status      string                 `json:"status"`
+	UniqueKey   string                 `json:"unique_key,omitempty"`
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"max_attempts"`
+	RunAt       int64                  `json:"run_at"`
+	LastError   string                 `json:"last_error,omitempty"`
+	CreatedAt   int64                  `json:"created_at"`
+	UpdatedAt   int64                  `json:"updated_at"`
+}
+
+func jobSchema() *schema.Schema {
+	jobSchema, ok := schema.GetManager().Schema(SchemaID)
+	if !ok {
+		panic("Schema 'job' not found. Check if gohan.json is loaded")
+	}
+	return jobSchema
+}
+
+func fromResource(resource *schema.Resource) *Job {
+	job := &Job{}
+	job.ID, _ = toInt64(resource.Get("id"))
+	job.Type, _ = resource.Get("type").(string)
+	job.Status, _ = resource.Get("status").(string)
+	job.UniqueKey, _ = resource.Get("unique_key").(string)
+	attempts, _ := toInt64(resource.Get("attempts"))
+	job.Attempts = int(attempts)
+	maxAttempts, _ := toInt64(resource.Get("max_attempts"))
+	job.MaxAttempts = int(maxAttempts)
+	job.RunAt, _ = toInt64(resource.Get("run_at"))
+	job.LastError, _ = resource.Get("last_error").(string)
+	job.CreatedAt, _ = toInt64(resource.Get("created_at"))
+	job.UpdatedAt, _ = toInt64(resource.Get("updated_at"))
+	if payload, ok := resource.Get("payload").(string); ok && payload != "" {
+		if err := json.Unmarshal([]byte(payload), &job.Payload); err != nil {
+			log.Warning("Invalid payload of job %d: %s", job.ID, err)
+		}
+	}
+	return job
+}
+
+// activeKey returns the value of the active_key column of a job with the unique key
+func activeKey(uniqueKey string) interface{} {
+	if uniqueKey == "" {
+		return nil
+	}
+	return uniqueKey
+}
+
+// queuedWithKey returns the pending or running job with the unique key, or nil
+func queuedWithKey(ctx context.Context, tx transaction.Transaction, uniqueKey string) (*Job, error) {
+	queued, _, err := tx.List(ctx, jobSchema(), transaction.Filter{"active_key": uniqueKey}, nil, nil)
+	if err != nil || len(queued) == 0 {
+		return nil, err
+	}
+	return fromResource(queued[0]), nil
+}
+
+// Enqueue stores a job within a transaction, so that the job is run only when the transaction
+// is committed. It returns the ID of the job, or of the queued job with the same unique key.
+// A job with the key queued by a concurrent transaction makes the insert fail on the unique
+// active_key column, then the ID of that job is returned if it's visible to the transaction.
+func Enqueue(ctx context.Context, tx transaction.Transaction, jobType string, payload map[string]interface{}, options Options) (int64, error) {
+	if jobType == "" {
+		return 0, fmt.Errorf("job type is required")
+	}
+	jobSchema := jobSchema()
+	if options.UniqueKey != "" {
+		queued, err := queuedWithKey(ctx, tx, options.UniqueKey)
+		if err != nil {
+			return 0, err
+		}
+		if queued != nil {
+			return queued.ID, nil
+		}
+	}
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("job payload can't be serialized: %s", err)
+	}
+	maxAttempts := options.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	now := time.Now()
+	result, err := tx.Create(ctx, schema.NewResource(jobSchema, map[string]interface{}{
+		"type":         jobType,
+		"payload":      string(data),
+		"status":       StatusPending,
+		"unique_key":   options.UniqueKey,
+		"active_key":   activeKey(options.UniqueKey),
+		"attempts":     0,
+		"max_attempts": maxAttempts,
+		"run_at":       now.Add(options.Delay).Unix(),
+		"last_error":   "",
+		"created_at":   now.Unix(),
+		"updated_at":   now.Unix(),
+	}))
+	if err != nil {
+		if options.UniqueKey != "" {
+			if queued, findErr := queuedWithKey(ctx, tx, options.UniqueKey); findErr == nil && queued != nil {
+				return queued.ID, nil
+			}
+		}
+		return 0, err
+	}
+	metrics.UpdateCounter(1, "job.%s.enqueued", jobType)
+	return result.LastInsertId()
+}
+
+// Queue manages jobs stored in the job table
+type Queue struct {
+	db db.DB
+}
+
+// NewQueue creates a queue of jobs stored in the database
+func NewQueue(dataStore db.DB) *Queue {
+	return &Queue{db: dataStore}
+}
+
+// Enqueue stores a job in its own transaction
+func (queue *Queue) Enqueue(ctx context.Context, jobType string, payload map[string]interface{}, options Options) (id int64, err error) {
+	err = db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		id, err = Enqueue(ctx, tx, jobType, payload, options)
+		return err
+	}, transaction.Context(ctx))
+	return
+}
+
+// List returns jobs, optionally of a status and a type, oldest first
+func (queue *Queue) List(ctx context.Context, status, jobType string) ([]*Job, error) {
+	filter := transaction.Filter{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if jobType != "" {
+		filter["type"] = jobType
+	}
+	return queue.list(ctx, filter, pagination.OptionKey(jobSchema(), "id"), pagination.OptionOrder(pagination.ASC))
+}
+
+// Due returns IDs of at most limit pending jobs which are due, and of running jobs
+// abandoned by workers which didn't finish them in time, in order they're due
+func (queue *Queue) Due(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	jobs, err := queue.list(ctx, transaction.Filter{
+		"status": []string{StatusPending, StatusRunning},
+		"__and__": []map[string]interface{}{
+			{"property": "run_at", "type": "lte", "value": now.Unix()},
+		},
+	},
+		pagination.OptionKey(jobSchema(), "run_at"),
+		pagination.OptionOrder(pagination.ASC),
+		pagination.OptionLimit(uint64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+	return ids, nil
+}
+
+func (queue *Queue) list(ctx context.Context, filter transaction.Filter, options ...pagination.OptionPaginator) (jobs []*Job, err error) {
+	paginator, err := pagination.NewPaginator(options...)
+	if err != nil {
+		return nil, err
+	}
+	err = db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		list, _, err := tx.List(ctx, jobSchema(), filter, nil, paginator)
+		if err != nil {
+			return err
+		}
+		jobs = make([]*Job, 0, len(list))
+		for _, resource := range list {
+			jobs = append(jobs, fromResource(resource))
+		}
+		return nil
+	}, transaction.Context(ctx))
+	return
+}
+
+func fetchInTx(ctx context.Context, tx transaction.Transaction, id int64) (*Job, error) {
+	resource, err := tx.Fetch(ctx, jobSchema(), transaction.Filter{"id": id}, nil)
+	if err == transaction.ErrResourceNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromResource(resource), nil
+}
+
+// lockFetchInTx fetches a job locking its row until the transaction ends, so that its status is changed
+// based on the current one
+func lockFetchInTx(ctx context.Context, tx transaction.Transaction, id int64) (*Job, error) {
+	resource, err := tx.LockFetch(ctx, jobSchema(), transaction.Filter{"id": id}, schema.SkipRelatedResources, nil)
+	if err == transaction.ErrResourceNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromResource(resource), nil
+}
+
+func updateInTx(ctx context.Context, tx transaction.Transaction, id int64, data map[string]interface{}) error {
+	data["id"] = id
+	data["updated_at"] = time.Now().Unix()
+	return tx.Update(ctx, schema.NewResource(jobSchema(), data))
+}
+
+// Get returns a job
+func (queue *Queue) Get(ctx context.Context, id int64) (job *Job, err error) {
+	err = db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		job, err = fetchInTx(ctx, tx, id)
+		return err
+	}, transaction.Context(ctx))
+	return
+}
+
+// Claim marks a due job as running until timeout passes, the caller must hold the lock of the job.
+// It returns nil when the job isn't due anymore, e.g. it was run by another worker.
+func (queue *Queue) Claim(ctx context.Context, id int64, now time.Time, timeout time.Duration) (job *Job, err error) {
+	err = db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		job, err = lockFetchInTx(ctx, tx, id)
+		if err == ErrNotFound {
+			job = nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if (job.Status != StatusPending && job.Status != StatusRunning) || job.RunAt > now.Unix() {
+			job = nil
+			return nil
+		}
+		if job.Status == StatusRunning {
+			log.Warning("Job %d of type %s was abandoned by its worker, running it again", job.ID, job.Type)
+		}
+		job.Status = StatusRunning
+		job.Attempts++
+		job.RunAt = now.Add(timeout).Unix()
+		return updateInTx(ctx, tx, job.ID, map[string]interface{}{
+			"status":   job.Status,
+			"attempts": job.Attempts,
+			"run_at":   job.RunAt,
+		})
+	}, transaction.Context(ctx))
+	return
+}
+
+// Finish records the result of an attempt of a claimed job. A failed job is due again after backoff
+// unless it has no attempts left. It returns ErrNotClaimed when the job was claimed again,
+// e.g. after the attempt timed out, so that a stale attempt doesn't overwrite its status.
+func (queue *Queue) Finish(ctx context.Context, job *Job, cause error, backoff time.Duration) error {
+	data := map[string]interface{}{}
+	status, lastError, runAt := job.Status, job.LastError, job.RunAt
+	if cause == nil {
+		status = StatusSucceeded
+		lastError = ""
+	} else {
+		lastError = cause.Error()
+		if job.Attempts >= job.MaxAttempts {
+			status = StatusFailed
+		} else {
+			status = StatusPending
+			runAt = time.Now().Add(backoff).Unix()
+			data["run_at"] = runAt
+		}
+	}
+	data["status"] = status
+	data["last_error"] = lastError
+	if status != StatusPending {
+		data["active_key"] = nil
+	}
+	err := db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		current, err := lockFetchInTx(ctx, tx, job.ID)
+		if err == ErrNotFound {
+			return ErrNotClaimed
+		}
+		if err != nil {
+			return err
+		}
+		if current.Status != StatusRunning || current.Attempts != job.Attempts {
+			return ErrNotClaimed
+		}
+		return updateInTx(ctx, tx, job.ID, data)
+	}, transaction.Context(ctx))
+	if err == nil {
+		job.Status, job.LastError, job.RunAt = status, lastError, runAt
+	}
+	return err
+}
+
+// Retry makes a failed job due now with all its attempts,
+// unless another job with its unique key was queued meanwhile
+func (queue *Queue) Retry(ctx context.Context, id int64) error {
+	return db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		job, err := lockFetchInTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if job.Status != StatusFailed {
+			return ErrConflict
+		}
+		if job.UniqueKey != "" {
+			queued, err := queuedWithKey(ctx, tx, job.UniqueKey)
+			if err != nil {
+				return err
+			}
+			if queued != nil {
+				return ErrConflict
+			}
+		}
+		return updateInTx(ctx, tx, id, map[string]interface{}{
+			"status":     StatusPending,
+			"active_key": activeKey(job.UniqueKey),
+			"attempts":   0,
+			"run_at":     time.Now().Unix(),
+			"last_error": "",
+		})
+	}, transaction.Context(ctx))
+}
+
+// Delete deletes a job which isn't running, so that a pending job is canceled
+func (queue *Queue) Delete(ctx context.Context, id int64) error {
+	return db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		job, err := lockFetchInTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if job.Status == StatusRunning {
+			return ErrConflict
+		}
+		return tx.Delete(ctx, jobSchema(), id)
+	}, transaction.Context(ctx))
+}
+
+// DeleteFinished deletes jobs which succeeded before a time
+func (queue *Queue) DeleteFinished(ctx context.Context, before time.Time) error {
+	return db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		return tx.DeleteFilter(ctx, jobSchema(), transaction.Filter{
+			"status": StatusSucceeded,
+			"__and__": []map[string]interface{}{
+				{"property": "updated_at", "type": "lt", "value": before.Unix()},
+			},
+		})
+	}, transaction.Context(ctx))
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,239 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/dbutil"
+	"github.com/cloudwan/gohan/db/options"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/job"
+	"github.com/cloudwan/gohan/schema"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Job queue", func() {
+	const conn = "./test.db"
+
+	var (
+		ctx       context.Context
+		dataStore db.DB
+		queue     *job.Queue
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		Expect(schema.GetManager().LoadSchemasFromFiles("../etc/schema/gohan.json")).To(Succeed())
+		Expect(dbutil.InitDBWithSchemas("sqlite3", conn, db.DefaultTestInitDBParams())).To(Succeed())
+		var err error
+		dataStore, err = dbutil.ConnectDB("sqlite3", conn, db.DefaultMaxOpenConn, options.Default())
+		Expect(err).ToNot(HaveOccurred())
+		queue = job.NewQueue(dataStore)
+	})
+
+	AfterEach(func() {
+		dataStore.Close()
+		schema.ClearManager()
+		os.Remove(conn)
+	})
+
+	It("should enqueue jobs within a transaction", func() {
+		tx, err := dataStore.BeginTx()
+		Expect(err).ToNot(HaveOccurred())
+		id, err := job.Enqueue(ctx, tx, "cleanup", map[string]interface{}{"network": "red"}, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tx.Close()).To(Succeed())
+
+		_, err = queue.Get(ctx, id)
+		Expect(err).To(Equal(job.ErrNotFound))
+
+		tx, err = dataStore.BeginTx()
+		Expect(err).ToNot(HaveOccurred())
+		id, err = job.Enqueue(ctx, tx, "cleanup", map[string]interface{}{"network": "red"}, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tx.Commit()).To(Succeed())
+		Expect(tx.Close()).To(Succeed())
+
+		queued, err := queue.Get(ctx, id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queued.Type).To(Equal("cleanup"))
+		Expect(queued.Status).To(Equal(job.StatusPending))
+		Expect(queued.Payload).To(HaveKeyWithValue("network", "red"))
+		Expect(queued.MaxAttempts).To(Equal(job.DefaultMaxAttempts))
+	})
+
+	It("should queue a single job of a unique key", func() {
+		first, err := queue.Enqueue(ctx, "provision", nil, job.Options{UniqueKey: "network/red"})
+		Expect(err).ToNot(HaveOccurred())
+		second, err := queue.Enqueue(ctx, "provision", nil, job.Options{UniqueKey: "network/red"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).To(Equal(first))
+
+		claimed, err := queue.Claim(ctx, first, time.Now(), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queue.Finish(ctx, claimed, nil, 0)).To(Succeed())
+		third, err := queue.Enqueue(ctx, "provision", nil, job.Options{UniqueKey: "network/red"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(third).ToNot(Equal(first))
+	})
+
+	It("should reject a second queued job of a unique key in the database", func() {
+		_, err := queue.Enqueue(ctx, "provision", nil, job.Options{UniqueKey: "network/red"})
+		Expect(err).ToNot(HaveOccurred())
+		jobSchema, _ := schema.GetManager().Schema(job.SchemaID)
+		err = db.WithinTx(dataStore, func(tx transaction.Transaction) error {
+			_, err := tx.Create(ctx, schema.NewResource(jobSchema, map[string]interface{}{
+				"type":         "provision",
+				"payload":      "{}",
+				"status":       job.StatusPending,
+				"unique_key":   "network/red",
+				"active_key":   "network/red",
+				"attempts":     0,
+				"max_attempts": 1,
+				"run_at":       0,
+				"last_error":   "",
+				"created_at":   0,
+				"updated_at":   0,
+			}))
+			return err
+		})
+		Expect(err).To(MatchError(ContainSubstring("UNIQUE")))
+	})
+
+	It("should not store results of stale attempts", func() {
+		id, err := queue.Enqueue(ctx, "provision", nil, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		now := time.Now()
+		stale, err := queue.Claim(ctx, id, now, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		claimed, err := queue.Claim(ctx, id, now.Add(2*time.Minute), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(queue.Finish(ctx, stale, nil, 0)).To(Equal(job.ErrNotClaimed))
+		running, err := queue.Get(ctx, id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(running.Status).To(Equal(job.StatusRunning))
+		Expect(running.Attempts).To(Equal(2))
+
+		Expect(queue.Finish(ctx, claimed, nil, 0)).To(Succeed())
+		Expect(queue.Finish(ctx, claimed, nil, 0)).To(Equal(job.ErrNotClaimed))
+	})
+
+	It("should return due jobs", func() {
+		now := time.Now()
+		delayed, err := queue.Enqueue(ctx, "provision", nil, job.Options{Delay: time.Hour})
+		Expect(err).ToNot(HaveOccurred())
+		due, err := queue.Enqueue(ctx, "provision", nil, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(queue.Due(ctx, now, 10)).To(Equal([]int64{due}))
+		Expect(queue.Due(ctx, now.Add(2*time.Hour), 10)).To(Equal([]int64{due, delayed}))
+		Expect(queue.Due(ctx, now.Add(2*time.Hour), 1)).To(Equal([]int64{due}))
+	})
+
+	It("should retry failed jobs with backoff until attempts run out", func() {
+		id, err := queue.Enqueue(ctx, "provision", nil, job.Options{MaxAttempts: 2})
+		Expect(err).ToNot(HaveOccurred())
+
+		now := time.Now()
+		claimed, err := queue.Claim(ctx, id, now, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed.Status).To(Equal(job.StatusRunning))
+		Expect(claimed.Attempts).To(Equal(1))
+		Expect(queue.Due(ctx, now, 10)).To(BeEmpty())
+		Expect(queue.Claim(ctx, id, now, time.Minute)).To(BeNil())
+
+		Expect(queue.Finish(ctx, claimed, fmt.Errorf("timed out"), time.Hour)).To(Succeed())
+		failed, err := queue.Get(ctx, id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed.Status).To(Equal(job.StatusPending))
+		Expect(failed.LastError).To(Equal("timed out"))
+		Expect(failed.RunAt).To(BeNumerically(">=", now.Add(time.Hour).Unix()))
+		Expect(queue.Retry(ctx, id)).To(Equal(job.ErrConflict))
+
+		claimed, err = queue.Claim(ctx, id, now.Add(2*time.Hour), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queue.Delete(ctx, id)).To(Equal(job.ErrConflict))
+		Expect(queue.Finish(ctx, claimed, fmt.Errorf("timed out again"), time.Hour)).To(Succeed())
+		failed, err = queue.Get(ctx, id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed.Status).To(Equal(job.StatusFailed))
+		Expect(failed.Attempts).To(Equal(2))
+
+		Expect(queue.Retry(ctx, id)).To(Succeed())
+		retried, err := queue.Get(ctx, id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(retried.Status).To(Equal(job.StatusPending))
+		Expect(retried.Attempts).To(BeZero())
+
+		Expect(queue.Delete(ctx, id)).To(Succeed())
+		Expect(queue.List(ctx, "", "")).To(BeEmpty())
+	})
+
+	It("should run abandoned jobs again", func() {
+		id, err := queue.Enqueue(ctx, "provision", nil, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		now := time.Now()
+		_, err = queue.Claim(ctx, id, now, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(queue.Due(ctx, now.Add(2*time.Minute), 10)).To(Equal([]int64{id}))
+		claimed, err := queue.Claim(ctx, id, now.Add(2*time.Minute), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed.Attempts).To(Equal(2))
+	})
+
+	It("should delete succeeded jobs", func() {
+		succeeded, err := queue.Enqueue(ctx, "provision", nil, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		claimed, err := queue.Claim(ctx, succeeded, time.Now(), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queue.Finish(ctx, claimed, nil, 0)).To(Succeed())
+		pending, err := queue.Enqueue(ctx, "provision", nil, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(queue.DeleteFinished(ctx, time.Now().Add(-time.Hour))).To(Succeed())
+		Expect(queue.List(ctx, job.StatusSucceeded, "")).To(HaveLen(1))
+		Expect(queue.DeleteFinished(ctx, time.Now().Add(time.Hour))).To(Succeed())
+		jobs, err := queue.List(ctx, "", "provision")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].ID).To(Equal(pending))
+	})
+
+	It("should read options of javascript extensions", func() {
+		options, err := job.OptionsFromMap(map[string]interface{}{"delay": 1.5, "max_attempts": int64(3), "unique_key": "key"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options).To(Equal(job.Options{Delay: 1500 * time.Millisecond, MaxAttempts: 3, UniqueKey: "key"}))
+
+		_, err = job.OptionsFromMap(map[string]interface{}{"delay": "soon"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should not store jobs without a type", func() {
+		err := db.WithinTx(dataStore, func(tx transaction.Transaction) error {
+			_, err := job.Enqueue(ctx, tx, "", nil, job.Options{})
+			return err
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -31,12 +31,14 @@ type Extension struct {
 	ID, CodeType, URL, File string
 	Code                    string
 	Path                    *regexp.Regexp
+
+	raw map[string]interface{}
 }
 
 //NewExtension returns new extension from object
 func NewExtension(raw interface{}) (*Extension, error) {
 	typeData := raw.(map[string](interface{}))
-	extension := &Extension{}
+	extension := &Extension{raw: typeData}
 	extension.ID, _ = typeData["id"].(string)
 	extension.CodeType, _ = typeData["code_type"].(string)
 	if extension.CodeType == "" {
@@ -72,8 +74,21 @@ func NewExtension(raw interface{}) (*Extension, error) {
 }
 
 func shouldLoadCode(extension *Extension) bool {
-	// goext are binary .so files which are loaded separately
-	return extension.URL != "" && extension.CodeType != "goext"
+	// goext are binary .so files and wasm are binary modules, both are loaded separately
+	return extension.URL != "" && extension.CodeType != "goext" && extension.CodeType != "wasm"
+}
+
+//Reload creates the extension again from its definition, fetching code from URL
+func (e *Extension) Reload() (*Extension, error) {
+	if e.raw == nil {
+		return nil, fmt.Errorf("extension %s has no definition to reload", e.ID)
+	}
+	extension, err := NewExtension(e.raw)
+	if err != nil {
+		return nil, err
+	}
+	extension.File = e.File
+	return extension, nil
 }
 
 //Match checks if this path matches for extension
@@ -43,6 +43,7 @@ type Manager struct {
 	TimeLimit   time.Duration         // default time limit for an extension
 	TimeLimits  []*PathEventTimeLimit // a list of exceptions for time limits
 	namespaces  map[string]*Namespace
+	roles       *RoleHierarchy
 	mu          sync.RWMutex
 }
 
@@ -431,30 +432,45 @@ func (manager *Manager) loadSchemaFromFile(filePath string) error {
 			manager.policies = append(manager.policies, policy)
 		}
 	}
-	extensions, _ := schemas["extensions"].([]interface{})
-	if extensions == nil {
-		return nil
+	extensions, err := newExtensionsFromMap(schemas, filePath)
+	if err != nil {
+		return err
 	}
+	manager.Extensions = append(manager.Extensions, extensions...)
+	return nil
+}
 
-	for _, extensionData := range extensions {
+func newExtensionsFromMap(schemas map[string]interface{}, filePath string) ([]*Extension, error) {
+	extensions := []*Extension{}
+	list, _ := schemas["extensions"].([]interface{})
+	for _, extensionData := range list {
 		d := extensionData.(map[string](interface{}))
 		rawurl, ok := d["url"].(string)
 		if ok {
-			d["url"], err = fixRelativeURL(rawurl, filepath.Dir(filePath))
+			url, err := fixRelativeURL(rawurl, filepath.Dir(filePath))
 			if err != nil {
-				return err
+				return nil, err
 			}
+			d["url"] = url
 		}
 
 		extension, err := NewExtension(extensionData)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		extension.File = filePath
-		manager.Extensions = append(manager.Extensions, extension)
+		extensions = append(extensions, extension)
 	}
+	return extensions, nil
+}
 
-	return nil
+//LoadExtensionsFromFile loads extensions defined in a schema file without registering them
+func LoadExtensionsFromFile(filePath string) ([]*Extension, error) {
+	schemas, err := util.LoadMap(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newExtensionsFromMap(schemas, filePath)
 }
 
 //LoadPolicies register policy by db object
@@ -503,6 +519,20 @@ func (manager *Manager) LoadNamespaces(namespaces []*Resource) error {
 	return nil
 }
 
+//GetExtensions returns registered extensions
+func (manager *Manager) GetExtensions() []*Extension {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return append([]*Extension{}, manager.Extensions...)
+}
+
+//ReplaceExtensions replaces all registered extensions
+func (manager *Manager) ReplaceExtensions(extensions []*Extension) {
+	manager.mu.Lock()
+	manager.Extensions = extensions
+	manager.mu.Unlock()
+}
+
 //ClearExtensions clears extensions
 func (manager *Manager) ClearExtensions() {
 	manager.mu.Lock()
@@ -541,6 +571,25 @@ func (manager *Manager) PolicyValidate(action, path string, auth Authorization)
 	return PolicyValidate(action, path, auth, manager.policies)
 }
 
+// MatchPolicy returns the policy deciding about API request, which may be a deny policy
+func (manager *Manager) MatchPolicy(action, path string, auth Authorization) (*Policy, *Role) {
+	return MatchPolicy(action, path, auth, manager.policies)
+}
+
+// SetRoleHierarchy sets role inheritance and aliases used when matching policy principals
+func (manager *Manager) SetRoleHierarchy(hierarchy *RoleHierarchy) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.roles = hierarchy
+}
+
+// RoleHierarchy returns role inheritance and aliases, nil if not configured
+func (manager *Manager) RoleHierarchy() *RoleHierarchy {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return manager.roles
+}
+
 //GetAttachmentPolicies returns policies that will validate relations (attachments)
 func (manager *Manager) GetAttachmentPolicies(path string, auth Authorization) []*Policy {
 	return GetAttachmentPolicies(path, auth, manager.policies)
@@ -152,6 +152,7 @@ type ResourceCondition struct {
 	actionTenantFilter            map[string][]tenantMatcher
 	actionPropertyConditionFilter map[string][]map[string]interface{}
 	actionFilter                  *conditionFilter
+	actionRequestConditions       map[string][]requestCondition
 	requireOwner                  bool
 	requireDomainOwner            bool
 	skipTenantDomainCheck         bool
@@ -169,8 +170,12 @@ type Authorization interface {
 	TenantName() string
 	DomainID() string
 	DomainName() string
+	UserID() string
+	UserName() string
 	Roles() []*Role
 	IsAdmin() bool
+	AuthMethods() []string
+	RequestAttributes() *RequestAttributes
 	getDomainCustomFilter(schema *Schema) []filter.FilterElem
 	getTenantCustomFilter(schema *Schema) []filter.FilterElem
 	checkAccessToResource(cond *ResourceCondition, action string, resource map[string]interface{}) error
@@ -184,8 +189,10 @@ type TenantScopedAuthorization struct {
 }
 
 type DomainScopedAuthorization struct {
-	domain Domain
-	roles  []*Role
+	domain      Domain
+	user        User
+	roles       []*Role
+	authMethods []string
 }
 
 // AdminScopedAuthorization represents authorization for the admin,
@@ -198,7 +205,9 @@ type AuthorizationBuilder struct {
 	authViaKeystoneV2 bool
 	tenant            Tenant
 	domain            Domain
+	user              User
 	roles             []*Role
+	authMethods       []string
 }
 
 func NewAuthorizationBuilder() *AuthorizationBuilder {
@@ -223,6 +232,12 @@ func (ab *AuthorizationBuilder) WithDomain(domain Domain) *AuthorizationBuilder
 	return ab
 }
 
+// WithUser sets the user the token was issued to
+func (ab *AuthorizationBuilder) WithUser(user User) *AuthorizationBuilder {
+	ab.user = user
+	return ab
+}
+
 func (ab *AuthorizationBuilder) WithRoleIDs(roleIDs ...string) *AuthorizationBuilder {
 	roles := []*Role{}
 	for _, id := range roleIDs {
@@ -232,6 +247,12 @@ func (ab *AuthorizationBuilder) WithRoleIDs(roleIDs ...string) *AuthorizationBui
 	return ab
 }
 
+// WithAuthMethods sets methods used to obtain the token, e.g. password or totp
+func (ab *AuthorizationBuilder) WithAuthMethods(methods ...string) *AuthorizationBuilder {
+	ab.authMethods = methods
+	return ab
+}
+
 func (ab *AuthorizationBuilder) BuildScopedToTenant() Authorization {
 	if ab.authViaKeystoneV2 {
 		// When using Keystone V2, user is an admin if they have an admin role in the current project
@@ -245,16 +266,20 @@ func (ab *AuthorizationBuilder) BuildScopedToTenant() Authorization {
 	return &TenantScopedAuthorization{
 		tenant: ab.tenant,
 		DomainScopedAuthorization: DomainScopedAuthorization{
-			domain: ab.domain,
-			roles:  ab.roles,
+			domain:      ab.domain,
+			user:        ab.user,
+			roles:       ab.roles,
+			authMethods: ab.authMethods,
 		},
 	}
 }
 
 func (ab *AuthorizationBuilder) BuildScopedToDomain() Authorization {
 	return &DomainScopedAuthorization{
-		domain: ab.domain,
-		roles:  ab.roles,
+		domain:      ab.domain,
+		user:        ab.user,
+		roles:       ab.roles,
+		authMethods: ab.authMethods,
 	}
 }
 
@@ -263,8 +288,10 @@ func (ab *AuthorizationBuilder) BuildAdmin() Authorization {
 		TenantScopedAuthorization: TenantScopedAuthorization{
 			tenant: ab.tenant,
 			DomainScopedAuthorization: DomainScopedAuthorization{
-				domain: ab.domain,
-				roles:  ab.roles,
+				domain:      ab.domain,
+				user:        ab.user,
+				roles:       ab.roles,
+				authMethods: ab.authMethods,
 			},
 		},
 	}
@@ -338,6 +365,25 @@ func (auth *DomainScopedAuthorization) IsAdmin() bool {
 	return false
 }
 
+// UserID returns ID of the user, empty if the identity service doesn't report it
+func (auth *DomainScopedAuthorization) UserID() string {
+	return auth.user.ID
+}
+
+// UserName returns name of the user, empty if the identity service doesn't report it
+func (auth *DomainScopedAuthorization) UserName() string {
+	return auth.user.Name
+}
+
+func (auth *DomainScopedAuthorization) AuthMethods() []string {
+	return auth.authMethods
+}
+
+// RequestAttributes returns nil as plain authorizations are not bound to any request
+func (auth *DomainScopedAuthorization) RequestAttributes() *RequestAttributes {
+	return nil
+}
+
 func (auth *DomainScopedAuthorization) getDomainCustomFilter(schema *Schema) []filter.FilterElem {
 	return getFilterByPropertyIfPresent(schema, domainIDKey, auth.DomainID())
 }
@@ -438,6 +484,11 @@ type Domain struct {
 	Name string
 }
 
+type User struct {
+	ID   string
+	Name string
+}
+
 var DefaultDomain = Domain{
 	ID:   "default",
 	Name: "Default",
@@ -448,9 +499,10 @@ type Role struct {
 	Name string
 }
 
-//Match checks if this role is for this principal
+//Match checks if this role is for this principal,
+//taking role inheritance and aliases into account
 func (r *Role) Match(principal string) bool {
-	return r.Name == principal
+	return GetManager().RoleHierarchy().Implies(r.Name, principal)
 }
 
 //NewPolicy returns new policy from object
@@ -620,6 +672,7 @@ func NewResourceCondition(rawCondition []interface{}, policyID string) (*Resourc
 	p := &ResourceCondition{Condition: rawCondition}
 	p.actionTenantFilter = map[string][]tenantMatcher{}
 	p.actionPropertyConditionFilter = map[string][]map[string]interface{}{}
+	p.actionRequestConditions = map[string][]requestCondition{}
 	for _, condition := range p.Condition {
 		switch condition.(type) {
 		case string:
@@ -673,6 +726,16 @@ func NewResourceCondition(rawCondition []interface{}, policyID string) (*Resourc
 					for _, action := range actions {
 						p.addPropertyConditionFilter(action, match)
 					}
+				case conditionTypeSourceIP, conditionTypeTimeWindow, conditionTypeHeader, conditionTypeAuthMethod:
+					action := ActionGlob
+					if rawAction, ok := conditionObject["action"].(string); ok {
+						action = rawAction
+					}
+					requestCondition, err := newRequestCondition(conditionObject, policyID)
+					if err != nil {
+						return nil, err
+					}
+					p.addRequestCondition(action, requestCondition)
 				default:
 					panic(fmt.Sprintf(
 						"Unknown condition type '%s' for policy '%s'",
@@ -709,6 +772,24 @@ func (p *ResourceCondition) addPropertyConditionFilter(action string, match map[
 	p.actionPropertyConditionFilter[action] = append(p.actionPropertyConditionFilter[action], match)
 }
 
+// addRequestCondition adds request based condition for action
+func (p *ResourceCondition) addRequestCondition(action string, condition requestCondition) {
+	p.actionRequestConditions[action] = append(p.actionRequestConditions[action], condition)
+}
+
+// MatchRequest checks if request attributes satisfy all request conditions for given action.
+// Conditions are never satisfied when no attributes are available, e.g. outside of an API request.
+func (p *ResourceCondition) MatchRequest(action string, attributes *RequestAttributes) bool {
+	for _, conditions := range [][]requestCondition{p.actionRequestConditions[ActionGlob], p.actionRequestConditions[action]} {
+		for _, condition := range conditions {
+			if attributes == nil || !condition.matchRequest(attributes) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 //NewEmptyPolicy Return Empty policy which match everything
 func NewEmptyPolicy() *Policy {
 	return &Policy{resource: &resourceFilter{}, currentResourceCondition: &ResourceCondition{}}
@@ -739,6 +820,12 @@ func (p *Policy) match(action, path string, auth Authorization) *Role {
 		return nil
 	}
 
+	// conditions which can't be evaluated fail closed, so a deny policy still applies
+	attributes := auth.RequestAttributes()
+	if !p.currentResourceCondition.MatchRequest(action, attributes) && !(attributes == nil && p.IsDeny()) {
+		return nil
+	}
+
 	roles := auth.Roles()
 	for _, role := range roles {
 		if role.Match(p.Principal) {
@@ -820,6 +907,9 @@ func (p *Policy) CheckAccess(action string, authorization Authorization,
 	resourceData map[string]interface{}) error {
 
 	currCond := p.GetCurrentResourceCondition()
+	if !currCond.MatchRequest(action, authorization.RequestAttributes()) {
+		return errors.New("Request does not satisfy policy conditions")
+	}
 	if err := authorization.checkAccessToResource(currCond, action, resourceData); err != nil {
 		return err
 	}
@@ -1052,10 +1142,20 @@ func addCustomFilters(schema *Schema, f filter.FilterElem, auth Authorization, t
 
 //PolicyValidate validates api request using policy validation
 func PolicyValidate(action, path string, auth Authorization, policies []*Policy) (foundPolicy *Policy, foundRole *Role) {
+	foundPolicy, foundRole = MatchPolicy(action, path, auth, policies)
+	if foundPolicy == nil || foundPolicy.IsDeny() {
+		return nil, nil
+	}
+	return
+}
+
+// MatchPolicy returns the policy which decides about api request.
+// It is the first matching deny policy if there is any, otherwise the first matching allow policy.
+func MatchPolicy(action, path string, auth Authorization, policies []*Policy) (foundPolicy *Policy, foundRole *Role) {
 	for _, policy := range policies {
 		if role := policy.match(action, path, auth); role != nil {
 			if policy.IsDeny() {
-				return nil, nil
+				return policy, role
 			} else if foundPolicy == nil {
 				foundPolicy = policy
 				foundRole = role
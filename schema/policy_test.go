@@ -17,6 +17,9 @@ package schema
 
 import (
 	"fmt"
+	"net"
+	"net/http"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -440,6 +443,14 @@ var _ = Describe("Policies", func() {
 					Expect(policy).To(BeNil())
 					Expect(role).To(BeNil())
 				})
+
+				It("should return deny policy as the deciding one", func() {
+					allowPolicy := *policy
+					policy.Effect = "deny"
+					matched, role := MatchPolicy("create", "/abc", authorization, []*Policy{&allowPolicy, policy})
+					Expect(matched).To(Equal(policy))
+					Expect(role).To(Equal(&Role{"admin"}))
+				})
 			})
 		})
 
@@ -754,6 +765,169 @@ var _ = Describe("Policies", func() {
 			})
 		})
 
+		Describe("Request based condition", func() {
+			var (
+				adminAuth  Authorization
+				attributes *RequestAttributes
+			)
+
+			validate := func() *Policy {
+				policy, err := NewPolicy(testPolicy)
+				Expect(err).NotTo(HaveOccurred())
+				found, _ := PolicyValidate("delete", "/abc", WithRequestAttributes(adminAuth, attributes), []*Policy{policy})
+				return found
+			}
+
+			BeforeEach(func() {
+				adminAuth = authorizationBuilder.WithRoleIDs("admin").BuildAdmin()
+				attributes = &RequestAttributes{
+					SourceIP: net.ParseIP("10.0.0.5"),
+					Time:     time.Date(2018, time.December, 24, 23, 30, 0, 0, time.UTC),
+					Header:   http.Header{"X-Change-Ticket": []string{"CHG0042"}},
+				}
+			})
+
+			It("should match source CIDR", func() {
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type": "source_ip",
+						"cidr": []interface{}{"192.168.0.0/16", "10.0.0.0/24"},
+					},
+				}
+				Expect(validate()).NotTo(BeNil())
+
+				attributes.SourceIP = net.ParseIP("172.16.0.1")
+				Expect(validate()).To(BeNil())
+			})
+
+			It("should match daily time window wrapping around midnight", func() {
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type": "time_window",
+						"from": "22:00",
+						"to":   "06:00",
+					},
+				}
+				Expect(validate()).NotTo(BeNil())
+
+				attributes.Time = time.Date(2018, time.December, 24, 12, 0, 0, 0, time.UTC)
+				Expect(validate()).To(BeNil())
+			})
+
+			It("should deny during freeze window", func() {
+				testPolicy["effect"] = "deny"
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type":  "time_window",
+						"start": "2018-12-20T00:00:00Z",
+						"end":   "2019-01-05T00:00:00Z",
+					},
+				}
+				allowPolicy, err := NewPolicy(map[string]interface{}{
+					"action":    "*",
+					"id":        "allow",
+					"principal": "admin",
+					"resource":  map[string]interface{}{"path": ".*"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				denyPolicy, err := NewPolicy(testPolicy)
+				Expect(err).NotTo(HaveOccurred())
+				policies := []*Policy{denyPolicy, allowPolicy}
+
+				found, _ := PolicyValidate("update", "/abc", WithRequestAttributes(adminAuth, attributes), policies)
+				Expect(found).To(BeNil())
+
+				attributes.Time = time.Date(2019, time.January, 10, 0, 0, 0, 0, time.UTC)
+				found, _ = PolicyValidate("update", "/abc", WithRequestAttributes(adminAuth, attributes), policies)
+				Expect(found).To(Equal(allowPolicy))
+			})
+
+			It("should match header value", func() {
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type":  "header",
+						"name":  "x-change-ticket",
+						"value": "^CHG[0-9]+$",
+					},
+				}
+				Expect(validate()).NotTo(BeNil())
+
+				attributes.Header = http.Header{}
+				Expect(validate()).To(BeNil())
+			})
+
+			It("should match auth method", func() {
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type":    "auth_method",
+						"methods": []interface{}{"totp"},
+					},
+				}
+				Expect(validate()).To(BeNil())
+
+				attributes.AuthMethods = []string{"password", "totp"}
+				Expect(validate()).NotTo(BeNil())
+			})
+
+			It("should apply condition only to given action", func() {
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type":   "source_ip",
+						"action": "create",
+						"cidr":   []interface{}{"192.168.0.0/16"},
+					},
+				}
+				Expect(validate()).NotTo(BeNil())
+			})
+
+			It("should not satisfy conditions without request attributes", func() {
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type": "source_ip",
+						"cidr": []interface{}{"192.168.0.0/16"},
+					},
+				}
+				policy, err := NewPolicy(testPolicy)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(policy.Check("delete", adminAuth, nil)).NotTo(Succeed())
+				Expect(policy.Check("delete", WithRequestAttributes(adminAuth, attributes), nil)).NotTo(Succeed())
+				attributes.SourceIP = net.ParseIP("192.168.1.1")
+				Expect(policy.Check("delete", WithRequestAttributes(adminAuth, attributes), nil)).To(Succeed())
+				Expect(policy.Check("read", adminAuth, nil)).NotTo(Succeed())
+			})
+
+			It("should apply deny policy with conditions without request attributes", func() {
+				testPolicy["effect"] = "deny"
+				testPolicy["condition"] = []interface{}{
+					map[string]interface{}{
+						"type": "source_ip",
+						"cidr": []interface{}{"192.168.0.0/16"},
+					},
+				}
+				policy, err := NewPolicy(testPolicy)
+				Expect(err).NotTo(HaveOccurred())
+				found, _ := MatchPolicy("delete", "/abc", adminAuth, []*Policy{policy})
+				Expect(found).To(Equal(policy))
+				found, _ = MatchPolicy("delete", "/abc", WithRequestAttributes(adminAuth, attributes), []*Policy{policy})
+				Expect(found).To(BeNil())
+			})
+
+			DescribeTable("should fail on invalid condition",
+				func(condition map[string]interface{}) {
+					testPolicy["condition"] = []interface{}{condition}
+					_, err := NewPolicy(testPolicy)
+					Expect(err).To(HaveOccurred())
+				},
+				Entry("invalid CIDR", map[string]interface{}{"type": "source_ip", "cidr": []interface{}{"10.0.0.0/99"}}),
+				Entry("missing CIDR", map[string]interface{}{"type": "source_ip"}),
+				Entry("invalid time of day", map[string]interface{}{"type": "time_window", "from": "25:00", "to": "06:00"}),
+				Entry("missing window end", map[string]interface{}{"type": "time_window", "from": "22:00"}),
+				Entry("unknown day", map[string]interface{}{"type": "time_window", "days": []interface{}{"someday"}}),
+				Entry("missing header name", map[string]interface{}{"type": "header"}),
+				Entry("missing auth methods", map[string]interface{}{"type": "auth_method"}),
+			)
+		})
+
 		Describe("Custom filter", func() {
 			var testAuth Authorization
 
@@ -0,0 +1,304 @@
+// Copyright (C) 2015 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	conditionTypeSourceIP   = "source_ip"
+	conditionTypeTimeWindow = "time_window"
+	conditionTypeHeader     = "header"
+	conditionTypeAuthMethod = "auth_method"
+
+	timeOfDayLayout = "15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// RequestAttributes describes the request a policy is evaluated for
+type RequestAttributes struct {
+	SourceIP    net.IP
+	Time        time.Time
+	Header      http.Header
+	AuthMethods []string
+}
+
+// NewRequestAttributes returns attributes of the given HTTP request
+func NewRequestAttributes(req *http.Request, authMethods []string) *RequestAttributes {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return &RequestAttributes{
+		SourceIP:    net.ParseIP(host),
+		Time:        time.Now(),
+		Header:      req.Header,
+		AuthMethods: authMethods,
+	}
+}
+
+// requestScopedAuthorization binds request attributes to an authorization
+type requestScopedAuthorization struct {
+	Authorization
+	attributes *RequestAttributes
+}
+
+// WithRequestAttributes returns authorization which carries given request attributes,
+// so that they can be used in policy conditions
+func WithRequestAttributes(auth Authorization, attributes *RequestAttributes) Authorization {
+	if scoped, ok := auth.(*requestScopedAuthorization); ok {
+		auth = scoped.Authorization
+	}
+	return &requestScopedAuthorization{Authorization: auth, attributes: attributes}
+}
+
+func (auth *requestScopedAuthorization) RequestAttributes() *RequestAttributes {
+	return auth.attributes
+}
+
+// requestCondition matches attributes of a request
+type requestCondition interface {
+	matchRequest(attributes *RequestAttributes) bool
+}
+
+type sourceIPCondition struct {
+	networks []*net.IPNet
+}
+
+func (c *sourceIPCondition) matchRequest(attributes *RequestAttributes) bool {
+	if attributes.SourceIP == nil {
+		return false
+	}
+	for _, network := range c.networks {
+		if network.Contains(attributes.SourceIP) {
+			return true
+		}
+	}
+	return false
+}
+
+type timeWindowCondition struct {
+	start, end time.Time
+	from, to   time.Duration
+	daily      bool
+	days       []time.Weekday
+	location   *time.Location
+}
+
+func (c *timeWindowCondition) matchRequest(attributes *RequestAttributes) bool {
+	now := attributes.Time.In(c.location)
+	if !c.start.IsZero() && now.Before(c.start) {
+		return false
+	}
+	if !c.end.IsZero() && !now.Before(c.end) {
+		return false
+	}
+	if len(c.days) > 0 && !containsWeekday(c.days, now.Weekday()) {
+		return false
+	}
+	if !c.daily {
+		return true
+	}
+	sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second
+	if c.from <= c.to {
+		return sinceMidnight >= c.from && sinceMidnight < c.to
+	}
+	// window wraps around midnight, e.g. 22:00 - 06:00
+	return sinceMidnight >= c.from || sinceMidnight < c.to
+}
+
+type headerCondition struct {
+	name  string
+	value *regexp.Regexp
+}
+
+func (c *headerCondition) matchRequest(attributes *RequestAttributes) bool {
+	values, ok := attributes.Header[http.CanonicalHeaderKey(c.name)]
+	if !ok || len(values) == 0 {
+		return false
+	}
+	if c.value == nil {
+		return true
+	}
+	for _, value := range values {
+		if c.value.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+type authMethodCondition struct {
+	methods []string
+}
+
+func (c *authMethodCondition) matchRequest(attributes *RequestAttributes) bool {
+	for _, required := range c.methods {
+		for _, method := range attributes.AuthMethods {
+			if method == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newRequestCondition(conditionObject map[string]interface{}, policyID string) (requestCondition, error) {
+	switch conditionObject["type"] {
+	case conditionTypeSourceIP:
+		return newSourceIPCondition(conditionObject, policyID)
+	case conditionTypeTimeWindow:
+		return newTimeWindowCondition(conditionObject, policyID)
+	case conditionTypeHeader:
+		return newHeaderCondition(conditionObject, policyID)
+	case conditionTypeAuthMethod:
+		return newAuthMethodCondition(conditionObject, policyID)
+	}
+	return nil, errors.Errorf("Unknown request condition type '%s' for policy '%s'", conditionObject["type"], policyID)
+}
+
+func newSourceIPCondition(conditionObject map[string]interface{}, policyID string) (requestCondition, error) {
+	cidrs := getStringSliceFromMap(conditionObject, "cidr")
+	if len(cidrs) == 0 {
+		return nil, errors.Errorf("\"cidr\" list is required in '%s' condition for policy '%s'", conditionTypeSourceIP, policyID)
+	}
+	condition := &sourceIPCondition{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Errorf("Invalid CIDR '%s' for policy '%s': %s", cidr, policyID, err)
+		}
+		condition.networks = append(condition.networks, network)
+	}
+	return condition, nil
+}
+
+func newTimeWindowCondition(conditionObject map[string]interface{}, policyID string) (requestCondition, error) {
+	condition := &timeWindowCondition{location: time.UTC}
+	if timezone, ok := conditionObject["timezone"].(string); ok {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, errors.Errorf("Invalid timezone '%s' for policy '%s': %s", timezone, policyID, err)
+		}
+		condition.location = location
+	}
+
+	var err error
+	if condition.start, err = parseTimestamp(conditionObject, "start", policyID); err != nil {
+		return nil, err
+	}
+	if condition.end, err = parseTimestamp(conditionObject, "end", policyID); err != nil {
+		return nil, err
+	}
+
+	rawFrom, hasFrom := conditionObject["from"].(string)
+	rawTo, hasTo := conditionObject["to"].(string)
+	if hasFrom != hasTo {
+		return nil, errors.Errorf("Both \"from\" and \"to\" are required in '%s' condition for policy '%s'", conditionTypeTimeWindow, policyID)
+	}
+	if hasFrom {
+		if condition.from, err = parseTimeOfDay(rawFrom, policyID); err != nil {
+			return nil, err
+		}
+		if condition.to, err = parseTimeOfDay(rawTo, policyID); err != nil {
+			return nil, err
+		}
+		condition.daily = true
+	}
+
+	for _, rawDay := range getStringSliceFromMap(conditionObject, "days") {
+		day, ok := weekdays[strings.ToLower(rawDay)]
+		if !ok {
+			return nil, errors.Errorf("Unknown day '%s' for policy '%s'", rawDay, policyID)
+		}
+		condition.days = append(condition.days, day)
+	}
+
+	if !condition.daily && condition.start.IsZero() && condition.end.IsZero() && len(condition.days) == 0 {
+		return nil, errors.Errorf("'%s' condition for policy '%s' should specify a time window", conditionTypeTimeWindow, policyID)
+	}
+	return condition, nil
+}
+
+func parseTimestamp(conditionObject map[string]interface{}, key, policyID string) (time.Time, error) {
+	raw, ok := conditionObject[key].(string)
+	if !ok {
+		return time.Time{}, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, errors.Errorf("Invalid \"%s\" timestamp '%s' for policy '%s': %s", key, raw, policyID, err)
+	}
+	return timestamp, nil
+}
+
+func parseTimeOfDay(raw, policyID string) (time.Duration, error) {
+	t, err := time.Parse(timeOfDayLayout, raw)
+	if err != nil {
+		return 0, errors.Errorf("Invalid time of day '%s' for policy '%s', expected HH:MM", raw, policyID)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func newHeaderCondition(conditionObject map[string]interface{}, policyID string) (requestCondition, error) {
+	name, _ := conditionObject["name"].(string)
+	if name == "" {
+		return nil, errors.Errorf("\"name\" is required in '%s' condition for policy '%s'", conditionTypeHeader, policyID)
+	}
+	condition := &headerCondition{name: name}
+	if rawValue, ok := conditionObject["value"].(string); ok {
+		value, err := regexp.Compile(rawValue)
+		if err != nil {
+			return nil, errors.Errorf("Invalid header value regexp '%s' for policy '%s': %s", rawValue, policyID, err)
+		}
+		condition.value = value
+	}
+	return condition, nil
+}
+
+func newAuthMethodCondition(conditionObject map[string]interface{}, policyID string) (requestCondition, error) {
+	methods := getStringSliceFromMap(conditionObject, "methods")
+	if len(methods) == 0 {
+		return nil, errors.Errorf("\"methods\" list is required in '%s' condition for policy '%s'", conditionTypeAuthMethod, policyID)
+	}
+	return &authMethodCondition{methods: methods}, nil
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
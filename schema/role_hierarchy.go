@@ -0,0 +1,127 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+
+	"github.com/cloudwan/gohan/util"
+)
+
+// RoleHierarchy describes which roles inherit other roles and which role names are aliases.
+// A role matches a policy principal if it is the principal, an alias of it, or inherits it
+// directly or transitively.
+type RoleHierarchy struct {
+	inherits map[string][]string
+	aliases  map[string]string
+	implied  map[string]map[string]bool
+}
+
+// NewRoleHierarchy creates a role hierarchy from a map of role to inherited roles
+// and a map of alias to role
+func NewRoleHierarchy(inherits map[string][]string, aliases map[string]string) (*RoleHierarchy, error) {
+	hierarchy := &RoleHierarchy{
+		inherits: map[string][]string{},
+		aliases:  map[string]string{},
+		implied:  map[string]map[string]bool{},
+	}
+	for alias, role := range aliases {
+		if _, ok := aliases[role]; ok {
+			return nil, fmt.Errorf("role alias %s points to another alias %s", alias, role)
+		}
+		hierarchy.aliases[alias] = role
+	}
+	for role, inheritedRoles := range inherits {
+		role = hierarchy.canonical(role)
+		for _, inherited := range inheritedRoles {
+			hierarchy.inherits[role] = append(hierarchy.inherits[role], hierarchy.canonical(inherited))
+		}
+	}
+	for role := range hierarchy.inherits {
+		if err := hierarchy.resolve(role, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return hierarchy, nil
+}
+
+// NewRoleHierarchyFromConfig creates a role hierarchy from "roles/inherits" and "roles/aliases" config
+func NewRoleHierarchyFromConfig(config *util.Config) (*RoleHierarchy, error) {
+	inherits := map[string][]string{}
+	rawInherits, _ := config.GetParam("roles/inherits", map[string]interface{}{}).(map[string]interface{})
+	for role, rawInherited := range rawInherits {
+		inherited, ok := rawInherited.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("roles/inherits/%s should be a list of roles", role)
+		}
+		inherits[role] = getStringSliceFromRawSlice(inherited)
+	}
+
+	aliases := map[string]string{}
+	rawAliases, _ := config.GetParam("roles/aliases", map[string]interface{}{}).(map[string]interface{})
+	for alias, rawRole := range rawAliases {
+		role, ok := rawRole.(string)
+		if !ok {
+			return nil, fmt.Errorf("roles/aliases/%s should be a role name", alias)
+		}
+		aliases[alias] = role
+	}
+
+	return NewRoleHierarchy(inherits, aliases)
+}
+
+func (hierarchy *RoleHierarchy) canonical(role string) string {
+	if aliased, ok := hierarchy.aliases[role]; ok {
+		return aliased
+	}
+	return role
+}
+
+// resolve precomputes all roles implied by the role, detecting inheritance cycles
+func (hierarchy *RoleHierarchy) resolve(role string, visiting map[string]bool) error {
+	if _, ok := hierarchy.implied[role]; ok {
+		return nil
+	}
+	if visiting[role] {
+		return fmt.Errorf("role %s inherits itself", role)
+	}
+	visiting[role] = true
+	defer delete(visiting, role)
+
+	implied := map[string]bool{role: true}
+	for _, inherited := range hierarchy.inherits[role] {
+		if err := hierarchy.resolve(inherited, visiting); err != nil {
+			return err
+		}
+		for r := range hierarchy.implied[inherited] {
+			implied[r] = true
+		}
+	}
+	hierarchy.implied[role] = implied
+	return nil
+}
+
+// Implies checks if the role matches given principal
+func (hierarchy *RoleHierarchy) Implies(role, principal string) bool {
+	if hierarchy == nil {
+		return role == principal
+	}
+	role, principal = hierarchy.canonical(role), hierarchy.canonical(principal)
+	if role == principal {
+		return true
+	}
+	return hierarchy.implied[role][principal]
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Role hierarchy", func() {
+	var hierarchy *RoleHierarchy
+
+	BeforeEach(func() {
+		var err error
+		hierarchy, err = NewRoleHierarchy(map[string][]string{
+			"admin":    {"operator"},
+			"operator": {"Member"},
+		}, map[string]string{
+			"_member_": "Member",
+			"root":     "admin",
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("matches the same role", func() {
+		Expect(hierarchy.Implies("Member", "Member")).To(BeTrue())
+		Expect(hierarchy.Implies("viewer", "viewer")).To(BeTrue())
+	})
+
+	It("matches inherited roles transitively", func() {
+		Expect(hierarchy.Implies("admin", "operator")).To(BeTrue())
+		Expect(hierarchy.Implies("admin", "Member")).To(BeTrue())
+		Expect(hierarchy.Implies("operator", "Member")).To(BeTrue())
+	})
+
+	It("does not match roles in the other direction", func() {
+		Expect(hierarchy.Implies("Member", "operator")).To(BeFalse())
+		Expect(hierarchy.Implies("operator", "admin")).To(BeFalse())
+	})
+
+	It("matches aliases", func() {
+		Expect(hierarchy.Implies("_member_", "Member")).To(BeTrue())
+		Expect(hierarchy.Implies("Member", "_member_")).To(BeTrue())
+		Expect(hierarchy.Implies("root", "Member")).To(BeTrue())
+		Expect(hierarchy.Implies("_member_", "admin")).To(BeFalse())
+	})
+
+	It("matches only equal roles without hierarchy", func() {
+		var empty *RoleHierarchy
+		Expect(empty.Implies("admin", "admin")).To(BeTrue())
+		Expect(empty.Implies("admin", "Member")).To(BeFalse())
+	})
+
+	It("rejects inheritance cycles", func() {
+		_, err := NewRoleHierarchy(map[string][]string{
+			"a": {"b"},
+			"b": {"c"},
+			"c": {"a"},
+		}, nil)
+		Expect(err).To(MatchError(ContainSubstring("inherits itself")))
+	})
+
+	It("rejects cycles through aliases", func() {
+		_, err := NewRoleHierarchy(map[string][]string{
+			"a": {"alias_of_a"},
+		}, map[string]string{"alias_of_a": "a"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects chained aliases", func() {
+		_, err := NewRoleHierarchy(nil, map[string]string{
+			"a": "b",
+			"b": "c",
+		})
+		Expect(err).To(MatchError(ContainSubstring("points to another alias")))
+	})
+
+	Describe("Policy validation", func() {
+		BeforeEach(func() {
+			manager := GetManager()
+			Expect(manager.LoadSchemaFromFile(abstractSchemaPath)).To(Succeed())
+			Expect(manager.LoadSchemaFromFile(schemaPath)).To(Succeed())
+			manager.SetRoleHierarchy(hierarchy)
+		})
+
+		AfterEach(func() {
+			ClearManager()
+		})
+
+		It("applies policies of inherited roles", func() {
+			auth := NewAuthorizationBuilder().
+				WithTenant(Tenant{ID: demoTenantID, Name: "demo"}).
+				WithRoleIDs("operator").
+				BuildScopedToTenant()
+			policy, role := GetManager().PolicyValidate("create", "/v2.0/networks", auth)
+			Expect(policy).NotTo(BeNil())
+			Expect(policy.Principal).To(Equal("Member"))
+			Expect(role.Name).To(Equal("operator"))
+		})
+
+		It("applies policies of aliased roles", func() {
+			auth := NewAuthorizationBuilder().
+				WithTenant(Tenant{ID: demoTenantID, Name: "demo"}).
+				WithRoleIDs("_member_").
+				BuildScopedToTenant()
+			policy, _ := GetManager().PolicyValidate("create", "/v2.0/networks", auth)
+			Expect(policy).NotTo(BeNil())
+			Expect(policy.Principal).To(Equal("Member"))
+		})
+	})
+})
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/cloudwan/gohan/util"
 	"github.com/flosch/pongo2"
@@ -490,6 +491,20 @@ func (schema *Schema) StateVersioning() bool {
 	return stateful
 }
 
+//StateTimeout returns how long state_version may lag behind config_version, e.g. "5m"
+func (schema *Schema) StateTimeout() (timeout time.Duration, ok bool) {
+	timeoutRaw, ok := schema.Metadata["state_timeout"].(string)
+	if !ok {
+		return
+	}
+	timeout, err := time.ParseDuration(timeoutRaw)
+	if err != nil || timeout <= 0 {
+		log.Warning("Invalid state_timeout %q of schema %s", timeoutRaw, schema.ID)
+		return 0, false
+	}
+	return timeout, true
+}
+
 //SyncKeyTemplate - for custom paths in etcd
 func (schema *Schema) SyncKeyTemplate() (syncKeyTemplate string, ok bool) {
 	syncKeyTemplateRaw, ok := schema.Metadata["sync_key_template"]
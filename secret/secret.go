@@ -0,0 +1,465 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/util"
+)
+
+const (
+	// Redacted replaces secret values in logs
+	Redacted = "******"
+
+	keySize       = 32
+	checkInterval = time.Second
+)
+
+var (
+	// ErrNotFound is returned for unknown secrets
+	ErrNotFound = errors.New("secret not found")
+	// ErrForbidden is returned when extensions of a path may not read a secret
+	ErrForbidden = errors.New("secret is not allowed for the extension path")
+	// ErrNotConfigured is returned when no secrets file is configured
+	ErrNotConfigured = errors.New("secrets are not configured")
+)
+
+var log = l.NewLogger()
+
+// Secret is a value with patterns of extension paths allowed to read it
+type Secret struct {
+	Value string   `json:"value"`
+	Paths []string `json:"paths,omitempty"`
+}
+
+// Allows reports whether extensions of path may read the secret, patterns have to match
+// the whole path and a secret without path patterns may be read by all extensions
+func (secret *Secret) Allows(path string) bool {
+	if len(secret.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range secret.Paths {
+		if matched, err := regexp.MatchString("^(?:"+pattern+")$", path); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+type keyRing struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func (ring *keyRing) key(id string) ([]byte, error) {
+	encoded, ok := ring.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in the key file", id)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("key %s is not a base64 encoded %d byte key", id, keySize)
+	}
+	return key, nil
+}
+
+func (ring *keyRing) add() (string, error) {
+	id := make([]byte, 4)
+	key := make([]byte, keySize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	ring.Current = hex.EncodeToString(id)
+	ring.Keys[ring.Current] = base64.StdEncoding.EncodeToString(key)
+	return ring.Current, nil
+}
+
+type encrypted struct {
+	KeyID string `json:"key_id"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Store keeps secrets in a file encrypted with AES-GCM by the current key of a key file.
+// Keys are rotated by Rotate, which encrypts the secrets with a new key. Changes of the files
+// by other processes, e.g. the CLI, are loaded when secrets are read.
+type Store struct {
+	file    string
+	keyFile string
+
+	mu        sync.RWMutex
+	secrets   map[string]*Secret
+	replacer  *strings.Replacer
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewStore allocates a store of a secrets file and a key file
+func NewStore(file, keyFile string) *Store {
+	return &Store{
+		file:     file,
+		keyFile:  keyFile,
+		secrets:  map[string]*Secret{},
+		replacer: strings.NewReplacer(),
+	}
+}
+
+// Load reads the secrets file if it changed since it was last read,
+// a missing secrets file is an empty store
+func (store *Store) Load() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.load()
+}
+
+func (store *Store) load() error {
+	store.checkedAt = time.Now()
+	info, err := os.Stat(store.file)
+	if os.IsNotExist(err) {
+		store.setSecrets(map[string]*Secret{}, time.Time{})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(store.modTime) {
+		return nil
+	}
+
+	ring, err := store.readKeyRing()
+	if err != nil {
+		return err
+	}
+	secrets, err := store.read(ring)
+	if err != nil {
+		return err
+	}
+	store.setSecrets(secrets, info.ModTime())
+	return nil
+}
+
+func (store *Store) reload() {
+	store.mu.RLock()
+	fresh := time.Since(store.checkedAt) < checkInterval
+	store.mu.RUnlock()
+	if fresh {
+		return
+	}
+	if err := store.Load(); err != nil {
+		log.Error("Failed to load secrets from %s: %s", store.file, err)
+	}
+}
+
+func (store *Store) setSecrets(secrets map[string]*Secret, modTime time.Time) {
+	values := []string{}
+	for _, secret := range secrets {
+		if secret.Value != "" {
+			values = append(values, secret.Value)
+		}
+	}
+	// longer values first, so that a value containing another one is redacted entirely
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	replacements := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		replacements = append(replacements, value, Redacted)
+	}
+
+	store.secrets = secrets
+	store.replacer = strings.NewReplacer(replacements...)
+	store.modTime = modTime
+}
+
+func (store *Store) readKeyRing() (*keyRing, error) {
+	data, err := ioutil.ReadFile(store.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ring := &keyRing{}
+	if err := json.Unmarshal(data, ring); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %s", store.keyFile, err)
+	}
+	if ring.Keys == nil {
+		ring.Keys = map[string]string{}
+	}
+	return ring, nil
+}
+
+func (store *Store) read(ring *keyRing) (map[string]*Secret, error) {
+	data, err := ioutil.ReadFile(store.file)
+	if err != nil {
+		return nil, err
+	}
+	sealed := &encrypted{}
+	if err := json.Unmarshal(data, sealed); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %s", store.file, err)
+	}
+	key, err := ring.key(sealed.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, sealed.Nonce, sealed.Data, []byte(sealed.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets file %s: %s", store.file, err)
+	}
+	secrets := map[string]*Secret{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (store *Store) write(ring *keyRing, secrets map[string]*Secret) error {
+	key, err := ring.key(ring.Current)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := &encrypted{
+		KeyID: ring.Current,
+		Nonce: nonce,
+		Data:  gcm.Seal(nil, nonce, plain, []byte(ring.Current)),
+	}
+	if err := writeJSON(store.file, sealed); err != nil {
+		return err
+	}
+	info, err := os.Stat(store.file)
+	if err != nil {
+		return err
+	}
+	store.setSecrets(secrets, info.ModTime())
+	return nil
+}
+
+func writeJSON(file string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written to a temporary file renamed over the file, so that readers never see a partial file
+	temp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(0600); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+// Lookup returns a secret regardless of extension paths allowed to read it
+func (store *Store) Lookup(name string) (*Secret, error) {
+	store.reload()
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	secret, ok := store.secrets[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return secret, nil
+}
+
+// Get returns the value of a secret for extensions of path
+func (store *Store) Get(name, path string) (string, error) {
+	secret, err := store.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if !secret.Allows(path) {
+		return "", ErrForbidden
+	}
+	return secret.Value, nil
+}
+
+// Set stores a secret, the key file is created when it doesn't exist
+func (store *Store) Set(name, value string, paths []string) error {
+	for _, pattern := range paths {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid path pattern %s: %s", pattern, err)
+		}
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	ring, err := store.readKeyRing()
+	if os.IsNotExist(err) {
+		ring = &keyRing{Keys: map[string]string{}}
+		if _, err = ring.add(); err == nil {
+			err = writeJSON(store.keyFile, ring)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// the file might have been changed by another process since it was read
+	store.modTime = time.Time{}
+	if err := store.load(); err != nil {
+		return err
+	}
+
+	secrets := map[string]*Secret{}
+	for secretName, secret := range store.secrets {
+		secrets[secretName] = secret
+	}
+	secrets[name] = &Secret{Value: value, Paths: paths}
+	return store.write(ring, secrets)
+}
+
+// Rotate encrypts secrets with a new key and removes previous keys from the key file
+func (store *Store) Rotate() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.modTime = time.Time{}
+	if err := store.load(); err != nil {
+		return err
+	}
+	ring, err := store.readKeyRing()
+	if err != nil {
+		return err
+	}
+
+	// the new key is stored along previous ones first, so that readers can decrypt
+	// the secrets file with either key until the previous ones are removed
+	current, err := ring.add()
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(store.keyFile, ring); err != nil {
+		return err
+	}
+	if err := store.write(ring, store.secrets); err != nil {
+		return err
+	}
+	ring.Keys = map[string]string{current: ring.Keys[current]}
+	return writeJSON(store.keyFile, ring)
+}
+
+// Redact replaces values of secrets in s
+func (store *Store) Redact(s string) string {
+	store.reload()
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.replacer.Replace(s)
+}
+
+var (
+	storeLock    sync.RWMutex
+	defaultStore *Store
+)
+
+// NewStoreFromConfig allocates a store from "secrets" config, it's nil when no secrets file is configured
+func NewStoreFromConfig(config *util.Config) *Store {
+	file := config.GetString("secrets/file", "")
+	if file == "" {
+		return nil
+	}
+	return NewStore(file, config.GetString("secrets/key_file", file+".key"))
+}
+
+// SetupSecrets sets up the store used by extensions from "secrets" config
+func SetupSecrets(config *util.Config) error {
+	store := NewStoreFromConfig(config)
+	if store != nil {
+		if err := store.Load(); err != nil {
+			return err
+		}
+	}
+	SetStore(store)
+	return nil
+}
+
+// SetStore sets the store used by extensions; nil disables secrets
+func SetStore(store *Store) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	defaultStore = store
+}
+
+// GetStore returns the store used by extensions, which is nil when secrets aren't configured
+func GetStore() *Store {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	return defaultStore
+}
+
+// Get returns the value of a secret of the store used by extensions for extensions of path
+func Get(name, path string) (string, error) {
+	store := GetStore()
+	if store == nil {
+		return "", ErrNotConfigured
+	}
+	return store.Get(name, path)
+}
+
+// Redact replaces values of secrets of the store used by extensions in s
+func Redact(s string) string {
+	store := GetStore()
+	if store == nil {
+		return s
+	}
+	return store.Redact(s)
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secret_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudwan/gohan/secret"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Secret store", func() {
+	var (
+		dir     string
+		file    string
+		keyFile string
+		store   *secret.Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "secret_test")
+		Expect(err).ToNot(HaveOccurred())
+		file = filepath.Join(dir, "secrets.json")
+		keyFile = filepath.Join(dir, "secrets.key")
+		store = secret.NewStore(file, keyFile)
+		Expect(store.Load()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		secret.SetStore(nil)
+		os.RemoveAll(dir)
+	})
+
+	It("should store secrets encrypted", func() {
+		_, err := store.Get("device_password", "/v2.0/devices")
+		Expect(err).To(Equal(secret.ErrNotFound))
+
+		Expect(store.Set("device_password", "s3cr3t-p4ss", nil)).To(Succeed())
+		Expect(store.Get("device_password", "/v2.0/devices")).To(Equal("s3cr3t-p4ss"))
+
+		data, err := ioutil.ReadFile(file)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).ToNot(ContainSubstring("s3cr3t-p4ss"))
+		info, err := os.Stat(keyFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		loaded := secret.NewStore(file, keyFile)
+		Expect(loaded.Load()).To(Succeed())
+		Expect(loaded.Get("device_password", "/v2.0/devices")).To(Equal("s3cr3t-p4ss"))
+	})
+
+	It("should allow secrets only for extensions of matching paths", func() {
+		Expect(store.Set("api_token", "token", []string{"/v2.0/devices(/.*)?", "job://provision"})).To(Succeed())
+
+		Expect(store.Get("api_token", "/v2.0/devices/1")).To(Equal("token"))
+		Expect(store.Get("api_token", "job://provision")).To(Equal("token"))
+		_, err := store.Get("api_token", "/v2.0/networks")
+		Expect(err).To(Equal(secret.ErrForbidden))
+		_, err = store.Get("api_token", "job://provision_all")
+		Expect(err).To(Equal(secret.ErrForbidden))
+		found, err := store.Lookup("api_token")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found.Value).To(Equal("token"))
+
+		Expect(store.Set("api_token", "token", []string{"("})).ToNot(Succeed())
+	})
+
+	It("should rotate the key", func() {
+		Expect(store.Set("device_password", "s3cr3t-p4ss", nil)).To(Succeed())
+		previousKey, err := ioutil.ReadFile(keyFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(store.Rotate()).To(Succeed())
+		Expect(store.Get("device_password", "")).To(Equal("s3cr3t-p4ss"))
+		currentKey, err := ioutil.ReadFile(keyFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(currentKey).ToNot(Equal(previousKey))
+
+		loaded := secret.NewStore(file, keyFile)
+		Expect(loaded.Load()).To(Succeed())
+		Expect(loaded.Get("device_password", "")).To(Equal("s3cr3t-p4ss"))
+
+		Expect(ioutil.WriteFile(keyFile, previousKey, 0600)).To(Succeed())
+		Expect(secret.NewStore(file, keyFile).Load()).ToNot(Succeed())
+	})
+
+	It("should redact secret values", func() {
+		Expect(secret.Redact("password s3cr3t")).To(Equal("password s3cr3t"))
+		_, err := secret.Get("password", "")
+		Expect(err).To(Equal(secret.ErrNotConfigured))
+
+		Expect(store.Set("password", "s3cr3t", nil)).To(Succeed())
+		Expect(store.Set("longer_password", "s3cr3t-and-more", nil)).To(Succeed())
+		secret.SetStore(store)
+		Expect(secret.Redact(`{"password":"s3cr3t","other":"s3cr3t-and-more"}`)).To(
+			Equal(`{"password":"` + secret.Redacted + `","other":"` + secret.Redacted + `"}`))
+		Expect(secret.Get("password", "")).To(Equal("s3cr3t"))
+	})
+
+	It("should redact secrets stored by other processes", func() {
+		Expect(store.Load()).To(Succeed())
+		Expect(secret.NewStore(file, keyFile).Set("password", "s3cr3t", nil)).To(Succeed())
+		Eventually(func() string {
+			return store.Redact("password s3cr3t")
+		}, 3*time.Second).Should(Equal("password " + secret.Redacted))
+	})
+})
@@ -22,6 +22,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cloudwan/gohan/audit"
 	"github.com/cloudwan/gohan/db"
 	"github.com/cloudwan/gohan/extension"
 	"github.com/cloudwan/gohan/extension/goext"
@@ -41,14 +42,20 @@ var (
 	exceptionPropertyIsNotExpectedTypeError = "Exception property '%s' is not '%s'"
 )
 
-func authorization(w http.ResponseWriter, r *http.Request, action, path string, s *schema.Schema, auth schema.Authorization) (*schema.Policy, *schema.Role) {
+func authorization(w http.ResponseWriter, r *http.Request, ctx middleware.Context, action, path string, s *schema.Schema, auth schema.Authorization) (*schema.Policy, *schema.Role) {
 	manager := schema.GetManager()
 	log.Debug("[authorization*] %s %v", action, auth)
 	if auth == nil {
 		return schema.NewEmptyPolicy(), nil
 	}
-	policy, role := manager.PolicyValidate(action, path, auth)
-	if policy == nil {
+	policy, role := manager.MatchPolicy(action, path, auth)
+	if audit.Enabled() {
+		request, _ := ctx["audit_request"].(*audit.Request)
+		record := audit.NewRecord(auth, action, path, policy, role).WithRequest(request)
+		record.TraceID, _ = ctx["trace_id"].(string)
+		audit.Log(record)
+	}
+	if policy == nil || policy.IsDeny() {
 		log.Debug("No policy match: %s %s", action, path)
 		return nil, nil
 	}
@@ -204,6 +211,7 @@ func fillInContext(context middleware.Context, db db.DB,
 	context["sync"] = sync
 	context["db"] = db
 	context["identity_service"] = identityService
+	traceExtensions(context, r, w)
 }
 
 func mustGetSchema(manager *schema.Manager, schemaID string) *schema.Schema {
@@ -228,7 +236,7 @@ func MapRouteBySchema(server *Server, dataStore db.DB, s *schema.Schema) {
 
 	//load extension environments
 	environmentManager := extension.GetManager()
-	if _, ok := environmentManager.GetEnvironment(s.ID); !ok {
+	if !environmentManager.HasEnvironment(s.ID) {
 		env, err := server.NewEnvironmentForPath(s.ID, pluralURL)
 		if err != nil {
 			log.Fatal(fmt.Sprintf("[%s] %v", pluralURL, err))
@@ -416,7 +424,7 @@ func MapRouteBySchemas(server *Server, dataStore db.DB) {
 	route := server.martini
 	log.Debug("[Initializing Routes]")
 	schemaManager := schema.GetManager()
-	route.Get("/_all", func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization, ctx middleware.Context) {
+	route.Get("/_all", middleware.Authorization(schema.ActionRead), func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization, ctx middleware.Context) {
 		responses := make(map[string]interface{})
 		context := map[string]interface{}{
 			"path":          r.URL.Path,
@@ -426,7 +434,7 @@ func MapRouteBySchemas(server *Server, dataStore db.DB) {
 			"trace_id":      ctx["trace_id"],
 		}
 		for _, s := range schemaManager.Schemas() {
-			policy, role := authorization(w, r, schema.ActionRead, s.GetPluralURL(), s, auth)
+			policy, role := authorization(w, r, ctx, schema.ActionRead, s.GetPluralURL(), s, auth)
 			if policy == nil {
 				continue
 			}
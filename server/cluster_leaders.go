@@ -0,0 +1,60 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/sync/election"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+// mapClusterRoutes maps admin endpoints listing leaders of cluster roles
+// and requesting their resignation
+func mapClusterRoutes(server *Server) {
+	server.martini.Get("/_cluster/leaders", func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Cluster leaders are allowed only for admin", http.StatusForbidden)
+			return
+		}
+		leaders, err := election.List(r.Context(), server.sync)
+		if err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"leaders": leaders})
+	})
+
+	server.martini.Post("/_cluster/leaders/**/resign", func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Cluster leaders are allowed only for admin", http.StatusForbidden)
+			return
+		}
+		leader, err := election.RequestResign(r.Context(), server.sync, p["_1"])
+		if err == election.ErrNoLeader {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"leader": leader})
+	})
+}
@@ -0,0 +1,363 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
+	"github.com/cloudwan/gohan/sync/namespace"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+)
+
+const (
+	compactionLock               = lockPath + "/compaction"
+	compactionStatusPath         = "/gohan/cluster/compaction"
+	defaultCompactionInterval    = 5 * time.Minute
+	defaultCompactionRetainCount = 10000
+)
+
+// CompactionResult describes a compaction of the sync backend revision history
+type CompactionResult struct {
+	CurrentRevision int64 `json:"current_revision"`
+	// Revision is the revision history was compacted to, revisions before it are dropped.
+	// It's 0 when nothing was compacted.
+	Revision int64 `json:"revision"`
+	// HeldBackBy is the watched path whose stored revision prevented compacting further
+	HeldBackBy  string     `json:"held_back_by,omitempty"`
+	CompactedAt *time.Time `json:"compacted_at,omitempty"`
+}
+
+type revisionSample struct {
+	revision int64
+	at       time.Time
+}
+
+// Compactor periodically compacts the revision history of the sync backend, keeping
+// the last "retain_revisions" revisions and/or revisions of the last "retention" period.
+// Only one node in the cluster compacts at a time. History still needed by a PathWatcher,
+// i.e. after the revision it stored, is kept unless "past_watchers" is set.
+type Compactor struct {
+	sync            gohan_sync.Sync
+	election        *election.Election
+	interval        time.Duration
+	retainRevisions int64
+	retention       time.Duration
+	pastWatchers    bool
+
+	mu            sync.Mutex
+	samples       []revisionSample
+	lastCompacted int64
+}
+
+// NewCompactor creates a compactor from "compaction" config
+func NewCompactor(sync gohan_sync.Sync) *Compactor {
+	config := util.GetConfig()
+	return &Compactor{
+		sync:            sync,
+		election:        election.New(sync, "compaction", compactionLock),
+		interval:        config.GetDuration("compaction/interval", defaultCompactionInterval),
+		retainRevisions: int64(config.GetInt("compaction/retain_revisions", defaultCompactionRetainCount)),
+		retention:       config.GetDuration("compaction/retention", 0),
+		pastWatchers:    config.GetBool("compaction/past_watchers", false),
+	}
+}
+
+// NewCompactorFromServer is a constructor for Compactor
+func NewCompactorFromServer(server *Server) *Compactor {
+	return NewCompactor(server.sync)
+}
+
+// Run compacts the sync backend every interval until the context is canceled
+func (compactor *Compactor) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	ticker := time.NewTicker(compactor.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := compactor.lockAndCompact(ctx); err != nil {
+				log.Error("Compaction failed: %s", err)
+			}
+		}
+	}
+}
+
+func (compactor *Compactor) lockAndCompact(ctx context.Context) error {
+	// every node samples revisions, so that time based retention works right after a takeover
+	current, err := compactor.sample(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := compactor.election.Campaign(ctx, false); err != nil {
+		log.Debug("Compaction is running on another node: %s", err)
+		return nil
+	}
+	defer compactor.election.Resign(context.Background())
+
+	_, err = compactor.compact(ctx, current, compactor.retainedRevision(current, time.Now()))
+	return err
+}
+
+// Compact compacts the revision history according to the retention
+func (compactor *Compactor) Compact(ctx context.Context) (*CompactionResult, error) {
+	current, err := compactor.sample(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return compactor.compact(ctx, current, compactor.retainedRevision(current, time.Now()))
+}
+
+// CompactTo compacts the revision history before the revision, regardless of the retention
+func (compactor *Compactor) CompactTo(ctx context.Context, revision int64) (*CompactionResult, error) {
+	current, err := compactor.sample(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if revision > current {
+		return nil, fmt.Errorf("revision %d is after the current revision %d", revision, current)
+	}
+	return compactor.compact(ctx, current, revision)
+}
+
+func (compactor *Compactor) sample(ctx context.Context) (int64, error) {
+	revisioner, ok := compactor.sync.(gohan_sync.Revisioner)
+	if !ok {
+		return 0, fmt.Errorf("sync backend doesn't report revisions")
+	}
+	current, err := revisioner.CurrentRevision(ctx)
+	if err != nil {
+		return 0, err
+	}
+	metrics.UpdateGauge(current, "compaction.current_revision")
+
+	compactor.mu.Lock()
+	defer compactor.mu.Unlock()
+	now := time.Now()
+	compactor.samples = append(compactor.samples, revisionSample{revision: current, at: now})
+	// keep the newest sample older than the retention, it's the one compacted to
+	expired := 0
+	for expired+1 < len(compactor.samples) && now.Sub(compactor.samples[expired+1].at) >= compactor.retention {
+		expired++
+	}
+	compactor.samples = compactor.samples[expired:]
+	return current, nil
+}
+
+// retainedRevision returns the revision to compact to, so that the retention is kept,
+// or 0 if nothing can be compacted yet
+func (compactor *Compactor) retainedRevision(current int64, now time.Time) int64 {
+	revision := int64(0)
+	if compactor.retainRevisions > 0 {
+		revision = current - compactor.retainRevisions
+	}
+	if compactor.retention > 0 {
+		byTime := int64(0)
+		compactor.mu.Lock()
+		for _, sample := range compactor.samples {
+			if now.Sub(sample.at) >= compactor.retention {
+				byTime = sample.revision
+			}
+		}
+		compactor.mu.Unlock()
+		if compactor.retainRevisions <= 0 || byTime < revision {
+			revision = byTime
+		}
+	}
+	return revision
+}
+
+func (compactor *Compactor) compact(ctx context.Context, current, revision int64) (*CompactionResult, error) {
+	result := &CompactionResult{CurrentRevision: current}
+	if !compactor.pastWatchers {
+		watched, path, err := compactor.lowestWatchedRevision(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// a watcher resumes from the revision after the stored one
+		if path != "" && watched+1 < revision {
+			log.Debug("Compaction to %d is held back by the watcher of %s at %d", revision, path, watched)
+			metrics.UpdateGauge(revision-watched-1, "compaction.held_back_revisions")
+			revision = watched + 1
+			result.HeldBackBy = path
+		} else {
+			metrics.UpdateGauge(0, "compaction.held_back_revisions")
+		}
+	}
+
+	lastCompacted, err := compactor.lastCompactedRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if revision <= lastCompacted {
+		return result, nil
+	}
+
+	if err := compactor.sync.Compact(ctx, revision); err != nil && !isCompactedError(err) {
+		metrics.UpdateCounter(1, "compaction.errors")
+		return nil, err
+	}
+	now := time.Now()
+	result.Revision = revision
+	result.CompactedAt = &now
+
+	compactor.mu.Lock()
+	compactor.lastCompacted = revision
+	compactor.mu.Unlock()
+	if data, err := json.Marshal(result); err == nil {
+		if err := compactor.sync.Update(ctx, compactionStatusPath, string(data)); err != nil {
+			log.Warning("Failed to store compaction status: %s", err)
+		}
+	}
+	log.Info("Compacted sync revision history before %d, current revision is %d", revision, current)
+	metrics.UpdateCounter(1, "compaction.compacted")
+	metrics.UpdateGauge(revision, "compaction.compacted_revision")
+	return result, nil
+}
+
+// lastCompactedRevision returns the revision compacted to by this or another node
+func (compactor *Compactor) lastCompactedRevision(ctx context.Context) (int64, error) {
+	compactor.mu.Lock()
+	lastCompacted := compactor.lastCompacted
+	compactor.mu.Unlock()
+	if lastCompacted > 0 {
+		return lastCompacted, nil
+	}
+	status, err := GetCompactionStatus(ctx, compactor.sync)
+	if err != nil || status == nil {
+		return 0, err
+	}
+	return status.Revision, nil
+}
+
+// lowestWatchedRevision returns the lowest revision stored by path watchers, with its path
+func (compactor *Compactor) lowestWatchedRevision(ctx context.Context) (int64, string, error) {
+	node, err := compactor.sync.Fetch(ctx, SyncWatchRevisionPrefix)
+	if err == gohan_sync.KeyNotFound {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	var (
+		lowest int64
+		path   string
+		visit  func(node *gohan_sync.Node)
+	)
+	visit = func(node *gohan_sync.Node) {
+		if len(node.Children) == 0 && strings.HasPrefix(node.Key, SyncWatchRevisionPrefix+"/") {
+			revision, err := strconv.ParseInt(node.Value, 10, 64)
+			if err == nil && (path == "" || revision < lowest) {
+				lowest, path = revision, strings.TrimPrefix(node.Key, SyncWatchRevisionPrefix)
+			}
+		}
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+	visit(node)
+	return lowest, path, nil
+}
+
+// isCompactedError checks if compacting failed because the revision is already compacted,
+// e.g. by an operator. Backends return the etcd error message.
+func isCompactedError(err error) bool {
+	return strings.Contains(err.Error(), "required revision has been compacted")
+}
+
+// GetCompactionStatus returns the last compaction, or nil if the backend wasn't compacted yet
+func GetCompactionStatus(ctx context.Context, sync gohan_sync.Sync) (*CompactionResult, error) {
+	node, err := sync.Fetch(ctx, compactionStatusPath)
+	if err == gohan_sync.KeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status CompactionResult
+	if err := json.Unmarshal([]byte(node.Value), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// mapCompactionRoutes maps admin endpoints showing the last compaction and triggering one,
+// "?revision=" compacts to the revision regardless of the retention
+func mapCompactionRoutes(server *Server) {
+	server.martini.Get("/_cluster/compaction", func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Compaction is allowed only for admin", http.StatusForbidden)
+			return
+		}
+		status, err := GetCompactionStatus(r.Context(), server.sync)
+		if err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"compaction": status})
+	})
+
+	server.martini.Post("/_cluster/compaction", func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Compaction is allowed only for admin", http.StatusForbidden)
+			return
+		}
+		// the running compactor has sampled revisions for the time based retention
+		compactor := server.compactor
+		if compactor == nil {
+			middleware.HTTPJSONError(w, "Sync is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		var (
+			result *CompactionResult
+			err    error
+		)
+		if value := r.URL.Query().Get("revision"); value != "" {
+			revision, parseErr := strconv.ParseInt(value, 10, 64)
+			if parseErr != nil || revision <= 0 {
+				middleware.HTTPJSONError(w, "invalid revision", http.StatusBadRequest)
+				return
+			}
+			result, err = compactor.CompactTo(r.Context(), revision)
+		} else {
+			result, err = compactor.Compact(r.Context())
+		}
+		if err == namespace.ErrCompactionRefused {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"compaction": result})
+	})
+}
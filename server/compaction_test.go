@@ -0,0 +1,87 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	srv "github.com/cloudwan/gohan/server"
+	"github.com/cloudwan/gohan/sync/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compaction", func() {
+	var (
+		ctx       context.Context
+		sync      *memory.Sync
+		compactor *srv.Compactor
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		store, err := memory.NewStore("")
+		Expect(err).ToNot(HaveOccurred())
+		sync = memory.NewSync(store)
+		compactor = srv.NewCompactor(sync)
+
+		for i := 0; i < 20; i++ {
+			Expect(sync.Update(ctx, "/compacted/key", fmt.Sprintf(`{"i": %d}`, i))).To(Succeed())
+		}
+	})
+
+	It("should keep retained revisions", func() {
+		result, err := compactor.Compact(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.CurrentRevision).To(BeEquivalentTo(20))
+		Expect(result.Revision).To(BeEquivalentTo(15))
+
+		status, err := srv.GetCompactionStatus(ctx, sync)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Revision).To(BeEquivalentTo(15))
+
+		// another node doesn't compact again
+		result, err = srv.NewCompactor(sync).CompactTo(ctx, 15)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Revision).To(BeZero())
+	})
+
+	It("should not compact past revisions stored by watchers", func() {
+		Expect(sync.Update(ctx, srv.SyncWatchRevisionPrefix+"/watched/path", "3")).To(Succeed())
+
+		result, err := compactor.Compact(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Revision).To(BeEquivalentTo(4))
+		Expect(result.HeldBackBy).To(Equal("/watched/path"))
+
+		result, err = compactor.CompactTo(ctx, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Revision).To(BeZero())
+	})
+
+	It("should not compact to a future revision", func() {
+		_, err := compactor.CompactTo(ctx, 100)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should be allowed only for admin", func() {
+		testURL("GET", baseURL+"/_cluster/compaction", memberTokenID, nil, http.StatusForbidden)
+		testURL("POST", baseURL+"/_cluster/compaction", memberTokenID, nil, http.StatusForbidden)
+		testURL("GET", baseURL+"/_cluster/compaction", adminTokenID, nil, http.StatusOK)
+	})
+})
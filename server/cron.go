@@ -22,6 +22,7 @@ import (
 	"runtime/debug"
 	"strings"
 
+	"github.com/cloudwan/gohan/sync/election"
 	"github.com/cloudwan/gohan/util"
 	"github.com/robfig/cron"
 )
@@ -47,6 +48,7 @@ func startCRONProcess(server *Server) {
 		name := strings.TrimPrefix(path, "cron://")
 		log.Info("New job for %s / %s", path, timing)
 		lockKey := lockPath + "/" + name
+		jobElection := election.New(server.sync, "cron/"+name, lockKey)
 		jobLocks[lockKey] = make(chan int, 1)
 		jobLocks[lockKey] <- 1
 		env, err := server.NewEnvironmentForPath(name, path)
@@ -57,7 +59,7 @@ func startCRONProcess(server *Server) {
 		takeLock := func(ctx context.Context) error {
 			select {
 			case <-jobLocks[lockKey]:
-				_, err := server.sync.Lock(ctx, lockKey, false)
+				_, err := jobElection.Campaign(ctx, false)
 				if err != nil {
 					log.Debug("Failed to take ETCD lock")
 					jobLocks[lockKey] <- 1
@@ -82,8 +84,8 @@ func startCRONProcess(server *Server) {
 				}
 				log.Debug("Unlocking %s", lockKey)
 				jobLocks[lockKey] <- 1
-				if err := server.sync.Unlock(ctx, lockKey); err != nil {
-					log.Warning("CRON: unlocking etcd failed: %s", err)
+				if err := jobElection.Resign(ctx); err != nil {
+					log.Warning("CRON: resigning failed: %s", err)
 				}
 			}()
 
@@ -0,0 +1,390 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+const (
+	deadLetterSchemaID = "dead_letter"
+
+	// DeadLetterSourceSyncWriter marks events of the event table which could not be written to the sync backend
+	DeadLetterSourceSyncWriter = "sync_writer"
+	// DeadLetterSourcePathWatcher marks sync events which extensions failed to handle
+	DeadLetterSourcePathWatcher = "path_watcher"
+
+	defaultDeadLetterMaxAttempts = 5
+)
+
+var (
+	errDeadLetterNotFound = errors.New("dead letter not found")
+	errDeadLetterConflict = errors.New("the resource changed after the event was dead-lettered, retrying it would overwrite newer data")
+)
+
+// DeadLetterQueue stores events which failed to be processed after all attempts,
+// so that they don't block other events and can be inspected, retried or discarded by an admin
+type DeadLetterQueue struct {
+	db   db.DB
+	sync gohan_sync.Sync
+	// environments of watch events by their names, handling retried path watcher events
+	watchExtensions map[string]extension.Environment
+}
+
+// NewDeadLetterQueue creates a dead letter queue stored in the dead_letter table
+func NewDeadLetterQueue(dataStore db.DB, sync gohan_sync.Sync) *DeadLetterQueue {
+	return &DeadLetterQueue{db: dataStore, sync: sync}
+}
+
+// UseWatchExtensions makes retried path watcher events handled by the environments
+// of watch events, as they're by path watchers
+func (queue *DeadLetterQueue) UseWatchExtensions(extensions map[string]extension.Environment) {
+	queue.watchExtensions = extensions
+}
+
+func deadLetterSchema() *schema.Schema {
+	deadLetterSchema, ok := schema.GetManager().Schema(deadLetterSchemaID)
+	if !ok {
+		panic("Schema 'dead_letter' not found. Check if gohan.json is loaded")
+	}
+	return deadLetterSchema
+}
+
+// pathWatcherPayload is a sync event dead-lettered by the path watcher of Path
+type pathWatcherPayload struct {
+	Path     string                 `json:"path"`
+	Action   string                 `json:"action"`
+	Key      string                 `json:"key"`
+	Value    string                 `json:"value"`
+	Data     map[string]interface{} `json:"data"`
+	Revision int64                  `json:"revision"`
+}
+
+func newDeadLetter(source, key string, payload interface{}, cause error, attempts int) (*schema.Resource, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return schema.NewResource(deadLetterSchema(), map[string]interface{}{
+		"source":     source,
+		"sync_key":   key,
+		"payload":    string(data),
+		"last_error": cause.Error(),
+		"attempts":   attempts,
+		"timestamp":  time.Now().Unix(),
+	}), nil
+}
+
+// addInTx stores a dead letter within a transaction
+func (queue *DeadLetterQueue) addInTx(ctx context.Context, tx transaction.Transaction, source, key string, payload interface{}, cause error, attempts int) error {
+	resource, err := newDeadLetter(source, key, payload, cause, attempts)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Create(ctx, resource); err != nil {
+		return err
+	}
+	metrics.UpdateCounter(1, "dead_letter.%s.added", source)
+	log.Error("Dead-lettered %s event on %s after %d attempts: %s", source, key, attempts, cause)
+	return nil
+}
+
+// Add stores a dead letter
+func (queue *DeadLetterQueue) Add(ctx context.Context, source, key string, payload interface{}, cause error, attempts int) error {
+	return db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		return queue.addInTx(ctx, tx, source, key, payload, cause, attempts)
+	}, transaction.Context(ctx))
+}
+
+// List returns dead letters, optionally of a single source, oldest first
+func (queue *DeadLetterQueue) List(ctx context.Context, source string) (list []*schema.Resource, err error) {
+	deadLetterSchema := deadLetterSchema()
+	filter := transaction.Filter{}
+	if source != "" {
+		filter["source"] = source
+	}
+	paginator, _ := pagination.NewPaginator(
+		pagination.OptionKey(deadLetterSchema, "id"),
+		pagination.OptionOrder(pagination.ASC),
+	)
+	err = db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		list, _, err = tx.List(ctx, deadLetterSchema, filter, nil, paginator)
+		return err
+	}, transaction.Context(ctx))
+	return
+}
+
+func (queue *DeadLetterQueue) fetchInTx(ctx context.Context, tx transaction.Transaction, id int) (*schema.Resource, error) {
+	resource, err := tx.Fetch(ctx, deadLetterSchema(), transaction.Filter{"id": id}, nil)
+	if err == transaction.ErrResourceNotFound {
+		return nil, errDeadLetterNotFound
+	}
+	return resource, err
+}
+
+// Get returns a dead letter
+func (queue *DeadLetterQueue) Get(ctx context.Context, id int) (resource *schema.Resource, err error) {
+	err = db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		resource, err = queue.fetchInTx(ctx, tx, id)
+		return err
+	}, transaction.Context(ctx))
+	return
+}
+
+// Discard deletes a dead letter
+func (queue *DeadLetterQueue) Discard(ctx context.Context, id int) error {
+	return db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		resource, err := queue.fetchInTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		metrics.UpdateCounter(1, "dead_letter.%s.discarded", resource.Get("source"))
+		return tx.Delete(ctx, deadLetterSchema(), id)
+	}, transaction.Context(ctx))
+}
+
+// Retry requeues a dead letter and deletes it.
+// Sync writer events are appended to the event table again, which is allowed only when
+// the resource was not changed since. Path watcher events are handled again by the extensions
+// of the watch event, without changing the key, so that other watchers of it aren't notified.
+func (queue *DeadLetterQueue) Retry(ctx context.Context, id int) error {
+	resource, err := queue.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if resource.Get("source") == DeadLetterSourcePathWatcher {
+		// extensions may use the database, so the event is handled outside of a transaction
+		payload, _ := resource.Get("payload").(string)
+		if err := queue.retryPathWatcherEvent(ctx, payload); err != nil {
+			return err
+		}
+		return db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+			metrics.UpdateCounter(1, "dead_letter.%s.retried", DeadLetterSourcePathWatcher)
+			return tx.Delete(ctx, deadLetterSchema(), id)
+		}, transaction.Context(ctx))
+	}
+
+	var eventID int64
+	err = db.WithinTx(queue.db, func(tx transaction.Transaction) error {
+		resource, err := queue.fetchInTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		source, _ := resource.Get("source").(string)
+		payload, _ := resource.Get("payload").(string)
+		switch source {
+		case DeadLetterSourceSyncWriter:
+			eventID, err = queue.retrySyncWriterEvent(ctx, tx, payload)
+		default:
+			err = fmt.Errorf("unknown dead letter source %s", source)
+		}
+		if err != nil {
+			return err
+		}
+		metrics.UpdateCounter(1, "dead_letter.%s.retried", source)
+		return tx.Delete(ctx, deadLetterSchema(), id)
+	}, transaction.Context(ctx))
+	if err == nil && eventID != 0 {
+		// wake up the sync writer, unless nothing listens in this process
+		select {
+		case transactionCommitted <- eventID:
+		default:
+		}
+	}
+	return err
+}
+
+func (queue *DeadLetterQueue) retrySyncWriterEvent(ctx context.Context, tx transaction.Transaction, payload string) (int64, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		return 0, fmt.Errorf("invalid dead letter payload: %s", err)
+	}
+	delete(data, "id")
+	for _, property := range []string{"version", "timestamp"} {
+		if number, ok := data[property].(float64); ok {
+			data[property] = int(number)
+		}
+	}
+	stale, err := isStaleSyncWriterEvent(ctx, tx, data)
+	if err != nil {
+		return 0, err
+	}
+	if stale {
+		return 0, errDeadLetterConflict
+	}
+	eventSchema, _ := schema.GetManager().Schema("event")
+	result, err := tx.Create(ctx, schema.NewResource(eventSchema, data))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// isStaleSyncWriterEvent tells whether the resource was changed after the event was dead-lettered,
+// so that writing the event again would roll the sync backend back to older data
+func isStaleSyncWriterEvent(ctx context.Context, tx transaction.Transaction, data map[string]interface{}) (bool, error) {
+	path, _ := data["path"].(string)
+	eventType, _ := data["type"].(string)
+	s := schema.GetSchemaByURLPath(path)
+	if s == nil {
+		return true, nil
+	}
+	id := strings.TrimPrefix(path, s.URL+"/")
+	resource, err := tx.Fetch(ctx, s, transaction.IDFilter(id), nil)
+	if err == transaction.ErrResourceNotFound {
+		return eventType != "delete", nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if eventType == "delete" {
+		return true, nil
+	}
+	if s.StateVersioning() {
+		state, err := tx.StateFetch(ctx, s, transaction.IDFilter(id))
+		if err != nil {
+			return false, err
+		}
+		version, _ := data["version"].(int)
+		return int64(version) != state.ConfigVersion, nil
+	}
+	body, err := resource.JSONString()
+	if err != nil {
+		return false, err
+	}
+	return !jsonStringsEqual(data["body"], body), nil
+}
+
+func (queue *DeadLetterQueue) retryPathWatcherEvent(ctx context.Context, payload string) error {
+	var event pathWatcherPayload
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return fmt.Errorf("invalid dead letter payload: %s", err)
+	}
+	env, ok := watchExtension(queue.watchExtensions, event.Key)
+	if !ok {
+		return fmt.Errorf("no watch extension handles %s watched by %s", event.Key, event.Path)
+	}
+	log.Info("Retrying %s event on %s watched by %s", event.Action, event.Key, event.Path)
+	return env.Clone().HandleEvent("notification", notificationContext(ctx, &gohan_sync.Event{
+		Action:   event.Action,
+		Key:      event.Key,
+		Value:    event.Value,
+		Data:     event.Data,
+		Revision: event.Revision,
+	}))
+}
+
+func deadLetterMaxAttempts(key string, defaultValue int) int {
+	maxAttempts := util.GetConfig().GetInt(key, defaultValue)
+	if maxAttempts < 1 {
+		return 1
+	}
+	return maxAttempts
+}
+
+func deadLetterHTTPError(w http.ResponseWriter, err error) {
+	switch err {
+	case errDeadLetterNotFound:
+		middleware.HTTPJSONError(w, err.Error(), http.StatusNotFound)
+	case errDeadLetterConflict:
+		middleware.HTTPJSONError(w, err.Error(), http.StatusConflict)
+	default:
+		middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func mapDeadLetterRoutes(server *Server) {
+	queue := NewDeadLetterQueue(server.db, server.sync)
+	if server.sync != nil {
+		queue.UseWatchExtensions(syncWatchExtensions(server))
+	}
+
+	withDeadLetter := func(fn func(w http.ResponseWriter, r *http.Request, id int)) martini.Handler {
+		return func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization) {
+			if !auth.IsAdmin() {
+				middleware.HTTPJSONError(w, "Dead letters are allowed only for admin", http.StatusForbidden)
+				return
+			}
+			id, err := strconv.Atoi(p["id"])
+			if err != nil {
+				middleware.HTTPJSONError(w, "invalid dead letter id", http.StatusBadRequest)
+				return
+			}
+			fn(w, r, id)
+		}
+	}
+
+	server.martini.Get("/_dead_letters", func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Dead letters are allowed only for admin", http.StatusForbidden)
+			return
+		}
+		list, err := queue.List(r.Context(), r.URL.Query().Get("source"))
+		if err != nil {
+			deadLetterHTTPError(w, err)
+			return
+		}
+		deadLetters := make([]interface{}, 0, len(list))
+		for _, resource := range list {
+			deadLetters = append(deadLetters, resource.Data())
+		}
+		routes.ServeJson(w, map[string]interface{}{"dead_letters": deadLetters})
+	})
+
+	server.martini.Get("/_dead_letters/:id", withDeadLetter(func(w http.ResponseWriter, r *http.Request, id int) {
+		resource, err := queue.Get(r.Context(), id)
+		if err != nil {
+			deadLetterHTTPError(w, err)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"dead_letter": resource.Data()})
+	}))
+
+	server.martini.Post("/_dead_letters/:id/retry", withDeadLetter(func(w http.ResponseWriter, r *http.Request, id int) {
+		if err := queue.Retry(r.Context(), id); err != nil {
+			deadLetterHTTPError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	server.martini.Delete("/_dead_letters/:id", withDeadLetter(func(w http.ResponseWriter, r *http.Request, id int) {
+		if err := queue.Discard(r.Context(), id); err != nil {
+			deadLetterHTTPError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
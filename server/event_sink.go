@@ -0,0 +1,270 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/eventsink"
+	"github.com/cloudwan/gohan/extension/goext/filter"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
+	"github.com/cloudwan/gohan/util"
+)
+
+const sinkEventSchemaID = "sink_event"
+
+func sinkEventSchema() *schema.Schema {
+	sinkEventSchema, _ := schema.GetManager().Schema(sinkEventSchemaID)
+	return sinkEventSchema
+}
+
+// loadEventSinkConfigs reads "event_sinks", invalid config is reported when the server starts
+func loadEventSinkConfigs() []*eventsink.Config {
+	configs, err := eventsink.LoadConfigs(util.GetConfig())
+	if err != nil {
+		log.Error("Invalid event sinks: %s", err)
+		return nil
+	}
+	return configs
+}
+
+// queueSinkEventsInTx queues events processed by the sync writer for each configured sink routing their schema,
+// so that every sink consumes them at its own pace. Events of a sink whose queue is full are dropped.
+func queueSinkEventsInTx(ctx context.Context, tx transaction.Transaction, sinks []*eventsink.Config, events []*schema.Resource) error {
+	if len(sinks) == 0 {
+		return nil
+	}
+	sinkEventSchema := sinkEventSchema()
+	queued := map[string]int{}
+	dropped := map[string]int64{}
+	for _, event := range events {
+		path, _ := event.Get("path").(string)
+		schemaID := ""
+		if resourceSchema := schema.GetSchemaByURLPath(path); resourceSchema != nil {
+			schemaID = resourceSchema.ID
+		}
+		for _, sink := range sinks {
+			if !sink.Routes(schemaID) {
+				continue
+			}
+			if sink.MaxQueued > 0 {
+				depth, ok := queued[sink.Name]
+				if !ok {
+					count, err := tx.Count(ctx, sinkEventSchema, transaction.Filter{"sink": sink.Name})
+					if err != nil {
+						return fmt.Errorf("failed to count events queued for sink %s: %s", sink.Name, err)
+					}
+					depth = int(count)
+				}
+				if depth >= sink.MaxQueued {
+					dropped[sink.Name]++
+					queued[sink.Name] = depth
+					continue
+				}
+				queued[sink.Name] = depth + 1
+			}
+			resource := schema.NewResource(sinkEventSchema, map[string]interface{}{
+				"sink":      sink.Name,
+				"event_id":  event.Get("id"),
+				"type":      event.Get("type"),
+				"path":      path,
+				"version":   event.Get("version"),
+				"timestamp": event.Get("timestamp"),
+				"body":      event.Get("body"),
+			})
+			if _, err := tx.Create(ctx, resource); err != nil {
+				return fmt.Errorf("failed to queue event for sink %s: %s", sink.Name, err)
+			}
+		}
+	}
+	for name, count := range dropped {
+		log.Error("Queue of event sink %s is full, dropped %d events", name, count)
+		metrics.UpdateCounter(count, "event_sink.%s.dropped", name)
+	}
+	return nil
+}
+
+// EventSinkWorker delivers events queued for a sink. Only one node in the cluster
+// delivers events of a sink at a time. Delivered events are removed from the queue
+// of the sink, events of a failed batch are sent again after a backoff.
+type EventSinkWorker struct {
+	config   *eventsink.Config
+	sink     eventsink.EventSink
+	db       db.DB
+	election *election.Election
+}
+
+// NewEventSinkWorker creates a worker delivering events to the sink
+func NewEventSinkWorker(sync gohan_sync.Sync, db db.DB, config *eventsink.Config, sink eventsink.EventSink) *EventSinkWorker {
+	return &EventSinkWorker{
+		config:   config,
+		sink:     sink,
+		db:       db,
+		election: election.New(sync, "event_sink/"+config.Name, lockPath+"/event_sink/"+config.Name),
+	}
+}
+
+// Run delivers events until the context is canceled
+func (worker *EventSinkWorker) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+	defer worker.sink.Close()
+
+	for {
+		if err := worker.run(ctx); err != nil {
+			log.Error("Event sink %s was interrupted: %s", worker.config.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(worker.config.Backoff):
+		}
+	}
+}
+
+func (worker *EventSinkWorker) run(ctx context.Context) error {
+	lost, err := worker.election.Campaign(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer worker.election.Resign(context.Background())
+
+	for {
+		delivered, err := worker.Deliver(ctx)
+		if err != nil {
+			return err
+		}
+		wait := worker.config.Interval
+		if delivered == worker.config.BatchSize {
+			// more events may be queued
+			wait = 0
+		}
+		select {
+		case <-lost:
+			return fmt.Errorf("lost lock for event sink %s", worker.config.Name)
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Deliver sends the oldest batch of events queued for the sink, and returns the number of delivered events
+func (worker *EventSinkWorker) Deliver(ctx context.Context) (int, error) {
+	name := worker.config.Name
+	sinkEventSchema := sinkEventSchema()
+	var (
+		queued []*schema.Resource
+		depth  uint64
+	)
+	if err := db.WithinTx(worker.db, func(tx transaction.Transaction) error {
+		paginator, _ := pagination.NewPaginator(
+			pagination.OptionKey(sinkEventSchema, "id"),
+			pagination.OptionOrder(pagination.ASC),
+			pagination.OptionLimit(uint64(worker.config.BatchSize)),
+		)
+		var err error
+		queued, depth, err = tx.List(ctx, sinkEventSchema, transaction.Filter{"sink": name}, nil, paginator)
+		return err
+	}, transaction.Context(ctx)); err != nil {
+		return 0, err
+	}
+	metrics.UpdateGauge(int64(depth), "event_sink.%s.queue_depth", name)
+	if len(queued) == 0 {
+		return 0, nil
+	}
+
+	events := make([]*eventsink.Event, 0, len(queued))
+	for _, resource := range queued {
+		events = append(events, sinkEventFromResource(resource))
+	}
+	if err := worker.sink.Send(ctx, events); err != nil {
+		metrics.UpdateCounter(1, "event_sink.%s.failures", name)
+		return 0, fmt.Errorf("sending %d events failed: %s", len(events), err)
+	}
+
+	if err := db.WithinTx(worker.db, func(tx transaction.Transaction) error {
+		for _, resource := range queued {
+			if err := tx.Delete(ctx, sinkEventSchema, resource.Get("id")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, transaction.Context(ctx)); err != nil {
+		return 0, err
+	}
+	metrics.UpdateCounter(int64(len(events)), "event_sink.%s.delivered", name)
+	return len(events), nil
+}
+
+func sinkEventFromResource(resource *schema.Resource) *eventsink.Event {
+	path, _ := resource.Get("path").(string)
+	event := &eventsink.Event{Path: path}
+	event.ID, _ = toInt64(resource.Get("event_id"))
+	event.Type, _ = resource.Get("type").(string)
+	event.Version, _ = toInt64(resource.Get("version"))
+	event.Timestamp, _ = toInt64(resource.Get("timestamp"))
+	if resourceSchema := schema.GetSchemaByURLPath(path); resourceSchema != nil {
+		event.SchemaID = resourceSchema.ID
+	}
+	if body, ok := resource.Get("body").(string); ok && body != "" {
+		if err := json.Unmarshal([]byte(body), &event.Resource); err != nil {
+			log.Warning("Invalid body of event %d queued for a sink: %s", event.ID, err)
+		}
+	}
+	return event
+}
+
+// PurgeUnknownSinkEvents removes events queued for sinks other than the given ones,
+// which were removed from the config, as no worker delivers them
+func PurgeUnknownSinkEvents(ctx context.Context, dataStore db.DB, sinks []*eventsink.Config) error {
+	names := make([]interface{}, 0, len(sinks))
+	for _, sink := range sinks {
+		names = append(names, sink.Name)
+	}
+	return db.WithinTx(dataStore, func(tx transaction.Transaction) error {
+		return tx.DeleteFilter(ctx, sinkEventSchema(), transaction.Filter(filter.And(filter.NotIn("sink", names...))))
+	}, transaction.Context(ctx))
+}
+
+// startEventSinks starts a worker for each sink listed under "event_sinks", and returns
+// configs of all listed sinks. Events of a sink which failed to start are still queued,
+// as other nodes may deliver them.
+func (server *Server) startEventSinks() []*eventsink.Config {
+	configs := loadEventSinkConfigs()
+	for _, config := range configs {
+		sink, err := eventsink.New(config)
+		if err != nil {
+			log.Error("Failed to create event sink %s: %s", config.Name, err)
+			continue
+		}
+		server.startSyncProcess(NewEventSinkWorker(server.sync, server.db, config, sink))
+	}
+	if err := PurgeUnknownSinkEvents(server.masterCtx, server.db, configs); err != nil {
+		log.Error("Failed to purge events of removed sinks: %s", err)
+	}
+	return configs
+}
@@ -33,10 +33,13 @@ package server
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/goja"
 	"github.com/cloudwan/gohan/extension/goplugin"
 	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/extension/wasm"
 	"github.com/cloudwan/gohan/schema"
 )
 
@@ -46,6 +49,10 @@ func (server *Server) newEnvironment(name string) extension.Environment {
 		switch extension {
 		case "javascript":
 			envs = append(envs, otto.NewEnvironment(name, server.db, server.keystoneIdentity, server.sync))
+		case "goja":
+			envs = append(envs, goja.NewEnvironment(name, server.db, server.keystoneIdentity, server.sync))
+		case "wasm":
+			envs = append(envs, wasm.NewEnvironment(name, server.db, server.keystoneIdentity, server.sync))
 		case "goext":
 			env := goplugin.NewEnvironment(name, nil, nil)
 			env.SetDatabase(server.db)
@@ -60,9 +67,79 @@ func (server *Server) newEnvironment(name string) extension.Environment {
 func (server *Server) NewEnvironmentForPath(name string, path string) (env extension.Environment, err error) {
 	manager := schema.GetManager()
 	env = server.newEnvironment(name)
-	err = env.LoadExtensionsForPath(manager.Extensions, manager.TimeLimit, manager.TimeLimits, path)
+	err = env.LoadExtensionsForPath(manager.GetExtensions(), manager.TimeLimit, manager.TimeLimits, path)
 	if err != nil {
 		err = fmt.Errorf("Extensions parsing error: %v", err)
 	}
 	return
 }
+
+// registerEnvironmentForPath registers an environment of extensions for path in extension manager
+// unless it's registered already, so that it's replaced when extensions are reloaded
+func (server *Server) registerEnvironmentForPath(name string, path string) error {
+	server.pathEnvironmentsMu.Lock()
+	defer server.pathEnvironmentsMu.Unlock()
+
+	environmentManager := extension.GetManager()
+	if !environmentManager.HasEnvironment(name) {
+		env, err := server.NewEnvironmentForPath(name, path)
+		if err != nil {
+			return err
+		}
+		if err := environmentManager.RegisterEnvironment(name, env); err != nil {
+			return err
+		}
+	}
+	server.pathEnvironments[name] = path
+	return nil
+}
+
+// registeredPathEnvironments returns paths of environments registered by registerEnvironmentForPath
+func (server *Server) registeredPathEnvironments() map[string]string {
+	server.pathEnvironmentsMu.Lock()
+	defer server.pathEnvironmentsMu.Unlock()
+
+	paths := make(map[string]string, len(server.pathEnvironments))
+	for name, path := range server.pathEnvironments {
+		paths[name] = path
+	}
+	return paths
+}
+
+// managedEnvironment handles events by a clone of the environment registered in extension manager,
+// so that events are handled by the latest version of reloaded extensions
+type managedEnvironment struct {
+	name string
+}
+
+// LoadExtensionsForPath fails, extensions are loaded by the registered environment
+func (env managedEnvironment) LoadExtensionsForPath(extensions []*schema.Extension, timeLimit time.Duration, timeLimits []*schema.PathEventTimeLimit, path string) error {
+	return fmt.Errorf("Extensions of environment %s are loaded when it's registered", env.name)
+}
+
+// HandleEvent handles the event by a clone of the registered environment
+func (env managedEnvironment) HandleEvent(event string, context map[string]interface{}) error {
+	environmentManager := extension.GetManager()
+	registered, ok := environmentManager.AcquireEnvironment(env.name)
+	if !ok {
+		return fmt.Errorf("No environment registered for %s", env.name)
+	}
+	defer environmentManager.ReleaseEnvironment(registered)
+	return registered.HandleEvent(event, context)
+}
+
+// Clone returns the environment itself, clones of the registered environment are made per event
+func (env managedEnvironment) Clone() extension.Environment {
+	return env
+}
+
+// IsEventHandled returns whether the event is handled by the registered environment
+func (env managedEnvironment) IsEventHandled(event string, context map[string]interface{}) bool {
+	environmentManager := extension.GetManager()
+	registered, ok := environmentManager.AcquireEnvironment(env.name)
+	if !ok {
+		return false
+	}
+	defer environmentManager.ReleaseEnvironment(registered)
+	return registered.IsEventHandled(event, context)
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/drone/routes"
+)
+
+const defaultHandlerProfilesLimit = 10
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+// mapExtensionProfilingRoutes maps admin endpoints showing the slowest extension handlers,
+// "?limit=" limits their number and "?sort=" orders them by average, max or total duration
+func mapExtensionProfilingRoutes(server *Server) {
+	server.martini.Get("/_extensions/handlers", func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Extension profiles are allowed only for admin", http.StatusForbidden)
+			return
+		}
+		limit := defaultHandlerProfilesLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+				middleware.HTTPJSONError(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+		profiles, err := extension.HandlerProfiles(limit, r.URL.Query().Get("sort"))
+		if err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handlers := make([]map[string]interface{}, 0, len(profiles))
+		for _, profile := range profiles {
+			handlers = append(handlers, map[string]interface{}{
+				"environment": profile.Environment,
+				"schema":      profile.Schema,
+				"event":       profile.Event,
+				"name":        profile.Name,
+				"calls":       profile.Calls,
+				"errors":      profile.Errors,
+				"average_ms":  milliseconds(profile.Average()),
+				"max_ms":      milliseconds(profile.Max),
+				"total_ms":    milliseconds(profile.Total),
+			})
+		}
+		routes.ServeJson(w, map[string]interface{}{
+			"enabled":  extension.ProfilingEnabled(),
+			"handlers": handlers,
+		})
+	})
+
+	server.martini.Delete("/_extensions/handlers", func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Extension profiles are allowed only for admin", http.StatusForbidden)
+			return
+		}
+		extension.ClearHandlerProfiles()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudwan/gohan/extension"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extension profiling", func() {
+	const handlersURL = baseURL + "/_extensions/handlers"
+
+	BeforeEach(func() {
+		extension.ClearHandlerProfiles()
+		extension.SetProfiling(true, 0)
+	})
+
+	AfterEach(func() {
+		extension.SetProfiling(false, 0)
+		extension.ClearHandlerProfiles()
+	})
+
+	It("should show the slowest handlers", func() {
+		now := time.Now()
+		slow := extension.Handler{Environment: "otto", Schema: "network", Event: "pre_create_in_transaction", Name: "slow.js#0"}
+		fast := extension.Handler{Environment: "goplugin", Schema: "network", Event: "pre_create", Name: "main.fast@0"}
+		extension.ProfileHandler(slow, now.Add(-time.Second), "", true)
+		extension.ProfileHandler(fast, now, "", false)
+
+		result := testURL("GET", handlersURL+"?limit=1", adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("enabled", true))
+		handlers := result.(map[string]interface{})["handlers"].([]interface{})
+		Expect(handlers).To(HaveLen(1))
+		Expect(handlers[0]).To(HaveKeyWithValue("name", "slow.js#0"))
+		Expect(handlers[0]).To(HaveKeyWithValue("event", "pre_create_in_transaction"))
+		Expect(handlers[0]).To(HaveKeyWithValue("errors", BeEquivalentTo(1)))
+		Expect(handlers[0]).To(HaveKeyWithValue("average_ms", BeNumerically(">=", 1000)))
+
+		testURL("GET", handlersURL+"?sort=unknown", adminTokenID, nil, http.StatusBadRequest)
+
+		testURL("DELETE", handlersURL, adminTokenID, nil, http.StatusNoContent)
+		result = testURL("GET", handlersURL, adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("handlers", BeEmpty()))
+	})
+
+	It("should be allowed only for admin", func() {
+		testURL("GET", handlersURL, memberTokenID, nil, http.StatusForbidden)
+		testURL("DELETE", handlersURL, memberTokenID, nil, http.StatusForbidden)
+	})
+})
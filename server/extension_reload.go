@@ -0,0 +1,300 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+)
+
+const defaultExtensionReloadInterval = time.Second
+
+// ExtensionReloadStatus describes reloads of extensions
+type ExtensionReloadStatus struct {
+	// Reloads counts successful reloads
+	Reloads    int        `json:"reloads"`
+	ReloadedAt *time.Time `json:"reloaded_at,omitempty"`
+	// Error is the last failed reload, it's cleared by a successful one
+	Error *ExtensionReloadError `json:"error,omitempty"`
+}
+
+// ExtensionReloadError describes a failed reload, previous versions of extensions are kept active
+type ExtensionReloadError struct {
+	Files    []string  `json:"files"`
+	Message  string    `json:"message"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// ExtensionReloader watches sources of extensions, i.e. schema files with extensions
+// and files referenced by extension URLs, including go plugins. Changed extensions
+// are loaded into new environments of schemas and of job and sync watch paths they match,
+// which replace the environments registered in extension manager. Previous environments
+// are stopped once events handled by them are finished. When loading fails,
+// previous versions are kept active and the error is reported in status.
+type ExtensionReloader struct {
+	server   *Server
+	interval time.Duration
+
+	mu     sync.Mutex
+	files  map[string]fileVersion
+	status ExtensionReloadStatus
+}
+
+// NewExtensionReloader creates an extension reloader from "extension/hot_reload" config
+func NewExtensionReloader(server *Server) *ExtensionReloader {
+	reloader := &ExtensionReloader{
+		server:   server,
+		interval: util.GetConfig().GetDuration("extension/hot_reload/interval", defaultExtensionReloadInterval),
+		files:    map[string]fileVersion{},
+	}
+	reloader.changedFiles()
+	return reloader
+}
+
+// Run reloads changed extensions every interval until the context is canceled
+func (reloader *ExtensionReloader) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	ticker := time.NewTicker(reloader.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := reloader.Reload(); err != nil {
+				log.Error("Failed to reload extensions, keeping previous versions: %s", err)
+			}
+		}
+	}
+}
+
+// Status returns the status of reloads
+func (reloader *ExtensionReloader) Status() ExtensionReloadStatus {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	return reloader.status
+}
+
+// Reload reloads extensions with sources changed since the last check
+func (reloader *ExtensionReloader) Reload() error {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	changed := reloader.changedFiles()
+	if len(changed) == 0 {
+		return nil
+	}
+	files := make([]string, 0, len(changed))
+	for file := range changed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	log.Info("Reloading extensions, changed files: %s", strings.Join(files, ", "))
+
+	if err := reloader.reload(changed); err != nil {
+		reloader.status.Error = &ExtensionReloadError{
+			Files:    files,
+			Message:  err.Error(),
+			FailedAt: time.Now(),
+		}
+		return err
+	}
+	now := time.Now()
+	reloader.status.Reloads++
+	reloader.status.ReloadedAt = &now
+	reloader.status.Error = nil
+	return nil
+}
+
+// changedFiles returns sources of extensions changed since the last check,
+// sources of extensions registered in the meantime are recorded without being reported
+func (reloader *ExtensionReloader) changedFiles() map[string]bool {
+	changed := map[string]bool{}
+	files := map[string]fileVersion{}
+	for _, ext := range schema.GetManager().GetExtensions() {
+		for _, file := range extensionSources(ext) {
+			if _, ok := files[file]; ok {
+				continue
+			}
+			var version fileVersion
+			if info, err := os.Stat(file); err == nil {
+				version = fileVersion{modTime: info.ModTime(), size: info.Size()}
+			}
+			files[file] = version
+			if previous, ok := reloader.files[file]; ok && (!previous.modTime.Equal(version.modTime) || previous.size != version.size) {
+				changed[file] = true
+			}
+		}
+	}
+	reloader.files = files
+	return changed
+}
+
+func (reloader *ExtensionReloader) reload(changed map[string]bool) error {
+	manager := schema.GetManager()
+
+	// extensions of changed schema files are loaded again, keeping their position in the list
+	extensions := []*schema.Extension{}
+	affected := []*schema.Extension{}
+	reloadedFiles := map[string]bool{}
+	for _, ext := range manager.GetExtensions() {
+		if ext.File != "" && changed[localPath(ext.File)] {
+			affected = append(affected, ext)
+			if reloadedFiles[ext.File] {
+				continue
+			}
+			reloadedFiles[ext.File] = true
+			reloaded, err := schema.LoadExtensionsFromFile(ext.File)
+			if err != nil {
+				return fmt.Errorf("failed to load extensions from %s: %s", ext.File, err)
+			}
+			extensions = append(extensions, reloaded...)
+			affected = append(affected, reloaded...)
+			continue
+		}
+		if ext.URL != "" && changed[localPath(ext.URL)] {
+			reloaded, err := ext.Reload()
+			if err != nil {
+				return fmt.Errorf("failed to reload extension %s: %s", ext.ID, err)
+			}
+			extensions = append(extensions, reloaded)
+			affected = append(affected, ext, reloaded)
+			continue
+		}
+		extensions = append(extensions, ext)
+	}
+
+	envs := map[string]extension.Environment{}
+	for _, s := range manager.Schemas() {
+		if s.IsAbstract() {
+			continue
+		}
+		path := s.GetPluralURL()
+		if !matchesAny(affected, path) {
+			continue
+		}
+		env := reloader.server.newEnvironment(s.ID)
+		if err := env.LoadExtensionsForPath(extensions, manager.TimeLimit, manager.TimeLimits, path); err != nil {
+			return fmt.Errorf("[%s] Extensions parsing error: %v", path, err)
+		}
+		envs[s.ID] = env
+	}
+	for name, path := range reloader.server.registeredPathEnvironments() {
+		if !matchesAny(affected, path) {
+			continue
+		}
+		env := reloader.server.newEnvironment(name)
+		if err := env.LoadExtensionsForPath(extensions, manager.TimeLimit, manager.TimeLimits, path); err != nil {
+			return fmt.Errorf("[%s] Extensions parsing error: %v", path, err)
+		}
+		envs[name] = env
+	}
+
+	manager.ReplaceExtensions(extensions)
+	environmentManager := extension.GetManager()
+	for name, env := range envs {
+		drained, err := environmentManager.ReplaceEnvironment(name, env)
+		if err != nil {
+			environmentManager.RegisterEnvironment(name, env)
+			continue
+		}
+		go func(name string) {
+			<-drained
+			log.Debug("Previous extension environment of %s drained and stopped", name)
+		}(name)
+	}
+	log.Info("Reloaded extensions of %d environments", len(envs))
+	return nil
+}
+
+// extensionSources returns local files the extension is loaded from
+func extensionSources(ext *schema.Extension) []string {
+	sources := []string{}
+	for _, source := range []string{ext.File, ext.URL} {
+		if file := localPath(source); file != "" {
+			sources = append(sources, file)
+		}
+	}
+	return sources
+}
+
+// localPath returns path of a local file, empty for remote and embedded sources
+func localPath(source string) string {
+	if strings.HasPrefix(source, "file://") {
+		return strings.TrimPrefix(source, "file://")
+	}
+	if strings.Contains(source, "://") {
+		return ""
+	}
+	return source
+}
+
+func matchesAny(extensions []*schema.Extension, path string) bool {
+	for _, ext := range extensions {
+		if ext.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapExtensionReloadRoutes maps admin endpoints showing the status of extension reloads
+// and reloading changed extensions right away
+func mapExtensionReloadRoutes(server *Server) {
+	withReloader := func(handler func(w http.ResponseWriter, r *http.Request, reloader *ExtensionReloader)) interface{} {
+		return func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+			if !auth.IsAdmin() {
+				middleware.HTTPJSONError(w, "Extension reload is allowed only for admin", http.StatusForbidden)
+				return
+			}
+			if server.extensionReloader == nil {
+				middleware.HTTPJSONError(w, "Extension hot reload is disabled", http.StatusNotFound)
+				return
+			}
+			handler(w, r, server.extensionReloader)
+		}
+	}
+
+	server.martini.Get("/_extensions/reload", withReloader(func(w http.ResponseWriter, r *http.Request, reloader *ExtensionReloader) {
+		routes.ServeJson(w, map[string]interface{}{"reload": reloader.Status()})
+	}))
+
+	server.martini.Post("/_extensions/reload", withReloader(func(w http.ResponseWriter, r *http.Request, reloader *ExtensionReloader) {
+		if err := reloader.Reload(); err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"reload": reloader.Status()})
+	}))
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/job"
+	"github.com/cloudwan/gohan/schema"
+	srv "github.com/cloudwan/gohan/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extension hot reload", func() {
+	const (
+		reloadURL   = baseURL + "/_extensions/reload"
+		networksURL = "/v2.0/networks"
+	)
+
+	var (
+		dir        string
+		codeFile   string
+		schemaFile string
+		original   []*schema.Extension
+	)
+
+	writeFile := func(file, content string) {
+		Expect(ioutil.WriteFile(file, []byte(content), 0644)).To(Succeed())
+	}
+
+	writeCode := func(version int) {
+		writeFile(codeFile, fmt.Sprintf(`gohan_register_handler("reload_test", function(context) { context.version = %d; });`, version))
+	}
+
+	handledVersion := func() interface{} {
+		env, ok := extension.GetManager().AcquireEnvironment("network")
+		Expect(ok).To(BeTrue())
+		defer extension.GetManager().ReleaseEnvironment(env)
+		context := map[string]interface{}{}
+		Expect(env.HandleEvent("reload_test", context)).To(Succeed())
+		return context["version"]
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gohan-reload")
+		Expect(err).ToNot(HaveOccurred())
+		codeFile = filepath.Join(dir, "reload_test.js")
+		schemaFile = filepath.Join(dir, "reload_test.yaml")
+
+		manager := schema.GetManager()
+		original = manager.GetExtensions()
+
+		writeCode(1)
+		writeFile(schemaFile, `
+extensions:
+- id: reload_test
+  path: ^/v2.0/networks$
+  url: file://reload_test.js
+`)
+		Expect(manager.LoadSchemaFromFile(schemaFile)).To(Succeed())
+		testURL("POST", reloadURL, adminTokenID, nil, http.StatusOK)
+	})
+
+	AfterEach(func() {
+		schema.GetManager().ReplaceExtensions(original)
+		env, err := server.NewEnvironmentForPath("network", networksURL)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = extension.GetManager().ReplaceEnvironment("network", env)
+		Expect(err).ToNot(HaveOccurred())
+		os.RemoveAll(dir)
+	})
+
+	It("should reload changed code", func() {
+		writeCode(22)
+		result := testURL("POST", reloadURL, adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("reload", HaveKeyWithValue("reloads", BeNumerically(">", 0))))
+		Expect(handledVersion()).To(BeEquivalentTo(22))
+	})
+
+	It("should reload changed schema files", func() {
+		writeFile(schemaFile, `
+extensions:
+- id: reload_test
+  path: ^/v2.0/networks$
+  code: |
+    gohan_register_handler("reload_test", function(context) { context.version = 333; });
+`)
+		testURL("POST", reloadURL, adminTokenID, nil, http.StatusOK)
+		Expect(handledVersion()).To(BeEquivalentTo(333))
+	})
+
+	It("should keep the previous version when loading fails", func() {
+		writeCode(22)
+		testURL("POST", reloadURL, adminTokenID, nil, http.StatusOK)
+
+		writeFile(codeFile, `gohan_register_handler("reload_test", function(context) {`)
+		testURL("POST", reloadURL, adminTokenID, nil, http.StatusInternalServerError)
+		Expect(handledVersion()).To(BeEquivalentTo(22))
+
+		result := testURL("GET", reloadURL, adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("reload", HaveKeyWithValue("error", HaveKeyWithValue("files", ConsistOf(codeFile)))))
+
+		writeCode(4444)
+		result = testURL("POST", reloadURL, adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("reload", Not(HaveKey("error"))))
+		Expect(handledVersion()).To(BeEquivalentTo(4444))
+	})
+
+	It("should reload extensions of jobs", func() {
+		const jobType = "reload_test"
+		jobCodeFile := filepath.Join(dir, "reload_job.js")
+		writeJobCode := func(version int) {
+			writeFile(jobCodeFile, fmt.Sprintf(`gohan_register_handler("job", function(context) { throw new Error("version %d"); });`, version))
+		}
+		writeJobCode(1)
+		jobSchemaFile := filepath.Join(dir, "reload_job.yaml")
+		writeFile(jobSchemaFile, `
+extensions:
+- id: reload_job
+  path: ^job://reload_test$
+  url: file://reload_job.js
+`)
+		Expect(schema.GetManager().LoadSchemaFromFile(jobSchemaFile)).To(Succeed())
+		testURL("POST", reloadURL, adminTokenID, nil, http.StatusOK)
+		defer extension.GetManager().UnRegisterEnvironment("job/" + jobType)
+
+		ctx := context.Background()
+		queue := job.NewQueue(testDB)
+		worker := srv.NewJobWorkerFromServer(server)
+		runJob := func() string {
+			id, err := queue.Enqueue(ctx, jobType, nil, job.Options{MaxAttempts: 1})
+			Expect(err).ToNot(HaveOccurred())
+			defer queue.Delete(ctx, id)
+			Expect(worker.Process(ctx)).To(Equal(1))
+			worker.Wait()
+			failed, err := queue.Get(ctx, id)
+			Expect(err).ToNot(HaveOccurred())
+			return failed.LastError
+		}
+		Expect(runJob()).To(ContainSubstring("version 1"))
+
+		writeJobCode(22)
+		testURL("POST", reloadURL, adminTokenID, nil, http.StatusOK)
+		Expect(runJob()).To(ContainSubstring("version 22"))
+	})
+
+	It("should be allowed only for admin", func() {
+		testURL("GET", reloadURL, memberTokenID, nil, http.StatusForbidden)
+		testURL("POST", reloadURL, memberTokenID, nil, http.StatusForbidden)
+	})
+})
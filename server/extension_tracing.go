@@ -0,0 +1,101 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+const (
+	//TraceHeader asks to trace extension handlers called for a request, it's honoured only for admin
+	TraceHeader = "X-Gohan-Debug-Trace"
+	//TraceIDHeader holds ID of the trace of a request, which is shown by /_debug/traces/<trace_id>
+	TraceIDHeader = "X-Gohan-Trace-Id"
+)
+
+// traceExtensions adds a trace to the context of a request asking for it
+func traceExtensions(context middleware.Context, r *http.Request, w http.ResponseWriter) {
+	if enabled, _ := strconv.ParseBool(r.Header.Get(TraceHeader)); !enabled || !extension.TracingEnabled() {
+		return
+	}
+	if auth, ok := context["auth"].(schema.Authorization); !ok || !auth.IsAdmin() {
+		return
+	}
+	traceID, ok := context["trace_id"].(string)
+	if !ok {
+		return
+	}
+	context[extension.TraceContextKey] = extension.NewTrace(traceID)
+	w.Header().Set(TraceIDHeader, traceID)
+}
+
+func changesToMap(changes extension.Changes) map[string]interface{} {
+	return map[string]interface{}{
+		"added":    nonNil(changes.Added),
+		"modified": nonNil(changes.Modified),
+		"removed":  nonNil(changes.Removed),
+	}
+}
+
+func nonNil(keys []string) []string {
+	if keys == nil {
+		return []string{}
+	}
+	return keys
+}
+
+// mapDebugTraceRoutes maps an admin endpoint showing extension handlers called for a traced request
+func mapDebugTraceRoutes(server *Server) {
+	server.martini.Get("/_debug/traces/:id", func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Traces are allowed only for admin", http.StatusForbidden)
+			return
+		}
+		trace, ok := extension.GetTrace(p["id"])
+		if !ok {
+			middleware.HTTPJSONError(w, "Trace not found", http.StatusNotFound)
+			return
+		}
+		handlers := []map[string]interface{}{}
+		for _, handler := range trace.Handlers() {
+			handlers = append(handlers, map[string]interface{}{
+				"environment": handler.Environment,
+				"schema":      handler.Schema,
+				"event":       handler.Event,
+				"name":        handler.Name,
+				"depth":       handler.Depth,
+				"started_at":  handler.Started,
+				"duration_ms": milliseconds(handler.Duration),
+				"error":       handler.Error,
+				"context":     changesToMap(handler.Context),
+				"resource":    changesToMap(handler.Resource),
+				"response":    changesToMap(handler.Response),
+			})
+		}
+		routes.ServeJson(w, map[string]interface{}{
+			"id":         trace.ID,
+			"created_at": trace.Created,
+			"handlers":   handlers,
+		})
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"net/http"
+
+	srv "github.com/cloudwan/gohan/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extension tracing", func() {
+	const tracesURL = baseURL + "/_debug/traces/"
+
+	withTraceHeader := func(request *http.Request) {
+		request.Header.Set(srv.TraceHeader, "true")
+	}
+
+	createNetwork := func(token, tenantID string) *http.Response {
+		network := getNetwork("traced", tenantID)
+		network["name"] = "run-pre-create"
+		_, resp := httpRequestWithCustomOptions("POST", networkPluralURL, network, withTokenPassedByHeader(token), withTraceHeader)
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		return resp
+	}
+
+	AfterEach(func() {
+		testURL("DELETE", getNetworkSingularURL("traced"), adminTokenID, nil, http.StatusNoContent)
+	})
+
+	It("should record handlers called for a traced request", func() {
+		traceID := createNetwork(adminTokenID, adminTenantID).Header.Get(srv.TraceIDHeader)
+		Expect(traceID).ToNot(BeEmpty())
+
+		result := testURL("GET", tracesURL+traceID, adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("id", traceID))
+		var preCreate map[string]interface{}
+		for _, handler := range result.(map[string]interface{})["handlers"].([]interface{}) {
+			handler := handler.(map[string]interface{})
+			if handler["event"] == "pre_create" && handler["name"] != "" && handler["schema"] == "network" {
+				resource := handler["resource"].(map[string]interface{})
+				if len(resource["modified"].([]interface{})) > 0 {
+					preCreate = handler
+				}
+			}
+		}
+		Expect(preCreate).ToNot(BeNil())
+		Expect(preCreate).To(HaveKeyWithValue("environment", "otto"))
+		Expect(preCreate).To(HaveKeyWithValue("depth", BeEquivalentTo(1)))
+		Expect(preCreate["resource"]).To(HaveKeyWithValue("modified", ConsistOf("name")))
+		Expect(preCreate["context"]).To(HaveKeyWithValue("modified", ContainElement("resource")))
+	})
+
+	It("should not trace requests of non admin users", func() {
+		Expect(createNetwork(powerUserTokenID, powerUserTenantID).Header.Get(srv.TraceIDHeader)).To(BeEmpty())
+	})
+
+	It("should show traces only to admin", func() {
+		traceID := createNetwork(adminTokenID, adminTenantID).Header.Get(srv.TraceIDHeader)
+		testURL("GET", tracesURL+traceID, memberTokenID, nil, http.StatusForbidden)
+		testURL("GET", tracesURL+"unknown", adminTokenID, nil, http.StatusNotFound)
+	})
+})
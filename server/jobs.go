@@ -0,0 +1,322 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/job"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+const (
+	jobLockPath = lockPath + "/job"
+
+	defaultJobWorkers      = 4
+	defaultJobPollInterval = time.Second
+	defaultJobTimeout      = 5 * time.Minute
+	defaultJobBackoff      = time.Second
+	defaultJobMaxBackoff   = 5 * time.Minute
+	defaultJobRetention    = 24 * time.Hour
+)
+
+// JobEnvironments returns the environment running jobs of a type,
+// it's released by extension manager's ReleaseEnvironment when the job is finished
+type JobEnvironments func(jobType string) (extension.Environment, error)
+
+// JobWorker runs due jobs of the job table in a pool of "workers" goroutines. A job is run
+// by extensions of path job://<type> handling the "job" event. Nodes of a cluster run
+// different jobs, as a job is run only by the node holding its lock. A job not finished
+// within "timeout" is considered abandoned and run again. A failed job is run again after
+// an exponential backoff starting at "backoff", until it runs out of attempts.
+type JobWorker struct {
+	queue        *job.Queue
+	sync         gohan_sync.Sync
+	environments JobEnvironments
+	workers      int
+	pollInterval time.Duration
+	timeout      time.Duration
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	retention    time.Duration
+
+	slots   chan struct{}
+	running sync.WaitGroup
+}
+
+// NewJobWorker creates a job worker from "jobs" config
+func NewJobWorker(sync gohan_sync.Sync, dataStore db.DB, environments JobEnvironments) *JobWorker {
+	config := util.GetConfig()
+	workers := config.GetInt("jobs/workers", defaultJobWorkers)
+	if workers < 1 {
+		workers = 1
+	}
+	return &JobWorker{
+		queue:        job.NewQueue(dataStore),
+		sync:         sync,
+		environments: environments,
+		workers:      workers,
+		pollInterval: config.GetDuration("jobs/poll_interval", defaultJobPollInterval),
+		timeout:      config.GetDuration("jobs/timeout", defaultJobTimeout),
+		backoff:      config.GetDuration("jobs/backoff", defaultJobBackoff),
+		maxBackoff:   config.GetDuration("jobs/max_backoff", defaultJobMaxBackoff),
+		retention:    config.GetDuration("jobs/retention", defaultJobRetention),
+		slots:        make(chan struct{}, workers),
+	}
+}
+
+// NewJobWorkerFromServer creates a job worker running jobs by extensions of the server,
+// environments of job types are registered in extension manager, so that they're hot reloaded
+func NewJobWorkerFromServer(server *Server) *JobWorker {
+	return NewJobWorker(server.sync, server.db, func(jobType string) (extension.Environment, error) {
+		name := "job/" + jobType
+		if err := server.registerEnvironmentForPath(name, job.PathPrefix+jobType); err != nil {
+			return nil, err
+		}
+		env, ok := extension.GetManager().AcquireEnvironment(name)
+		if !ok {
+			return nil, fmt.Errorf("No environment registered for %s", name)
+		}
+		return env, nil
+	})
+}
+
+// Run runs due jobs until the context is canceled
+func (worker *JobWorker) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+	defer worker.Wait()
+
+	lastCleanup := time.Time{}
+	for {
+		if _, err := worker.Process(ctx); err != nil {
+			log.Error("Failed to run jobs: %s", err)
+		}
+		if worker.retention > 0 && time.Since(lastCleanup) > worker.retention/10 {
+			if err := worker.queue.DeleteFinished(ctx, time.Now().Add(-worker.retention)); err != nil {
+				log.Warning("Failed to delete finished jobs: %s", err)
+			}
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(worker.pollInterval):
+		}
+	}
+}
+
+// Process starts due jobs in free workers, and returns the number of started jobs
+func (worker *JobWorker) Process(ctx context.Context) (int, error) {
+	free := worker.workers - len(worker.slots)
+	if free == 0 {
+		return 0, nil
+	}
+	ids, err := worker.queue.Due(ctx, time.Now(), free)
+	if err != nil {
+		return 0, err
+	}
+	started := 0
+	for _, id := range ids {
+		lockKey := fmt.Sprintf("%s/%d", jobLockPath, id)
+		lost, err := worker.sync.Lock(ctx, lockKey, false)
+		if err != nil {
+			log.Debug("Job %d is run by another worker: %s", id, err)
+			continue
+		}
+		claimed, err := worker.queue.Claim(ctx, id, time.Now(), worker.timeout)
+		if err != nil || claimed == nil {
+			worker.unlock(lockKey)
+			if err != nil {
+				return started, err
+			}
+			continue
+		}
+		worker.slots <- struct{}{}
+		worker.running.Add(1)
+		started++
+		go func() {
+			defer worker.running.Done()
+			defer func() { <-worker.slots }()
+			defer worker.unlock(lockKey)
+			jobCtx, cancel := worker.lockedContext(ctx, lost, claimed)
+			defer cancel()
+			worker.run(jobCtx, claimed)
+		}()
+	}
+	return started, nil
+}
+
+// Wait waits until started jobs finish
+func (worker *JobWorker) Wait() {
+	worker.running.Wait()
+}
+
+func (worker *JobWorker) unlock(lockKey string) {
+	if err := worker.sync.Unlock(context.Background(), lockKey); err != nil {
+		log.Warning("Failed to unlock %s: %s", lockKey, err)
+	}
+}
+
+// lockedContext returns a context of the job canceled when its lock is lost,
+// as another worker may claim the job after its timeout
+func (worker *JobWorker) lockedContext(ctx context.Context, lost chan struct{}, claimed *job.Job) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-lost:
+			log.Warning("Lost the lock of job %d of type %s, canceling it", claimed.ID, claimed.Type)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (worker *JobWorker) run(ctx context.Context, claimed *job.Job) {
+	started := time.Now()
+	err := worker.handle(ctx, claimed)
+	metrics.UpdateTimer(started, "job.%s.run", claimed.Type)
+
+	backoff := worker.backoff << uint(claimed.Attempts-1)
+	if backoff > worker.maxBackoff || backoff <= 0 {
+		backoff = worker.maxBackoff
+	}
+	// the job is finished even if the server is stopping, so that it isn't run again after timeout
+	if finishErr := worker.queue.Finish(context.Background(), claimed, err, backoff); finishErr != nil {
+		if finishErr == job.ErrNotClaimed {
+			log.Warning("Job %d of type %s was claimed again before attempt %d finished, dropping its result",
+				claimed.ID, claimed.Type, claimed.Attempts)
+			return
+		}
+		log.Error("Failed to store result of job %d: %s", claimed.ID, finishErr)
+		return
+	}
+	metrics.UpdateCounter(1, "job.%s.%s", claimed.Type, claimed.Status)
+	if err != nil {
+		log.Warning("Job %d of type %s failed in attempt %d of %d: %s",
+			claimed.ID, claimed.Type, claimed.Attempts, claimed.MaxAttempts, err)
+	}
+}
+
+func (worker *JobWorker) handle(ctx context.Context, claimed *job.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Job %d of type %s panicked: %s %s", claimed.ID, claimed.Type, r, string(debug.Stack()))
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	env, err := worker.environments(claimed.Type)
+	if err != nil {
+		return err
+	}
+	defer extension.GetManager().ReleaseEnvironment(env)
+	return env.HandleEvent(job.Event, map[string]interface{}{
+		"job": map[string]interface{}{
+			"id":           claimed.ID,
+			"type":         claimed.Type,
+			"attempts":     claimed.Attempts,
+			"max_attempts": claimed.MaxAttempts,
+			"unique_key":   claimed.UniqueKey,
+		},
+		"payload":  claimed.Payload,
+		"context":  ctx,
+		"trace_id": util.NewTraceID(),
+	})
+}
+
+func jobHTTPError(w http.ResponseWriter, err error) {
+	switch err {
+	case job.ErrNotFound:
+		middleware.HTTPJSONError(w, err.Error(), http.StatusNotFound)
+	case job.ErrConflict:
+		middleware.HTTPJSONError(w, err.Error(), http.StatusConflict)
+	default:
+		middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func mapJobRoutes(server *Server) {
+	queue := job.NewQueue(server.db)
+
+	withJob := func(fn func(w http.ResponseWriter, r *http.Request, id int64)) martini.Handler {
+		return func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization) {
+			if !auth.IsAdmin() {
+				middleware.HTTPJSONError(w, "Jobs are allowed only for admin", http.StatusForbidden)
+				return
+			}
+			id, err := strconv.ParseInt(p["id"], 10, 64)
+			if err != nil {
+				middleware.HTTPJSONError(w, "invalid job id", http.StatusBadRequest)
+				return
+			}
+			fn(w, r, id)
+		}
+	}
+
+	server.martini.Get("/_jobs", func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Jobs are allowed only for admin", http.StatusForbidden)
+			return
+		}
+		jobs, err := queue.List(r.Context(), r.URL.Query().Get("status"), r.URL.Query().Get("type"))
+		if err != nil {
+			jobHTTPError(w, err)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"jobs": jobs})
+	})
+
+	server.martini.Get("/_jobs/:id", withJob(func(w http.ResponseWriter, r *http.Request, id int64) {
+		found, err := queue.Get(r.Context(), id)
+		if err != nil {
+			jobHTTPError(w, err)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"job": found})
+	}))
+
+	server.martini.Post("/_jobs/:id/retry", withJob(func(w http.ResponseWriter, r *http.Request, id int64) {
+		if err := queue.Retry(r.Context(), id); err != nil {
+			jobHTTPError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	server.martini.Delete("/_jobs/:id", withJob(func(w http.ResponseWriter, r *http.Request, id int64) {
+		if err := queue.Delete(r.Context(), id); err != nil {
+			jobHTTPError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/extension/otto"
+	"github.com/cloudwan/gohan/job"
+	"github.com/cloudwan/gohan/schema"
+	srv "github.com/cloudwan/gohan/server"
+	"github.com/cloudwan/gohan/server/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Jobs", func() {
+	const jobsURL = baseURL + "/_jobs"
+
+	var (
+		ctx    context.Context
+		queue  *job.Queue
+		worker *srv.JobWorker
+		types  []string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		queue = job.NewQueue(testDB)
+		types = nil
+		worker = srv.NewJobWorker(server.GetSync(), testDB, func(jobType string) (extension.Environment, error) {
+			types = append(types, jobType)
+			env := otto.NewEnvironment("job_test", testDB, &middleware.FakeIdentity{}, server.GetSync())
+			ext, err := schema.NewExtension(map[string]interface{}{
+				"id":   "job_test",
+				"path": job.PathPrefix + jobType,
+				"code": `
+					gohan_register_handler("job", function(context) {
+						if (context.payload.fail) {
+							throw new Error("failed in attempt " + context.job.attempts);
+						}
+					});`,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env.LoadExtensionsForPath([]*schema.Extension{ext}, time.Second, nil, job.PathPrefix+jobType)).To(Succeed())
+			return env, nil
+		})
+	})
+
+	AfterEach(func() {
+		jobs, err := queue.List(ctx, "", "")
+		Expect(err).ToNot(HaveOccurred())
+		for _, found := range jobs {
+			Expect(queue.Delete(ctx, found.ID)).To(Succeed())
+		}
+	})
+
+	It("should run due jobs by extensions of their type", func() {
+		succeeding, err := queue.Enqueue(ctx, "provision", map[string]interface{}{"network": "red"}, job.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		failing, err := queue.Enqueue(ctx, "cleanup", map[string]interface{}{"fail": true}, job.Options{MaxAttempts: 1})
+		Expect(err).ToNot(HaveOccurred())
+		delayed, err := queue.Enqueue(ctx, "provision", nil, job.Options{Delay: time.Hour})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(worker.Process(ctx)).To(Equal(2))
+		worker.Wait()
+		Expect(types).To(ConsistOf("provision", "cleanup"))
+
+		succeeded, err := queue.Get(ctx, succeeding)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(succeeded.Status).To(Equal(job.StatusSucceeded))
+		failed, err := queue.Get(ctx, failing)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed.Status).To(Equal(job.StatusFailed))
+		Expect(failed.LastError).To(ContainSubstring("failed in attempt 1"))
+		pending, err := queue.Get(ctx, delayed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending.Status).To(Equal(job.StatusPending))
+
+		Expect(worker.Process(ctx)).To(BeZero())
+	})
+
+	It("should retry failed jobs after a backoff", func() {
+		id, err := queue.Enqueue(ctx, "cleanup", map[string]interface{}{"fail": true}, job.Options{MaxAttempts: 2})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(worker.Process(ctx)).To(Equal(1))
+		worker.Wait()
+		retried, err := queue.Get(ctx, id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(retried.Status).To(Equal(job.StatusPending))
+		Expect(retried.Attempts).To(Equal(1))
+		Expect(retried.RunAt).To(BeNumerically(">=", time.Now().Unix()))
+	})
+
+	It("should show, retry and delete jobs", func() {
+		id, err := queue.Enqueue(ctx, "cleanup", map[string]interface{}{"fail": true}, job.Options{MaxAttempts: 1})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(worker.Process(ctx)).To(Equal(1))
+		worker.Wait()
+		jobURL := fmt.Sprintf("%s/%d", jobsURL, id)
+
+		result := testURL("GET", jobsURL+"?status=failed&type=cleanup", adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("jobs", HaveLen(1)))
+		result = testURL("GET", jobURL, adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("job", HaveKeyWithValue("last_error", ContainSubstring("failed in attempt 1"))))
+
+		testURL("POST", jobURL+"/retry", adminTokenID, nil, http.StatusNoContent)
+		testURL("POST", jobURL+"/retry", adminTokenID, nil, http.StatusConflict)
+		result = testURL("GET", jobURL, adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("job", HaveKeyWithValue("status", job.StatusPending)))
+
+		testURL("DELETE", jobURL, adminTokenID, nil, http.StatusNoContent)
+		testURL("GET", jobURL, adminTokenID, nil, http.StatusNotFound)
+	})
+
+	It("should be allowed only for admin", func() {
+		testURL("GET", jobsURL, memberTokenID, nil, http.StatusForbidden)
+		testURL("POST", jobsURL+"/1/retry", memberTokenID, nil, http.StatusForbidden)
+		testURL("DELETE", jobsURL+"/1", memberTokenID, nil, http.StatusForbidden)
+	})
+})
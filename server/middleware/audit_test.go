@@ -0,0 +1,96 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudwan/gohan/audit"
+	"github.com/cloudwan/gohan/schema"
+)
+
+type memoryAuditSink struct {
+	records []*audit.Record
+}
+
+func (sink *memoryAuditSink) Write(records []*audit.Record) error {
+	sink.records = append(sink.records, records...)
+	return nil
+}
+
+func (sink *memoryAuditSink) Close() error {
+	return nil
+}
+
+var _ = ginkgo.Describe("Audit of authorization decisions", func() {
+	var (
+		sink   *memoryAuditSink
+		auth   schema.Authorization
+		policy *schema.Policy
+	)
+
+	ginkgo.BeforeEach(func() {
+		sink = &memoryAuditSink{}
+		audit.SetLogger(audit.NewLogger(sink, true, 100, nil, 10, time.Hour))
+		auth = schema.NewAuthorizationBuilder().
+			WithTenant(schema.Tenant{ID: "tenant", Name: "demo"}).
+			WithUser(schema.User{ID: "user", Name: "alice"}).
+			WithRoleIDs("Member").
+			BuildScopedToTenant()
+		var err error
+		policy, err = schema.NewPolicy(map[string]interface{}{
+			"action":    "*",
+			"effect":    "allow",
+			"id":        "allow_member",
+			"principal": "Member",
+			"resource":  map[string]interface{}{"path": ".*"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		audit.Stop()
+	})
+
+	finalDecision := func(status int) *audit.Record {
+		res := martini.NewResponseWriter(httptest.NewRecorder())
+		res.WriteHeader(status)
+		context := Context{"audit_record": audit.NewRecord(auth, "read", "/v2.0/networks", policy, &schema.Role{Name: "Member"})}
+		auditFinalDecision(res, context)
+		audit.Stop()
+		Expect(sink.records).To(HaveLen(1))
+		return sink.records[0]
+	}
+
+	ginkgo.It("Records requests allowed by the policy and handled", func() {
+		record := finalDecision(http.StatusOK)
+		Expect(record.Decision).To(Equal(audit.Allow))
+		Expect(record.Principal).To(Equal("alice"))
+	})
+
+	ginkgo.It("Records requests denied after the policy allowed them", func() {
+		record := finalDecision(http.StatusUnauthorized)
+		Expect(record.Decision).To(Equal(audit.Deny))
+		Expect(record.PolicyID).To(Equal("allow_member"))
+		Expect(record.UserID).To(Equal("user"))
+	})
+})
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"time"
 
 	"github.com/cloudwan/gohan/metrics"
@@ -10,9 +11,12 @@ import (
 
 type CachedIdentityService struct {
 	IdentityService
-	cache *cache.Cache
+	cache  *cache.Cache
+	shared *SharedTokenCache
 }
 
+const tokenCacheKeyPrefix = "token:"
+
 func (c *CachedIdentityService) GetTenantName(tenantID string) (string, error) {
 	i, ok := c.cache.Get(tenantID)
 	if ok {
@@ -30,21 +34,48 @@ func (c *CachedIdentityService) GetTenantName(tenantID string) (string, error) {
 }
 
 func (c *CachedIdentityService) VerifyToken(token string) (schema.Authorization, error) {
-	i, ok := c.cache.Get(token)
+	key := tokenCacheKey(hashToken(token))
+	i, ok := c.cache.Get(key)
 	if ok {
 		c.updateCounter(1, "token.hit")
 		return i.(schema.Authorization), nil
 	}
 	c.updateCounter(1, "token.miss")
 
+	if c.shared != nil {
+		if a, ok := c.shared.Get(context.Background(), token); ok {
+			c.cache.Set(key, a, cache.DefaultExpiration)
+			return a, nil
+		}
+	}
+
 	a, err := c.IdentityService.VerifyToken(token)
 	if err != nil {
 		return nil, err
 	}
-	c.cache.Set(token, a, cache.DefaultExpiration)
+	c.cache.Set(key, a, cache.DefaultExpiration)
+	if c.shared != nil {
+		c.shared.Set(context.Background(), token, a)
+	}
 	return a, nil
 }
 
+// UseSharedCache makes the service consult the cluster-shared cache before the inner service
+// and evict tokens revoked on any node
+func (c *CachedIdentityService) UseSharedCache(shared *SharedTokenCache) {
+	c.shared = shared
+	shared.OnRevoke(c.evictToken)
+}
+
+func (c *CachedIdentityService) evictToken(tokenHash string) {
+	c.cache.Delete(tokenCacheKey(tokenHash))
+	c.updateCounter(1, "token.evicted")
+}
+
+func tokenCacheKey(tokenHash string) string {
+	return tokenCacheKeyPrefix + tokenHash
+}
+
 func (c *CachedIdentityService) GetServiceAuthorization() (schema.Authorization, error) {
 	defer c.measureTime(time.Now(), "get_service_authorization")
 	return c.VerifyToken(c.GetServiceTokenID())
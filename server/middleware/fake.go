@@ -203,10 +203,16 @@ func (*FakeIdentity) VerifyToken(tokenID string) (schema.Authorization, error) {
 		ID:   access["token"].(token).Tenant.ID,
 		Name: access["token"].(token).Tenant.Name,
 	}
-	role := access["user"].(map[string]interface{})["roles"].([]role)[0].Name
+	rawUser := access["user"].(map[string]interface{})
+	user := schema.User{
+		ID:   rawUser["id"].(string),
+		Name: rawUser["name"].(string),
+	}
+	role := rawUser["roles"].([]role)[0].Name
 	auth := schema.NewAuthorizationBuilder().
 		WithKeystoneV2Compatibility().
 		WithTenant(tenant).
+		WithUser(user).
 		WithRoleIDs(role).
 		BuildScopedToTenant()
 	return auth, nil
@@ -29,9 +29,11 @@ import (
 	"github.com/go-martini/martini"
 	"github.com/gophercloud/gophercloud"
 
+	"github.com/cloudwan/gohan/audit"
 	"github.com/cloudwan/gohan/cloud"
 	"github.com/cloudwan/gohan/metrics"
 	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/secret"
 	"github.com/cloudwan/gohan/util"
 )
 
@@ -100,9 +102,10 @@ func Logging() martini.Handler {
 		} else {
 			compactBody = string(buffer.Bytes())
 		}
+		compactBody = secret.Redact(compactBody)
 
 		log.Info("[%s] Started %s %s for client %s",
-			requestContext["trace_id"], req.Method, req.URL.String(), addr)
+			requestContext["trace_id"], req.Method, secret.Redact(req.URL.String()), addr)
 		if len(compactBody) > 0 {
 			if logRequestBodyAsInfo {
 				log.Info("[%s] Request body: %s", requestContext["trace_id"], compactBody)
@@ -127,6 +130,7 @@ func Logging() martini.Handler {
 		} else {
 			compactBody = string(buffer.Bytes())
 		}
+		compactBody = secret.Redact(compactBody)
 
 		if len(compactBody) > 0 {
 			log.Debug("[%s] Response body: %s", requestContext["trace_id"], compactBody)
@@ -387,7 +391,7 @@ func Authentication() martini.Handler {
 			return
 		}
 
-		c.Map(auth)
+		c.Map(schema.WithRequestAttributes(auth, schema.NewRequestAttributes(req, auth.AuthMethods())))
 		c.Next()
 	}
 }
@@ -465,11 +469,44 @@ func Tracing() martini.Handler {
 
 //Authorization checks user permissions against policy
 func Authorization(action string) martini.Handler {
-	return func(res http.ResponseWriter, req *http.Request, auth schema.Authorization, context Context) {
+	return func(res http.ResponseWriter, req *http.Request, auth schema.Authorization, context Context, c martini.Context) {
 		context["tenant_id"] = auth.TenantID()
 		context["domain_id"] = auth.DomainID()
 		context["auth"] = auth
+		if !audit.Enabled() {
+			return
+		}
+		context["audit_request"] = newAuditRequest(req, auth)
+		c.Next()
+		auditFinalDecision(res, context)
+	}
+}
+
+// auditFinalDecision logs the policy decision of the request, denied if the request was
+// rejected by access checks after the policy allowed it
+func auditFinalDecision(res http.ResponseWriter, context Context) {
+	record, ok := context["audit_record"].(*audit.Record)
+	if !ok {
+		return
+	}
+	if rw, ok := res.(martini.ResponseWriter); ok {
+		switch rw.Status() {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			record.Deny()
+		}
+	}
+	audit.Log(record)
+}
+
+func newAuditRequest(req *http.Request, auth schema.Authorization) *audit.Request {
+	request := &audit.Request{
+		Method: req.Method,
+		Path:   req.URL.Path,
+	}
+	if attributes := auth.RequestAttributes(); attributes != nil && attributes.SourceIP != nil {
+		request.SourceIP = attributes.SourceIP.String()
 	}
+	return request
 }
 
 // JSONURLs strips ".json" suffixes added to URLs
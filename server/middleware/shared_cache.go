@@ -0,0 +1,230 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goext"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+)
+
+const (
+	// SyncKeyAuthCacheTokens is a prefix of verified tokens shared by all nodes
+	SyncKeyAuthCacheTokens = "/gohan/cluster/auth_cache/tokens"
+	// SyncKeyAuthCacheRevoked is a prefix of revoked tokens, any change under it evicts the token from caches
+	SyncKeyAuthCacheRevoked = "/gohan/cluster/auth_cache/revoked"
+
+	authScopeAdmin  = "admin"
+	authScopeDomain = "domain"
+	authScopeTenant = "tenant"
+
+	defaultSharedCacheRetryDelay = time.Second
+)
+
+// SharedTokenCache stores verified tokens in the sync backend, so that they are shared by the cluster.
+// Tokens are stored by their SHA-256 hash, never in plain text.
+type SharedTokenCache struct {
+	sync       gohan_sync.Sync
+	ttl        time.Duration
+	retryDelay time.Duration
+	mu         sync.RWMutex
+	onRevoke   []func(tokenHash string)
+}
+
+type sharedAuthorization struct {
+	Scope       string   `json:"scope"`
+	TenantID    string   `json:"tenant_id,omitempty"`
+	TenantName  string   `json:"tenant_name,omitempty"`
+	DomainID    string   `json:"domain_id"`
+	DomainName  string   `json:"domain_name"`
+	UserID      string   `json:"user_id,omitempty"`
+	UserName    string   `json:"user_name,omitempty"`
+	Roles       []string `json:"roles"`
+	AuthMethods []string `json:"auth_methods,omitempty"`
+	ExpiresAt   int64    `json:"expires_at"`
+}
+
+func NewSharedTokenCache(sync gohan_sync.Sync, ttl time.Duration) *SharedTokenCache {
+	return &SharedTokenCache{
+		sync:       sync,
+		ttl:        ttl,
+		retryDelay: defaultSharedCacheRetryDelay,
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sharedTokenKey(tokenHash string) string {
+	return SyncKeyAuthCacheTokens + "/" + tokenHash
+}
+
+// OnRevoke registers a callback called with the hash of each revoked token
+func (s *SharedTokenCache) OnRevoke(callback func(tokenHash string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRevoke = append(s.onRevoke, callback)
+}
+
+// Get returns authorization of the token if it is cached and not expired
+func (s *SharedTokenCache) Get(ctx context.Context, token string) (schema.Authorization, bool) {
+	key := sharedTokenKey(hashToken(token))
+	node, err := s.sync.Fetch(ctx, key)
+	if err != nil || node == nil || node.Value == "" {
+		s.updateCounter(1, "miss")
+		return nil, false
+	}
+
+	var shared sharedAuthorization
+	if err := json.Unmarshal([]byte(node.Value), &shared); err != nil {
+		log.Warning("Invalid shared auth cache entry %s: %s", key, err)
+		s.updateCounter(1, "miss")
+		return nil, false
+	}
+	if time.Now().Unix() >= shared.ExpiresAt {
+		if err := s.sync.Delete(ctx, key, false); err != nil {
+			log.Debug("Failed to delete expired shared auth cache entry %s: %s", key, err)
+		}
+		s.updateCounter(1, "expired")
+		return nil, false
+	}
+
+	s.updateCounter(1, "hit")
+	return shared.authorization(), true
+}
+
+// Set stores authorization of the token, expiring after the cache TTL
+func (s *SharedTokenCache) Set(ctx context.Context, token string, auth schema.Authorization) {
+	shared := newSharedAuthorization(auth, time.Now().Add(s.ttl))
+	data, err := json.Marshal(shared)
+	if err != nil {
+		log.Warning("Failed to marshal authorization for shared auth cache: %s", err)
+		return
+	}
+	// entries are kept until read again only by sync backends which can't expire keys
+	if err := gohan_sync.UpdateWithTTL(ctx, s.sync, sharedTokenKey(hashToken(token)), string(data), s.ttl); err != nil {
+		s.updateCounter(1, "set_failed")
+		log.Warning("Failed to store token in shared auth cache: %s", err)
+	}
+}
+
+// Run watches the revocation prefix until the context is canceled
+func (s *SharedTokenCache) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	for {
+		if err := s.watchRevocations(ctx); err != nil {
+			log.Error("Shared auth cache revocation watch interrupted: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.retryDelay):
+		}
+	}
+}
+
+func (s *SharedTokenCache) watchRevocations(ctx context.Context) error {
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	defer watchCancel()
+
+	events := s.sync.Watch(watchCtx, SyncKeyAuthCacheRevoked, goext.RevisionCurrent)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, open := <-events:
+			if !open {
+				return nil
+			}
+			if event.Err != nil {
+				return event.Err
+			}
+			if event.Action == "delete" {
+				continue
+			}
+			s.revoked(ctx, strings.TrimPrefix(event.Key, SyncKeyAuthCacheRevoked+"/"))
+		}
+	}
+}
+
+func (s *SharedTokenCache) revoked(ctx context.Context, tokenHash string) {
+	s.updateCounter(1, "revocation_received")
+	if err := s.sync.Delete(ctx, sharedTokenKey(tokenHash), false); err != nil {
+		log.Warning("Failed to delete revoked token from shared auth cache: %s", err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, callback := range s.onRevoke {
+		callback(tokenHash)
+	}
+}
+
+func (s *SharedTokenCache) updateCounter(delta int64, action string) {
+	metrics.UpdateCounter(delta, "auth.shared_cache.%s", action)
+}
+
+func newSharedAuthorization(auth schema.Authorization, expiresAt time.Time) *sharedAuthorization {
+	shared := &sharedAuthorization{
+		Scope:       authScopeTenant,
+		TenantID:    auth.TenantID(),
+		TenantName:  auth.TenantName(),
+		DomainID:    auth.DomainID(),
+		DomainName:  auth.DomainName(),
+		UserID:      auth.UserID(),
+		UserName:    auth.UserName(),
+		AuthMethods: auth.AuthMethods(),
+		ExpiresAt:   expiresAt.Unix(),
+	}
+	switch auth.(type) {
+	case *schema.AdminAuthorization:
+		shared.Scope = authScopeAdmin
+	case *schema.DomainScopedAuthorization:
+		shared.Scope = authScopeDomain
+	}
+	for _, role := range auth.Roles() {
+		shared.Roles = append(shared.Roles, role.Name)
+	}
+	return shared
+}
+
+func (shared *sharedAuthorization) authorization() schema.Authorization {
+	builder := schema.NewAuthorizationBuilder().
+		WithDomain(schema.Domain{ID: shared.DomainID, Name: shared.DomainName}).
+		WithUser(schema.User{ID: shared.UserID, Name: shared.UserName}).
+		WithRoleIDs(shared.Roles...).
+		WithAuthMethods(shared.AuthMethods...)
+	switch shared.Scope {
+	case authScopeAdmin:
+		return builder.WithTenant(schema.Tenant{ID: shared.TenantID, Name: shared.TenantName}).BuildAdmin()
+	case authScopeDomain:
+		return builder.BuildScopedToDomain()
+	default:
+		return builder.WithTenant(schema.Tenant{ID: shared.TenantID, Name: shared.TenantName}).BuildScopedToTenant()
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	mock_sync "github.com/cloudwan/gohan/sync/mocks"
+)
+
+var _ = ginkgo.Describe("Shared token cache", func() {
+	var (
+		ctrl                  *gomock.Controller
+		mockedSync            *mock_sync.MockSync
+		mockedIdentityService *MockIdentityService
+		sharedCache           *SharedTokenCache
+		cachedIdentityService *CachedIdentityService
+		auth                  schema.Authorization
+		token                 string
+		key                   string
+	)
+
+	ginkgo.BeforeEach(func() {
+		ctrl = gomock.NewController(ginkgo.GinkgoT())
+		mockedSync = mock_sync.NewMockSync(ctrl)
+		mockedIdentityService = NewMockIdentityService(ctrl)
+		sharedCache = NewSharedTokenCache(mockedSync, time.Minute)
+		cachedIdentityService = NewCachedIdentityService(mockedIdentityService, time.Minute).(*CachedIdentityService)
+		cachedIdentityService.UseSharedCache(sharedCache)
+
+		token = "token"
+		key = SyncKeyAuthCacheTokens + "/" + hashToken(token)
+		auth = schema.NewAuthorizationBuilder().
+			WithTenant(schema.Tenant{ID: "tenant-id", Name: "tenant-name"}).
+			WithDomain(schema.Domain{ID: "domain-id", Name: "domain-name"}).
+			WithRoleIDs("Member").
+			WithAuthMethods("password").
+			BuildScopedToTenant()
+	})
+
+	ginkgo.AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	sharedValue := func(auth schema.Authorization, expiresAt time.Time) string {
+		data, err := json.Marshal(newSharedAuthorization(auth, expiresAt))
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	ginkgo.It("Stores verified tokens in the sync backend without the plain token", func() {
+		mockedSync.EXPECT().Fetch(gomock.Any(), key).Return(nil, fmt.Errorf("Key not found"))
+		mockedIdentityService.EXPECT().VerifyToken(token).Return(auth, nil)
+		mockedSync.EXPECT().Update(gomock.Any(), key, gomock.Any()).DoAndReturn(
+			func(_ context.Context, _, value string) error {
+				Expect(value).NotTo(ContainSubstring(token))
+				return nil
+			})
+
+		rv, err := cachedIdentityService.VerifyToken(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rv).To(Equal(auth))
+
+		rv, err = cachedIdentityService.VerifyToken(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rv).To(Equal(auth))
+	})
+
+	ginkgo.It("Uses tokens verified by other nodes", func() {
+		mockedSync.EXPECT().Fetch(gomock.Any(), key).Return(&gohan_sync.Node{
+			Key:   key,
+			Value: sharedValue(auth, time.Now().Add(time.Minute)),
+		}, nil)
+
+		rv, err := cachedIdentityService.VerifyToken(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rv).To(Equal(auth))
+	})
+
+	ginkgo.It("Preserves the scope of authorization", func() {
+		admin := schema.NewAuthorizationBuilder().
+			WithTenant(schema.Tenant{ID: "admin-id", Name: "admin"}).
+			WithRoleIDs("admin").
+			BuildAdmin()
+		domain := schema.NewAuthorizationBuilder().
+			WithDomain(schema.Domain{ID: "domain-id", Name: "domain-name"}).
+			WithRoleIDs("Member").
+			BuildScopedToDomain()
+
+		for _, a := range []schema.Authorization{admin, domain} {
+			Expect(newSharedAuthorization(a, time.Now()).authorization()).To(Equal(a))
+		}
+	})
+
+	ginkgo.It("Ignores and deletes expired entries", func() {
+		mockedSync.EXPECT().Fetch(gomock.Any(), key).Return(&gohan_sync.Node{
+			Key:   key,
+			Value: sharedValue(auth, time.Now().Add(-time.Minute)),
+		}, nil)
+		mockedSync.EXPECT().Delete(gomock.Any(), key, false).Return(nil)
+
+		rv, ok := sharedCache.Get(context.Background(), token)
+		Expect(ok).To(BeFalse())
+		Expect(rv).To(BeNil())
+	})
+
+	ginkgo.It("Evicts local entries of tokens revoked on other nodes", func() {
+		mockedSync.EXPECT().Fetch(gomock.Any(), key).Return(nil, fmt.Errorf("Key not found")).Times(2)
+		mockedIdentityService.EXPECT().VerifyToken(token).Return(auth, nil).Times(2)
+		mockedSync.EXPECT().Update(gomock.Any(), key, gomock.Any()).Return(nil).Times(2)
+
+		_, err := cachedIdentityService.VerifyToken(token)
+		Expect(err).NotTo(HaveOccurred())
+
+		mockedSync.EXPECT().Delete(gomock.Any(), key, false).Return(nil)
+		sharedCache.revoked(context.Background(), hashToken(token))
+
+		_, err = cachedIdentityService.VerifyToken(token)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	ginkgo.It("Stores entries expiring after the cache TTL", func() {
+		expiring := &expiringSync{MockSync: mockedSync}
+		sharedCache = NewSharedTokenCache(expiring, time.Minute)
+
+		sharedCache.Set(context.Background(), token, auth)
+		Expect(expiring.ttls).To(HaveKeyWithValue(key, time.Minute))
+	})
+})
+
+type expiringSync struct {
+	*mock_sync.MockSync
+	ttls map[string]time.Duration
+}
+
+func (s *expiringSync) UpdateWithTTL(ctx context.Context, path, json string, ttl time.Duration) error {
+	if s.ttls == nil {
+		s.ttls = map[string]time.Duration{}
+	}
+	s.ttls[path] = ttl
+	return nil
+}
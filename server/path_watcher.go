@@ -28,6 +28,7 @@ import (
 	"github.com/cloudwan/gohan/extension/goext"
 	"github.com/cloudwan/gohan/metrics"
 	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 	"github.com/cloudwan/gohan/util"
 	"github.com/pkg/errors"
 )
@@ -38,9 +39,19 @@ type PathWatcher struct {
 	path                      string
 	escapedPath               string
 	extensions                map[string]extension.Environment
+	election                  *election.Election
 	previousProcessedRevision int64
+	deadLetters               *DeadLetterQueue
+	maxAttempts               int
+	retryBackoff              time.Duration
 }
 
+const (
+	// notification extensions may not be idempotent, so they are not retried unless configured
+	defaultWatchMaxAttempts  = 1
+	defaultWatchRetryBackoff = 200 * time.Millisecond
+)
+
 var (
 	errInconsistentCluster = errors.New("inconsistent cluster state detected")
 	replacer               = strings.NewReplacer(".", "_", "/", "_")
@@ -48,14 +59,23 @@ var (
 
 func NewPathWatcher(sync gohan_sync.Sync, extensions map[string]extension.Environment, path string, priority int) *PathWatcher {
 	return &PathWatcher{
-		sync:        sync,
-		extensions:  extensions,
-		priority:    priority,
-		path:        path,
-		escapedPath: replacer.Replace(path),
+		sync:         sync,
+		extensions:   extensions,
+		election:     election.New(sync, "sync_watch"+path, lockPath+"/watch"+path),
+		priority:     priority,
+		path:         path,
+		escapedPath:  replacer.Replace(path),
+		maxAttempts:  deadLetterMaxAttempts("watch/max_attempts", defaultWatchMaxAttempts),
+		retryBackoff: util.GetConfig().GetDuration("watch/retry_backoff", defaultWatchRetryBackoff),
 	}
 }
 
+// UseDeadLetterQueue makes the watcher store events which extensions failed to handle
+// after all attempts in the dead letter queue
+func (watcher *PathWatcher) UseDeadLetterQueue(queue *DeadLetterQueue) {
+	watcher.deadLetters = queue
+}
+
 func (watcher PathWatcher) String() string {
 	sb := strings.Builder{}
 	sb.WriteString("(PathWatcher ")
@@ -102,15 +122,14 @@ func (watcher *PathWatcher) run(parentCtx context.Context) error {
 	watcher.updateCounter(1, "active")
 	defer watcher.updateCounter(-1, "active")
 
-	lockKey := lockPath + "/watch" + watcher.path
-	lost, err := watcher.sync.Lock(parentCtx, lockKey, false)
+	lost, err := watcher.election.Campaign(parentCtx, false)
 	if err != nil {
 		return errLockFailed
 	}
 	defer func() {
 		// can't use the parent context, it may be already canceled
-		if err := watcher.sync.Unlock(context.Background(), lockKey); err != nil {
-			log.Warning("%s unlocking etcd failed on %s: %s", watcher, lockKey, err)
+		if err := watcher.election.Resign(context.Background()); err != nil {
+			log.Warning("%s resigning failed: %s", watcher, err)
 		}
 	}()
 
@@ -249,12 +268,30 @@ func (watcher *PathWatcher) tryRecoverCompaction(ctx context.Context, event *goh
 func (watcher *PathWatcher) watchExtensionHandler(ctx context.Context, response *gohan_sync.Event) {
 	defer watcher.recoverPanic()
 
-	for event, env := range watcher.extensions {
-		if strings.HasPrefix(response.Key, "/"+event) {
-			watcher.runExtensionOnSync(ctx, response, env.Clone())
-			return
+	if env, ok := watchExtension(watcher.extensions, response.Key); ok {
+		watcher.runExtensionOnSync(ctx, response, env)
+	}
+}
+
+// watchExtension returns the environment of the watch event handling changes of the key
+func watchExtension(extensions map[string]extension.Environment, key string) (extension.Environment, bool) {
+	for event, env := range extensions {
+		if strings.HasPrefix(key, "/"+event) {
+			return env, true
 		}
 	}
+	return nil, false
+}
+
+// notificationContext is the context of the notification event handled by watch extensions
+func notificationContext(ctx context.Context, response *gohan_sync.Event) map[string]interface{} {
+	return map[string]interface{}{
+		"action":   response.Action,
+		"data":     response.Data,
+		"key":      response.Key,
+		"context":  ctx,
+		"trace_id": util.NewTraceID(),
+	}
 }
 
 func (watcher *PathWatcher) recoverPanic() {
@@ -314,20 +351,44 @@ func (watcher *PathWatcher) storeRevision(ctx context.Context, revision int64) e
 	return nil
 }
 
-//Run extension on sync
+//Run extension on sync, retrying failures and dead-lettering the event when all attempts failed
 func (watcher *PathWatcher) runExtensionOnSync(ctx context.Context, response *gohan_sync.Event, env extension.Environment) {
 	defer watcher.measureTime(time.Now(), response.Action)
 
-	context := map[string]interface{}{
-		"action":   response.Action,
-		"data":     response.Data,
-		"key":      response.Key,
-		"context":  ctx,
-		"trace_id": util.NewTraceID(),
+	var err error
+	for attempt := 1; attempt <= watcher.maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(watcher.retryBackoff):
+			case <-ctx.Done():
+				log.Warning("%s canceled while retrying, last processed event may be lost: %s", watcher, err)
+				return
+			}
+		}
+		if err = env.Clone().HandleEvent("notification", notificationContext(ctx, response)); err == nil {
+			return
+		}
+		watcher.updateCounter(1, "extension_failures")
+		log.Warning("%s extension error, attempt %d of %d: %s", watcher, attempt, watcher.maxAttempts, err)
 	}
-	if err := env.HandleEvent("notification", context); err != nil {
+
+	if watcher.deadLetters == nil {
 		log.Error("%s extension error, last processed event may be lost: %s", watcher, err)
+		return
+	}
+	payload := &pathWatcherPayload{
+		Path:     watcher.path,
+		Action:   response.Action,
+		Key:      response.Key,
+		Value:    response.Value,
+		Data:     response.Data,
+		Revision: response.Revision,
+	}
+	if dlErr := watcher.deadLetters.Add(ctx, DeadLetterSourcePathWatcher, response.Key, payload, err, watcher.maxAttempts); dlErr != nil {
+		log.Error("%s extension error, dead-lettering the event failed, it is lost: %s: %s", watcher, err, dlErr)
+		return
 	}
+	watcher.updateCounter(1, "dead_letters")
 }
 
 func (watcher *PathWatcher) measureTime(timeStarted time.Time, action string) {
@@ -17,6 +17,7 @@ package server_test
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"sync"
 	"time"
@@ -205,4 +206,71 @@ var _ = Describe("Sync watcher test", func() {
 
 		thenLastProcessedRevisionIs(firstEventRevision)
 	})
+
+	It("Dead-letters events which extensions failed to handle", func() {
+		testCtx, waitForDone := synchronizeTest()
+		defer waitForDone()
+
+		queue := srv.NewDeadLetterQueue(testDB, server.GetSync())
+		pw.UseDeadLetterQueue(queue)
+
+		mockEnv.EXPECT().Clone().AnyTimes().Return(mockEnv)
+		mockEnv.EXPECT().HandleEvent("notification", gomock.Any()).Times(1).Return(errors.New("extension failed"))
+
+		revision := givenWatchedKeySet(`{"index": 1}`)
+		givenLastSeenRevisionForcedTo(revision - 1)
+
+		whenPathWatcherStarted(testCtx)
+
+		thenLastProcessedRevisionIs(revision)
+		deadLetters, err := queue.List(ctx, srv.DeadLetterSourcePathWatcher)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deadLetters).To(HaveLen(1))
+		Expect(deadLetters[0].Get("sync_key")).To(Equal(watchedKey))
+		Expect(deadLetters[0].Get("last_error")).To(Equal("extension failed"))
+
+		Expect(queue.Discard(ctx, deadLetters[0].Get("id").(int))).To(Succeed())
+	})
+
+	It("Retries dead-lettered events by extensions of the watch event", func() {
+		testCtx, waitForDone := synchronizeTest()
+		defer waitForDone()
+
+		queue := srv.NewDeadLetterQueue(testDB, server.GetSync())
+		queue.UseWatchExtensions(map[string]extension.Environment{"path_watcher/test": mockEnv})
+		pw.UseDeadLetterQueue(queue)
+
+		retried := make(chan map[string]interface{}, 1)
+		mockEnv.EXPECT().Clone().AnyTimes().Return(mockEnv)
+		gomock.InOrder(
+			mockEnv.EXPECT().HandleEvent("notification", gomock.Any()).Return(errors.New("extension failed")),
+			mockEnv.EXPECT().HandleEvent("notification", gomock.Any()).DoAndReturn(func(event string, context map[string]interface{}) error {
+				retried <- context
+				return nil
+			}),
+		)
+
+		revision := givenWatchedKeySet(`plain value`)
+		givenLastSeenRevisionForcedTo(revision - 1)
+
+		whenPathWatcherStarted(testCtx)
+
+		thenLastProcessedRevisionIs(revision)
+		deadLetters, err := queue.List(ctx, srv.DeadLetterSourcePathWatcher)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deadLetters).To(HaveLen(1))
+		Expect(deadLetters[0].Get("payload")).To(ContainSubstring(`"value":"plain value"`))
+		Expect(deadLetters[0].Get("payload")).To(ContainSubstring(`"path":"` + watchedKey + `"`))
+
+		Expect(queue.Retry(ctx, deadLetters[0].Get("id").(int))).To(Succeed())
+		var context map[string]interface{}
+		Eventually(retried).Should(Receive(&context))
+		Expect(context).To(HaveKeyWithValue("key", watchedKey))
+		Expect(context).To(HaveKeyWithValue("action", "set"))
+		Expect(getRevision(watchedKey)).To(Equal(revision))
+
+		deadLetters, err = queue.List(ctx, srv.DeadLetterSourcePathWatcher)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deadLetters).To(BeEmpty())
+	})
 })
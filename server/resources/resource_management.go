@@ -25,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cloudwan/gohan/audit"
 	"github.com/cloudwan/gohan/db"
 	"github.com/cloudwan/gohan/db/pagination"
 	"github.com/cloudwan/gohan/db/search"
@@ -233,10 +234,11 @@ func GetResourcesInTransaction(context middleware.Context, resourceSchema *schem
 	response := map[string]interface{}{}
 
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("no environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 
 	if err := extension.HandleEvent(context, environment, "pre_list_in_transaction", resourceSchema.ID); err != nil {
 		return err
@@ -353,6 +355,10 @@ func GetMultipleResources(context middleware.Context, dataStore db.DB, resourceS
 	}
 
 	propertiesFilter = filter.And(propertiesFilter, customFilters)
+	propertiesFilter, err = applyOutOfSyncFilter(resourceSchema, propertiesFilter, queryParameters)
+	if err != nil {
+		return err
+	}
 	extendFilterByTenantAndDomain(resourceSchema, propertiesFilter, schema.ActionRead, currCond, auth)
 
 	paginator, err := pagination.FromURLQuery(resourceSchema, queryParameters)
@@ -366,10 +372,11 @@ func GetMultipleResources(context middleware.Context, dataStore db.DB, resourceS
 	}
 
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 	if err := extension.HandleEvent(context, environment, "pre_list", resourceSchema.ID); err != nil {
 		return err
 	}
@@ -409,6 +416,23 @@ func applyAnyOfFilter(propertiesFilter map[string]interface{}, queryParameters m
 	return filterFunc(filters...)
 }
 
+func applyOutOfSyncFilter(resourceSchema *schema.Schema, propertiesFilter map[string]interface{}, queryParameters map[string][]string) (map[string]interface{}, error) {
+	outOfSync, ok := queryParameters["out_of_sync"]
+	if !ok || len(outOfSync) == 0 {
+		return propertiesFilter, nil
+	}
+	if !resourceSchema.StateVersioning() {
+		err := fmt.Errorf("out_of_sync is supported only for schemas with state versioning")
+		return nil, ResourceError{err, err.Error(), WrongQuery}
+	}
+	value, err := strconv.ParseBool(outOfSync[0])
+	if err != nil {
+		err = fmt.Errorf("invalid out_of_sync value %q", outOfSync[0])
+		return nil, ResourceError{err, err.Error(), WrongQuery}
+	}
+	return filter.And(propertiesFilter, filter.OutOfSync(value)), nil
+}
+
 func verifyQueryParams(resourceSchema *schema.Schema, queryParameters map[string][]string) error {
 	for _, key := range resourceSchema.Properties {
 		delete(queryParameters, key.ID)
@@ -420,6 +444,7 @@ func verifyQueryParams(resourceSchema *schema.Schema, queryParameters map[string
 
 	delete(queryParameters, "search_field")
 	delete(queryParameters, "any_of")
+	delete(queryParameters, "out_of_sync")
 
 	delete(queryParameters, "_details")
 	delete(queryParameters, "_fields")
@@ -446,10 +471,11 @@ func GetSingleResource(context middleware.Context, dataStore db.DB, resourceSche
 	}
 
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 	if err := extension.HandleEvent(context, environment, "pre_show", resourceSchema.ID); err != nil {
 		return err
 	}
@@ -493,10 +519,11 @@ func GetSingleResourceInTransaction(context middleware.Context, resourceSchema *
 	}
 	mainTransaction := mustGetTransaction(context)
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("no environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 
 	if err := extension.HandleEvent(context, environment, "pre_show_in_transaction", resourceSchema.ID); err != nil {
 		return err
@@ -630,11 +657,12 @@ func CreateResource(
 	manager := schema.GetManager()
 	// Load environment
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 	auth := context["auth"].(schema.Authorization)
 
 	//LoadPolicy
@@ -766,10 +794,11 @@ func CreateResourceInTransaction(context middleware.Context, resourceSchema *sch
 	manager := schema.GetManager()
 	mainTransaction := mustGetTransaction(context)
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 
 	data := resource.Data()
 	tenancy := schema.NewTenancy(data)
@@ -822,10 +851,11 @@ func UpdateResource(
 
 	//load environment
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 
 	auth := context["auth"].(schema.Authorization)
 
@@ -903,10 +933,11 @@ func UpdateResourceInTransaction(
 	manager := schema.GetManager()
 	mainTransaction := mustGetTransaction(context)
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 	filter := transaction.IDFilter(resourceID)
 	if _, err := resourceSchema.GetPropertyByID(tenantIDKey); err == nil && tenantIDs != nil {
 		filter[tenantIDKey] = tenantIDs
@@ -997,10 +1028,11 @@ func DeleteResource(ctx middleware.Context,
 	defer MeasureRequestTime(time.Now(), "delete", resourceSchema.ID)
 	ctx["id"] = resourceID
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 
 	var resource *schema.Resource
 	var fetchErr error
@@ -1090,10 +1122,11 @@ func DeleteResourceInTransaction(context middleware.Context, resourceSchema *sch
 	defer MeasureRequestTime(time.Now(), "delete.in_tx", resourceSchema.ID)
 	mainTransaction := mustGetTransaction(context)
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 
 	auth := context["auth"].(schema.Authorization)
 	policy := context["policy"].(*schema.Policy)
@@ -1154,10 +1187,11 @@ func ActionResource(context middleware.Context, dataStore db.DB, resourceSchema
 	context["id"] = resourceID
 
 	environmentManager := extension.GetManager()
-	environment, ok := environmentManager.GetEnvironment(resourceSchema.ID)
+	environment, ok := environmentManager.AcquireEnvironment(resourceSchema.ID)
 	if !ok {
 		return fmt.Errorf("No environment for schema")
 	}
+	defer environmentManager.ReleaseEnvironment(environment)
 
 	if actionSchema != nil {
 		err := resourceSchema.Validate(actionSchema, data)
@@ -1427,8 +1461,11 @@ func extendFilterByTenantAndDomain(
 
 func LoadPolicy(context middleware.Context, action, path string, auth schema.Authorization) (*schema.Policy, error) {
 	manager := schema.GetManager()
-	policy, role := manager.PolicyValidate(action, path, auth)
-	if policy == nil {
+	policy, role := manager.MatchPolicy(action, path, auth)
+	if audit.Enabled() {
+		auditPolicyDecision(context, action, path, auth, policy, role)
+	}
+	if policy == nil || policy.IsDeny() {
 		err := fmt.Errorf(fmt.Sprintf("No matching policy: %s %s", action, path))
 		return nil, ResourceError{err, err.Error(), Unauthorized}
 	}
@@ -1438,6 +1475,21 @@ func LoadPolicy(context middleware.Context, action, path string, auth schema.Aut
 	return policy, nil
 }
 
+// auditPolicyDecision records the decision of the policy. Decisions made for API requests
+// are logged by the Authorization middleware when the request is handled, as access
+// to the resource may still be denied after the policy allowed the request.
+func auditPolicyDecision(context middleware.Context, action, path string, auth schema.Authorization, policy *schema.Policy, role *schema.Role) {
+	request, _ := context["audit_request"].(*audit.Request)
+	record := audit.NewRecord(auth, action, path, policy, role).WithRequest(request)
+	record.TraceID, _ = context["trace_id"].(string)
+	record.ResourceID, _ = context["id"].(string)
+	if request != nil {
+		context["audit_record"] = record
+		return
+	}
+	audit.Log(record)
+}
+
 type dataTraversal struct {
 	currentProperty *schema.Property
 	currentChildren []schema.Property
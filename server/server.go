@@ -33,17 +33,23 @@ import (
 	"time"
 
 	"github.com/braintree/manners"
+	"github.com/cloudwan/gohan/audit"
 	"github.com/cloudwan/gohan/db"
 	"github.com/cloudwan/gohan/db/dbutil"
 	"github.com/cloudwan/gohan/db/initializer"
 	"github.com/cloudwan/gohan/db/migration"
 	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/eventsink"
+	"github.com/cloudwan/gohan/extension"
 	"github.com/cloudwan/gohan/healthcheck"
+	"github.com/cloudwan/gohan/httpclient"
 	l "github.com/cloudwan/gohan/log"
 	"github.com/cloudwan/gohan/metrics"
 	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/secret"
 	"github.com/cloudwan/gohan/server/middleware"
 	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/namespace"
 	sync_util "github.com/cloudwan/gohan/sync/util"
 	"github.com/cloudwan/gohan/util"
 	"github.com/cloudwan/gohan/version"
@@ -69,8 +75,16 @@ type Server struct {
 	martini          *martini.ClassicMartini
 	extensions       []string
 	keystoneIdentity middleware.IdentityService
+	sharedTokenCache *middleware.SharedTokenCache
 	HealthCheck      *healthcheck.HealthCheck
 
+	extensionReloader *ExtensionReloader
+	compactor         *Compactor
+
+	// paths of environments registered by registerEnvironmentForPath, by their names
+	pathEnvironments   map[string]string
+	pathEnvironmentsMu sync_lib.Mutex
+
 	masterCtx       context.Context
 	masterCtxCancel context.CancelFunc
 	done            sync_lib.WaitGroup
@@ -83,6 +97,14 @@ func (server *Server) mapRoutes() {
 	mapVersionRoute(server.martini, schemaManager)
 	MapNamespacesRoutes(server.martini)
 	MapRouteBySchemas(server, server.db)
+	mapTenantPurgeRoute(server)
+	mapDeadLetterRoutes(server)
+	mapJobRoutes(server)
+	mapClusterRoutes(server)
+	mapCompactionRoutes(server)
+	mapExtensionReloadRoutes(server)
+	mapExtensionProfilingRoutes(server)
+	mapDebugTraceRoutes(server)
 
 	if txErr := db.WithinTx(server.db, func(tx transaction.Transaction) error {
 		ctx := context.Background()
@@ -213,7 +235,13 @@ func NewServer(configFile string) (*Server, error) {
 	}
 	log.Info("logging initialized")
 
-	server := &Server{}
+	roleHierarchy, err := schema.NewRoleHierarchyFromConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Role hierarchy error: %s", err)
+	}
+	manager.SetRoleHierarchy(roleHierarchy)
+
+	server := &Server{pathEnvironments: map[string]string{}}
 
 	m := martini.Classic()
 	m.Handlers()
@@ -293,6 +321,25 @@ func NewServer(configFile string) (*Server, error) {
 		return nil, err
 	}
 
+	if err = audit.SetupAudit(config, server.db); err != nil {
+		return nil, err
+	}
+
+	if err = secret.SetupSecrets(config); err != nil {
+		return nil, fmt.Errorf("Secrets setup error: %s", err)
+	}
+
+	if err = httpclient.SetupHTTPClient(config); err != nil {
+		return nil, fmt.Errorf("HTTP client setup error: %s", err)
+	}
+
+	extension.SetupProfiling(config)
+	extension.SetupTracing(config)
+
+	if _, err = eventsink.LoadConfigs(config); err != nil {
+		return nil, err
+	}
+
 	if config.GetList("database/initial_data", nil) != nil {
 		initialDataList := config.GetList("database/initial_data", nil)
 		for _, initialData := range initialDataList {
@@ -311,6 +358,11 @@ func NewServer(configFile string) (*Server, error) {
 
 	if config.GetBool("keystone/use_keystone", false) {
 		server.keystoneIdentity, err = middleware.CreateIdentityServiceFromConfig(config)
+		if err == nil && config.GetBool("keystone/use_shared_auth_cache", false) {
+			if err := server.setupSharedTokenCache(config); err != nil {
+				return nil, fmt.Errorf("Shared auth cache setup error: %s", err)
+			}
+		}
 		m.MapTo(server.keystoneIdentity, (*middleware.IdentityService)(nil))
 		m.Use(middleware.Authentication())
 	} else {
@@ -320,6 +372,9 @@ func NewServer(configFile string) (*Server, error) {
 			WithRoleIDs("admin").
 			BuildAdmin()
 		m.Map(auth)
+		m.Use(func(req *http.Request, c martini.Context) {
+			c.Map(schema.WithRequestAttributes(auth, schema.NewRequestAttributes(req, nil)))
+		})
 	}
 
 	if err != nil {
@@ -403,8 +458,15 @@ func NewServer(configFile string) (*Server, error) {
 		}))
 	}
 	server.HealthCheck = healthcheck.NewHealthCheck(server.db, server.sync, server.address, config)
+	if server.sync != nil {
+		server.compactor = NewCompactorFromServer(server)
+	}
 	server.mapRoutes()
 
+	if config.GetBool("extension/hot_reload/enabled", false) {
+		server.extensionReloader = NewExtensionReloader(server)
+	}
+
 	return server, nil
 }
 
@@ -460,6 +522,7 @@ func (server *Server) Stop() {
 	stopCRONProcess(server)
 	manners.Close()
 	server.done.Wait()
+	audit.Stop()
 }
 
 //RunServer runs gohan api server
@@ -498,12 +561,20 @@ func RunServer(configFile string) {
 
 	server.startSyncProcesses()
 
+	if server.extensionReloader != nil {
+		server.startSyncProcess(server.extensionReloader)
+	}
+
 	startCRONProcess(server)
 	metrics.StartMetricsProcess()
 	err = server.Start()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if server.sync != nil {
+		// releases locks of this node and writes pending changes of the memory backend
+		server.sync.Close()
+	}
 }
 
 func (server *Server) startSyncProcesses() {
@@ -511,17 +582,67 @@ func (server *Server) startSyncProcesses() {
 		return
 	}
 
+	if server.sharedTokenCache != nil {
+		server.startSyncProcess(server.sharedTokenCache)
+	}
+
 	stateWatcher := NewStateWatcher(server.sync, server.db, server.keystoneIdentity)
 	server.startSyncProcess(stateWatcher)
 
+	stateTimeoutChecker := NewStateTimeoutCheckerFromServer(server)
+	server.startSyncProcess(stateTimeoutChecker)
+
 	transactionCommitInformer := NewTransactionCommitInformer(server.sync)
 	server.startSyncProcess(transactionCommitInformer)
 
-	syncWriter := NewSyncWriter(server.sync, server.db)
+	sinks := server.startEventSinks()
+
+	syncWriter := NewSyncWriterWithEventSinks(server.sync, server.db, sinks)
 	server.startSyncProcess(syncWriter)
 
 	syncWatcher := NewSyncWatcherFromServer(server)
 	server.startSyncProcess(syncWatcher)
+
+	if util.GetConfig().GetBool("compaction/enabled", false) {
+		if _, ok := server.sync.(*namespace.Sync); ok {
+			log.Warning("Compaction is disabled, the sync backend is shared with other clusters by sync_prefix")
+		} else {
+			server.startSyncProcess(server.compactor)
+		}
+	}
+
+	if util.GetConfig().GetBool("sync_verify/enabled", false) {
+		server.startSyncProcess(NewSyncVerifyJob(server))
+	}
+
+	if util.GetConfig().GetBool("jobs/enabled", false) {
+		server.startSyncProcess(NewJobWorkerFromServer(server))
+	}
+
+	if util.GetConfig().GetBool("tenant_purge/reconciler/enabled", false) {
+		if server.keystoneIdentity == nil {
+			log.Warning("Tenant purge reconciler requires keystone, not starting it")
+		} else {
+			server.startSyncProcess(NewTenantPurgeReconciler(server))
+		}
+	}
+}
+
+func (server *Server) setupSharedTokenCache(config *util.Config) error {
+	cached, ok := server.keystoneIdentity.(*middleware.CachedIdentityService)
+	if !ok {
+		return fmt.Errorf("keystone/use_shared_auth_cache requires keystone/use_auth_cache")
+	}
+	if server.sync == nil {
+		return fmt.Errorf("keystone/use_shared_auth_cache requires sync backend")
+	}
+	ttl, err := time.ParseDuration(config.GetString("keystone/cache_ttl", "15m"))
+	if err != nil {
+		return fmt.Errorf("failed to parse keystone cache TTL: %s", err)
+	}
+	server.sharedTokenCache = middleware.NewSharedTokenCache(server.sync, ttl)
+	cached.UseSharedCache(server.sharedTokenCache)
+	return nil
 }
 
 type syncProcess interface {
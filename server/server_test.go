@@ -40,6 +40,7 @@ import (
 	srv "github.com/cloudwan/gohan/server"
 	"github.com/cloudwan/gohan/server/middleware"
 	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 	mock_sync "github.com/cloudwan/gohan/sync/mocks"
 	sync_util "github.com/cloudwan/gohan/sync/util"
 	"github.com/cloudwan/gohan/util"
@@ -391,6 +392,99 @@ var _ = Describe("Server package test", func() {
 		})
 	})
 
+	Describe("Tenant purge", func() {
+		tenantPurgeURL := func(tenantID string) string {
+			return baseURL + "/_tenants/" + tenantID + "/purge"
+		}
+
+		BeforeEach(func() {
+			testURL("POST", networkPluralURL, adminTokenID, getNetwork("red", "purged"), http.StatusCreated)
+			testURL("POST", getSubnetFullPluralURL("red"), adminTokenID, getSubnet("red", "purged", "networkred"), http.StatusCreated)
+			testURL("POST", networkPluralURL, adminTokenID, getNetwork("blue", "kept"), http.StatusCreated)
+		})
+
+		It("should list resources of the tenant in dry-run mode", func() {
+			result := testURL("POST", tenantPurgeURL("purged")+"?dry_run=true", adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("dry_run", true))
+			Expect(result).To(HaveKeyWithValue("resources", And(
+				HaveKeyWithValue("network", ConsistOf("networkred")),
+				HaveKeyWithValue("subnet", ConsistOf("subnetred")),
+			)))
+
+			result = testURL("GET", networkPluralURL, adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("networks", HaveLen(2)))
+		})
+
+		It("should delete resources of the tenant children first", func() {
+			result := testURL("POST", tenantPurgeURL("purged"), adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("deleted", BeNumerically("==", 2)))
+
+			testURL("GET", getSubnetSingularURL("red"), adminTokenID, nil, http.StatusNotFound)
+			testURL("GET", getNetworkSingularURL("red"), adminTokenID, nil, http.StatusNotFound)
+			testURL("GET", getNetworkSingularURL("blue"), adminTokenID, nil, http.StatusOK)
+		})
+
+		It("should be allowed only for admin", func() {
+			testURL("POST", tenantPurgeURL("purged"), memberTokenID, nil, http.StatusForbidden)
+			testURL("GET", getNetworkSingularURL("red"), adminTokenID, nil, http.StatusOK)
+		})
+	})
+
+	Describe("Dead letters", func() {
+		deadLettersURL := baseURL + "/_dead_letters"
+
+		It("should list, inspect and discard dead letters", func() {
+			queue := srv.NewDeadLetterQueue(testDB, server.GetSync())
+			Expect(queue.Add(context.Background(), srv.DeadLetterSourcePathWatcher, "/watched/key",
+				map[string]interface{}{"action": "set", "key": "/watched/key"}, fmt.Errorf("extension failed"), 3)).To(Succeed())
+
+			result := testURL("GET", deadLettersURL+"?source=path_watcher", adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("dead_letters", HaveLen(1)))
+			deadLetter := result.(map[string]interface{})["dead_letters"].([]interface{})[0].(map[string]interface{})
+			Expect(deadLetter).To(HaveKeyWithValue("last_error", "extension failed"))
+			deadLetterURL := fmt.Sprintf("%s/%v", deadLettersURL, deadLetter["id"])
+
+			result = testURL("GET", deadLetterURL, adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("dead_letter", HaveKeyWithValue("sync_key", "/watched/key")))
+
+			testURL("DELETE", deadLetterURL, adminTokenID, nil, http.StatusNoContent)
+			testURL("GET", deadLetterURL, adminTokenID, nil, http.StatusNotFound)
+		})
+
+		It("should be allowed only for admin", func() {
+			testURL("GET", deadLettersURL, memberTokenID, nil, http.StatusForbidden)
+			testURL("POST", deadLettersURL+"/1/retry", memberTokenID, nil, http.StatusForbidden)
+		})
+	})
+
+	Describe("Cluster leaders", func() {
+		leadersURL := baseURL + "/_cluster/leaders"
+
+		It("should list leaders and request resignation", func() {
+			gohanSync := server.GetSync()
+			testElection := election.New(gohanSync, "test/leader", "/gohan/cluster/lock/test_leader")
+			lost, err := testElection.Campaign(context.Background(), false)
+			Expect(err).ToNot(HaveOccurred())
+			defer testElection.Resign(context.Background())
+
+			result := testURL("GET", leadersURL, adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("leaders", ContainElement(SatisfyAll(
+				HaveKeyWithValue("role", "test/leader"),
+				HaveKeyWithValue("process_id", gohanSync.GetProcessID()),
+			))))
+
+			result = testURL("POST", leadersURL+"/test/leader/resign", adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("leader", HaveKeyWithValue("role", "test/leader")))
+			Eventually(lost).Should(BeClosed())
+			testURL("POST", leadersURL+"/test/leader/resign", adminTokenID, nil, http.StatusNotFound)
+		})
+
+		It("should be allowed only for admin", func() {
+			testURL("GET", leadersURL, memberTokenID, nil, http.StatusForbidden)
+			testURL("POST", leadersURL+"/sync_writer/resign", memberTokenID, nil, http.StatusForbidden)
+		})
+	})
+
 	Describe("NullableProperties", func() {
 		It("should work", func() {
 			network := getNetwork("red", "red")
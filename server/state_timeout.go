@@ -0,0 +1,218 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/sql"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/extension"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
+	"github.com/cloudwan/gohan/util"
+)
+
+const (
+	//StateTimeoutEventName is raised when state_version doesn't match config_version within the state_timeout of a schema
+	StateTimeoutEventName = "state_timeout"
+
+	stateTimeoutLock            = lockPath + "/state_timeout"
+	defaultStateTimeoutInterval = 30 * time.Second
+)
+
+// outOfSyncResource tracks since when a config version of a resource is not acknowledged
+type outOfSyncResource struct {
+	configVersion int64
+	since         time.Time
+	raised        bool
+}
+
+// StateTimeoutChecker periodically lists resources of state versioned schemas having
+// a "state_timeout" in metadata, and raises the state_timeout event for resources whose
+// state_version didn't match config_version within that timeout.
+// Only the elected node of the cluster runs the check. Out of sync resources are tracked
+// in memory, so the timeout starts over when another node takes over.
+type StateTimeoutChecker struct {
+	sync     gohan_sync.Sync
+	db       db.DB
+	election *election.Election
+	interval time.Duration
+	tracked  map[string]map[string]*outOfSyncResource
+}
+
+// NewStateTimeoutChecker creates a new instance of StateTimeoutChecker
+func NewStateTimeoutChecker(sync gohan_sync.Sync, db db.DB) *StateTimeoutChecker {
+	return &StateTimeoutChecker{
+		sync:     sync,
+		db:       db,
+		election: election.New(sync, "state_timeout", stateTimeoutLock),
+		interval: util.GetConfig().GetDuration("state_timeout/interval", defaultStateTimeoutInterval),
+		tracked:  map[string]map[string]*outOfSyncResource{},
+	}
+}
+
+// NewStateTimeoutCheckerFromServer is a constructor for StateTimeoutChecker
+func NewStateTimeoutCheckerFromServer(server *Server) *StateTimeoutChecker {
+	return NewStateTimeoutChecker(server.sync, server.db)
+}
+
+// Run checks state versioned resources every interval until the context is canceled
+func (checker *StateTimeoutChecker) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	for {
+		if err := checker.run(ctx); err != nil {
+			log.Error("State timeout checker was interrupted: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(checker.interval):
+		}
+	}
+}
+
+// run waits for the leadership and checks resources until it is lost,
+// so that the event is raised by a single node for each config version
+func (checker *StateTimeoutChecker) run(ctx context.Context) error {
+	lost, err := checker.election.Campaign(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer checker.election.Resign(context.Background())
+
+	// resources tracked during a previous term may have been raised by another node since
+	checker.tracked = map[string]map[string]*outOfSyncResource{}
+	ticker := time.NewTicker(checker.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lost:
+			return fmt.Errorf("lost lock for state timeout checker")
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := checker.Check(ctx); err != nil {
+				log.Error("State timeout check failed: %s", err)
+			}
+		}
+	}
+}
+
+// Check lists out of sync resources of all schemas with a state timeout, updates gauges
+// and raises the state_timeout event once per config version of each timed out resource.
+// It returns the number of raised events.
+func (checker *StateTimeoutChecker) Check(ctx context.Context) (int, error) {
+	raised := 0
+	for _, s := range schema.GetManager().OrderedSchemas() {
+		if s.IsAbstract() || !s.StateVersioning() {
+			continue
+		}
+		timeout, ok := s.StateTimeout()
+		if !ok {
+			continue
+		}
+		n, err := checker.checkSchema(ctx, s, timeout)
+		raised += n
+		if err != nil {
+			return raised, err
+		}
+	}
+	return raised, nil
+}
+
+func (checker *StateTimeoutChecker) checkSchema(ctx context.Context, s *schema.Schema, timeout time.Duration) (int, error) {
+	var states []transaction.ResourceState
+	err := db.WithinTx(checker.db, func(tx transaction.Transaction) (err error) {
+		states, err = tx.StateList(ctx, s, transaction.Filter{sql.OutOfSyncCondition: true})
+		return err
+	}, transaction.Context(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	previous := checker.tracked[s.ID]
+	current := make(map[string]*outOfSyncResource, len(states))
+	timedOut := 0
+	raised := 0
+	for _, state := range states {
+		resource, ok := previous[state.ID]
+		if !ok || resource.configVersion != state.ConfigVersion {
+			resource = &outOfSyncResource{configVersion: state.ConfigVersion, since: now}
+		}
+		current[state.ID] = resource
+		if now.Sub(resource.since) < timeout {
+			continue
+		}
+		timedOut++
+		if resource.raised {
+			continue
+		}
+		if err := checker.raiseStateTimeout(ctx, s, state, now.Sub(resource.since)); err != nil {
+			log.Warning("Failed to raise %s for %s %s: %s", StateTimeoutEventName, s.ID, state.ID, err)
+			continue
+		}
+		resource.raised = true
+		raised++
+	}
+	checker.tracked[s.ID] = current
+
+	metrics.UpdateGauge(int64(len(states)), "state_timeout.%s.out_of_sync", s.ID)
+	metrics.UpdateGauge(int64(timedOut), "state_timeout.%s.timed_out", s.ID)
+	return raised, nil
+}
+
+func (checker *StateTimeoutChecker) raiseStateTimeout(ctx context.Context, s *schema.Schema, state transaction.ResourceState, outOfSync time.Duration) error {
+	log.Warning("State of %s %s didn't reach config version %d within %s, state version is %d",
+		s.ID, state.ID, state.ConfigVersion, outOfSync, state.StateVersion)
+	metrics.UpdateCounter(1, "state_timeout.%s.raised", s.ID)
+
+	environment, ok := extension.GetManager().AcquireEnvironment(s.ID)
+	if !ok {
+		return nil
+	}
+	defer extension.GetManager().ReleaseEnvironment(environment)
+	return db.WithinTx(checker.db, func(tx transaction.Transaction) error {
+		resource, err := tx.Fetch(ctx, s, transaction.IDFilter(state.ID), nil)
+		if err != nil {
+			return err
+		}
+		context := map[string]interface{}{
+			"resource": resource.Data(),
+			"schema":   s,
+			"state": map[string]interface{}{
+				"version":    state.StateVersion,
+				"error":      state.Error,
+				"state":      state.State,
+				"monitoring": state.Monitoring,
+			},
+			"config_version":      state.ConfigVersion,
+			"out_of_sync_seconds": int64(outOfSync.Seconds()),
+			"transaction":         tx,
+			"context":             ctx,
+		}
+		return extension.HandleEvent(context, environment, StateTimeoutEventName, s.ID)
+	}, transaction.Context(ctx))
+}
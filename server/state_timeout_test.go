@@ -0,0 +1,119 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/dbutil"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	srv "github.com/cloudwan/gohan/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const stateTimeoutTestPluralURL = baseURL + "/v2.0/state_timeout_tests"
+
+var _ = Describe("State timeout", func() {
+	var (
+		ctx        context.Context
+		testSchema *schema.Schema
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var ok bool
+		testSchema, ok = schema.GetManager().Schema("state_timeout_test")
+		Expect(ok).To(BeTrue())
+
+		resource, err := schema.GetManager().LoadResource("state_timeout_test", map[string]interface{}{
+			"id":        "stuck",
+			"tenant_id": adminTenantID,
+			"domain_id": "default",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.WithinTx(srv.NewDbSyncWrapper(testDB), func(tx transaction.Transaction) error {
+			_, err := tx.Create(ctx, resource)
+			return err
+		})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(db.WithinTx(testDB, func(tx transaction.Transaction) error {
+			for _, s := range schema.GetManager().Schemas() {
+				if whitelist[s.ID] {
+					continue
+				}
+				if err := dbutil.ClearTable(ctx, tx, s); err != nil {
+					return err
+				}
+			}
+			return nil
+		})).To(Succeed())
+	})
+
+	acknowledge := func() {
+		Expect(db.WithinTx(testDB, func(tx transaction.Transaction) error {
+			resource, err := tx.Fetch(ctx, testSchema, transaction.IDFilter("stuck"), nil)
+			if err != nil {
+				return err
+			}
+			state, err := tx.StateFetch(ctx, testSchema, transaction.IDFilter("stuck"))
+			if err != nil {
+				return err
+			}
+			state.StateVersion = state.ConfigVersion
+			return tx.StateUpdate(ctx, resource, &state)
+		})).To(Succeed())
+	}
+
+	It("should list out of sync resources", func() {
+		result := testURL("GET", stateTimeoutTestPluralURL+"?out_of_sync=true", adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("state_timeout_tests", HaveLen(1)))
+		result = testURL("GET", stateTimeoutTestPluralURL+"?out_of_sync=false", adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("state_timeout_tests", BeEmpty()))
+
+		acknowledge()
+		result = testURL("GET", stateTimeoutTestPluralURL+"?out_of_sync=true", adminTokenID, nil, http.StatusOK)
+		Expect(result).To(HaveKeyWithValue("state_timeout_tests", BeEmpty()))
+	})
+
+	It("should reject out_of_sync for schemas without state versioning", func() {
+		testURL("GET", filterTestPluralURL+"?out_of_sync=true", adminTokenID, nil, http.StatusBadRequest)
+	})
+
+	It("should raise state_timeout once when the state timeout is exceeded", func() {
+		checker := srv.NewStateTimeoutCheckerFromServer(server)
+		Expect(checker.Check(ctx)).To(Equal(0))
+
+		time.Sleep(150 * time.Millisecond)
+		Expect(checker.Check(ctx)).To(Equal(1))
+		Expect(checker.Check(ctx)).To(Equal(0))
+	})
+
+	It("should not raise state_timeout for acknowledged resources", func() {
+		checker := srv.NewStateTimeoutCheckerFromServer(server)
+		Expect(checker.Check(ctx)).To(Equal(0))
+
+		acknowledge()
+		time.Sleep(150 * time.Millisecond)
+		Expect(checker.Check(ctx)).To(Equal(0))
+	})
+})
@@ -31,12 +31,14 @@ import (
 	"github.com/cloudwan/gohan/schema"
 	"github.com/cloudwan/gohan/server/middleware"
 	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 )
 
 const (
 	stateWatchPrefix = "/state_watch"
 	statePrefix      = stateWatchPrefix + "/state"
 	monitoringPrefix = stateWatchPrefix + "/monitoring"
+	stateWatchLock   = lockPath + "/state_watch"
 
 	//StateUpdateEventName used in etcd path
 	StateUpdateEventName = "state_update"
@@ -55,6 +57,7 @@ type StateWatcher struct {
 	db       db.DB
 	identity middleware.IdentityService
 	backoff  time.Duration
+	election *election.Election
 }
 
 // NewStateWatcher creates a new instance of StateWatcher.
@@ -64,6 +67,7 @@ func NewStateWatcher(sync gohan_sync.Sync, db db.DB, identity middleware.Identit
 		db:       db,
 		identity: identity,
 		backoff:  time.Second * 5,
+		election: election.New(sync, "state_watcher", stateWatchLock),
 	}
 }
 
@@ -93,22 +97,21 @@ func (watcher *StateWatcher) Run(ctx context.Context, wg *sync.WaitGroup) error
 }
 
 func (watcher *StateWatcher) iterate(ctx context.Context) error {
-	lockKey := lockPath + "/state_watch"
-	lost, err := watcher.sync.Lock(ctx, lockKey, true)
+	lost, err := watcher.election.Campaign(ctx, true)
 	if err != nil {
 		// lock failed, another process is running
 		return nil
 	}
 	defer func() {
 		// can't use the parent context, it may be already canceled
-		if err := watcher.sync.Unlock(context.Background(), lockKey); err != nil {
-			log.Warning("StateWatcher: unlocking etcd failed: %s", err)
+		if err := watcher.election.Resign(context.Background()); err != nil {
+			log.Warning("StateWatcher: resigning failed: %s", err)
 		}
 	}()
 
 	watchCtx, watchCancel := context.WithCancel(ctx)
 	defer watchCancel()
-	respCh := watcher.sync.Watch(watchCtx, lockKey, goext.RevisionCurrent)
+	respCh := watcher.sync.Watch(watchCtx, stateWatchLock, goext.RevisionCurrent)
 	watchErr := make(chan error, 1)
 	go func() {
 		watchErr <- func() error {
@@ -197,7 +200,8 @@ func (watcher *StateWatcher) StateUpdate(event *gohan_sync.Event) error {
 			}
 
 			environmentManager := extension.GetManager()
-			environment, haveEnvironment := environmentManager.GetEnvironment(curSchema.ID)
+			environment, haveEnvironment := environmentManager.AcquireEnvironment(curSchema.ID)
+			defer environmentManager.ReleaseEnvironment(environment)
 			context := map[string]interface{}{}
 
 			if haveEnvironment {
@@ -274,7 +278,8 @@ func (watcher *StateWatcher) MonitoringUpdate(event *gohan_sync.Event) error {
 		}
 
 		environmentManager := extension.GetManager()
-		environment, haveEnvironment := environmentManager.GetEnvironment(curSchema.ID)
+		environment, haveEnvironment := environmentManager.AcquireEnvironment(curSchema.ID)
+		defer environmentManager.ReleaseEnvironment(environment)
 		context := map[string]interface{}{}
 		context["resource"] = curResource.Data()
 		context["schema"] = curSchema
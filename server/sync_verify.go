@@ -0,0 +1,485 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
+	"github.com/cloudwan/gohan/util"
+)
+
+const (
+	syncVerifyLock = lockPath + "/sync_verify"
+
+	defaultSyncVerifyInterval  = time.Hour
+	defaultSyncVerifyBatchSize = 1000
+)
+
+// SyncVerifyReport describes differences between resources in the DB and the sync backend
+type SyncVerifyReport struct {
+	Repair  bool `json:"repair"`
+	Checked int  `json:"checked"`
+	// Missing are keys of resources which are not in the sync backend
+	Missing []string `json:"missing"`
+	// Stale are keys of resources which have different content in the sync backend
+	Stale []string `json:"stale"`
+	// Orphaned are keys under prefixes of syncable schemas which don't belong to any resource
+	Orphaned []string `json:"orphaned"`
+	// Repaired is the number of re-emitted resources and deleted orphaned keys
+	Repaired int `json:"repaired"`
+}
+
+// HasDrift tells whether any difference was found
+func (report *SyncVerifyReport) HasDrift() bool {
+	return len(report.Missing)+len(report.Stale)+len(report.Orphaned) > 0
+}
+
+type expectedSyncEntry struct {
+	resource *schema.Resource
+	content  string
+	// versioned compares the version of wrapped content, which is meaningful only with state versioning
+	versioned bool
+	// skipped entries failed to generate content, their keys are known but not compared
+	skipped bool
+}
+
+// SyncVerifier compares resources in the DB with their content in the sync backend,
+// as written by the SyncWriter, and repairs only the differences
+type SyncVerifier struct {
+	db        db.DB
+	sync      gohan_sync.Sync
+	batchSize int
+}
+
+// NewSyncVerifier creates a sync verifier
+func NewSyncVerifier(dataStore db.DB, sync gohan_sync.Sync) *SyncVerifier {
+	batchSize := util.GetConfig().GetInt("sync_verify/batch_size", defaultSyncVerifyBatchSize)
+	if batchSize < 1 {
+		batchSize = defaultSyncVerifyBatchSize
+	}
+	return &SyncVerifier{db: dataStore, sync: sync, batchSize: batchSize}
+}
+
+func syncableSchemas() []*schema.Schema {
+	schemas := []*schema.Schema{}
+	for _, s := range schema.GetManager().OrderedSchemas() {
+		if s.IsAbstract() || s.Metadata["type"] == "metaschema" || s.Metadata["nosync"] == true {
+			continue
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas
+}
+
+// SyncPaths returns paths of keys used by Gohan in the sync backend, and paths of keys
+// bound to leases of running processes, which must not be copied to another cluster prefix.
+// Keys of extensions are not known, except for watched keys.
+func SyncPaths() (paths, leased []string) {
+	paths = []string{"/gohan", configPrefix, stateWatchPrefix}
+	for _, s := range syncableSchemas() {
+		if prefix := syncKeyPrefix(s); prefix != "" && !strings.HasPrefix(prefix, configPrefix+"/") {
+			paths = append(paths, prefix)
+		}
+	}
+	paths = append(paths, util.GetConfig().GetStringList("watch/keys", []string{})...)
+	leased = []string{syncPath, lockPath, processPathPrefix, election.LeadersPath, election.ResignPath}
+	return
+}
+
+// syncKeyPrefix returns the prefix under which all keys of a schema are written,
+// or an empty string if the prefix can't be determined narrowly enough to detect orphaned keys
+func syncKeyPrefix(s *schema.Schema) string {
+	prefix := s.URL + "/"
+	if template, ok := s.SyncKeyTemplate(); ok {
+		prefix = template
+		if i := strings.Index(prefix, "{"); i >= 0 {
+			prefix = prefix[:i]
+		}
+		prefix = prefix[:strings.LastIndex(prefix, "/")+1]
+	}
+	if !s.SkipConfigPrefix() {
+		prefix = configPrefix + prefix
+	}
+	if prefix == "" || prefix == "/" || prefix == configPrefix+"/" {
+		return ""
+	}
+	return prefix
+}
+
+// Verify compares the DB and the sync backend. With repair, events are re-emitted
+// for missing and stale resources, to be written by the SyncWriter, and orphaned keys are deleted.
+// Both are checked again before repairing, so that changes made during the verification are kept.
+func (verifier *SyncVerifier) Verify(ctx context.Context, repair bool) (*SyncVerifyReport, error) {
+	report := &SyncVerifyReport{Repair: repair, Missing: []string{}, Stale: []string{}, Orphaned: []string{}}
+
+	expected := map[string]*expectedSyncEntry{}
+	actual := map[string]string{}
+	for _, s := range syncableSchemas() {
+		if err := verifier.expectedEntries(ctx, s, expected); err != nil {
+			return nil, err
+		}
+		if prefix := syncKeyPrefix(s); prefix != "" {
+			if err := verifier.fetchTree(ctx, prefix, actual); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	toResync := map[string]*schema.Resource{}
+	for key, entry := range expected {
+		if entry.skipped {
+			continue
+		}
+		report.Checked++
+		value, ok := actual[key]
+		if !ok {
+			node, err := verifier.sync.Fetch(ctx, key)
+			if err == nil && node.Key == key {
+				value, ok = node.Value, true
+			}
+		}
+		if !ok {
+			report.Missing = append(report.Missing, key)
+			toResync[key] = entry.resource
+		} else if !entry.matches(value) {
+			report.Stale = append(report.Stale, key)
+			toResync[key] = entry.resource
+		}
+	}
+	for key := range actual {
+		if _, ok := expected[key]; !ok {
+			report.Orphaned = append(report.Orphaned, key)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Stale)
+	sort.Strings(report.Orphaned)
+
+	metrics.UpdateGauge(int64(len(report.Missing)), "sync_verify.missing")
+	metrics.UpdateGauge(int64(len(report.Stale)), "sync_verify.stale")
+	metrics.UpdateGauge(int64(len(report.Orphaned)), "sync_verify.orphaned")
+
+	if !repair || !report.HasDrift() {
+		return report, nil
+	}
+	resynced, err := verifier.resync(ctx, toResync)
+	report.Repaired += resynced
+	if err != nil {
+		return report, err
+	}
+	deleted, err := verifier.deleteOrphaned(ctx, report.Orphaned, actual)
+	report.Repaired += deleted
+	return report, err
+}
+
+func (verifier *SyncVerifier) expectedEntries(ctx context.Context, s *schema.Schema, expected map[string]*expectedSyncEntry) error {
+	for offset := uint64(0); ; offset += uint64(verifier.batchSize) {
+		paginator, _ := pagination.NewPaginator(
+			pagination.OptionKey(s, "id"),
+			pagination.OptionOrder(pagination.ASC),
+			pagination.OptionLimit(uint64(verifier.batchSize)),
+			pagination.OptionOffset(offset),
+		)
+		var list []*schema.Resource
+		err := db.WithinTx(verifier.db, func(tx transaction.Transaction) error {
+			var err error
+			list, _, err = tx.List(ctx, s, transaction.Filter{}, nil, paginator)
+			if err != nil {
+				return err
+			}
+			for _, resource := range list {
+				entry, key, err := newExpectedSyncEntry(ctx, tx, resource)
+				if err != nil {
+					log.Warning("Sync verify: skipping %s: %s", resource.Path(), err)
+					entry = &expectedSyncEntry{resource: resource, skipped: true}
+				}
+				expected[key] = entry
+			}
+			return nil
+		}, transaction.Context(ctx))
+		if err != nil || len(list) < verifier.batchSize {
+			return err
+		}
+	}
+}
+
+func newExpectedSyncEntry(ctx context.Context, tx transaction.Transaction, resource *schema.Resource) (*expectedSyncEntry, string, error) {
+	body, err := resource.JSONString()
+	if err != nil {
+		return nil, resource.Path(), err
+	}
+	key := generatePath(resource.Path(), body)
+	version := 0
+	versioned := resource.Schema().StateVersioning()
+	if versioned {
+		state, err := tx.StateFetch(ctx, resource.Schema(), transaction.IDFilter(resource.ID()))
+		if err != nil {
+			return nil, key, err
+		}
+		version = int(state.ConfigVersion)
+	}
+	content, err := syncContent(body, getSyncProperty(resource), getSyncPlain(resource), version)
+	if err != nil {
+		return nil, key, err
+	}
+	entry := &expectedSyncEntry{
+		resource:  resource,
+		content:   content,
+		versioned: versioned && !getSyncPlain(resource),
+	}
+	return entry, key, nil
+}
+
+func (verifier *SyncVerifier) fetchTree(ctx context.Context, prefix string, actual map[string]string) error {
+	// nodes are built for directories without the trailing separator
+	node, err := verifier.sync.Fetch(ctx, strings.TrimSuffix(prefix, "/"))
+	if err == gohan_sync.KeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var collect func(node *gohan_sync.Node)
+	collect = func(node *gohan_sync.Node) {
+		if len(node.Children) == 0 && strings.HasPrefix(node.Key, prefix) {
+			actual[node.Key] = node.Value
+		}
+		for _, child := range node.Children {
+			collect(child)
+		}
+	}
+	collect(node)
+	return nil
+}
+
+// matches compares JSON values semantically, ignoring the version of resources without state versioning
+func (entry *expectedSyncEntry) matches(value string) bool {
+	if value == entry.content {
+		return true
+	}
+	var expected, actual interface{}
+	if json.Unmarshal([]byte(entry.content), &expected) != nil || json.Unmarshal([]byte(value), &actual) != nil {
+		return false
+	}
+	if getSyncPlain(entry.resource) {
+		return reflect.DeepEqual(expected, actual)
+	}
+	expectedWrapped, ok := expected.(map[string]interface{})
+	actualWrapped, ok2 := actual.(map[string]interface{})
+	if !ok || !ok2 {
+		return false
+	}
+	if entry.versioned && !reflect.DeepEqual(expectedWrapped["version"], actualWrapped["version"]) {
+		return false
+	}
+	return jsonStringsEqual(expectedWrapped["body"], actualWrapped["body"])
+}
+
+func jsonStringsEqual(a, b interface{}) bool {
+	aString, ok := a.(string)
+	bString, ok2 := b.(string)
+	if !ok || !ok2 {
+		return false
+	}
+	if aString == bString {
+		return true
+	}
+	var aValue, bValue interface{}
+	if json.Unmarshal([]byte(aString), &aValue) != nil || json.Unmarshal([]byte(bString), &bValue) != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
+
+// resync re-emits events for resources read again in the same transaction, since the DB and
+// the sync backend are not read at the same time. Resources which were deleted meanwhile,
+// or which still have events pending for the SyncWriter, are skipped.
+func (verifier *SyncVerifier) resync(ctx context.Context, resources map[string]*schema.Resource) (int, error) {
+	if len(resources) == 0 {
+		return 0, nil
+	}
+	resynced := 0
+	err := db.WithinTx(NewDbSyncWrapper(verifier.db), func(tx transaction.Transaction) error {
+		resynced = 0
+		pending, err := verifier.pendingKeys(ctx, tx)
+		if err != nil {
+			return err
+		}
+		tl := tx.(*transactionEventLogger)
+		for key, resource := range resources {
+			if pending[key] {
+				continue
+			}
+			current, err := tx.Fetch(ctx, resource.Schema(), transaction.IDFilter(resource.ID()), nil)
+			if err == transaction.ErrResourceNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := tl.Resync(ctx, current); err != nil {
+				return err
+			}
+			resynced++
+		}
+		return nil
+	}, transaction.Context(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return resynced, nil
+}
+
+// deleteOrphaned deletes orphaned keys which still don't belong to any resource, after reading
+// the DB again, and which still hold the value observed by Verify. Keys of resources
+// created between reads of the DB and the sync backend, or with pending events, are kept.
+func (verifier *SyncVerifier) deleteOrphaned(ctx context.Context, orphaned []string, observed map[string]string) (int, error) {
+	if len(orphaned) == 0 {
+		return 0, nil
+	}
+	current := map[string]*expectedSyncEntry{}
+	for _, s := range syncableSchemas() {
+		if err := verifier.expectedEntries(ctx, s, current); err != nil {
+			return 0, err
+		}
+	}
+	var pending map[string]bool
+	err := db.WithinTx(verifier.db, func(tx transaction.Transaction) error {
+		var err error
+		pending, err = verifier.pendingKeys(ctx, tx)
+		return err
+	}, transaction.Context(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, key := range orphaned {
+		if _, ok := current[key]; ok || pending[key] {
+			continue
+		}
+		node, err := verifier.sync.Fetch(ctx, key)
+		if err == gohan_sync.KeyNotFound {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		if node.Key != key || node.Value != observed[key] {
+			continue
+		}
+		if err := verifier.sync.Delete(ctx, key, false); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// pendingKeys returns keys of events which weren't written by the SyncWriter yet
+func (verifier *SyncVerifier) pendingKeys(ctx context.Context, tx transaction.Transaction) (map[string]bool, error) {
+	eventSchema, _ := schema.GetManager().Schema("event")
+	pending := map[string]bool{}
+	for offset := uint64(0); ; offset += uint64(verifier.batchSize) {
+		paginator, _ := pagination.NewPaginator(
+			pagination.OptionKey(eventSchema, "id"),
+			pagination.OptionOrder(pagination.ASC),
+			pagination.OptionLimit(uint64(verifier.batchSize)),
+			pagination.OptionOffset(offset),
+		)
+		list, _, err := tx.List(ctx, eventSchema, transaction.Filter{}, nil, paginator)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range list {
+			path, _ := event.Get("path").(string)
+			body, _ := event.Get("body").(string)
+			pending[generatePath(path, body)] = true
+		}
+		if len(list) < verifier.batchSize {
+			return pending, nil
+		}
+	}
+}
+
+// SyncVerifyJob periodically verifies the sync backend, optionally repairing drift.
+// Only one node in the cluster runs it at a time.
+type SyncVerifyJob struct {
+	verifier *SyncVerifier
+	election *election.Election
+	interval time.Duration
+	repair   bool
+}
+
+// NewSyncVerifyJob creates a job from "sync_verify" config
+func NewSyncVerifyJob(server *Server) *SyncVerifyJob {
+	config := util.GetConfig()
+	return &SyncVerifyJob{
+		verifier: NewSyncVerifier(server.db, server.sync),
+		election: election.New(server.sync, "sync_verify", syncVerifyLock),
+		interval: config.GetDuration("sync_verify/interval", defaultSyncVerifyInterval),
+		repair:   config.GetBool("sync_verify/repair", false),
+	}
+}
+
+// Run verifies the sync backend every interval until the context is canceled
+func (job *SyncVerifyJob) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := job.verify(ctx); err != nil {
+				log.Error("Sync verify failed: %s", err)
+			}
+		}
+	}
+}
+
+func (job *SyncVerifyJob) verify(ctx context.Context) error {
+	if _, err := job.election.Campaign(ctx, false); err != nil {
+		log.Debug("Sync verify is running on another node: %s", err)
+		return nil
+	}
+	defer job.election.Resign(context.Background())
+
+	report, err := job.verifier.Verify(ctx, job.repair)
+	if err != nil {
+		return err
+	}
+	if report.HasDrift() {
+		log.Warning("Sync verify found %d missing, %d stale and %d orphaned keys, repaired %d",
+			len(report.Missing), len(report.Stale), len(report.Orphaned), report.Repaired)
+	}
+	return nil
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/cloudwan/gohan/extension"
 	"github.com/cloudwan/gohan/extension/goext"
 	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 	"github.com/cloudwan/gohan/util"
 )
 
@@ -42,18 +43,21 @@ var errLockFailed = errors.New("failed to lock on sync backend")
 // The watcher implements a load balancing mechanism that uses
 // entries on the sync.
 type SyncWatcher struct {
-	sync gohan_sync.Sync
+	sync     gohan_sync.Sync
+	election *election.Election
 	// list of key names to watch
 	watchKeys []string
 	// map from event names to VM environments
 	watchExtensions map[string]extension.Environment
 	backoff         time.Duration
+	deadLetters     *DeadLetterQueue
 }
 
 // NewSyncWatcher creates a new instance of syncWatcher
 func NewSyncWatcher(sync gohan_sync.Sync, keys []string, extensions map[string]extension.Environment) *SyncWatcher {
 	return &SyncWatcher{
 		sync:            sync,
+		election:        election.New(sync, "sync_watcher/process/"+sync.GetProcessID(), processPathPrefix+"/"+sync.GetProcessID()),
 		watchKeys:       keys,
 		watchExtensions: extensions,
 		backoff:         time.Second * 5,
@@ -64,18 +68,32 @@ func NewSyncWatcher(sync gohan_sync.Sync, keys []string, extensions map[string]e
 func NewSyncWatcherFromServer(server *Server) *SyncWatcher {
 	config := util.GetConfig()
 	keys := config.GetStringList("watch/keys", []string{})
-	events := config.GetStringList("watch/events", []string{})
+
+	watcher := NewSyncWatcher(server.sync, keys, syncWatchExtensions(server))
+	if server.db != nil {
+		watcher.UseDeadLetterQueue(NewDeadLetterQueue(server.db, server.sync))
+	}
+	return watcher
+}
+
+// syncWatchExtensions returns environments of "watch/events" registered in extension manager
+func syncWatchExtensions(server *Server) map[string]extension.Environment {
+	events := util.GetConfig().GetStringList("watch/events", []string{})
 	extensions := make(map[string]extension.Environment, len(events))
 	for _, event := range events {
-		path := "sync://" + event
-		env, err := server.NewEnvironmentForPath("sync."+event, path)
-		if err != nil {
+		name := "sync." + event
+		if err := server.registerEnvironmentForPath(name, "sync://"+event); err != nil {
 			log.Fatal(err.Error())
 		}
-		extensions[event] = env
+		extensions[event] = managedEnvironment{name: name}
 	}
+	return extensions
+}
 
-	return NewSyncWatcher(server.sync, keys, extensions)
+// UseDeadLetterQueue makes path watchers store events which extensions failed to handle
+// in the dead letter queue
+func (watcher *SyncWatcher) UseDeadLetterQueue(queue *DeadLetterQueue) {
+	watcher.deadLetters = queue
 }
 
 // Run starts the main loop of the watcher.
@@ -86,15 +104,14 @@ func (watcher *SyncWatcher) Run(ctx context.Context, wg *sync.WaitGroup) error {
 	for {
 		err := func() error {
 			// register self process to the cluster
-			lockKey := processPathPrefix + "/" + watcher.sync.GetProcessID()
-			lost, err := watcher.sync.Lock(ctx, lockKey, true)
+			lost, err := watcher.election.Campaign(ctx, true)
 			if err != nil {
 				return err
 			}
 			defer func() {
 				// can't use the parent context, it may be already canceled
-				if err := watcher.sync.Unlock(context.Background(), lockKey); err != nil {
-					log.Warning("SyncWatcher: unlocking etcd failed on %s: %s", lockKey, err)
+				if err := watcher.election.Resign(context.Background()); err != nil {
+					log.Warning("SyncWatcher: resigning failed: %s", err)
 				}
 			}()
 
@@ -224,6 +241,9 @@ func (watcher *SyncWatcher) runSyncWatches(ctx context.Context, size int, positi
 		log.Debug("(SyncWatch) Priority of `%s`: `%d`", path, prio)
 
 		pathWatcher := NewPathWatcher(watcher.sync, watcher.watchExtensions, path, prio)
+		if watcher.deadLetters != nil {
+			pathWatcher.UseDeadLetterQueue(watcher.deadLetters)
+		}
 
 		go func(ctx context.Context, wg *sync.WaitGroup, pw *PathWatcher) {
 			pw.Run(ctx, wg)
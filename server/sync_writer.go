@@ -19,16 +19,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"sync"
 	"time"
 
 	"github.com/cloudwan/gohan/db"
 	"github.com/cloudwan/gohan/db/pagination"
 	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/eventsink"
 	"github.com/cloudwan/gohan/extension/goext"
 	"github.com/cloudwan/gohan/metrics"
 	"github.com/cloudwan/gohan/schema"
 	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 	"github.com/cloudwan/gohan/util"
 )
 
@@ -40,29 +43,62 @@ const (
 
 	defaultBackoff       = 5 * time.Second
 	defaultUnlockTimeout = 3 * time.Second
+
+	defaultWorkers   = 4
+	defaultBatchSize = 1000
+	// etcd rejects transactions with more than 128 operations by default
+	defaultTxnOps = 128
 )
 
 // SyncWriter copies data from the RDBMS to the sync layer.
 // All changes happens in the RDBMS will be synchronized into the
 // sync layer by SyncWriter.
 // SyncWriter gets items to sync from the event table.
+// Events are partitioned by resource path; partitions are written in parallel,
+// each in order, with several events applied in a single sync transaction.
 type SyncWriter struct {
 	sync          gohan_sync.Sync
 	db            db.DB
+	election      *election.Election
 	backoff       time.Duration
 	unlockTimeout time.Duration
+	workers       int
+	batchSize     int
+	txnOps        int
+	deadLetters   *DeadLetterQueue
+	maxAttempts   int
+	sinks         []*eventsink.Config
+
+	mu       sync.Mutex
+	attempts map[string]int
 }
 
 // NewSyncWriter creates a new instance of SyncWriter.
 func NewSyncWriter(sync gohan_sync.Sync, db db.DB) *SyncWriter {
+	config := util.GetConfig()
 	return &SyncWriter{
 		sync:          sync,
 		db:            db,
+		election:      election.New(sync, "sync_writer", syncPath),
 		backoff:       getBackoff(),
 		unlockTimeout: getUnlockTimeout(),
+		workers:       atLeastOne(config.GetInt("sync_writer/workers", defaultWorkers)),
+		batchSize:     atLeastOne(config.GetInt("sync_writer/batch_size", defaultBatchSize)),
+		txnOps:        atLeastOne(config.GetInt("sync_writer/txn_ops", defaultTxnOps)),
+		deadLetters:   NewDeadLetterQueue(db, sync),
+		maxAttempts:   deadLetterMaxAttempts("sync_writer/max_attempts", defaultDeadLetterMaxAttempts),
+		attempts:      map[string]int{},
 	}
 }
 
+// NewSyncWriterWithEventSinks creates a new instance of SyncWriter which queues processed events
+// for the given sinks, whether they were started on this node or not.
+func NewSyncWriterWithEventSinks(sync gohan_sync.Sync, db db.DB, sinks []*eventsink.Config) *SyncWriter {
+	writer := NewSyncWriter(sync, db)
+	writer.sinks = sinks
+	return writer
+}
+
 func getBackoff() time.Duration {
 	return util.GetConfig().GetDuration("sync_writer/backoff", defaultBackoff)
 }
@@ -71,6 +107,13 @@ func getUnlockTimeout() time.Duration {
 	return util.GetConfig().GetDuration("sync_writer/unlock", defaultUnlockTimeout)
 }
 
+func atLeastOne(value int) int {
+	if value < 1 {
+		return 1
+	}
+	return value
+}
+
 // NewSyncWriterFromServer is a helper method for test.
 // Should be removes in the future.
 func NewSyncWriterFromServer(server *Server) *SyncWriter {
@@ -96,7 +139,7 @@ func (writer *SyncWriter) Run(ctx context.Context, wg *sync.WaitGroup) error {
 }
 
 func (writer *SyncWriter) run(ctx context.Context) error {
-	lost, err := writer.sync.Lock(ctx, syncPath, true)
+	lost, err := writer.election.Campaign(ctx, true)
 	if err != nil {
 		return err
 	}
@@ -105,8 +148,8 @@ func (writer *SyncWriter) run(ctx context.Context) error {
 		unlockCtx, cancel := context.WithTimeout(context.Background(), writer.unlockTimeout)
 		defer cancel()
 
-		if err := writer.sync.Unlock(unlockCtx, syncPath); err != nil {
-			log.Warning("SyncWriter: unlocking failed: %s", err)
+		if err := writer.election.Resign(unlockCtx); err != nil {
+			log.Warning("SyncWriter: resigning failed: %s", err)
 		}
 	}()
 
@@ -157,19 +200,21 @@ func (writer *SyncWriter) triggerSync(ctx context.Context, eventId int) error {
 }
 
 // Sync runs a synchronization iteration, which
-// executes requests in the event table.
+// executes requests in the event table until it is empty.
 func (writer *SyncWriter) Sync(ctx context.Context) (synced int, err error) {
 	writer.updateCounter(1, "syncs")
-	resourceList, err := writer.listEvents()
-	if err != nil {
-		return
-	}
-	for _, resource := range resourceList {
-		err = writer.syncEvent(ctx, resource)
+	for ctx.Err() == nil {
+		var resourceList []*schema.Resource
+		resourceList, err = writer.listEvents()
+		if err != nil || len(resourceList) == 0 {
+			break
+		}
+		var batchSynced int
+		batchSynced, err = writer.syncEvents(ctx, resourceList)
+		synced += batchSynced
 		if err != nil {
-			return
+			break
 		}
-		synced++
 	}
 
 	if synced == 0 {
@@ -179,125 +224,336 @@ func (writer *SyncWriter) Sync(ctx context.Context) (synced int, err error) {
 	return
 }
 
+// listEvents returns the oldest events, at most batchSize of them, and updates lag metrics
 func (writer *SyncWriter) listEvents() ([]*schema.Resource, error) {
-	var resourceList []*schema.Resource
+	var (
+		resourceList []*schema.Resource
+		depth        uint64
+	)
 	if dbErr := db.WithinTx(writer.db, func(tx transaction.Transaction) error {
 		schemaManager := schema.GetManager()
 		eventSchema, _ := schemaManager.Schema("event")
 		paginator, _ := pagination.NewPaginator(
 			pagination.OptionKey(eventSchema, "id"),
 			pagination.OptionOrder(pagination.ASC),
+			pagination.OptionLimit(uint64(writer.batchSize)),
 		)
-		res, _, err := tx.List(context.Background(), eventSchema, nil, nil, paginator)
+		res, total, err := tx.List(context.Background(), eventSchema, nil, nil, paginator)
 		resourceList = res
+		depth = total
 		return err
 	}); dbErr != nil {
 		return nil, dbErr
 	}
 
+	var oldestAge int64
+	if len(resourceList) > 0 {
+		if timestamp, ok := toInt64(resourceList[0].Get("timestamp")); ok && timestamp > 0 {
+			oldestAge = time.Now().Unix() - timestamp
+		}
+	}
+	metrics.UpdateGauge(int64(depth), "sync_writer.queue_depth")
+	metrics.UpdateGauge(oldestAge, "sync_writer.oldest_event_age_seconds")
+
 	return resourceList, nil
 }
 
-func (writer *SyncWriter) syncEvent(ctx context.Context, resource *schema.Resource) error {
-	schemaManager := schema.GetManager()
-	eventSchema, _ := schemaManager.Schema("event")
-	return db.WithinTx(writer.db, func(tx transaction.Transaction) error {
-		var err error
-		eventType := resource.Get("type").(string)
-		resourcePath := resource.Get("path").(string)
-		body := resource.Get("body").(string)
-		syncPlain := resource.Get("sync_plain").(bool)
-		syncProperty := resource.Get("sync_property").(string)
-
-		path := generatePath(resourcePath, body)
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
 
-		version, ok := resource.Get("version").(int)
-		if !ok {
-			log.Debug("cannot cast version value in int for %s", path)
-		}
-		log.Debug("event %s", eventType)
+func partitionOf(resourcePath string, partitions int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(resourcePath))
+	return int(hash.Sum32() % uint32(partitions))
+}
 
-		if eventType == "create" || eventType == "update" {
-			log.Debug("set %s on sync", path)
+// syncEvents writes events partitioned by resource path in parallel, so events of a resource
+// are written in order. A partition stops at its first failure, leaving its remaining events
+// for the next iteration.
+func (writer *SyncWriter) syncEvents(ctx context.Context, resourceList []*schema.Resource) (int, error) {
+	defer metrics.UpdateTimer(time.Now(), "sync_writer.sync_events")
 
-			content := body
+	partitions := make([][]*schema.Resource, writer.workers)
+	for _, resource := range resourceList {
+		i := partitionOf(resource.Get("path").(string), writer.workers)
+		partitions[i] = append(partitions[i], resource)
+	}
 
-			var data map[string]interface{}
-			if syncProperty != "" {
-				err = json.Unmarshal(([]byte)(body), &data)
-				if err != nil {
-					return fmt.Errorf("failed to unmarshal body on sync: %s", err)
-				}
-				target, ok := data[syncProperty]
-				if !ok {
-					return fmt.Errorf("could not find property `%s`", syncProperty)
-				}
-				jsonData, err := json.Marshal(target)
-				if err != nil {
-					return err
-				}
-				content = string(jsonData)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		synced   int
+		firstErr error
+	)
+	for _, partition := range partitions {
+		if len(partition) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(partition []*schema.Resource) {
+			defer wg.Done()
+			partitionSynced, err := writer.syncPartition(ctx, partition)
+
+			mu.Lock()
+			defer mu.Unlock()
+			synced += partitionSynced
+			if err != nil && firstErr == nil {
+				firstErr = err
 			}
+		}(partition)
+	}
+	wg.Wait()
 
-			if syncPlain {
-				var target interface{}
-				json.Unmarshal([]byte(content), &target)
-				switch target.(type) {
-				case string:
-					content = fmt.Sprintf("%v", target)
-				}
-			} else {
-				data, err := json.Marshal(map[string]interface{}{
-					"body":    content,
-					"version": version,
-				})
-				if err != nil {
-					return fmt.Errorf("failed to marshal marshalling sync object: %s", err)
-				}
-				content = string(data)
-			}
+	writer.updateCounter(int64(synced), "events_synced")
+	return synced, firstErr
+}
 
-			err = writer.sync.Update(ctx, path, content)
-			if err != nil {
-				return fmt.Errorf("Update() failed on sync: %s", err)
+func (writer *SyncWriter) syncPartition(ctx context.Context, events []*schema.Resource) (synced int, err error) {
+	// number of events of a failed batch which are still to be written one by one
+	singles := 0
+	for len(events) > 0 {
+		batch, ops, err := writer.nextBatch(events, singles > 0)
+		if singles > 0 {
+			singles--
+		}
+		if err == nil {
+			if err = gohan_sync.ApplyBatch(ctx, writer.sync, ops); err != nil {
+				writer.updateCounter(1, "batch_error")
+				err = fmt.Errorf("writing batch to sync failed: %s", err)
 			}
-		} else if eventType == "delete" {
-			log.Debug("delete %s", resourcePath)
-			deletePath := resourcePath
-			resourceSchema := schema.GetSchemaByURLPath(resourcePath)
-			if _, ok := resourceSchema.SyncKeyTemplate(); ok {
-				var data map[string]interface{}
-				json.Unmarshal(([]byte)(body), &data)
-				deletePath, err = resourceSchema.GenerateCustomPath(data)
-				if err != nil {
-					return fmt.Errorf("Delete from sync failed: %s - generating of custom path failed", err)
-				}
+		}
+		if err != nil && len(batch) > 1 {
+			// find the failing event by writing events of the batch one by one
+			singles = len(batch)
+			continue
+		}
+		if err != nil {
+			deadLettered, dlErr := writer.eventFailed(ctx, events[0], err)
+			if dlErr != nil {
+				return synced, fmt.Errorf("%s, dead-lettering failed: %s", err, dlErr)
 			}
-			log.Debug("deleting %s", statePrefix+deletePath)
-			err = writer.sync.Delete(ctx, statePrefix+deletePath, false)
-			if err != nil {
-				log.Error(fmt.Sprintf("Delete from sync failed: %s", err))
+			if !deadLettered {
+				return synced, err
 			}
-			log.Debug("deleting %s", monitoringPrefix+deletePath)
-			err = writer.sync.Delete(ctx, monitoringPrefix+deletePath, false)
-			if err != nil {
-				log.Error(fmt.Sprintf("Delete from sync failed: %s", err))
+			events = events[1:]
+			continue
+		}
+		if err := writer.deleteEvents(ctx, batch); err != nil {
+			return synced, err
+		}
+		writer.clearAttempts(batch)
+		synced += len(batch)
+		events = events[len(batch):]
+	}
+	return synced, nil
+}
+
+// eventFailed counts a failed attempt to sync an event, and moves the event
+// to the dead letter queue when attempts are exhausted, so it doesn't block the queue
+func (writer *SyncWriter) eventFailed(ctx context.Context, resource *schema.Resource, cause error) (bool, error) {
+	writer.updateCounter(1, "event_failures")
+	id := fmt.Sprint(resource.Get("id"))
+
+	writer.mu.Lock()
+	writer.attempts[id]++
+	attempts := writer.attempts[id]
+	writer.mu.Unlock()
+
+	if attempts < writer.maxAttempts {
+		log.Warning("SyncWriter: syncing event %s failed, attempt %d of %d: %s", id, attempts, writer.maxAttempts, cause)
+		return false, nil
+	}
+
+	eventSchema, _ := schema.GetManager().Schema("event")
+	err := db.WithinTx(writer.db, func(tx transaction.Transaction) error {
+		path, _ := resource.Get("path").(string)
+		if err := writer.deadLetters.addInTx(ctx, tx, DeadLetterSourceSyncWriter, path, resource.Data(), cause, attempts); err != nil {
+			return err
+		}
+		// the change was committed, only writing it to the sync backend failed
+		if err := queueSinkEventsInTx(ctx, tx, writer.sinks, []*schema.Resource{resource}); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, eventSchema, resource.Get("id"))
+	})
+	if err != nil {
+		return false, err
+	}
+	writer.clearAttempts([]*schema.Resource{resource})
+	writer.updateCounter(1, "dead_letters")
+	return true, nil
+}
+
+func (writer *SyncWriter) clearAttempts(events []*schema.Resource) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.attempts) == 0 {
+		return
+	}
+	for _, resource := range events {
+		delete(writer.attempts, fmt.Sprint(resource.Get("id")))
+	}
+}
+
+// nextBatch takes events from the head of the list as long as their writes fit into
+// a single sync transaction and touch distinct keys, or just the first event if singles is set
+func (writer *SyncWriter) nextBatch(events []*schema.Resource, singles bool) ([]*schema.Resource, []gohan_sync.Op, error) {
+	ops := []gohan_sync.Op{}
+	keys := map[string]bool{}
+	for i, resource := range events {
+		eventOps, err := eventOps(resource)
+		if err != nil {
+			if i > 0 {
+				// write the preceding events, the failing one is reported by the next batch
+				return events[:i], ops, nil
 			}
-			log.Debug("deleting %s", resourcePath)
-			err = writer.sync.Delete(ctx, path, false)
+			return nil, nil, err
+		}
+		if i > 0 && (singles || len(ops)+len(eventOps) > writer.txnOps || touchesAny(eventOps, keys)) {
+			return events[:i], ops, nil
+		}
+		for _, op := range eventOps {
+			keys[op.Key] = true
+		}
+		ops = append(ops, eventOps...)
+	}
+	return events, ops, nil
+}
+
+func touchesAny(ops []gohan_sync.Op, keys map[string]bool) bool {
+	for _, op := range ops {
+		if keys[op.Key] {
+			return true
+		}
+	}
+	return false
+}
+
+func (writer *SyncWriter) deleteEvents(ctx context.Context, events []*schema.Resource) error {
+	schemaManager := schema.GetManager()
+	eventSchema, _ := schemaManager.Schema("event")
+	return db.WithinTx(writer.db, func(tx transaction.Transaction) error {
+		for _, resource := range events {
+			log.Debug("delete event %d", resource.Get("id"))
+			if err := tx.Delete(ctx, eventSchema, resource.Get("id")); err != nil {
+				return fmt.Errorf("delete failed: %s", err)
+			}
+		}
+		return queueSinkEventsInTx(ctx, tx, writer.sinks, events)
+	})
+}
+
+// eventOps returns sync writes of an event
+func eventOps(resource *schema.Resource) ([]gohan_sync.Op, error) {
+	var err error
+	eventType := resource.Get("type").(string)
+	resourcePath := resource.Get("path").(string)
+	body := resource.Get("body").(string)
+	syncPlain := resource.Get("sync_plain").(bool)
+	syncProperty := resource.Get("sync_property").(string)
+
+	path := generatePath(resourcePath, body)
+
+	version, ok := resource.Get("version").(int)
+	if !ok {
+		log.Debug("cannot cast version value in int for %s", path)
+	}
+	log.Debug("event %s", eventType)
+
+	if eventType == "create" || eventType == "update" {
+		log.Debug("set %s on sync", path)
+
+		content, err := syncContent(body, syncProperty, syncPlain, version)
+		if err != nil {
+			return nil, err
+		}
+
+		if content == "" {
+			// same as Update of an empty value, which does nothing
+			return nil, nil
+		}
+		return []gohan_sync.Op{{Key: path, Value: content}}, nil
+	} else if eventType == "delete" {
+		log.Debug("delete %s", resourcePath)
+		deletePath := resourcePath
+		resourceSchema := schema.GetSchemaByURLPath(resourcePath)
+		if _, ok := resourceSchema.SyncKeyTemplate(); ok {
+			var data map[string]interface{}
+			json.Unmarshal(([]byte)(body), &data)
+			deletePath, err = resourceSchema.GenerateCustomPath(data)
 			if err != nil {
-				return fmt.Errorf("delete from sync failed: %s", err)
+				return nil, fmt.Errorf("Delete from sync failed: %s - generating of custom path failed", err)
+			}
+		}
+		ops := []gohan_sync.Op{}
+		for _, key := range []string{statePrefix + deletePath, monitoringPrefix + deletePath, path} {
+			if !containsOp(ops, key) {
+				log.Debug("deleting %s", key)
+				ops = append(ops, gohan_sync.Op{Key: key, Delete: true})
 			}
 		}
-		log.Debug("delete event %d", resource.Get("id"))
-		id := resource.Get("id")
-		err = tx.Delete(ctx, eventSchema, id)
+		return ops, nil
+	}
+	return nil, nil
+}
+
+// syncContent returns the value written to the sync backend for a resource body
+func syncContent(body, syncProperty string, syncPlain bool, version int) (string, error) {
+	content := body
+
+	var data map[string]interface{}
+	if syncProperty != "" {
+		err := json.Unmarshal(([]byte)(body), &data)
 		if err != nil {
-			return fmt.Errorf("delete failed: %s", err)
+			return "", fmt.Errorf("failed to unmarshal body on sync: %s", err)
 		}
+		target, ok := data[syncProperty]
+		if !ok {
+			return "", fmt.Errorf("could not find property `%s`", syncProperty)
+		}
+		jsonData, err := json.Marshal(target)
+		if err != nil {
+			return "", err
+		}
+		content = string(jsonData)
+	}
 
-		return nil
-	})
+	if syncPlain {
+		var target interface{}
+		json.Unmarshal([]byte(content), &target)
+		switch target.(type) {
+		case string:
+			content = fmt.Sprintf("%v", target)
+		}
+	} else {
+		data, err := json.Marshal(map[string]interface{}{
+			"body":    content,
+			"version": version,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal marshalling sync object: %s", err)
+		}
+		content = string(data)
+	}
+	return content, nil
+}
+
+func containsOp(ops []gohan_sync.Op, key string) bool {
+	for _, op := range ops {
+		if op.Key == key {
+			return true
+		}
+	}
+	return false
 }
 
 func generatePath(resourcePath string, body string) string {
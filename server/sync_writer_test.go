@@ -24,6 +24,7 @@ import (
 
 	"github.com/cloudwan/gohan/db"
 	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/eventsink"
 	"github.com/cloudwan/gohan/schema"
 	srv "github.com/cloudwan/gohan/server"
 	gohan_sync "github.com/cloudwan/gohan/sync"
@@ -33,6 +34,23 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+type memoryEventSink struct {
+	events []*eventsink.Event
+	err    error
+}
+
+func (sink *memoryEventSink) Send(ctx context.Context, events []*eventsink.Event) error {
+	if sink.err != nil {
+		return sink.err
+	}
+	sink.events = append(sink.events, events...)
+	return nil
+}
+
+func (sink *memoryEventSink) Close() error {
+	return nil
+}
+
 var _ = Describe("Server package test", func() {
 	var (
 		ctx                       context.Context
@@ -136,6 +154,103 @@ var _ = Describe("Server package test", func() {
 			Expect(err).To(HaveOccurred(), "Failed to sync db resource deletion to sync backend")
 		})
 
+		It("should write events of many resources, keeping the last change of each", func() {
+			raws := []map[string]interface{}{}
+			resources := []*schema.Resource{}
+			withinTx(func(tx transaction.Transaction) {
+				for i := 0; i < 20; i++ {
+					raw, resource := createNetwork(ctx, tx, fmt.Sprintf("net%d", i))
+					raws = append(raws, raw)
+					resources = append(resources, resource)
+				}
+			})
+			deleteNetwork(resources[0])
+
+			writer := srv.NewSyncWriterFromServer(server)
+			Expect(writer.Sync(ctx)).To(Equal(21))
+
+			_, err := sync.Fetch(ctx, "/config"+resources[0].Path())
+			Expect(err).To(HaveOccurred())
+			for i := 1; i < len(resources); i++ {
+				checkIsSynced(raws[i], resources[i])
+				deleteNetwork(resources[i])
+			}
+
+			Expect(writer.Sync(ctx)).To(Equal(19))
+		})
+
+		It("should dead-letter events failing all attempts", func() {
+			withinTx(func(tx transaction.Transaction) {
+				rawRed, red = createNetwork(ctx, tx, "red")
+				body, err := red.JSONString()
+				Expect(err).NotTo(HaveOccurred())
+				eventSchema, _ := schema.GetManager().Schema("event")
+				_, err = tx.Create(ctx, schema.NewResource(eventSchema, map[string]interface{}{
+					"type":          "create",
+					"path":          red.Path(),
+					"version":       1,
+					"body":          body,
+					"sync_plain":    false,
+					"sync_property": "missing",
+					"timestamp":     time.Now().Unix(),
+				}))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			writer := srv.NewSyncWriterFromServer(server)
+			synced, err := writer.Sync(ctx)
+			Expect(err).To(HaveOccurred())
+			Expect(synced).To(Equal(1))
+			checkIsSynced(rawRed, red)
+			for i := 0; i < 3; i++ {
+				_, err = writer.Sync(ctx)
+				Expect(err).To(HaveOccurred())
+			}
+			Expect(writer.Sync(ctx)).To(Equal(0))
+
+			queue := srv.NewDeadLetterQueue(testDB, sync)
+			deadLetters, err := queue.List(ctx, srv.DeadLetterSourceSyncWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].Get("sync_key")).To(Equal(red.Path()))
+			Expect(deadLetters[0].Get("attempts")).To(Equal(5))
+
+			Expect(queue.Retry(ctx, deadLetters[0].Get("id").(int))).To(Succeed())
+			Expect(queue.List(ctx, "")).To(BeEmpty())
+			_, err = writer.Sync(ctx)
+			Expect(err).To(MatchError(ContainSubstring("could not find property `missing`")))
+
+			withinTx(func(tx transaction.Transaction) {
+				_, err := tx.RawTransaction().ExecContext(ctx, "DELETE FROM events;")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			body, err := red.JSONString()
+			Expect(err).NotTo(HaveOccurred())
+			withinTx(func(tx transaction.Transaction) {
+				red.Data()["name"] = "changed"
+				Expect(tx.Update(ctx, red)).To(Succeed())
+			})
+			Expect(writer.Sync(ctx)).To(Equal(1))
+			Expect(queue.Add(ctx, srv.DeadLetterSourceSyncWriter, red.Path(), map[string]interface{}{
+				"type":          "update",
+				"path":          red.Path(),
+				"version":       1,
+				"body":          body,
+				"sync_plain":    false,
+				"sync_property": "",
+				"timestamp":     time.Now().Unix(),
+			}, fmt.Errorf("failed"), 5)).To(Succeed())
+			deadLetters, err = queue.List(ctx, srv.DeadLetterSourceSyncWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(queue.Retry(ctx, deadLetters[0].Get("id").(int))).To(MatchError(ContainSubstring("changed after the event was dead-lettered")))
+			Expect(queue.Discard(ctx, deadLetters[0].Get("id").(int))).To(Succeed())
+
+			deleteNetwork(red)
+			Expect(writer.Sync(ctx)).To(Equal(1))
+		})
+
 		create := func(schemaId string, rawResource map[string]interface{}) *schema.Resource {
 			manager := schema.GetManager()
 			resource, err := manager.LoadResource(schemaId, rawResource)
@@ -259,6 +374,161 @@ var _ = Describe("Server package test", func() {
 
 	})
 
+	Describe("Event sinks", func() {
+		var sinks []*eventsink.Config
+
+		BeforeEach(func() {
+			var err error
+			sinks, err = eventsink.LoadConfigs(util.NewConfig(map[string]interface{}{
+				"event_sinks": []interface{}{
+					map[string]interface{}{
+						"name":    "test",
+						"type":    "http",
+						"url":     "http://127.0.0.1:1/events",
+						"schemas": []interface{}{"network"},
+					},
+				},
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			withinTx(func(tx transaction.Transaction) {
+				_, err := tx.RawTransaction().ExecContext(ctx, "DELETE FROM sink_events;")
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		It("should deliver events of routed schemas independently of the sync", func() {
+			withinTx(func(tx transaction.Transaction) {
+				rawRed, red = createNetwork(ctx, tx, "red")
+			})
+			writer := srv.NewSyncWriterWithEventSinks(sync, testDB, sinks)
+			Expect(writer.Sync(ctx)).To(Equal(1))
+			deleteNetwork(red)
+			Expect(writer.Sync(ctx)).To(Equal(1))
+
+			sink := &memoryEventSink{err: fmt.Errorf("sink is down")}
+			worker := srv.NewEventSinkWorker(sync, testDB, sinks[0], sink)
+
+			_, err := worker.Deliver(ctx)
+			Expect(err).To(MatchError(ContainSubstring("sink is down")))
+			Expect(sink.events).To(BeEmpty())
+
+			sink.err = nil
+			Expect(worker.Deliver(ctx)).To(Equal(2))
+			Expect(sink.events).To(HaveLen(2))
+			Expect(sink.events[0].Type).To(Equal("create"))
+			Expect(sink.events[0].SchemaID).To(Equal("network"))
+			Expect(sink.events[0].Path).To(Equal(red.Path()))
+			Expect(sink.events[0].Resource).To(HaveKeyWithValue("id", red.ID()))
+			Expect(sink.events[1].Type).To(Equal("delete"))
+			Expect(sink.events[0].ID).To(BeNumerically("<", sink.events[1].ID))
+
+			Expect(worker.Deliver(ctx)).To(Equal(0))
+		})
+
+		It("should queue events for configured sinks and purge events of removed ones", func() {
+			withinTx(func(tx transaction.Transaction) {
+				rawRed, red = createNetwork(ctx, tx, "red")
+			})
+			Expect(srv.NewSyncWriterFromServer(server).Sync(ctx)).To(Equal(1))
+			countSinkEvents := func() int {
+				var count int
+				withinTx(func(tx transaction.Transaction) {
+					Expect(tx.RawTransaction().GetContext(ctx, &count, "SELECT COUNT(*) FROM sink_events;")).To(Succeed())
+				})
+				return count
+			}
+			Expect(countSinkEvents()).To(Equal(0))
+
+			deleteNetwork(red)
+			Expect(srv.NewSyncWriterWithEventSinks(sync, testDB, sinks).Sync(ctx)).To(Equal(1))
+			Expect(countSinkEvents()).To(Equal(1))
+
+			Expect(srv.PurgeUnknownSinkEvents(ctx, testDB, sinks)).To(Succeed())
+			Expect(countSinkEvents()).To(Equal(1))
+			Expect(srv.PurgeUnknownSinkEvents(ctx, testDB, nil)).To(Succeed())
+			Expect(countSinkEvents()).To(Equal(0))
+		})
+
+		It("should drop events of a sink whose queue is full", func() {
+			sinks[0].MaxQueued = 1
+			withinTx(func(tx transaction.Transaction) {
+				rawRed, red = createNetwork(ctx, tx, "red")
+			})
+			writer := srv.NewSyncWriterWithEventSinks(sync, testDB, sinks)
+			Expect(writer.Sync(ctx)).To(Equal(1))
+			deleteNetwork(red)
+			Expect(writer.Sync(ctx)).To(Equal(1))
+
+			sink := &memoryEventSink{}
+			Expect(srv.NewEventSinkWorker(sync, testDB, sinks[0], sink).Deliver(ctx)).To(Equal(1))
+			Expect(sink.events[0].Type).To(Equal("create"))
+		})
+	})
+
+	Describe("Sync verify", func() {
+		It("should report and repair only differences", func() {
+			withinTx(func(tx transaction.Transaction) {
+				rawRed, red = createNetwork(ctx, tx, "red")
+				rawBlue, blue = createNetwork(ctx, tx, "blue")
+			})
+			writer := srv.NewSyncWriterFromServer(server)
+			Expect(writer.Sync(ctx)).To(Equal(2))
+
+			redKey := "/config" + red.Path()
+			blueKey := "/config" + blue.Path()
+			orphanKey := "/config" + red.Schema().URL + "/orphan"
+			Expect(sync.Update(ctx, redKey, `{"body": "{}", "version": 1}`)).To(Succeed())
+			Expect(sync.Delete(ctx, blueKey, false)).To(Succeed())
+			Expect(sync.Update(ctx, orphanKey, `{}`)).To(Succeed())
+
+			verifier := srv.NewSyncVerifier(testDB, sync)
+			report, err := verifier.Verify(ctx, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Stale).To(ContainElement(redKey))
+			Expect(report.Missing).To(ContainElement(blueKey))
+			Expect(report.Orphaned).To(ContainElement(orphanKey))
+			Expect(report.Repaired).To(BeZero())
+
+			report, err = verifier.Verify(ctx, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Repaired).To(BeNumerically(">=", 3))
+			Expect(writer.Sync(ctx)).To(BeNumerically(">=", 2))
+
+			checkIsSynced(rawRed, red)
+			checkIsSynced(rawBlue, blue)
+			report, err = verifier.Verify(ctx, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Stale).NotTo(ContainElement(redKey))
+			Expect(report.Missing).NotTo(ContainElement(blueKey))
+			Expect(report.Orphaned).NotTo(ContainElement(orphanKey))
+
+			deleteNetwork(red)
+			deleteNetwork(blue)
+			Expect(writer.Sync(ctx)).To(Equal(2))
+		})
+
+		It("should leave resources with pending events to the SyncWriter", func() {
+			withinTx(func(tx transaction.Transaction) {
+				rawRed, red = createNetwork(ctx, tx, "red")
+			})
+			redKey := "/config" + red.Path()
+			Expect(sync.Update(ctx, redKey, `{"body": "{}", "version": 1}`)).To(Succeed())
+
+			verifier := srv.NewSyncVerifier(testDB, sync)
+			report, err := verifier.Verify(ctx, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Stale).To(ContainElement(redKey))
+			Expect(report.Repaired).To(BeZero())
+
+			writer := srv.NewSyncWriterFromServer(server)
+			Expect(writer.Sync(ctx)).To(Equal(1))
+			checkIsSynced(rawRed, red)
+
+			deleteNetwork(red)
+			Expect(writer.Sync(ctx)).To(Equal(1))
+		})
+	})
+
 	It("Unordered event id", func() {
 		startInformer()
 
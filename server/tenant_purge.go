@@ -0,0 +1,413 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/server/resources"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+const (
+	tenantPurgePrefix         = "/gohan/cluster/tenant_purge"
+	tenantPurgeCheckpointPath = tenantPurgePrefix + "/checkpoints"
+	tenantPurgeReconcilerLock = lockPath + "/tenant_purge"
+
+	tenantIDProperty = "tenant_id"
+
+	defaultTenantPurgeBatchSize = 100
+	defaultReconcileInterval    = time.Hour
+)
+
+// TenantPurgeReport describes resources deleted, or to be deleted in dry-run mode, by a tenant purge
+type TenantPurgeReport struct {
+	TenantID  string              `json:"tenant_id"`
+	DryRun    bool                `json:"dry_run"`
+	Resumed   bool                `json:"resumed"`
+	Resources map[string][]string `json:"resources"`
+	Deleted   int                 `json:"deleted"`
+}
+
+type tenantPurgeCheckpoint struct {
+	CompletedSchemas []string `json:"completed_schemas"`
+	Deleted          int      `json:"deleted"`
+}
+
+// TenantPurger deletes all resources owned by a tenant, e.g. after the Keystone project is deleted.
+// Resources are deleted in reverse schema dependency order using DeleteResourceInTransaction,
+// so in-transaction delete extensions are run.
+type TenantPurger struct {
+	db        db.DB
+	sync      gohan_sync.Sync
+	identity  middleware.IdentityService
+	batchSize int
+}
+
+// NewTenantPurger creates a tenant purger using server backends
+func NewTenantPurger(server *Server) *TenantPurger {
+	return &TenantPurger{
+		db:        server.db,
+		sync:      server.sync,
+		identity:  server.keystoneIdentity,
+		batchSize: util.GetConfig().GetInt("tenant_purge/batch_size", defaultTenantPurgeBatchSize),
+	}
+}
+
+// tenantSchemas returns schemas with tenant owned resources, children first
+func tenantSchemas() []*schema.Schema {
+	ordered := schema.GetManager().OrderedSchemas()
+	schemas := []*schema.Schema{}
+	for i := len(ordered) - 1; i >= 0; i-- {
+		s := ordered[i]
+		if s.IsAbstract() {
+			continue
+		}
+		if _, err := s.GetPropertyByID(tenantIDProperty); err != nil {
+			continue
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas
+}
+
+// Purge deletes all resources of the tenant. In dry-run mode it only lists them.
+// Progress is checkpointed in the sync backend after each batch, so an interrupted purge resumes
+// from the first schema not completed yet.
+func (purger *TenantPurger) Purge(ctx context.Context, tenantID string, dryRun bool) (*TenantPurgeReport, error) {
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant ID is required")
+	}
+	report := &TenantPurgeReport{
+		TenantID:  tenantID,
+		DryRun:    dryRun,
+		Resources: map[string][]string{},
+	}
+
+	checkpoint := &tenantPurgeCheckpoint{}
+	if !dryRun {
+		var err error
+		if checkpoint, err = purger.loadCheckpoint(ctx, tenantID); err != nil {
+			return nil, err
+		}
+		report.Resumed = len(checkpoint.CompletedSchemas) > 0
+		report.Deleted = checkpoint.Deleted
+	}
+
+	for _, s := range tenantSchemas() {
+		if util.ContainsString(checkpoint.CompletedSchemas, s.ID) {
+			continue
+		}
+		if dryRun {
+			ids, err := purger.listIDs(ctx, s, tenantID, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(ids) > 0 {
+				report.Resources[s.ID] = ids
+			}
+			continue
+		}
+		if err := purger.purgeSchema(ctx, s, tenantID, checkpoint, report); err != nil {
+			return report, err
+		}
+		checkpoint.CompletedSchemas = append(checkpoint.CompletedSchemas, s.ID)
+		if err := purger.saveCheckpoint(ctx, tenantID, checkpoint); err != nil {
+			return report, err
+		}
+	}
+
+	if !dryRun {
+		if err := purger.deleteCheckpoint(ctx, tenantID); err != nil {
+			return report, err
+		}
+		metrics.UpdateCounter(1, "tenant_purge.completed")
+		log.Info("Purged %d resources of tenant %s", report.Deleted, tenantID)
+	}
+	return report, nil
+}
+
+func (purger *TenantPurger) purgeSchema(ctx context.Context, s *schema.Schema, tenantID string,
+	checkpoint *tenantPurgeCheckpoint, report *TenantPurgeReport) error {
+	for {
+		ids, err := purger.listIDs(ctx, s, tenantID, uint64(purger.batchSize))
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		for _, id := range ids {
+			if err := purger.deleteResource(ctx, s, id); err != nil {
+				metrics.UpdateCounter(1, "tenant_purge.failed")
+				return fmt.Errorf("failed to delete %s %s of tenant %s: %s", s.ID, id, tenantID, err)
+			}
+			report.Resources[s.ID] = append(report.Resources[s.ID], id)
+			report.Deleted++
+		}
+		metrics.UpdateCounter(int64(len(ids)), "tenant_purge.deleted")
+
+		checkpoint.Deleted = report.Deleted
+		if err := purger.saveCheckpoint(ctx, tenantID, checkpoint); err != nil {
+			return err
+		}
+	}
+}
+
+func (purger *TenantPurger) listIDs(ctx context.Context, s *schema.Schema, tenantID string, limit uint64) ([]string, error) {
+	var ids []string
+	err := db.WithinTx(purger.db, func(tx transaction.Transaction) error {
+		var paginator *pagination.Paginator
+		if limit > 0 {
+			var err error
+			if paginator, err = pagination.NewPaginator(pagination.OptionLimit(limit)); err != nil {
+				return err
+			}
+		}
+		list, _, err := tx.List(ctx, s, transaction.Filter{tenantIDProperty: tenantID},
+			&transaction.ViewOptions{Fields: []string{"id"}}, paginator)
+		if err != nil {
+			return err
+		}
+		for _, resource := range list {
+			ids = append(ids, resource.ID())
+		}
+		return nil
+	}, transaction.Context(ctx))
+	return ids, err
+}
+
+// collectTenantIDs adds tenant IDs of resources of the schema, listed page by page
+func (purger *TenantPurger) collectTenantIDs(ctx context.Context, s *schema.Schema, tenantIDs map[string]bool) error {
+	pageSize := uint64(defaultTenantPurgeBatchSize)
+	if purger.batchSize > 0 {
+		pageSize = uint64(purger.batchSize)
+	}
+	for offset := uint64(0); ; offset += pageSize {
+		paginator, err := pagination.NewPaginator(
+			pagination.OptionKey(s, "id"), pagination.OptionLimit(pageSize), pagination.OptionOffset(offset))
+		if err != nil {
+			return err
+		}
+		var page []*schema.Resource
+		err = db.WithinTx(purger.db, func(tx transaction.Transaction) error {
+			var err error
+			page, _, err = tx.List(ctx, s, nil,
+				&transaction.ViewOptions{Fields: []string{"id", tenantIDProperty}}, paginator)
+			return err
+		}, transaction.Context(ctx))
+		if err != nil {
+			return err
+		}
+		for _, resource := range page {
+			if tenantID, ok := resource.Get(tenantIDProperty).(string); ok && tenantID != "" {
+				tenantIDs[tenantID] = true
+			}
+		}
+		if uint64(len(page)) < pageSize {
+			return nil
+		}
+	}
+}
+
+func (purger *TenantPurger) deleteResource(ctx context.Context, s *schema.Schema, id string) error {
+	auth := schema.NewAuthorizationBuilder().
+		WithTenant(schema.Tenant{ID: "admin", Name: "admin"}).
+		WithRoleIDs(schema.AdminRole).
+		BuildAdmin()
+	context := middleware.Context{
+		"context":          ctx,
+		"trace_id":         util.NewTraceID(),
+		"schema":           s,
+		"schema_id":        s.ID,
+		"path":             s.GetPluralURL(),
+		"id":               id,
+		"auth":             auth,
+		"policy":           schema.NewEmptyPolicy(),
+		"sync":             purger.sync,
+		"db":               purger.db,
+		"identity_service": purger.identity,
+	}
+	err := db.WithinTx(purger.db, func(tx transaction.Transaction) error {
+		context["transaction"] = tx
+		defer delete(context, "transaction")
+		return resources.DeleteResourceInTransaction(context, s, id)
+	}, transaction.Context(ctx), transaction.TraceId(context["trace_id"].(string)))
+	if err == transaction.ErrResourceNotFound {
+		// already deleted, e.g. by an extension of its parent
+		return nil
+	}
+	return err
+}
+
+func tenantPurgeCheckpointKey(tenantID string) string {
+	return tenantPurgeCheckpointPath + "/" + tenantID
+}
+
+func (purger *TenantPurger) loadCheckpoint(ctx context.Context, tenantID string) (*tenantPurgeCheckpoint, error) {
+	checkpoint := &tenantPurgeCheckpoint{}
+	if purger.sync == nil {
+		return checkpoint, nil
+	}
+	node, err := purger.sync.Fetch(ctx, tenantPurgeCheckpointKey(tenantID))
+	if err != nil || node == nil || node.Value == "" {
+		return checkpoint, nil
+	}
+	if err := json.Unmarshal([]byte(node.Value), checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint of tenant %s purge: %s", tenantID, err)
+	}
+	log.Info("Resuming purge of tenant %s, completed schemas: %v", tenantID, checkpoint.CompletedSchemas)
+	return checkpoint, nil
+}
+
+func (purger *TenantPurger) saveCheckpoint(ctx context.Context, tenantID string, checkpoint *tenantPurgeCheckpoint) error {
+	if purger.sync == nil {
+		return nil
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return purger.sync.Update(ctx, tenantPurgeCheckpointKey(tenantID), string(data))
+}
+
+func (purger *TenantPurger) deleteCheckpoint(ctx context.Context, tenantID string) error {
+	if purger.sync == nil {
+		return nil
+	}
+	return purger.sync.Delete(ctx, tenantPurgeCheckpointKey(tenantID), false)
+}
+
+// OrphanedTenants returns IDs of tenants owning resources which are no longer known to the identity service.
+// Resources are listed in pages of the purge batch size, so whole tables aren't loaded at once.
+func (purger *TenantPurger) OrphanedTenants(ctx context.Context) ([]string, error) {
+	tenantIDs := map[string]bool{}
+	for _, s := range tenantSchemas() {
+		if err := purger.collectTenantIDs(ctx, s, tenantIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	orphaned := []string{}
+	for tenantID := range tenantIDs {
+		valid, err := purger.identity.ValidateTenantID(tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate tenant %s: %s", tenantID, err)
+		}
+		if !valid {
+			orphaned = append(orphaned, tenantID)
+		}
+	}
+	sort.Strings(orphaned)
+	return orphaned, nil
+}
+
+// TenantPurgeReconciler periodically looks for orphaned tenants and optionally purges them.
+// Only one node in the cluster runs it at a time.
+type TenantPurgeReconciler struct {
+	purger    *TenantPurger
+	election  *election.Election
+	interval  time.Duration
+	autoPurge bool
+}
+
+// NewTenantPurgeReconciler creates a reconciler from "tenant_purge/reconciler" config
+func NewTenantPurgeReconciler(server *Server) *TenantPurgeReconciler {
+	config := util.GetConfig()
+	return &TenantPurgeReconciler{
+		purger:    NewTenantPurger(server),
+		election:  election.New(server.sync, "tenant_purge", tenantPurgeReconcilerLock),
+		interval:  config.GetDuration("tenant_purge/reconciler/interval", defaultReconcileInterval),
+		autoPurge: config.GetBool("tenant_purge/reconciler/auto_purge", false),
+	}
+}
+
+// Run reconciles orphaned tenants every interval until the context is canceled
+func (reconciler *TenantPurgeReconciler) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	ticker := time.NewTicker(reconciler.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := reconciler.reconcile(ctx); err != nil {
+				log.Error("Tenant purge reconciliation failed: %s", err)
+			}
+		}
+	}
+}
+
+func (reconciler *TenantPurgeReconciler) reconcile(ctx context.Context) error {
+	if _, err := reconciler.election.Campaign(ctx, false); err != nil {
+		log.Debug("Tenant purge reconciler is running on another node: %s", err)
+		return nil
+	}
+	defer reconciler.election.Resign(context.Background())
+
+	orphaned, err := reconciler.purger.OrphanedTenants(ctx)
+	if err != nil {
+		return err
+	}
+	metrics.UpdateGauge(int64(len(orphaned)), "tenant_purge.orphaned")
+	for _, tenantID := range orphaned {
+		if !reconciler.autoPurge {
+			log.Warning("Found resources of deleted tenant %s", tenantID)
+			continue
+		}
+		if _, err := reconciler.purger.Purge(ctx, tenantID, false); err != nil {
+			log.Error("Failed to purge deleted tenant %s: %s", tenantID, err)
+		}
+	}
+	return nil
+}
+
+// mapTenantPurgeRoute maps admin only endpoint purging resources of a tenant,
+// "?dry_run=true" lists resources without deleting them
+func mapTenantPurgeRoute(server *Server) {
+	server.martini.Post("/_tenants/:tenant_id/purge", func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization) {
+		if !auth.IsAdmin() {
+			middleware.HTTPJSONError(w, "Tenant purge is allowed only for admin", http.StatusForbidden)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		report, err := NewTenantPurger(server).Purge(r.Context(), p["tenant_id"], dryRun)
+		if err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		routes.ServeJson(w, report)
+	})
+}
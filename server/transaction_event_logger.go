@@ -57,21 +57,12 @@ func (tl *transactionEventLogger) logEvent(ctx context.Context, eventType string
 		return fmt.Errorf("Error during event resource deserialisation: %s", err.Error())
 	}
 
-	syncPlain := false
-	syncPlainRaw, ok := resource.Schema().Metadata["sync_plain"]
-	if ok {
-		syncPlainBool, ok := syncPlainRaw.(bool)
-		if ok {
-			syncPlain = syncPlainBool
-		}
-	}
-
 	eventResource := schema.NewResource(eventSchema, map[string]interface{}{
 		"type":          eventType,
 		"path":          resource.Path(),
 		"version":       version,
 		"body":          body,
-		"sync_plain":    syncPlain,
+		"sync_plain":    getSyncPlain(resource),
 		"sync_property": getSyncProperty(resource),
 		"timestamp":     int64(time.Now().Unix()),
 	})
@@ -85,6 +76,16 @@ func (tl *transactionEventLogger) logEvent(ctx context.Context, eventType string
 	return err
 }
 
+func getSyncPlain(resource *schema.Resource) bool {
+	if syncPlainRaw, ok := resource.Schema().Metadata["sync_plain"]; ok {
+		if syncPlainBool, ok := syncPlainRaw.(bool); ok {
+			return syncPlainBool
+		}
+	}
+
+	return false
+}
+
 func getSyncProperty(resource *schema.Resource) string {
 	if syncPropertyRaw, ok := resource.Schema().Metadata["sync_property"]; ok {
 		if syncPropertyStr, ok := syncPropertyRaw.(string); ok {
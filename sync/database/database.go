@@ -0,0 +1,874 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	sqlpkg "database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	syn "sync"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goext"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/sync"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/twinj/uuid"
+)
+
+const (
+	metaTable   = "gohan_sync_meta"
+	keysTable   = "gohan_sync_keys"
+	eventsTable = "gohan_sync_events"
+
+	likeEscape = "!"
+
+	// DefaultLockTTL is the time after which a lock which is not refreshed is lost
+	DefaultLockTTL = 10 * time.Second
+	// DefaultPollInterval is the interval of polling for changes made by other processes
+	DefaultPollInterval = 500 * time.Millisecond
+	// DefaultHistoryLimit is the number of revisions kept in the events table when it's not compacted
+	DefaultHistoryLimit = 10000
+
+	watchBatchSize = 1000
+)
+
+var (
+	log = l.NewLogger()
+
+	// KeyNotFound is returned by Fetch when there is no key under the path
+	KeyNotFound = sync.KeyNotFound
+
+	errCompacted      = errors.New("mvcc: required revision has been compacted")
+	errFutureRevision = errors.New("mvcc: required revision is a future revision")
+)
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sqlpkg.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sqlpkg.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sqlpkg.Row
+}
+
+type watchEvent struct {
+	action   string
+	key      string
+	value    string
+	revision int64
+}
+
+//Sync is a sync backend storing revisioned keys in SQL tables of the database Gohan already uses.
+//Changes of other processes are detected by polling, changes of this process wake up watchers immediately.
+//Expired leases are deleted every poll interval, so that watchers see locks of stopped processes deleted,
+//and events are trimmed to the history limit.
+type Sync struct {
+	db           *sqlpkg.DB
+	processID    string
+	timeout      time.Duration
+	lockTTL      time.Duration
+	pollInterval time.Duration
+	historyLimit int64
+
+	mu      syn.Mutex
+	locks   map[string]chan struct{}
+	changed chan struct{}
+
+	closed    chan struct{}
+	closeOnce syn.Once
+}
+
+//NewSync connects to the database and creates sync tables if needed
+func NewSync(dbType, connection string, timeout, lockTTL, pollInterval time.Duration) (*Sync, error) {
+	db, err := sqlpkg.Open(dbType, connection)
+	if err != nil {
+		return nil, err
+	}
+	if dbType == "sqlite3" {
+		// sqlite does not support concurrent writers
+		db.SetMaxOpenConns(1)
+	}
+	hostname, _ := os.Hostname()
+	s := &Sync{
+		db:           db,
+		processID:    hostname + uuid.NewV4().String(),
+		timeout:      timeout,
+		lockTTL:      lockTTL,
+		pollInterval: pollInterval,
+		historyLimit: DefaultHistoryLimit,
+		locks:        map[string]chan struct{}{},
+		changed:      make(chan struct{}),
+		closed:       make(chan struct{}),
+	}
+	if err := s.createTables(dbType); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sync tables: %s", err)
+	}
+	go s.maintain()
+	return s, nil
+}
+
+func (s *Sync) createTables(dbType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	keyType, valueType := "VARCHAR(255)", "TEXT"
+	if dbType == "mysql" {
+		// keys are case sensitive like in etcd and values may be larger than 64KB
+		keyType, valueType = "VARBINARY(255)", "LONGTEXT"
+	}
+	statements := []string{
+		"CREATE TABLE IF NOT EXISTS " + metaTable + " (" +
+			"id INT NOT NULL PRIMARY KEY, revision BIGINT NOT NULL, compact_revision BIGINT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS " + keysTable + " (" +
+			"sync_key " + keyType + " NOT NULL PRIMARY KEY, value " + valueType + " NOT NULL, " +
+			"create_revision BIGINT NOT NULL, mod_revision BIGINT NOT NULL, " +
+			"lease_owner VARCHAR(255) NOT NULL DEFAULT '', lease_expires BIGINT NOT NULL DEFAULT 0)",
+		"CREATE TABLE IF NOT EXISTS " + eventsTable + " (" +
+			"revision BIGINT NOT NULL, sync_key " + keyType + " NOT NULL, action VARCHAR(16) NOT NULL, value " + valueType + " NOT NULL, " +
+			"PRIMARY KEY (revision, sync_key))",
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+metaTable).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err := s.db.ExecContext(ctx, "INSERT INTO "+metaTable+" (id, revision, compact_revision) VALUES (1, 0, 0)")
+		return err
+	}
+	return nil
+}
+
+func measureTime(timeStarted time.Time, action string) {
+	metrics.UpdateTimer(timeStarted, "sync.database.%s", action)
+}
+
+func updateCounter(delta int64, counter string) {
+	metrics.UpdateCounter(delta, "sync.database.%s", counter)
+}
+
+func escapeLike(prefix string) string {
+	replacer := strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_")
+	return replacer.Replace(prefix) + "%"
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// withTx runs fn in a transaction and wakes up local watchers and lock waiters if it changed anything
+func (s *Sync) withTx(parent context.Context, fn func(ctx context.Context, tx *sqlpkg.Tx) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(parent, s.timeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	changed, err := fn(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if changed {
+		s.notify()
+	}
+	return nil
+}
+
+func (s *Sync) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Sync) changedCh() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+// nextRevision increments the revision. Updating the single meta row serializes writers,
+// so revisions become visible in order.
+func nextRevision(ctx context.Context, tx execer) (int64, error) {
+	if _, err := tx.ExecContext(ctx, "UPDATE "+metaTable+" SET revision = revision + 1 WHERE id = 1"); err != nil {
+		return 0, err
+	}
+	var revision int64
+	err := tx.QueryRowContext(ctx, "SELECT revision FROM "+metaTable+" WHERE id = 1").Scan(&revision)
+	return revision, err
+}
+
+func currentRevisions(ctx context.Context, q execer) (revision, compactRevision int64, err error) {
+	err = q.QueryRowContext(ctx, "SELECT revision, compact_revision FROM "+metaTable+" WHERE id = 1").
+		Scan(&revision, &compactRevision)
+	return
+}
+
+func put(ctx context.Context, tx execer, revision int64, key, value, leaseOwner string, leaseExpires int64) error {
+	result, err := tx.ExecContext(ctx, "UPDATE "+keysTable+
+		" SET value = ?, mod_revision = ?, lease_owner = ?, lease_expires = ? WHERE sync_key = ?",
+		value, revision, leaseOwner, leaseExpires, key)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+keysTable+
+			" (sync_key, value, create_revision, mod_revision, lease_owner, lease_expires) VALUES (?, ?, ?, ?, ?, ?)",
+			key, value, revision, revision, leaseOwner, leaseExpires); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+eventsTable+" (revision, sync_key, action, value) VALUES (?, ?, 'set', ?)",
+		revision, key, value)
+	return err
+}
+
+func deleteKeys(ctx context.Context, tx execer, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	revision, err := nextRevision(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := remove(ctx, tx, revision, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func remove(ctx context.Context, tx execer, revision int64, key string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+keysTable+" WHERE sync_key = ?", key); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO "+eventsTable+" (revision, sync_key, action, value) VALUES (?, ?, 'delete', '')",
+		revision, key)
+	return err
+}
+
+func queryKeys(ctx context.Context, q execer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// expireLeases deletes lock keys which were not refreshed in time
+func expireLeases(ctx context.Context, tx execer) (bool, error) {
+	keys, err := queryKeys(ctx, tx, "SELECT sync_key FROM "+keysTable+" WHERE lease_expires > 0 AND lease_expires < ?", nowMillis())
+	if err != nil || len(keys) == 0 {
+		return false, err
+	}
+	updateCounter(int64(len(keys)), "lock.expired")
+	return true, deleteKeys(ctx, tx, keys)
+}
+
+//GetProcessID returns processID
+func (s *Sync) GetProcessID() string {
+	return s.processID
+}
+
+//Update sets the value of the key.
+//When jsonString is empty, this method does nothing, same as in the etcd backend.
+func (s *Sync) Update(ctx context.Context, key, jsonString string) error {
+	defer measureTime(time.Now(), "update")
+	if jsonString == "" {
+		return nil
+	}
+	err := s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+		revision, err := nextRevision(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+		return true, put(ctx, tx, revision, key, jsonString, "", 0)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to sync with backend: %s", err))
+		updateCounter(1, "update.error")
+	}
+	return err
+}
+
+//Delete deletes the key, or all keys starting with it if prefix is set
+func (s *Sync) Delete(ctx context.Context, key string, prefix bool) error {
+	defer measureTime(time.Now(), "delete")
+	err := s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+		var (
+			keys []string
+			err  error
+		)
+		if prefix {
+			keys, err = queryKeys(ctx, tx, "SELECT sync_key FROM "+keysTable+" WHERE sync_key LIKE ? ESCAPE '"+likeEscape+"' ORDER BY sync_key",
+				escapeLike(key))
+		} else {
+			keys, err = queryKeys(ctx, tx, "SELECT sync_key FROM "+keysTable+" WHERE sync_key = ?", key)
+		}
+		if err != nil {
+			return false, err
+		}
+		return len(keys) > 0, deleteKeys(ctx, tx, keys)
+	})
+	if err != nil {
+		updateCounter(1, "delete.error")
+	}
+	return err
+}
+
+//Batch applies all ops in a single transaction and revision
+func (s *Sync) Batch(ctx context.Context, ops []sync.Op) error {
+	defer measureTime(time.Now(), "batch")
+	if len(ops) == 0 {
+		return nil
+	}
+	err := s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+		revision, err := nextRevision(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+		for _, op := range ops {
+			if !op.Delete {
+				err = put(ctx, tx, revision, op.Key, op.Value, "", 0)
+			} else if keys, qerr := queryKeys(ctx, tx, "SELECT sync_key FROM "+keysTable+" WHERE sync_key = ?", op.Key); qerr != nil {
+				err = qerr
+			} else if len(keys) > 0 {
+				err = remove(ctx, tx, revision, op.Key)
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		updateCounter(1, "batch.error")
+	}
+	return err
+}
+
+//Fetch returns the tree of keys under the path
+func (s *Sync) Fetch(ctx context.Context, key string) (*sync.Node, error) {
+	defer measureTime(time.Now(), "fetch")
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, "SELECT sync_key, value, mod_revision FROM "+keysTable+
+		" WHERE sync_key LIKE ? ESCAPE '"+likeEscape+"' AND (lease_expires = 0 OR lease_expires >= ?) ORDER BY sync_key",
+		escapeLike(key), nowMillis())
+	if err != nil {
+		updateCounter(1, "fetch.error")
+		return nil, err
+	}
+	defer rows.Close()
+
+	kvs := []*sync.KeyValue{}
+	for rows.Next() {
+		kv := &sync.KeyValue{}
+		if err := rows.Scan(&kv.Key, &kv.Value, &kv.ModRevision); err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	node := sync.NodeFromKeyValues(key, kvs)
+	if node == nil {
+		log.Debug("Key not found (%s)", key)
+		return nil, KeyNotFound
+	}
+	return node, nil
+}
+
+//HasLock checks current process owns lock or not
+func (s *Sync) HasLock(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.locks[path]
+	return ok
+}
+
+func (s *Sync) tryLock(ctx context.Context, path string) (bool, error) {
+	locked := false
+	err := s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+		expired, err := expireLeases(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+		keys, err := queryKeys(ctx, tx, "SELECT sync_key FROM "+keysTable+" WHERE sync_key = ?", path)
+		if err != nil {
+			return false, err
+		}
+		if len(keys) > 0 {
+			return expired, nil
+		}
+		revision, err := nextRevision(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+		locked = true
+		return true, put(ctx, tx, revision, path, s.processID, s.processID, nowMillis()+s.lockTTL.Nanoseconds()/int64(time.Millisecond))
+	})
+	return locked, err
+}
+
+// Lock locks the path using a key with a lease refreshed every half of the lock TTL.
+// The returned channel is closed when the lease could not be refreshed.
+func (s *Sync) Lock(ctx context.Context, path string, block bool) (chan struct{}, error) {
+	defer measureTime(time.Now(), "lock")
+	updateCounter(1, "lock.waiting")
+	defer updateCounter(-1, "lock.waiting")
+
+	for {
+		changed := s.changedCh()
+		locked, err := s.tryLock(ctx, path)
+		if err == nil && locked {
+			break
+		}
+		msg := fmt.Sprintf("failed to lock path %s", path)
+		if err != nil {
+			updateCounter(1, "lock.error")
+			msg = fmt.Sprintf("failed to lock path %s: %s", path, err)
+		}
+		log.Debug(msg)
+		if err == context.Canceled {
+			return nil, err
+		}
+		if !block {
+			return nil, errors.New(msg)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		case <-time.After(s.pollInterval):
+		}
+	}
+
+	lost := make(chan struct{})
+	s.mu.Lock()
+	s.locks[path] = lost
+	s.mu.Unlock()
+	log.Info("Locked %s", path)
+	updateCounter(1, "lock.granted")
+
+	go s.keepAlive(path, lost)
+	return lost, nil
+}
+
+func (s *Sync) keepAlive(path string, lost chan struct{}) {
+	defer updateCounter(-1, "lock.granted")
+
+	ticker := time.NewTicker(s.lockTTL / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !s.ownsLock(path, lost) {
+			return
+		}
+		refreshed, err := s.refreshLease(path)
+		if err == nil && refreshed {
+			continue
+		}
+		updateCounter(1, "lock.keepalive.error")
+		log.Notice("failed to keepalive lock for %s %v", path, err)
+		s.mu.Lock()
+		if s.locks[path] == lost {
+			delete(s.locks, path)
+			close(lost)
+		}
+		s.mu.Unlock()
+		return
+	}
+}
+
+func (s *Sync) ownsLock(path string, lost chan struct{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.locks[path] == lost
+}
+
+func (s *Sync) refreshLease(path string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	now := nowMillis()
+	result, err := s.db.ExecContext(ctx, "UPDATE "+keysTable+" SET lease_expires = ? "+
+		"WHERE sync_key = ? AND lease_owner = ? AND lease_expires >= ?",
+		now+s.lockTTL.Nanoseconds()/int64(time.Millisecond), path, s.processID, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+//Unlock path
+func (s *Sync) Unlock(ctx context.Context, path string) error {
+	defer measureTime(time.Now(), "unlock")
+
+	s.mu.Lock()
+	lost, ok := s.locks[path]
+	if ok {
+		delete(s.locks, path)
+		close(lost)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	err := s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+		keys, err := queryKeys(ctx, tx, "SELECT sync_key FROM "+keysTable+" WHERE sync_key = ? AND lease_owner = ?", path, s.processID)
+		if err != nil {
+			return false, err
+		}
+		return len(keys) > 0, deleteKeys(ctx, tx, keys)
+	})
+	if err != nil {
+		log.Notice("Deleting %s failed: %s", path, err)
+	}
+	log.Info("Unlocked path %s", path)
+	return nil
+}
+
+func toEvent(ev *watchEvent) *sync.Event {
+	event := &sync.Event{
+		Action:   ev.action,
+		Key:      ev.key,
+		Value:    ev.value,
+		Revision: ev.revision,
+	}
+	if ev.value != "" {
+		if err := json.Unmarshal([]byte(ev.value), &event.Data); err != nil {
+			log.Warning("failed to unmarshal watch response value %s: %s", ev.value, err)
+		}
+	}
+	return event
+}
+
+func (s *Sync) currentValues(ctx context.Context, path string) ([]*watchEvent, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, &sqlpkg.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	revision, _, err := currentRevisions(ctx, tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT sync_key, value, mod_revision FROM "+keysTable+
+		" WHERE sync_key LIKE ? ESCAPE '"+likeEscape+"' ORDER BY mod_revision", escapeLike(path))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	events := []*watchEvent{}
+	for rows.Next() {
+		ev := &watchEvent{action: "get"}
+		if err := rows.Scan(&ev.key, &ev.value, &ev.revision); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, ev)
+	}
+	return events, revision, rows.Err()
+}
+
+func (s *Sync) eventsSince(ctx context.Context, path string, revision int64) ([]*watchEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, compactRevision, err := currentRevisions(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	if revision < compactRevision {
+		return nil, goext.NewErrCompacted(errCompacted, compactRevision)
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT revision, sync_key, action, value FROM "+eventsTable+
+		" WHERE revision >= ? AND sync_key LIKE ? ESCAPE '"+likeEscape+"' ORDER BY revision, sync_key LIMIT ?",
+		revision, escapeLike(path), watchBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*watchEvent{}
+	for rows.Next() {
+		ev := &watchEvent{}
+		if err := rows.Scan(&ev.revision, &ev.key, &ev.action, &ev.value); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// SetHistoryLimit sets the number of revisions kept in the events table, 0 keeps all of them until compaction.
+// Events are trimmed once they exceed the limit by a tenth, watching from a trimmed revision
+// fails with a compaction error.
+func (s *Sync) SetHistoryLimit(limit int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.historyLimit = limit
+}
+
+// maintain deletes expired leases and trims events every poll interval until the sync is closed
+func (s *Sync) maintain() {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.expire(context.Background())
+			s.trimHistory(context.Background())
+		}
+	}
+}
+
+// trimHistory compacts events over the history limit
+func (s *Sync) trimHistory(ctx context.Context) {
+	s.mu.Lock()
+	limit := s.historyLimit
+	s.mu.Unlock()
+	if limit <= 0 {
+		return
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	revision, compactRevision, err := currentRevisions(queryCtx, s.db)
+	cancel()
+	if err != nil {
+		log.Debug("Failed to read sync revisions: %s", err)
+		return
+	}
+	if revision-compactRevision <= limit+limit/10 {
+		return
+	}
+	// other processes may trim the same events concurrently
+	if err := s.Compact(ctx, revision-limit+1); err != nil && err != errCompacted {
+		log.Debug("Failed to trim sync events: %s", err)
+		return
+	}
+	updateCounter(1, "history.trimmed")
+}
+
+// expire deletes expired leases, a write transaction is started only when there are any
+func (s *Sync) expire(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var expired bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+keysTable+" WHERE lease_expires > 0 AND lease_expires < ?)", nowMillis()).Scan(&expired)
+	if err == nil && expired {
+		err = s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+			return expireLeases(ctx, tx)
+		})
+	}
+	if err != nil {
+		log.Debug("Failed to expire sync leases: %s", err)
+	}
+}
+
+func (s *Sync) watch(ctx context.Context, path string, revision int64, responseChan chan *sync.Event) error {
+	updateCounter(1, "watch.active")
+	defer updateCounter(-1, "watch.active")
+
+	send := func(ev *watchEvent) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case responseChan <- toEvent(ev):
+			return true
+		}
+	}
+
+	next := revision
+	if revision == goext.RevisionCurrent {
+		events, current, err := s.currentValues(ctx, path)
+		if err != nil {
+			updateCounter(1, "watch.get.error")
+			return err
+		}
+		for _, ev := range events {
+			if !send(ev) {
+				return nil
+			}
+		}
+		next = current + 1
+	}
+
+	for {
+		changed := s.changedCh()
+		events, err := s.eventsSince(ctx, path, next)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, ev := range events {
+			if !send(ev) {
+				return nil
+			}
+			next = ev.revision + 1
+		}
+		if len(events) == watchBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// Watch keep watch update under the path until context is canceled
+func (s *Sync) Watch(ctx context.Context, path string, revision int64) <-chan *sync.Event {
+	eventCh := make(chan *sync.Event, 32)
+	go func() {
+		defer close(eventCh)
+		if err := s.watch(ctx, path, revision, eventCh); err != nil {
+			select {
+			case eventCh <- &sync.Event{Err: err}:
+			default:
+				updateCounter(1, "watch.eventch_full")
+				log.Debug("Unable to send error: '%s' via response chan. Don't linger.", err)
+			}
+		}
+	}()
+	return eventCh
+}
+
+//Compact deletes events before the revision
+func (s *Sync) Compact(ctx context.Context, revision int64) error {
+	return s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+		current, compactRevision, err := currentRevisions(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+		if revision <= compactRevision {
+			return false, errCompacted
+		}
+		if revision > current {
+			return false, errFutureRevision
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+eventsTable+" WHERE revision < ?", revision); err != nil {
+			return false, err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE "+metaTable+" SET compact_revision = ? WHERE id = 1", revision)
+		return false, err
+	})
+}
+
+//CurrentRevision returns the revision of the latest change
+func (s *Sync) CurrentRevision(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	revision, _, err := currentRevisions(ctx, s.db)
+	return revision, err
+}
+
+type valueCondition string
+
+//CompareAndSwap sets the value if all conditions are met
+func (s *Sync) CompareAndSwap(ctx context.Context, path, data string, conditions ...sync.CASCondition) (bool, error) {
+	swapped := false
+	err := s.withTx(ctx, func(ctx context.Context, tx *sqlpkg.Tx) (bool, error) {
+		// take the revision first, so that concurrent swaps are serialized
+		revision, err := nextRevision(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+		for _, condition := range conditions {
+			expected, ok := condition.(valueCondition)
+			if !ok {
+				return false, fmt.Errorf("unsupported CAS condition %T", condition)
+			}
+			var value string
+			err := tx.QueryRowContext(ctx, "SELECT value FROM "+keysTable+" WHERE sync_key = ?", path).Scan(&value)
+			if err == sqlpkg.ErrNoRows || (err == nil && value != string(expected)) {
+				return false, errCASFailed
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		swapped = true
+		return true, put(ctx, tx, revision, path, data, "", 0)
+	})
+	if err == errCASFailed {
+		return false, nil
+	}
+	return swapped, err
+}
+
+var errCASFailed = errors.New("CAS condition not met")
+
+//ByValue is a CAS condition met when the current value equals value
+func (s *Sync) ByValue(value string) sync.CASCondition {
+	return valueCondition(value)
+}
+
+//Close releases locks held by this process and closes the database connection
+func (s *Sync) Close() {
+	defer measureTime(time.Now(), "close")
+	s.closeOnce.Do(func() { close(s.closed) })
+
+	s.mu.Lock()
+	paths := make([]string, 0, len(s.locks))
+	for path := range s.locks {
+		paths = append(paths, path)
+	}
+	s.mu.Unlock()
+	for _, path := range paths {
+		s.Unlock(context.Background(), path)
+	}
+	if err := s.db.Close(); err != nil {
+		log.Notice("Closing sync database failed: %s", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goext"
+)
+
+const testLockTTL = 200 * time.Millisecond
+
+func newTestSyncs(t *testing.T, count int) ([]*Sync, func()) {
+	dir, err := ioutil.TempDir("", "gohan_sync")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	connection := "file:" + filepath.Join(dir, "sync.db") + "?_txlock=immediate"
+	syncs := []*Sync{}
+	for i := 0; i < count; i++ {
+		sync, err := NewSync("sqlite3", connection, time.Second, testLockTTL, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		syncs = append(syncs, sync)
+	}
+	return syncs, func() {
+		for _, sync := range syncs {
+			sync.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func expectLost(t *testing.T, lost chan struct{}) {
+	select {
+	case <-lost:
+	case <-time.After(5 * testLockTTL):
+		t.Fatalf("lock was not lost")
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if escaped := escapeLike("/a_b%c!"); escaped != "/a!_b!%c!!%" {
+		t.Fatalf("unexpected escaped prefix %s", escaped)
+	}
+}
+
+func TestPrefixIsNotPattern(t *testing.T) {
+	ctx := context.Background()
+	syncs, cleanup := newTestSyncs(t, 1)
+	defer cleanup()
+
+	if err := syncs[0].Update(ctx, "/a_b/c", `{}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := syncs[0].Update(ctx, "/axb/c", `{}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	node, err := syncs[0].Fetch(ctx, "/a_b")
+	if err != nil || len(node.Children) != 1 {
+		t.Fatalf("unexpected fetch result %+v, %v", node, err)
+	}
+}
+
+func TestLockLostWhenLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	syncs, cleanup := newTestSyncs(t, 2)
+	defer cleanup()
+
+	lost, err := syncs[0].Lock(ctx, "/lock", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// simulate a process which stopped refreshing its lease
+	if _, err := syncs[0].db.Exec("UPDATE "+keysTable+" SET lease_expires = 1 WHERE sync_key = ?", "/lock"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := syncs[1].Lock(ctx, "/lock", true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectLost(t, lost)
+	if syncs[0].HasLock("/lock") {
+		t.Fatalf("lock should be lost")
+	}
+}
+
+func TestExpiredLeaseDeletedWithoutWatchers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	syncs, cleanup := newTestSyncs(t, 2)
+	defer cleanup()
+
+	if _, err := syncs[0].Lock(ctx, "/lock", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := syncs[0].db.Exec("UPDATE "+keysTable+" SET lease_expires = 1 WHERE sync_key = ?", "/lock"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	revision, err := syncs[1].CurrentRevision(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the lease is deleted by the expiry of the sync, not by watchers or lockers
+	for {
+		current, err := syncs[1].CurrentRevision(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if current > revision {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expired lease was not deleted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	event := <-syncs[1].Watch(ctx, "/lock", revision+1)
+	if event.Err != nil || event.Action != "delete" || event.Key != "/lock" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestLockKeptAlive(t *testing.T) {
+	ctx := context.Background()
+	syncs, cleanup := newTestSyncs(t, 2)
+	defer cleanup()
+
+	lost, err := syncs[0].Lock(ctx, "/lock", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(3 * testLockTTL)
+	select {
+	case <-lost:
+		t.Fatalf("lock should be kept alive")
+	default:
+	}
+	if _, err := syncs[1].Lock(ctx, "/lock", false); err == nil {
+		t.Fatalf("lock should be held by another process")
+	}
+}
+
+func TestWatchCompacted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	syncs, cleanup := newTestSyncs(t, 1)
+	defer cleanup()
+
+	for i := 0; i < 2; i++ {
+		if err := syncs[0].Update(ctx, "/key", `{"version": 1}`); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := syncs[0].Compact(ctx, 3); err == nil {
+		t.Fatalf("compacting a future revision should fail")
+	}
+	if err := syncs[0].Compact(ctx, 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	event := <-syncs[0].Watch(ctx, "/key", 1)
+	if _, ok := event.Err.(goext.ErrCompacted); !ok {
+		t.Fatalf("expected compaction error, got %+v", event)
+	}
+	event = <-syncs[0].Watch(ctx, "/key", 2)
+	if event.Err != nil || event.Revision != 2 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestHistoryTrimmed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	syncs, cleanup := newTestSyncs(t, 1)
+	defer cleanup()
+	syncs[0].SetHistoryLimit(10)
+
+	for i := 0; i < 12; i++ {
+		if err := syncs[0].Update(ctx, "/key", `{}`); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	for {
+		if _, compactRevision, err := currentRevisions(ctx, syncs[0].db); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if compactRevision == 3 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("events were not trimmed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	event := <-syncs[0].Watch(ctx, "/key", 2)
+	if _, ok := event.Err.(goext.ErrCompacted); !ok {
+		t.Fatalf("trimmed events should be compacted, got %+v", event)
+	}
+	event = <-syncs[0].Watch(ctx, "/key", 3)
+	if event.Err != nil || event.Revision != 3 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
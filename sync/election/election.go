@@ -0,0 +1,353 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	syncpkg "sync"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goext"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/sync"
+)
+
+var log = l.NewLogger()
+
+const (
+	//LeadersPath is where leaders publish their role, process and acquisition time
+	LeadersPath = "/gohan/cluster/leaders"
+	//ResignPath is where resignations of leaders are requested
+	ResignPath = "/gohan/cluster/resign"
+
+	//ResignHoldOff is how long a leader which was requested to resign doesn't campaign again,
+	//so that another process can take over
+	ResignHoldOff = 10 * time.Second
+)
+
+var (
+	//ErrResigned is returned by Campaign during the hold-off after a requested resignation
+	ErrResigned = errors.New("leadership was resigned on request recently")
+	//ErrNoLeader is returned when a role has no leader
+	ErrNoLeader = errors.New("role has no leader")
+)
+
+//Leader describes the holder of a role
+type Leader struct {
+	Role       string     `json:"role"`
+	ProcessID  string     `json:"process_id"`
+	AcquiredAt *time.Time `json:"acquired_at,omitempty"`
+	LockKey    string     `json:"lock_key"`
+}
+
+//Election elects a single leader for a role among Gohan processes, using a lock on the sync backend
+type Election struct {
+	sync    sync.Sync
+	role    string
+	lockKey string
+
+	mu          syncpkg.Mutex
+	cancelTerm  context.CancelFunc
+	resignedAt  time.Time
+	termRunning chan struct{}
+}
+
+//New creates an election of role, held with a lock of lockKey
+func New(s sync.Sync, role, lockKey string) *Election {
+	return &Election{sync: s, role: role, lockKey: lockKey}
+}
+
+//Role returns the elected role
+func (e *Election) Role() string {
+	return e.role
+}
+
+func infoKey(role string) string {
+	return LeadersPath + "/" + strings.Trim(role, "/")
+}
+
+func resignKey(role string) string {
+	return ResignPath + "/" + strings.Trim(role, "/")
+}
+
+//Campaign makes the process the leader of the role, waiting for the current leader to step down if block is true.
+//The returned channel is closed when the leadership is lost or resigned.
+func (e *Election) Campaign(ctx context.Context, block bool) (<-chan struct{}, error) {
+	if err := e.holdOff(ctx, block); err != nil {
+		return nil, err
+	}
+	lockLost, err := e.sync.Lock(ctx, e.lockKey, block)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	leader := Leader{Role: e.role, ProcessID: e.sync.GetProcessID(), AcquiredAt: &now, LockKey: e.lockKey}
+	if data, err := json.Marshal(leader); err == nil {
+		if err := e.sync.Update(ctx, infoKey(e.role), string(data)); err != nil {
+			log.Warning("Failed to publish leader of %s: %s", e.role, err)
+		}
+	}
+	log.Info("Elected leader of %s", e.role)
+
+	termCtx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	e.mu.Lock()
+	e.cancelTerm = cancel
+	e.termRunning = running
+	e.mu.Unlock()
+
+	lost := make(chan struct{})
+	requests := e.sync.Watch(termCtx, resignKey(e.role), goext.RevisionCurrent)
+	go func() {
+		defer close(running)
+		defer close(lost)
+		for {
+			select {
+			case <-termCtx.Done():
+				return
+			case <-lockLost:
+				log.Warning("Lost leadership of %s", e.role)
+				cancel()
+				return
+			case request, ok := <-requests:
+				if !ok {
+					<-termCtx.Done()
+					return
+				}
+				if request.Err != nil || request.Action == "delete" || request.Data["process_id"] != leader.ProcessID {
+					continue
+				}
+				e.mu.Lock()
+				resigning := e.cancelTerm != nil
+				e.cancelTerm, e.termRunning = nil, nil
+				e.resignedAt = time.Now()
+				e.mu.Unlock()
+				cancel()
+				if !resigning {
+					// Resign was called concurrently and steps down itself
+					return
+				}
+				log.Notice("Resigning leadership of %s on request", e.role)
+				e.stepDown(context.Background())
+				if err := e.sync.Delete(context.Background(), resignKey(e.role), false); err != nil {
+					log.Warning("Failed to delete resign request of %s: %s", e.role, err)
+				}
+				return
+			}
+		}
+	}()
+	return lost, nil
+}
+
+func (e *Election) holdOff(ctx context.Context, block bool) error {
+	e.mu.Lock()
+	wait := ResignHoldOff - time.Since(e.resignedAt)
+	e.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if !block {
+		return ErrResigned
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Election) stepDown(ctx context.Context) {
+	// the leader is unpublished while holding the lock, so that it doesn't remove the next leader
+	if node, err := e.sync.Fetch(ctx, infoKey(e.role)); err == nil {
+		var leader Leader
+		if json.Unmarshal([]byte(node.Value), &leader) == nil && leader.ProcessID == e.sync.GetProcessID() {
+			if err := e.sync.Delete(ctx, infoKey(e.role), false); err != nil {
+				log.Warning("Failed to unpublish leader of %s: %s", e.role, err)
+			}
+		}
+	}
+	if err := e.sync.Unlock(ctx, e.lockKey); err != nil {
+		log.Warning("Failed to unlock %s: %s", e.lockKey, err)
+	}
+}
+
+//Resign steps down from the leadership of the role
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	cancel, running := e.cancelTerm, e.termRunning
+	e.cancelTerm, e.termRunning = nil, nil
+	e.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-running
+	e.stepDown(ctx)
+	return nil
+}
+
+//IsLeader checks if the process is the leader of the role
+func (e *Election) IsLeader() bool {
+	return e.sync.HasLock(e.lockKey)
+}
+
+//Observe sends the leader of the role whenever it changes, or nil when the role has no leader,
+//until the context is canceled
+func (e *Election) Observe(ctx context.Context) <-chan *Leader {
+	leaders := make(chan *Leader, 1)
+	go func() {
+		defer close(leaders)
+		send := func(leader *Leader) bool {
+			select {
+			case leaders <- leader:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		leader, err := GetLeader(ctx, e.sync, e.role)
+		if err != nil && err != ErrNoLeader {
+			log.Warning("Failed to get leader of %s: %s", e.role, err)
+		}
+		if !send(leader) {
+			return
+		}
+		for event := range e.sync.Watch(ctx, infoKey(e.role), goext.RevisionCurrent) {
+			if event.Err != nil {
+				log.Warning("Failed to observe leader of %s: %s", e.role, event.Err)
+				return
+			}
+			var leader *Leader
+			if event.Action != "delete" {
+				leader, _ = leaderFromData(event.Data)
+			}
+			if !send(leader) {
+				return
+			}
+		}
+	}()
+	return leaders
+}
+
+func leaderFromData(data map[string]interface{}) (*Leader, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var leader Leader
+	if err := json.Unmarshal(raw, &leader); err != nil {
+		return nil, err
+	}
+	return &leader, nil
+}
+
+//List returns current leaders of all roles, sorted by role.
+//Published leaders are verified with the holder of the lock.
+func List(ctx context.Context, s sync.Sync) ([]*Leader, error) {
+	node, err := s.Fetch(ctx, LeadersPath)
+	if err == sync.KeyNotFound {
+		return []*Leader{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	leaders := []*Leader{}
+	var collect func(node *sync.Node) error
+	collect = func(node *sync.Node) error {
+		if len(node.Children) == 0 && strings.HasPrefix(node.Key, LeadersPath+"/") {
+			var published Leader
+			if err := json.Unmarshal([]byte(node.Value), &published); err != nil {
+				log.Warning("Invalid leader %s: %s", node.Key, err)
+				return nil
+			}
+			leader, err := verify(ctx, s, &published)
+			if err != nil {
+				return err
+			}
+			if leader != nil {
+				leaders = append(leaders, leader)
+			}
+		}
+		for _, child := range node.Children {
+			if err := collect(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := collect(node); err != nil {
+		return nil, err
+	}
+	sort.Slice(leaders, func(i, j int) bool { return leaders[i].Role < leaders[j].Role })
+	return leaders, nil
+}
+
+//GetLeader returns the current leader of the role
+func GetLeader(ctx context.Context, s sync.Sync, role string) (*Leader, error) {
+	node, err := s.Fetch(ctx, infoKey(role))
+	if err == sync.KeyNotFound {
+		return nil, ErrNoLeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	var published Leader
+	if err := json.Unmarshal([]byte(node.Value), &published); err != nil {
+		return nil, fmt.Errorf("invalid leader of %s: %s", role, err)
+	}
+	leader, err := verify(ctx, s, &published)
+	if err == nil && leader == nil {
+		err = ErrNoLeader
+	}
+	return leader, err
+}
+
+//verify returns the holder of the lock of a published leader, or nil if nobody holds it
+func verify(ctx context.Context, s sync.Sync, published *Leader) (*Leader, error) {
+	lock, err := s.Fetch(ctx, published.LockKey)
+	if err == sync.KeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lock.Value != published.ProcessID {
+		// another process took over, but didn't publish itself yet
+		return &Leader{Role: published.Role, ProcessID: lock.Value, LockKey: published.LockKey}, nil
+	}
+	return published, nil
+}
+
+//RequestResign asks the current leader of the role to resign. The leader doesn't campaign
+//again for ResignHoldOff, so that another process can take over.
+func RequestResign(ctx context.Context, s sync.Sync, role string) (*Leader, error) {
+	leader, err := GetLeader(ctx, s, role)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(map[string]interface{}{"process_id": leader.ProcessID})
+	if err != nil {
+		return nil, err
+	}
+	return leader, s.Update(ctx, resignKey(role), string(data))
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/sync/memory"
+)
+
+func newTestProcesses(t *testing.T) (*memory.Sync, *memory.Sync) {
+	store, err := memory.NewStore("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return memory.NewSync(store), memory.NewSync(store)
+}
+
+func TestCampaignPublishesLeader(t *testing.T) {
+	ctx := context.Background()
+	first, second := newTestProcesses(t)
+	firstElection := New(first, "writer", "/gohan/cluster/sync")
+	secondElection := New(second, "writer", "/gohan/cluster/sync")
+
+	if _, err := firstElection.Campaign(ctx, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := secondElection.Campaign(ctx, false); err == nil {
+		t.Fatalf("only one process should be elected")
+	}
+	if !firstElection.IsLeader() || secondElection.IsLeader() {
+		t.Fatalf("first process should be the leader")
+	}
+
+	leaders, err := List(ctx, second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(leaders) != 1 || leaders[0].Role != "writer" || leaders[0].ProcessID != first.GetProcessID() ||
+		leaders[0].LockKey != "/gohan/cluster/sync" || leaders[0].AcquiredAt == nil {
+		t.Fatalf("unexpected leaders %+v", leaders)
+	}
+
+	if err := firstElection.Resign(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := GetLeader(ctx, second, "writer"); err != ErrNoLeader {
+		t.Fatalf("expected ErrNoLeader, got %v", err)
+	}
+	if _, err := secondElection.Campaign(ctx, false); err != nil {
+		t.Fatalf("second process should be elected after resignation: %s", err)
+	}
+}
+
+func TestListVerifiesLockHolder(t *testing.T) {
+	ctx := context.Background()
+	first, second := newTestProcesses(t)
+
+	if _, err := New(first, "cron/job", "/gohan/cluster/lock/job").Campaign(ctx, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the lock was lost without stepping down
+	if err := first.Delete(ctx, "/gohan/cluster/lock/job", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	leaders, err := List(ctx, second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(leaders) != 0 {
+		t.Fatalf("expected no leaders, got %+v", leaders)
+	}
+}
+
+func TestRequestResign(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, second := newTestProcesses(t)
+	firstElection := New(first, "writer", "/gohan/cluster/sync")
+	secondElection := New(second, "writer", "/gohan/cluster/sync")
+
+	lost, err := firstElection.Campaign(ctx, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	elected := make(chan error, 1)
+	go func() {
+		_, err := secondElection.Campaign(ctx, true)
+		elected <- err
+	}()
+
+	leader, err := RequestResign(ctx, second, "writer")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if leader.ProcessID != first.GetProcessID() {
+		t.Fatalf("unexpected leader %+v", leader)
+	}
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatalf("leader didn't resign")
+	}
+	select {
+	case err := <-elected:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("second process wasn't elected")
+	}
+	if leader, err := GetLeader(ctx, first, "writer"); err != nil || leader.ProcessID != second.GetProcessID() {
+		t.Fatalf("expected second process to lead, got %+v %v", leader, err)
+	}
+	if err := secondElection.Resign(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := firstElection.Campaign(ctx, false); err != ErrResigned {
+		t.Fatalf("expected ErrResigned during the hold-off, got %v", err)
+	}
+	// nothing to resign twice
+	if err := firstElection.Resign(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
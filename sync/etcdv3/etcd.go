@@ -21,8 +21,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"sort"
-	"strings"
 	syn "sync"
 	"time"
 
@@ -42,7 +40,7 @@ const (
 	masterTTL = 10
 )
 
-var KeyNotFound = errors.New("Key not found")
+var KeyNotFound = sync.KeyNotFound
 
 //Sync is struct for etcd based sync
 type Sync struct {
@@ -118,6 +116,34 @@ func (s *Sync) Update(ctx context.Context, key, jsonString string) error {
 	return nil
 }
 
+//UpdateWithTTL sets the value of the key bound to a lease, so that it's deleted after ttl
+func (s *Sync) UpdateWithTTL(ctx context.Context, key, jsonString string, ttl time.Duration) error {
+	defer measureTime(time.Now(), "update")
+
+	// leases are granted with a precision of seconds
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	var (
+		lease *etcd.LeaseGrantResponse
+		err   error
+	)
+	s.withTimeout(ctx, func(ctx context.Context) {
+		lease, err = s.etcdClient.Grant(ctx, seconds)
+		if err == nil {
+			_, err = s.etcdClient.Put(ctx, key, jsonString, etcd.WithLease(lease.ID))
+		}
+	})
+
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to sync with backend: %s", err))
+		updateCounter(1, "update.error")
+		return err
+	}
+	return nil
+}
+
 //Delete sync update sync
 func (s *Sync) Delete(ctx context.Context, key string, prefix bool) error {
 	defer measureTime(time.Now(), "delete")
@@ -138,6 +164,34 @@ func (s *Sync) Delete(ctx context.Context, key string, prefix bool) error {
 	return err
 }
 
+//Batch applies all ops in a single etcd transaction
+func (s *Sync) Batch(ctx context.Context, ops []sync.Op) error {
+	defer measureTime(time.Now(), "batch")
+	if len(ops) == 0 {
+		return nil
+	}
+
+	etcdOps := make([]etcd.Op, 0, len(ops))
+	for _, op := range ops {
+		if op.Delete {
+			etcdOps = append(etcdOps, etcd.OpDelete(op.Key))
+		} else {
+			etcdOps = append(etcdOps, etcd.OpPut(op.Key, op.Value))
+		}
+	}
+
+	var err error
+	s.withTimeout(ctx, func(ctx context.Context) {
+		_, err = s.etcdClient.Txn(ctx).Then(etcdOps...).Commit()
+	})
+
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to sync batch with backend: %s", err))
+		updateCounter(1, "batch.error")
+	}
+	return err
+}
+
 //Fetch data from sync
 func (s *Sync) Fetch(ctx context.Context, key string) (*sync.Node, error) {
 	defer measureTime(time.Now(), "fetch")
@@ -156,12 +210,11 @@ func (s *Sync) Fetch(ctx context.Context, key string) (*sync.Node, error) {
 		return nil, err
 	}
 
-	sep := "/"
-	curr := strings.Count(key, sep)
-	if curr == 0 {
-		curr = 1
+	kvs := make([]*sync.KeyValue, 0, len(dir.Kvs))
+	for _, kv := range dir.Kvs {
+		kvs = append(kvs, &sync.KeyValue{Key: string(kv.Key), Value: string(kv.Value), ModRevision: kv.ModRevision})
 	}
-	children := recursiveFetch(curr, dir.Kvs, key, sep)
+	children := sync.NodeFromKeyValues(key, kvs)
 	if children == nil {
 		log.Warning("Key not found (%s)", key)
 		return nil, KeyNotFound
@@ -169,63 +222,6 @@ func (s *Sync) Fetch(ctx context.Context, key string) (*sync.Node, error) {
 	return children, nil
 }
 
-func recursiveFetch(curr int, children []*pb.KeyValue, rootKey, sep string) *sync.Node {
-	if len(children) == 0 {
-		return nil
-	}
-	if len(children) == 1 {
-		return handleSingleChild(curr, children[0], rootKey, sep)
-	}
-
-	key := substrN(string(children[0].Key), sep, curr)
-	commonChild := make(map[string][]*pb.KeyValue)
-	for _, child := range children {
-		val := substrN(string(child.Key), sep, curr+1)
-		commonChild[val] = append(commonChild[val], child)
-	}
-	// children nodes has to be alphabetically sorted
-	keys := make([]string, 0, len(commonChild))
-	for k, v := range commonChild {
-		if len(v) != 0 {
-			keys = append(keys, k)
-		}
-	}
-	sort.Strings(keys)
-	nodes := make([]*sync.Node, 0, len(keys))
-	for _, key := range keys {
-		v := commonChild[key]
-		if node := recursiveFetch(curr+1, v, rootKey, sep); node != nil {
-			nodes = append(nodes, node)
-		}
-	}
-	node := &sync.Node{Key: key, Children: nodes}
-	return node
-}
-
-func handleSingleChild(curr int, child *pb.KeyValue, rootKey, sep string) *sync.Node {
-	key := substrN(string(child.Key), sep, curr)
-	if string(child.Key) == key {
-		if string(child.Key) != rootKey && string(child.Key[:len(rootKey)+1]) != rootKey+sep { // remove invalid keys
-			return nil
-		}
-		return &sync.Node{Key: string(child.Key), Value: string(child.Value), Revision: child.ModRevision}
-	}
-	nodes := handleSingleChild(curr+1, child, rootKey, sep)
-	return &sync.Node{Key: key, Children: []*sync.Node{nodes}}
-}
-
-func substrN(s, substr string, n int) string {
-	idx := 1
-	for i := 0; i < n; i++ {
-		tmp := strings.Index(s[idx:], substr)
-		if tmp == -1 {
-			return s
-		}
-		idx += tmp + 1
-	}
-	return s[0 : idx-1]
-}
-
 //HasLock checks current process owns lock or not
 func (s *Sync) HasLock(path string) bool {
 	return s.locks.Has(path)
@@ -345,6 +341,7 @@ func eventsFromNode(ctx context.Context, action string, kvs []*pb.KeyValue, resp
 		event := &sync.Event{
 			Action:   action,
 			Key:      string(kv.Key),
+			Value:    string(kv.Value),
 			Revision: kv.ModRevision,
 		}
 		if kv.Value != nil {
@@ -493,6 +490,21 @@ func (s *Sync) Compact(ctx context.Context, revision int64) error {
 	return err
 }
 
+//CurrentRevision returns the current revision of the etcd cluster
+func (s *Sync) CurrentRevision(ctx context.Context) (int64, error) {
+	var (
+		resp *etcd.GetResponse
+		err  error
+	)
+	s.withTimeout(ctx, func(ctx context.Context) {
+		resp, err = s.etcdClient.Get(ctx, "/", etcd.WithCountOnly())
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
 func (s *Sync) CompareAndSwap(ctx context.Context, path, data string, condition ...sync.CASCondition) (bool, error) {
 	var (
 		resp *etcd.TxnResponse
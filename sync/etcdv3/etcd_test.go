@@ -2,18 +2,29 @@ package etcdv3
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	syn "sync"
 	"testing"
 	"time"
 
 	"github.com/cloudwan/gohan/extension/goext"
 	gohan_sync "github.com/cloudwan/gohan/sync"
-	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/cloudwan/gohan/sync/database"
+	"github.com/cloudwan/gohan/sync/memory"
 )
 
 var (
 	endpoints   = []string{"localhost:2379"}
 	testTimeout = 10 * time.Second
+
+	memoryStore     *memory.Store
+	memoryStoreOnce syn.Once
+
+	databaseFile     string
+	databaseFileOnce syn.Once
 )
 
 func TestNewSyncTimeout(t *testing.T) {
@@ -210,7 +221,7 @@ func TestWatch(t *testing.T) {
 		t.Fatalf("mismatch response: %+v", resp)
 	}
 
-	sync.etcdClient.Delete(ctx, path+"/existing")
+	sync.mustDelete(path+"/existing", false)
 	resp = <-responseChan
 	if resp.Action != "delete" || resp.Key != path+"/existing" || len(resp.Data) != 0 {
 		t.Fatalf("mismatch response: %+v", resp)
@@ -399,19 +410,6 @@ func TestNotIncludedPaths(t *testing.T) {
 	sync.mustNotExist("/path/to/not")
 }
 
-func TestSubstr(t *testing.T) {
-	expectToEqual := func(a, b string) {
-		if a != b {
-			t.Fatalf("expected %s to equal %s", a, b)
-		}
-	}
-	expectToEqual(substrN("/a/b/c/d", "/", 1), "/a")
-	expectToEqual(substrN("/a/b/c/d", "/", 2), "/a/b")
-	expectToEqual(substrN("/a/b/c/d", "/", 3), "/a/b/c")
-	expectToEqual(substrN("/a/b/c/d", "/", 4), "/a/b/c/d")
-	expectToEqual(substrN("/a/b/c/d", "/", 5), "/a/b/c/d")
-}
-
 func TestCASShouldUpdateWhenValueDidNotChange(t *testing.T) {
 	ctx := context.Background()
 
@@ -453,14 +451,37 @@ func TestCASShouldNotUpdateWhenValueChanged(t *testing.T) {
 	sync.mustExist(path, updatedData, 0)
 }
 
+func TestUpdateWithTTLShouldExpireKeys(t *testing.T) {
+	ctx := context.Background()
+
+	sync := newSync(t, ctx)
+	sync.cleanup()
+	if _, ok := sync.Sync.(gohan_sync.TTLUpdater); !ok {
+		t.Skip("sync backend doesn't expire keys")
+	}
+
+	path := "/path/to/ttl"
+	if err := gohan_sync.UpdateWithTTL(ctx, sync.Sync, path, "data", time.Second); err != nil {
+		t.Fatalf("failed to update with TTL: %s", err)
+	}
+	sync.mustExist(path, "data", 0)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if _, err := sync.Fetch(ctx, path); err == KeyNotFound {
+			return
+		}
+	}
+	sync.mustNotExist(path)
+}
+
 type testedSync struct {
-	*Sync
+	gohan_sync.Sync
 	t   *testing.T
 	ctx context.Context
 }
 
 func (sync *testedSync) cleanup() {
-	sync.etcdClient.Delete(sync.ctx, "/", etcd.WithPrefix())
+	sync.Delete(sync.ctx, "/", true)
 }
 
 func (sync *testedSync) getCurrentRevision(key string) int64 {
@@ -480,12 +501,8 @@ func (sync *testedSync) mustUpdate(path, data string) {
 }
 
 func (sync *testedSync) mustPut(path, data string) int64 {
-	resp, err := sync.etcdClient.Put(sync.ctx, path, data)
-	if err != nil {
-		sync.t.Fatalf("unexpected error on putting %s with %s: %s", path, data, err)
-	}
-
-	return resp.Header.Revision
+	sync.mustUpdate(path, data)
+	return sync.mustFetch(path).Revision
 }
 
 func (sync *testedSync) mustCompact(revision int64) {
@@ -532,7 +549,32 @@ func (sync *testedSync) mustExist(path, value string, children int) *gohan_sync.
 	return node
 }
 
+// newSync creates a sync of the backend selected by SYNC_TEST_BACKEND, etcd by default.
+// With SYNC_TEST_BACKEND=memory all syncs share an in-process store, so the suite runs without etcd.
+// With SYNC_TEST_BACKEND=database all syncs share a sqlite file, like separate processes sharing a database.
 func newSync(t *testing.T, ctx context.Context) *testedSync {
+	switch os.Getenv("SYNC_TEST_BACKEND") {
+	case "database":
+		databaseFileOnce.Do(func() {
+			dir, _ := ioutil.TempDir("", "gohan_sync")
+			databaseFile = filepath.Join(dir, "sync.db")
+		})
+		sync, err := database.NewSync("sqlite3", "file:"+databaseFile+"?_txlock=immediate",
+			testTimeout, database.DefaultLockTTL, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return &testedSync{
+			sync, t, ctx,
+		}
+	case "memory":
+		memoryStoreOnce.Do(func() {
+			memoryStore, _ = memory.NewStore("")
+		})
+		return &testedSync{
+			memory.NewSync(memoryStore), t, ctx,
+		}
+	}
 	sync, err := NewSync(endpoints, time.Millisecond*100)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
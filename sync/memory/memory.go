@@ -0,0 +1,627 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	syn "sync"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goext"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/metrics"
+	"github.com/cloudwan/gohan/sync"
+	"github.com/twinj/uuid"
+)
+
+var (
+	log = l.NewLogger()
+
+	// KeyNotFound is returned by Fetch when there is no key under the path
+	KeyNotFound = sync.KeyNotFound
+
+	errCompacted      = errors.New("mvcc: required revision has been compacted")
+	errFutureRevision = errors.New("mvcc: required revision is a future revision")
+)
+
+const (
+	// DefaultHistoryLimit is the number of revisions kept in the history when it's not compacted
+	DefaultHistoryLimit = 10000
+	// persistDelay is the delay of writing changes to the store file, changes made in the meantime are written together
+	persistDelay = time.Second
+)
+
+type entry struct {
+	Value          string `json:"value"`
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+}
+
+type event struct {
+	action   string
+	key      string
+	value    string
+	revision int64
+}
+
+type heldLock struct {
+	owner *Sync
+	lost  chan struct{}
+}
+
+// Store keeps keys, their revision history and locks of all Sync instances using it.
+// Several Sync instances sharing a store behave like several processes sharing an etcd cluster.
+type Store struct {
+	mu               syn.Mutex
+	entries          map[string]*entry
+	history          []*event
+	historyLimit     int64
+	revision         int64
+	compactRevision  int64
+	changed          chan struct{}
+	locks            map[string]*heldLock
+	file             string
+	persistScheduled bool
+	// writeMu serializes writes of the store file, so that an older snapshot doesn't replace a newer one
+	writeMu syn.Mutex
+}
+
+type snapshot struct {
+	Revision int64             `json:"revision"`
+	Entries  map[string]*entry `json:"entries"`
+}
+
+// NewStore creates a store. If file is not empty, keys are loaded from and persisted to it.
+// Changes are written to the file at most every second and when a Sync using the store is closed.
+// Locks and revision history are not persisted, watching from a revision before the restart
+// fails with a compaction error. The history is trimmed to DefaultHistoryLimit revisions.
+func NewStore(file string) (*Store, error) {
+	store := &Store{
+		entries:      map[string]*entry{},
+		historyLimit: DefaultHistoryLimit,
+		changed:      make(chan struct{}),
+		locks:        map[string]*heldLock{},
+		file:         file,
+	}
+	if file == "" {
+		return store, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync file %s: %s", file, err)
+	}
+	var loaded snapshot
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("failed to load sync file %s: %s", file, err)
+	}
+	if loaded.Entries != nil {
+		store.entries = loaded.Entries
+	}
+	store.revision = loaded.Revision
+	store.compactRevision = loaded.Revision
+	return store, nil
+}
+
+// notify wakes up watchers and lock waiters, must be called with mu held
+func (store *Store) notify() {
+	close(store.changed)
+	store.changed = make(chan struct{})
+}
+
+// SetHistoryLimit sets the number of revisions kept in the history, 0 keeps all of them until compaction.
+// The history is trimmed once it exceeds the limit by a tenth, watching from a trimmed revision
+// fails with a compaction error.
+func (store *Store) SetHistoryLimit(limit int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.historyLimit = limit
+	store.trimHistory()
+}
+
+// trimHistory drops revisions over the history limit, must be called with mu held
+func (store *Store) trimHistory() {
+	if store.historyLimit <= 0 || store.revision-store.compactRevision <= store.historyLimit+store.historyLimit/10 {
+		return
+	}
+	store.compact(store.revision - store.historyLimit + 1)
+	updateCounter(1, "history.trimmed")
+}
+
+// compact drops revision history before the revision, must be called with mu held
+func (store *Store) compact(revision int64) {
+	first := sort.Search(len(store.history), func(i int) bool {
+		return store.history[i].revision >= revision
+	})
+	store.history = append([]*event{}, store.history[first:]...)
+	store.compactRevision = revision
+}
+
+// schedulePersist writes keys to the store file after persistDelay, must be called with mu held
+func (store *Store) schedulePersist() {
+	if store.file == "" || store.persistScheduled {
+		return
+	}
+	store.persistScheduled = true
+	time.AfterFunc(persistDelay, store.Flush)
+}
+
+// Flush writes keys to the store file right away
+func (store *Store) Flush() {
+	if store.file == "" {
+		return
+	}
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+
+	store.mu.Lock()
+	store.persistScheduled = false
+	entries := make(map[string]*entry, len(store.entries))
+	for key, e := range store.entries {
+		if _, locked := store.locks[key]; !locked {
+			copied := *e
+			entries[key] = &copied
+		}
+	}
+	revision := store.revision
+	store.mu.Unlock()
+
+	data, err := json.Marshal(snapshot{Revision: revision, Entries: entries})
+	if err != nil {
+		log.Error("Failed to marshal sync snapshot: %s", err)
+		return
+	}
+	tmp := store.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.Error("Failed to write sync file %s: %s", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, store.file); err != nil {
+		log.Error("Failed to replace sync file %s: %s", store.file, err)
+	}
+}
+
+// put must be called with mu held
+func (store *Store) put(key, value string) int64 {
+	store.revision++
+	store.set(key, value)
+	return store.revision
+}
+
+// set writes the key in the current revision, must be called with mu held
+func (store *Store) set(key, value string) {
+	e, ok := store.entries[key]
+	if !ok {
+		e = &entry{CreateRevision: store.revision}
+		store.entries[key] = e
+	}
+	e.Value = value
+	e.ModRevision = store.revision
+	store.history = append(store.history, &event{action: "set", key: key, value: value, revision: store.revision})
+}
+
+// deleteKeys must be called with mu held, all keys are deleted in a single revision
+func (store *Store) deleteKeys(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	store.revision++
+	for _, key := range keys {
+		delete(store.entries, key)
+		store.history = append(store.history, &event{action: "delete", key: key, revision: store.revision})
+	}
+}
+
+// loseLock notifies the lock owner if the lock key was modified by anything else than Unlock,
+// must be called with mu held
+func (store *Store) loseLock(key string) {
+	lock, ok := store.locks[key]
+	if !ok {
+		return
+	}
+	delete(store.locks, key)
+	close(lock.lost)
+	updateCounter(1, "lock.lost")
+}
+
+func (store *Store) commit() {
+	store.trimHistory()
+	store.schedulePersist()
+	store.notify()
+}
+
+// keysWithPrefix must be called with mu held
+func (store *Store) keysWithPrefix(prefix string) []string {
+	keys := []string{}
+	for key := range store.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (store *Store) current(prefix string) ([]*event, int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	events := []*event{}
+	for _, key := range store.keysWithPrefix(prefix) {
+		e := store.entries[key]
+		events = append(events, &event{action: "get", key: key, value: e.Value, revision: e.ModRevision})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].revision < events[j].revision })
+	return events, store.revision
+}
+
+// since returns events under prefix starting at revision and a channel closed on the next change
+func (store *Store) since(prefix string, revision int64) ([]*event, <-chan struct{}, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if revision < store.compactRevision {
+		return nil, nil, goext.NewErrCompacted(errCompacted, store.compactRevision)
+	}
+	first := sort.Search(len(store.history), func(i int) bool {
+		return store.history[i].revision >= revision
+	})
+	events := []*event{}
+	for _, ev := range store.history[first:] {
+		if strings.HasPrefix(ev.key, prefix) {
+			events = append(events, ev)
+		}
+	}
+	return events, store.changed, nil
+}
+
+//Sync is an in-process sync backend. It implements the same semantics as the etcd backend,
+//including revisions, compaction, prefix watches, locks and CAS.
+type Sync struct {
+	store     *Store
+	processID string
+}
+
+//NewSync creates a sync using the given store
+func NewSync(store *Store) *Sync {
+	hostname, _ := os.Hostname()
+	return &Sync{
+		store:     store,
+		processID: hostname + uuid.NewV4().String(),
+	}
+}
+
+func measureTime(timeStarted time.Time, action string) {
+	metrics.UpdateTimer(timeStarted, "sync.memory.%s", action)
+}
+
+func updateCounter(delta int64, counter string) {
+	metrics.UpdateCounter(delta, "sync.memory.%s", counter)
+}
+
+//GetProcessID returns processID
+func (s *Sync) GetProcessID() string {
+	return s.processID
+}
+
+//Update sets the value of the key.
+//When jsonString is empty, this method does nothing, same as in the etcd backend.
+func (s *Sync) Update(ctx context.Context, key, jsonString string) error {
+	defer measureTime(time.Now(), "update")
+	if jsonString == "" {
+		return nil
+	}
+
+	store := s.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.loseLock(key)
+	store.put(key, jsonString)
+	store.commit()
+	return nil
+}
+
+//Delete deletes the key, or all keys starting with it if prefix is set
+func (s *Sync) Delete(ctx context.Context, key string, prefix bool) error {
+	defer measureTime(time.Now(), "delete")
+
+	store := s.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	keys := []string{}
+	if prefix {
+		keys = store.keysWithPrefix(key)
+		sort.Strings(keys)
+	} else if _, ok := store.entries[key]; ok {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, k := range keys {
+		store.loseLock(k)
+	}
+	store.deleteKeys(keys)
+	store.commit()
+	return nil
+}
+
+//Batch applies all ops in a single revision
+func (s *Sync) Batch(ctx context.Context, ops []sync.Op) error {
+	defer measureTime(time.Now(), "batch")
+
+	store := s.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	changed := false
+	for _, op := range ops {
+		if _, ok := store.entries[op.Key]; op.Delete && !ok {
+			continue
+		}
+		if !changed {
+			store.revision++
+			changed = true
+		}
+		store.loseLock(op.Key)
+		if op.Delete {
+			delete(store.entries, op.Key)
+			store.history = append(store.history, &event{action: "delete", key: op.Key, revision: store.revision})
+		} else {
+			store.set(op.Key, op.Value)
+		}
+	}
+	if changed {
+		store.commit()
+	}
+	return nil
+}
+
+//Fetch returns the tree of keys under the path
+func (s *Sync) Fetch(ctx context.Context, key string) (*sync.Node, error) {
+	defer measureTime(time.Now(), "fetch")
+
+	store := s.store
+	store.mu.Lock()
+	keys := store.keysWithPrefix(key)
+	sort.Strings(keys)
+	kvs := make([]*sync.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		e := store.entries[k]
+		kvs = append(kvs, &sync.KeyValue{Key: k, Value: e.Value, ModRevision: e.ModRevision})
+	}
+	store.mu.Unlock()
+
+	node := sync.NodeFromKeyValues(key, kvs)
+	if node == nil {
+		log.Debug("Key not found (%s)", key)
+		return nil, KeyNotFound
+	}
+	return node, nil
+}
+
+//HasLock checks current process owns lock or not
+func (s *Sync) HasLock(path string) bool {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	lock, ok := s.store.locks[path]
+	return ok && lock.owner == s
+}
+
+// Lock locks the path. The returned channel is closed when the lock is lost,
+// i.e. when the lock key is modified or deleted by anyone else or the sync is closed.
+func (s *Sync) Lock(ctx context.Context, path string, block bool) (chan struct{}, error) {
+	defer measureTime(time.Now(), "lock")
+	updateCounter(1, "lock.waiting")
+	defer updateCounter(-1, "lock.waiting")
+
+	store := s.store
+	for {
+		store.mu.Lock()
+		if _, exists := store.entries[path]; !exists {
+			store.put(path, s.processID)
+			lock := &heldLock{owner: s, lost: make(chan struct{})}
+			store.locks[path] = lock
+			store.commit()
+			store.mu.Unlock()
+			log.Info("Locked %s", path)
+			updateCounter(1, "lock.granted")
+			return lock.lost, nil
+		}
+		changed := store.changed
+		store.mu.Unlock()
+
+		if !block {
+			return nil, fmt.Errorf("failed to lock path %s", path)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+//Unlock path
+func (s *Sync) Unlock(ctx context.Context, path string) error {
+	defer measureTime(time.Now(), "unlock")
+
+	store := s.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	lock, ok := store.locks[path]
+	if !ok || lock.owner != s {
+		return nil
+	}
+	delete(store.locks, path)
+	close(lock.lost)
+	if e, ok := store.entries[path]; ok && e.Value == s.processID {
+		store.deleteKeys([]string{path})
+	}
+	store.commit()
+	log.Info("Unlocked path %s", path)
+	return nil
+}
+
+func sendEvent(ctx context.Context, ev *event, responseChan chan *sync.Event) bool {
+	response := &sync.Event{
+		Action:   ev.action,
+		Key:      ev.key,
+		Value:    ev.value,
+		Revision: ev.revision,
+	}
+	if ev.value != "" {
+		if err := json.Unmarshal([]byte(ev.value), &response.Data); err != nil {
+			log.Warning("failed to unmarshal watch response value %s: %s", ev.value, err)
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case responseChan <- response:
+		return true
+	}
+}
+
+func (s *Sync) watch(ctx context.Context, path string, revision int64, responseChan chan *sync.Event) {
+	updateCounter(1, "watch.active")
+	defer updateCounter(-1, "watch.active")
+
+	next := revision
+	if revision == goext.RevisionCurrent {
+		events, current := s.store.current(path)
+		for _, ev := range events {
+			if !sendEvent(ctx, ev, responseChan) {
+				return
+			}
+		}
+		next = current + 1
+	}
+
+	for {
+		events, changed, err := s.store.since(path, next)
+		if err != nil {
+			select {
+			case responseChan <- &sync.Event{Err: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		for _, ev := range events {
+			if !sendEvent(ctx, ev, responseChan) {
+				return
+			}
+			next = ev.revision + 1
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// Watch keep watch update under the path until context is canceled
+func (s *Sync) Watch(ctx context.Context, path string, revision int64) <-chan *sync.Event {
+	eventCh := make(chan *sync.Event, 32)
+	go func() {
+		defer close(eventCh)
+		s.watch(ctx, path, revision, eventCh)
+	}()
+	return eventCh
+}
+
+//Compact drops revision history before the revision
+func (s *Sync) Compact(ctx context.Context, revision int64) error {
+	store := s.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if revision <= store.compactRevision {
+		return errCompacted
+	}
+	if revision > store.revision {
+		return errFutureRevision
+	}
+	store.compact(revision)
+	return nil
+}
+
+//CurrentRevision returns the revision of the latest change
+func (s *Sync) CurrentRevision(ctx context.Context) (int64, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	return s.store.revision, nil
+}
+
+type valueCondition string
+
+//CompareAndSwap sets the value if all conditions are met
+func (s *Sync) CompareAndSwap(ctx context.Context, path, data string, conditions ...sync.CASCondition) (bool, error) {
+	store := s.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, condition := range conditions {
+		value, ok := condition.(valueCondition)
+		if !ok {
+			return false, fmt.Errorf("unsupported CAS condition %T", condition)
+		}
+		e, exists := store.entries[path]
+		if !exists || e.Value != string(value) {
+			return false, nil
+		}
+	}
+	store.loseLock(path)
+	store.put(path, data)
+	store.commit()
+	return true, nil
+}
+
+//ByValue is a CAS condition met when the current value equals value
+func (s *Sync) ByValue(value string) sync.CASCondition {
+	return valueCondition(value)
+}
+
+//Close releases all locks held by this sync and writes the store file
+func (s *Sync) Close() {
+	store := s.store
+	store.mu.Lock()
+
+	released := []string{}
+	for path, lock := range store.locks {
+		if lock.owner != s {
+			continue
+		}
+		delete(store.locks, path)
+		close(lock.lost)
+		released = append(released, path)
+	}
+	sort.Strings(released)
+	store.deleteKeys(released)
+	store.commit()
+	store.mu.Unlock()
+	store.Flush()
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goext"
+)
+
+func newTestSync(t *testing.T) *Sync {
+	store, err := NewStore("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return NewSync(store)
+}
+
+func expectLost(t *testing.T, lost chan struct{}) {
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatalf("lock was not lost")
+	}
+}
+
+func TestLockLostWhenKeyDeleted(t *testing.T) {
+	ctx := context.Background()
+	sync0 := newTestSync(t)
+	sync1 := NewSync(sync0.store)
+
+	lost, err := sync0.Lock(ctx, "/lock", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := sync1.Delete(ctx, "/", true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectLost(t, lost)
+	if sync0.HasLock("/lock") {
+		t.Fatalf("lock should be lost")
+	}
+	if _, err := sync1.Lock(ctx, "/lock", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestLockLostOnClose(t *testing.T) {
+	ctx := context.Background()
+	sync0 := newTestSync(t)
+	sync1 := NewSync(sync0.store)
+
+	lost, err := sync0.Lock(ctx, "/lock", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	locked := make(chan error, 1)
+	go func() {
+		_, err := sync1.Lock(ctx, "/lock", true)
+		locked <- err
+	}()
+
+	sync0.Close()
+	expectLost(t, lost)
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("lock was not taken over")
+	}
+}
+
+func TestBlockingLockCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sync0 := newTestSync(t)
+	sync1 := NewSync(sync0.store)
+
+	if _, err := sync0.Lock(ctx, "/lock", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cancel()
+	if _, err := sync1.Lock(ctx, "/lock", true); err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestCompactErrors(t *testing.T) {
+	ctx := context.Background()
+	sync := newTestSync(t)
+
+	if err := sync.Update(ctx, "/key", `{"version": 1}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if revision, err := sync.CurrentRevision(ctx); err != nil || revision != 1 {
+		t.Fatalf("expected current revision 1, got %d %v", revision, err)
+	}
+	if err := sync.Compact(ctx, 2); err == nil {
+		t.Fatalf("compacting a future revision should fail")
+	}
+	if err := sync.Compact(ctx, 1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := sync.Compact(ctx, 1); err == nil {
+		t.Fatalf("compacting a compacted revision should fail")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "gohan_sync")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sync.json")
+
+	store, err := NewStore(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sync := NewSync(store)
+	if err := sync.Update(ctx, "/key", `{"version": 1}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := sync.Lock(ctx, "/lock", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	store.Flush()
+
+	store, err = NewStore(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	restored := NewSync(store)
+	node, err := restored.Fetch(ctx, "/key")
+	if err != nil || node.Value != `{"version": 1}` || node.Revision != 1 {
+		t.Fatalf("key not restored: %+v, %v", node, err)
+	}
+	if _, err := restored.Fetch(ctx, "/lock"); err != KeyNotFound {
+		t.Fatalf("locks should not be restored, got %v", err)
+	}
+
+	event := <-restored.Watch(ctx, "/key", 1)
+	if _, ok := event.Err.(goext.ErrCompacted); !ok {
+		t.Fatalf("history before restart should be compacted, got %+v", event)
+	}
+}
+
+func TestHistoryTrimmed(t *testing.T) {
+	ctx := context.Background()
+	sync := newTestSync(t)
+	sync.store.SetHistoryLimit(10)
+
+	for i := 0; i < 11; i++ {
+		if err := sync.Update(ctx, "/key", `{}`); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if len(sync.store.history) != 11 {
+		t.Fatalf("history within the slack should be kept, got %d events", len(sync.store.history))
+	}
+	if err := sync.Update(ctx, "/key", `{}`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sync.store.history) != 10 {
+		t.Fatalf("history should be trimmed to the limit, got %d events", len(sync.store.history))
+	}
+	event := <-sync.Watch(ctx, "/key", 2)
+	if _, ok := event.Err.(goext.ErrCompacted); !ok {
+		t.Fatalf("trimmed history should be compacted, got %+v", event)
+	}
+	event = <-sync.Watch(ctx, "/key", 3)
+	if event.Err != nil || event.Revision != 3 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestChangesPersistedTogether(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "gohan_sync")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sync.json")
+
+	store, err := NewStore(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sync := NewSync(store)
+	for i := 0; i < 3; i++ {
+		if err := sync.Update(ctx, "/key", `{}`); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("changes should be written after a delay, got %v", err)
+	}
+	sync.Close()
+
+	restored, err := NewStore(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if restored.revision != 3 {
+		t.Fatalf("changes should be written on close, got revision %d", restored.revision)
+	}
+}
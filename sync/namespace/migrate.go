@@ -0,0 +1,122 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudwan/gohan/sync"
+)
+
+//MoveOptions select keys moved between cluster prefixes
+type MoveOptions struct {
+	//From is the current cluster prefix, empty for keys without a prefix
+	From string
+	//To is the new cluster prefix, empty to remove the prefix
+	To string
+	//Paths are moved with all keys under them, relative to the cluster prefix
+	Paths []string
+	//Exclude skips keys under these paths, e.g. locks bound to leases of running processes
+	Exclude []string
+	//DryRun only returns keys which would be moved
+	DryRun bool
+}
+
+//Move copies keys under paths from one cluster prefix to another on a raw sync backend,
+//then deletes the old keys. Gohan must be stopped while keys are moved.
+//It returns moved keys relative to the cluster prefix.
+func Move(ctx context.Context, raw sync.Sync, options MoveOptions) ([]string, error) {
+	from, to := NormalizePrefix(options.From), NormalizePrefix(options.To)
+	if from == to {
+		return nil, fmt.Errorf("source and destination prefixes are the same")
+	}
+	source := NewSync(raw, from)
+	destination := NewSync(raw, to)
+
+	moved := []string{}
+	for _, path := range options.Paths {
+		values, err := fetchValues(ctx, source, path)
+		if err != nil {
+			return moved, fmt.Errorf("failed to fetch %s: %s", path, err)
+		}
+		for _, key := range sortedKeys(values) {
+			if excluded(key, options.Exclude) || (to != "" && hasPath(from+key, to)) {
+				continue
+			}
+			moved = append(moved, key)
+			if options.DryRun {
+				continue
+			}
+			if err := destination.Update(ctx, key, values[key]); err != nil {
+				return moved, fmt.Errorf("failed to write %s%s: %s", to, key, err)
+			}
+			if err := source.Delete(ctx, key, false); err != nil {
+				return moved, fmt.Errorf("failed to delete %s%s: %s", from, key, err)
+			}
+		}
+	}
+	return moved, nil
+}
+
+func fetchValues(ctx context.Context, s *Sync, path string) (map[string]string, error) {
+	path = "/" + strings.Trim(path, "/")
+	values := map[string]string{}
+	node, err := s.Fetch(ctx, path)
+	if err == sync.KeyNotFound {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var collect func(node *sync.Node)
+	collect = func(node *sync.Node) {
+		if len(node.Children) == 0 && hasPath(node.Key, path) {
+			values[node.Key] = node.Value
+		}
+		for _, child := range node.Children {
+			collect(child)
+		}
+	}
+	collect(node)
+	return values, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func excluded(key string, exclude []string) bool {
+	for _, path := range exclude {
+		if hasPath(key, path) {
+			return true
+		}
+	}
+	return false
+}
+
+//hasPath checks if the key is the path or is under it
+func hasPath(key, path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	return path == "" || key == path || strings.HasPrefix(key, path+"/")
+}
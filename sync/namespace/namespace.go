@@ -0,0 +1,219 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudwan/gohan/sync"
+)
+
+//ErrCompactionRefused is returned when a backend shared by several clusters is compacted
+var ErrCompactionRefused = errors.New("sync backend with a cluster prefix can't be compacted, other clusters may still watch its revisions")
+
+//Sync prefixes all keys of a wrapped sync backend with a cluster prefix,
+//so that several Gohan clusters can share one backend.
+//Keys of fetched nodes and watch events are returned without the prefix.
+type Sync struct {
+	raw    sync.Sync
+	prefix string
+}
+
+//NormalizePrefix returns the prefix with a leading and without a trailing separator,
+//or an empty string for the root
+func NormalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+//NewSync wraps a sync backend, prefixing all keys with prefix
+func NewSync(raw sync.Sync, prefix string) *Sync {
+	return &Sync{raw: raw, prefix: NormalizePrefix(prefix)}
+}
+
+//Prefix returns the cluster prefix
+func (s *Sync) Prefix() string {
+	return s.prefix
+}
+
+//Raw returns the wrapped sync backend
+func (s *Sync) Raw() sync.Sync {
+	return s.raw
+}
+
+func (s *Sync) key(path string) string {
+	if s.prefix == "" {
+		return path
+	}
+	if path == "/" || path == "" {
+		return s.prefix
+	}
+	return s.prefix + path
+}
+
+func (s *Sync) trim(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	if key == s.prefix {
+		return "/"
+	}
+	return strings.TrimPrefix(key, s.prefix)
+}
+
+func (s *Sync) inPrefix(key string) bool {
+	return s.prefix == "" || key == s.prefix || strings.HasPrefix(key, s.prefix+"/")
+}
+
+//trimNode removes the prefix from keys of the node tree, and drops nodes of other
+//prefixes sharing the same beginning, e.g. "/cluster-ab" when the prefix is "/cluster-a"
+func (s *Sync) trimNode(node *sync.Node) *sync.Node {
+	if node == nil || !s.inPrefix(node.Key) {
+		return nil
+	}
+	node.Key = s.trim(node.Key)
+	children := node.Children[:0]
+	for _, child := range node.Children {
+		if child = s.trimNode(child); child != nil {
+			children = append(children, child)
+		}
+	}
+	node.Children = children
+	return node
+}
+
+//GetProcessID returns processID of the wrapped backend
+func (s *Sync) GetProcessID() string {
+	return s.raw.GetProcessID()
+}
+
+//HasLock checks if the current process has the lock
+func (s *Sync) HasLock(path string) bool {
+	return s.raw.HasLock(s.key(path))
+}
+
+//Lock locks the path
+func (s *Sync) Lock(ctx context.Context, path string, block bool) (chan struct{}, error) {
+	return s.raw.Lock(ctx, s.key(path), block)
+}
+
+//Unlock unlocks the path
+func (s *Sync) Unlock(ctx context.Context, path string) error {
+	return s.raw.Unlock(ctx, s.key(path))
+}
+
+//Fetch fetches the node tree under the path
+func (s *Sync) Fetch(ctx context.Context, path string) (*sync.Node, error) {
+	node, err := s.raw.Fetch(ctx, s.key(path))
+	if err != nil {
+		return nil, err
+	}
+	if node = s.trimNode(node); node == nil {
+		return nil, sync.KeyNotFound
+	}
+	return node, nil
+}
+
+//Update sets the value of the path
+func (s *Sync) Update(ctx context.Context, path, json string) error {
+	return s.raw.Update(ctx, s.key(path), json)
+}
+
+//UpdateWithTTL sets the value of the path which expires after ttl, if the wrapped backend supports it
+func (s *Sync) UpdateWithTTL(ctx context.Context, path, json string, ttl time.Duration) error {
+	return sync.UpdateWithTTL(ctx, s.raw, s.key(path), json, ttl)
+}
+
+//Delete deletes the path, or all keys with the path as prefix
+func (s *Sync) Delete(ctx context.Context, path string, prefix bool) error {
+	return s.raw.Delete(ctx, s.key(path), prefix)
+}
+
+//Batch applies ops with prefixed keys
+func (s *Sync) Batch(ctx context.Context, ops []sync.Op) error {
+	prefixed := make([]sync.Op, len(ops))
+	for i, op := range ops {
+		prefixed[i] = op
+		prefixed[i].Key = s.key(op.Key)
+	}
+	return sync.ApplyBatch(ctx, s.raw, prefixed)
+}
+
+//Watch watches the path, events are returned without the prefix
+func (s *Sync) Watch(ctx context.Context, path string, revision int64) <-chan *sync.Event {
+	rawCh := s.raw.Watch(ctx, s.key(path), revision)
+	if rawCh == nil {
+		return nil
+	}
+	eventCh := make(chan *sync.Event, 32)
+	go func() {
+		defer close(eventCh)
+		for event := range rawCh {
+			if event.Key != "" && !s.inPrefix(event.Key) {
+				continue
+			}
+			if event.Key != "" {
+				event.Key = s.trim(event.Key)
+			}
+			select {
+			case eventCh <- event:
+			case <-ctx.Done():
+				// drain the raw channel so that the backend can close it
+				for range rawCh {
+				}
+				return
+			}
+		}
+	}()
+	return eventCh
+}
+
+//Compact refuses to compact the backend, as revisions are shared by all prefixes and
+//watchers of other clusters may still need revisions this cluster doesn't know about
+func (s *Sync) Compact(ctx context.Context, revision int64) error {
+	return ErrCompactionRefused
+}
+
+//CurrentRevision returns the current revision of the wrapped backend, revisions are shared by all prefixes
+func (s *Sync) CurrentRevision(ctx context.Context) (int64, error) {
+	revisioner, ok := s.raw.(sync.Revisioner)
+	if !ok {
+		return 0, fmt.Errorf("sync backend doesn't report revisions")
+	}
+	return revisioner.CurrentRevision(ctx)
+}
+
+//CompareAndSwap sets the value of the path when conditions are met
+func (s *Sync) CompareAndSwap(ctx context.Context, path, data string, condition ...sync.CASCondition) (bool, error) {
+	return s.raw.CompareAndSwap(ctx, s.key(path), data, condition...)
+}
+
+//ByValue returns a CAS condition of the wrapped backend
+func (s *Sync) ByValue(value string) sync.CASCondition {
+	return s.raw.ByValue(value)
+}
+
+//Close closes the wrapped backend
+func (s *Sync) Close() {
+	s.raw.Close()
+}
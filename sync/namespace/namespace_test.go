@@ -0,0 +1,203 @@
+// Copyright (C) 2020 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/extension/goext"
+	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/memory"
+)
+
+func newTestBackend(t *testing.T) *memory.Sync {
+	store, err := memory.NewStore("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return memory.NewSync(store)
+}
+
+func mustUpdate(t *testing.T, s sync.Sync, key, value string) {
+	if err := s.Update(context.Background(), key, value); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func leaves(t *testing.T, s sync.Sync, path string) map[string]string {
+	values, err := fetchValues(context.Background(), NewSync(s, ""), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return values
+}
+
+func TestKeysArePrefixed(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestBackend(t)
+	clusterA := NewSync(raw, "cluster-a/")
+	clusterAB := NewSync(raw, "/cluster-ab")
+
+	mustUpdate(t, clusterA, "/config/a", "1")
+	mustUpdate(t, clusterAB, "/config/b", "2")
+
+	if expected, actual := map[string]string{"/cluster-a/config/a": "1", "/cluster-ab/config/b": "2"}, leaves(t, raw, "/"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	node, err := clusterA.Fetch(ctx, "/config/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if node.Key != "/config/a" || node.Value != "1" {
+		t.Fatalf("unexpected node %+v", node)
+	}
+	if expected, actual := map[string]string{"/config/a": "1"}, leaves(t, clusterA, "/"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if _, err := clusterA.Fetch(ctx, "/config/b"); err != sync.KeyNotFound {
+		t.Fatalf("expected KeyNotFound, got %v", err)
+	}
+
+	if err := clusterA.Delete(ctx, "/config", true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected, actual := map[string]string{"/cluster-ab/config/b": "2"}, leaves(t, raw, "/"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestLocksAndCASArePrefixed(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestBackend(t)
+	clusterA := NewSync(raw, "/cluster-a")
+
+	if _, err := clusterA.Lock(ctx, "/lock", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !clusterA.HasLock("/lock") || raw.HasLock("/lock") || !raw.HasLock("/cluster-a/lock") {
+		t.Fatalf("lock should be taken under the prefix only")
+	}
+	if _, err := raw.Lock(ctx, "/lock", false); err != nil {
+		t.Fatalf("locks of different prefixes should not conflict: %s", err)
+	}
+
+	mustUpdate(t, clusterA, "/key", "old")
+	swapped, err := clusterA.CompareAndSwap(ctx, "/key", "new", clusterA.ByValue("old"))
+	if err != nil || !swapped {
+		t.Fatalf("expected swap, got %v %v", swapped, err)
+	}
+	if err := sync.ApplyBatch(ctx, clusterA, []sync.Op{{Key: "/batch", Value: "1"}, {Key: "/key", Delete: true}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected, actual := map[string]string{"/cluster-a/batch": "1"}, leaves(t, raw, "/cluster-a/batch"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if _, err := clusterA.Fetch(ctx, "/key"); err != sync.KeyNotFound {
+		t.Fatalf("expected KeyNotFound, got %v", err)
+	}
+}
+
+func TestWatchTrimsPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	raw := newTestBackend(t)
+	clusterA := NewSync(raw, "/cluster-a")
+
+	events := clusterA.Watch(ctx, "/config", goext.RevisionCurrent)
+	mustUpdate(t, raw, "/config/other", `{"id":"other"}`)
+	mustUpdate(t, clusterA, "/config/a", `{"id":"a"}`)
+
+	select {
+	case event := <-events:
+		if event.Err != nil {
+			t.Fatalf("unexpected error: %s", event.Err)
+		}
+		if event.Key != "/config/a" {
+			t.Fatalf("expected /config/a, got %s", event.Key)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+}
+
+func TestCompactionIsRefused(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestBackend(t)
+	clusterA := NewSync(raw, "/cluster-a")
+
+	mustUpdate(t, raw, "/config/other", "1")
+	mustUpdate(t, raw, "/config/other", "2")
+	if err := clusterA.Compact(ctx, 2); err != ErrCompactionRefused {
+		t.Fatalf("expected ErrCompactionRefused, got %v", err)
+	}
+	if _, err := raw.Fetch(ctx, "/config/other"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestBackend(t)
+
+	mustUpdate(t, raw, "/config/a", "1")
+	mustUpdate(t, raw, "/gohan/cluster/lock/job", "held")
+	mustUpdate(t, raw, "/gohan/watch/revision/v2.0", "7")
+	mustUpdate(t, raw, "/unrelated", "x")
+
+	options := MoveOptions{
+		To:      "/cluster-a",
+		Paths:   []string{"/config", "/gohan"},
+		Exclude: []string{"/gohan/cluster/lock"},
+		DryRun:  true,
+	}
+	moved, err := Move(ctx, raw, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := []string{"/config/a", "/gohan/watch/revision/v2.0"}; !reflect.DeepEqual(expected, moved) {
+		t.Fatalf("expected %v, got %v", expected, moved)
+	}
+	if len(leaves(t, raw, "/cluster-a")) != 0 {
+		t.Fatalf("dry run should not move keys")
+	}
+
+	options.DryRun = false
+	if _, err := Move(ctx, raw, options); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]string{
+		"/cluster-a/config/a":                  "1",
+		"/cluster-a/gohan/watch/revision/v2.0": "7",
+		"/gohan/cluster/lock/job":              "held",
+		"/unrelated":                           "x",
+	}
+	if actual := leaves(t, raw, "/"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	// moving back to keys without a prefix
+	moved, err = Move(ctx, raw, MoveOptions{From: "/cluster-a", Paths: []string{"/"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(moved) != 2 || len(leaves(t, raw, "/cluster-a")) != 0 {
+		t.Fatalf("expected all keys to be moved back, moved %v", moved)
+	}
+}
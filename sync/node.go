@@ -0,0 +1,100 @@
+// Copyright (C) 2015 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sync
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+//KeyNotFound is returned by Fetch when there is no key under the path
+var KeyNotFound = errors.New("Key not found")
+
+//KeyValue is a single key stored in a flat key-value sync backend
+type KeyValue struct {
+	Key         string
+	Value       string
+	ModRevision int64
+}
+
+//NodeFromKeyValues builds a tree of nodes under rootKey from keys fetched by prefix,
+//sorted by key. Returns nil if there are no keys under rootKey.
+func NodeFromKeyValues(rootKey string, kvs []*KeyValue) *Node {
+	sep := "/"
+	curr := strings.Count(rootKey, sep)
+	if curr == 0 {
+		curr = 1
+	}
+	return recursiveFetch(curr, kvs, rootKey, sep)
+}
+
+func recursiveFetch(curr int, children []*KeyValue, rootKey, sep string) *Node {
+	if len(children) == 0 {
+		return nil
+	}
+	if len(children) == 1 {
+		return handleSingleChild(curr, children[0], rootKey, sep)
+	}
+
+	key := substrN(children[0].Key, sep, curr)
+	commonChild := make(map[string][]*KeyValue)
+	for _, child := range children {
+		val := substrN(child.Key, sep, curr+1)
+		commonChild[val] = append(commonChild[val], child)
+	}
+	// children nodes has to be alphabetically sorted
+	keys := make([]string, 0, len(commonChild))
+	for k, v := range commonChild {
+		if len(v) != 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	nodes := make([]*Node, 0, len(keys))
+	for _, key := range keys {
+		v := commonChild[key]
+		if node := recursiveFetch(curr+1, v, rootKey, sep); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	node := &Node{Key: key, Children: nodes}
+	return node
+}
+
+func handleSingleChild(curr int, child *KeyValue, rootKey, sep string) *Node {
+	key := substrN(child.Key, sep, curr)
+	if child.Key == key {
+		if child.Key != rootKey && !strings.HasPrefix(child.Key, strings.TrimSuffix(rootKey, sep)+sep) { // remove invalid keys
+			return nil
+		}
+		return &Node{Key: child.Key, Value: child.Value, Revision: child.ModRevision}
+	}
+	nodes := handleSingleChild(curr+1, child, rootKey, sep)
+	return &Node{Key: key, Children: []*Node{nodes}}
+}
+
+func substrN(s, substr string, n int) string {
+	idx := 1
+	for i := 0; i < n; i++ {
+		tmp := strings.Index(s[idx:], substr)
+		if tmp == -1 {
+			return s
+		}
+		idx += tmp + 1
+	}
+	return s[0 : idx-1]
+}
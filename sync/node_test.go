@@ -0,0 +1,55 @@
+// Copyright (C) 2015 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sync
+
+import "testing"
+
+func TestSubstr(t *testing.T) {
+	expectToEqual := func(a, b string) {
+		if a != b {
+			t.Fatalf("expected %s to equal %s", a, b)
+		}
+	}
+	expectToEqual(substrN("/a/b/c/d", "/", 1), "/a")
+	expectToEqual(substrN("/a/b/c/d", "/", 2), "/a/b")
+	expectToEqual(substrN("/a/b/c/d", "/", 3), "/a/b/c")
+	expectToEqual(substrN("/a/b/c/d", "/", 4), "/a/b/c/d")
+	expectToEqual(substrN("/a/b/c/d", "/", 5), "/a/b/c/d")
+}
+
+func TestNodeFromKeyValuesOfRoot(t *testing.T) {
+	node := NodeFromKeyValues("/", []*KeyValue{
+		{Key: "/a/b", Value: "1"},
+		{Key: "/c/d/e", Value: "2"},
+	})
+	values := map[string]string{}
+	var collect func(node *Node)
+	collect = func(node *Node) {
+		if node == nil {
+			t.Fatalf("unexpected nil node")
+		}
+		if len(node.Children) == 0 {
+			values[node.Key] = node.Value
+		}
+		for _, child := range node.Children {
+			collect(child)
+		}
+	}
+	collect(node)
+	if len(values) != 2 || values["/a/b"] != "1" || values["/c/d/e"] != "2" {
+		t.Fatalf("unexpected leaves %v", values)
+	}
+}
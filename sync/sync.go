@@ -17,6 +17,7 @@ package sync
 
 import (
 	"context"
+	"time"
 
 	l "github.com/cloudwan/gohan/log"
 )
@@ -44,10 +45,66 @@ type Sync interface {
 	Close()
 }
 
+//Op is a single write applied by Batch
+type Op struct {
+	Key   string
+	Value string
+	// Delete removes Key instead of setting Value
+	Delete bool
+}
+
+//Batcher is implemented by sync backends which can apply several writes atomically,
+//in a single revision. A batch must not contain the same key twice.
+type Batcher interface {
+	Batch(ctx context.Context, ops []Op) error
+}
+
+//ApplyBatch applies ops in a single transaction if the sync backend supports it,
+//otherwise one by one
+func ApplyBatch(ctx context.Context, s Sync, ops []Op) error {
+	if batcher, ok := s.(Batcher); ok {
+		return batcher.Batch(ctx, ops)
+	}
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			err = s.Delete(ctx, op.Key, false)
+		} else {
+			err = s.Update(ctx, op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//TTLUpdater is implemented by sync backends which can expire keys
+type TTLUpdater interface {
+	UpdateWithTTL(ctx context.Context, path, json string, ttl time.Duration) error
+}
+
+//UpdateWithTTL sets a value which expires after ttl if the sync backend supports it,
+//otherwise the value is set without expiration
+func UpdateWithTTL(ctx context.Context, s Sync, path, json string, ttl time.Duration) error {
+	if updater, ok := s.(TTLUpdater); ok {
+		return updater.UpdateWithTTL(ctx, path, json, ttl)
+	}
+	return s.Update(ctx, path, json)
+}
+
+//Revisioner is implemented by sync backends which can report the current revision,
+//i.e. the revision of the latest change of any key
+type Revisioner interface {
+	CurrentRevision(ctx context.Context) (int64, error)
+}
+
 //Event is a struct for Watch response
 type Event struct {
-	Action   string
-	Key      string
+	Action string
+	Key    string
+	// Value is the raw value of the key, Data is parsed from it when it's a JSON object
+	Value    string
 	Data     map[string]interface{}
 	Revision int64
 	// Err is used only by Sync.Watch()
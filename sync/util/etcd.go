@@ -6,16 +6,36 @@ import (
 
 	l "github.com/cloudwan/gohan/log"
 	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/database"
 	"github.com/cloudwan/gohan/sync/etcdv3"
+	"github.com/cloudwan/gohan/sync/memory"
+	"github.com/cloudwan/gohan/sync/namespace"
 	"github.com/cloudwan/gohan/util"
 )
 
 var log = l.NewLogger()
 
-const etcdTimeoutDefaultValueMS = 1000
+const (
+	etcdTimeoutDefaultValueMS = 1000
+	databaseSyncTimeoutMS     = 5000
+)
 
-// CreateFromConfig creates etcd sync from config
+// CreateFromConfig creates etcd sync from config.
+// When "sync_prefix" is set, all keys are prefixed with it, so that several clusters can share a backend.
 func CreateFromConfig(config *util.Config) (s sync.Sync, err error) {
+	s, err = CreateBackendFromConfig(config)
+	if err != nil || s == nil {
+		return
+	}
+	if prefix := namespace.NormalizePrefix(config.GetString("sync_prefix", "")); prefix != "" {
+		log.Info("sync prefix: %s", prefix)
+		s = namespace.NewSync(s, prefix)
+	}
+	return
+}
+
+// CreateBackendFromConfig creates the sync backend from config, without the "sync_prefix"
+func CreateBackendFromConfig(config *util.Config) (s sync.Sync, err error) {
 	syncType := config.GetString("sync", "etcdv3")
 	switch syncType {
 	case "etcdv3":
@@ -28,6 +48,29 @@ func CreateFromConfig(config *util.Config) (s sync.Sync, err error) {
 				return
 			}
 		}
+	case "memory":
+		var store *memory.Store
+		store, err = memory.NewStore(config.GetString("memory_sync_file", ""))
+		if err != nil {
+			return
+		}
+		store.SetHistoryLimit(int64(config.GetInt("memory_sync_history_limit", memory.DefaultHistoryLimit)))
+		s = memory.NewSync(store)
+	case "database":
+		var databaseSync *database.Sync
+		databaseSync, err = database.NewSync(
+			config.GetString("database_sync/type", config.GetString("database/type", "sqlite3")),
+			config.GetString("database_sync/connection", config.GetString("database/connection", "")),
+			time.Duration(config.GetInt("database_sync/timeout_ms", databaseSyncTimeoutMS))*time.Millisecond,
+			config.GetDuration("database_sync/lock_ttl", database.DefaultLockTTL),
+			config.GetDuration("database_sync/poll_interval", database.DefaultPollInterval),
+		)
+		if err != nil {
+			err = fmt.Errorf("failed to set up database sync: %s", err)
+			return
+		}
+		databaseSync.SetHistoryLimit(int64(config.GetInt("database_sync/history_limit", database.DefaultHistoryLimit)))
+		s = databaseSync
 	default:
 		err = fmt.Errorf("invalid sync type: %s", syncType)
 		return